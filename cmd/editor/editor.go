@@ -5,10 +5,12 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"syscall/js"
 
 	"github.com/johnstarich/go-wasm/cmd/editor/dom"
 	"github.com/johnstarich/go-wasm/cmd/editor/ide"
+	"github.com/johnstarich/go-wasm/internal/global"
 	"github.com/johnstarich/go-wasm/log"
 )
 
@@ -33,6 +35,9 @@ type jsEditor struct {
 	elem      js.Value
 	filePath  string
 	titleChan chan string
+
+	stopWatch     js.Value
+	watchCallback js.Func
 }
 
 func (j *jsEditor) onEdit(js.Value, []js.Value) interface{} {
@@ -54,9 +59,39 @@ func (j *jsEditor) onEdit(js.Value, []js.Value) interface{} {
 func (j *jsEditor) OpenFile(path string) error {
 	j.filePath = path
 	j.titleChan <- path
+	j.watchFile()
 	return j.ReloadFile()
 }
 
+// watchFile reloads the editor's contents when another process changes the open file
+func (j *jsEditor) watchFile() {
+	if j.stopWatch.Truthy() {
+		j.stopWatch.Invoke()
+		j.watchCallback.Release()
+	}
+	path, err := filepath.Abs(j.filePath)
+	if err != nil {
+		log.Error("Failed to watch file: ", err)
+		return
+	}
+	j.watchCallback = js.FuncOf(func(js.Value, []js.Value) interface{} {
+		go j.reloadIfChanged()
+		return nil
+	})
+	j.stopWatch = global.Get("watch").Invoke(path, map[string]interface{}{}, j.watchCallback)
+}
+
+func (j *jsEditor) reloadIfChanged() {
+	contents, err := ioutil.ReadFile(j.filePath)
+	if err != nil {
+		return // file may be mid-rename or removed, keep current contents
+	}
+	if string(contents) == j.elem.Call("getContents").String() {
+		return // skip our own writes
+	}
+	j.elem.Call("setContents", string(contents))
+}
+
 func (j *jsEditor) CurrentFile() string {
 	return j.filePath
 }
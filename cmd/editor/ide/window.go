@@ -62,7 +62,7 @@ func New(elem *dom.Element, editorBuilder EditorBuilder, consoleBuilder ConsoleB
 	w.panesElem.AppendChild(w.editorsPane.Element)
 
 	w.consolesPane = NewTabPane(TabOptions{}, func(_ int, _, contents *dom.Element) Tabber {
-		console, err := w.consoleBuilder.New(contents, "", "sh")
+		console, err := w.consoleBuilder.New(contents, "", "sh", "-i")
 		if err != nil {
 			log.Error(err)
 		}
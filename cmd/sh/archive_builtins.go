@@ -0,0 +1,672 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	for k, v := range map[string]builtinFunc{
+		"gunzip": gunzip,
+		"gzip":   gzipBuiltin,
+		"tar":    tarBuiltin,
+		"unzip":  unzip,
+		"zip":    zipBuiltin,
+	} {
+		builtins[k] = v
+	}
+}
+
+// archiveName returns the name of 'filePath' inside an archive. Leading slashes are removed, like tar.
+func archiveName(filePath string) string {
+	name := strings.TrimLeft(path.Clean(filePath), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// extractPath returns the path for archive entry 'name' inside 'dest'
+func extractPath(dest, name string) string {
+	return path.Join(dest, path.Join("/", name)) // joining with "/" first prevents escaping 'dest'
+}
+
+// walkArchivePaths calls 'fn' for each file and directory in 'paths', parents before children.
+// Files are read from 'dir' if it's set, but named relative to it. Directory contents are skipped unless 'recursive' is set.
+func walkArchivePaths(term console.Console, dir string, paths []string, recursive bool, fn func(filePath, name string, info os.FileInfo) error) error {
+	var walk func(name string) error
+	walk = func(name string) error {
+		if err := jobFromConsole(term).checkpoint(); err != nil {
+			return err
+		}
+		filePath := name
+		if dir != "" {
+			filePath = path.Join(dir, name)
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		if err := fn(filePath, name, info); err != nil {
+			return err
+		}
+		if !info.IsDir() || !recursive {
+			return nil
+		}
+		children, err := readDirNames(filePath)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := walk(joinGlobPath(name, child)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, p := range paths {
+		if err := walk(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes 'r' to a new file at 'filePath', creating any missing parent directories
+func extractFile(r io.Reader, filePath string, mode os.FileMode, modTime time.Time) error {
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return applyExtractedAttrs(filePath, mode, modTime)
+}
+
+func applyExtractedAttrs(filePath string, mode os.FileMode, modTime time.Time) error {
+	if err := os.Chmod(filePath, mode.Perm()); err != nil {
+		return err
+	}
+	if modTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(filePath, modTime, modTime)
+}
+
+type tarOptions struct {
+	mode      rune // one of 'c', 'x', or 't'
+	gzip      bool
+	verbose   bool
+	sameOwner bool // restore owners when extracting
+	file      string
+	dir       string
+	paths     []string
+}
+
+// parseTarArgs parses tar's bundled flags, like 'tar czf out.tar.gz dir' or 'tar -x -f in.tar -C dest'
+func parseTarArgs(args []string) (tarOptions, error) {
+	var opts tarOptions
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		args = append([]string{"-" + args[0]}, args[1:]...) // the first argument's '-' is optional
+	}
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			args = args[1:]
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		args = args[1:]
+		letters := arg[1:]
+		for i, letter := range letters {
+			switch letter {
+			case 'c', 'x', 't':
+				if opts.mode != 0 && opts.mode != letter {
+					return opts, errors.New("Only one of -c, -x, or -t may be set")
+				}
+				opts.mode = letter
+			case 'z':
+				opts.gzip = true
+			case 'v':
+				opts.verbose = true
+			case 'p':
+				opts.sameOwner = true
+			case 'f', 'C':
+				// the value is either the rest of this argument or the next one
+				value := letters[i+1:]
+				if value == "" {
+					if len(args) == 0 {
+						return opts, errors.Errorf("Option -%c requires an argument", letter)
+					}
+					value, args = args[0], args[1:]
+				}
+				if letter == 'f' {
+					opts.file = value
+				} else {
+					opts.dir = value
+				}
+			default:
+				return opts, errors.Errorf("Invalid option: -%c", letter)
+			}
+			if letter == 'f' || letter == 'C' {
+				break
+			}
+		}
+	}
+	opts.paths = args
+	if opts.mode == 0 {
+		return opts, errors.New("Usage: tar {c|x|t}[zvp] [-f archive] [-C dir] [file...]")
+	}
+	if opts.file == "" {
+		opts.file = "-"
+	}
+	return opts, nil
+}
+
+// tarBuiltin creates, extracts, or lists tar archives, optionally compressed with gzip. Reads and writes stdin and stdout without -f.
+// Extracted files are owned by the current user, unless -p restores the archived owners.
+func tarBuiltin(term console.Console, args ...string) error {
+	opts, err := parseTarArgs(args)
+	if err != nil {
+		return err
+	}
+	switch opts.mode {
+	case 'c':
+		return createTar(term, opts)
+	default:
+		return readTar(term, opts)
+	}
+}
+
+func createTar(term console.Console, opts tarOptions) (returnedErr error) {
+	if len(opts.paths) == 0 {
+		return errors.New("Refusing to create an empty archive")
+	}
+	out := term.Stdout()
+	verboseOut := term.Stdout()
+	if opts.file == "-" {
+		verboseOut = term.Stderr()
+	} else {
+		f, err := os.Create(opts.file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := f.Close(); err != nil && returnedErr == nil {
+				returnedErr = err
+			}
+		}()
+		out = f
+	}
+	if opts.gzip {
+		compressor := gzip.NewWriter(out)
+		defer func() {
+			if err := compressor.Close(); err != nil && returnedErr == nil {
+				returnedErr = err
+			}
+		}()
+		out = compressor
+	}
+
+	archive := tar.NewWriter(out)
+	err := walkArchivePaths(term, opts.dir, opts.paths, true, func(filePath, name string, info os.FileInfo) error {
+		if path.Clean(filePath) == path.Clean(opts.file) {
+			return nil // skip the archive being written
+		}
+		header, err := tarHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = archiveName(name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if opts.verbose {
+			fmt.Fprintln(verboseOut, header.Name)
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(archive, f)
+		f.Close()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// tarHeader returns a header for 'info', including its owner.
+// tar.FileInfoHeader only reads owners on some platforms, so they're set here for js/wasm too.
+func tarHeader(info os.FileInfo) (*tar.Header, error) {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		header.Uid, header.Gid = int(stat.Uid), int(stat.Gid)
+	}
+	return header, nil
+}
+
+// openArchiveReader opens 'file' for reading, or stdin for '-'. Decompresses gzip data, whether or not 'gzip' is set.
+func openArchiveReader(term console.Console, file string, gzipped bool) (io.Reader, func(), error) {
+	var r io.Reader
+	closeFn := func() {}
+	if file == "-" {
+		r = getConsoleStdin(term)
+	} else {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = f
+		closeFn = func() { f.Close() }
+	}
+
+	buf := bufio.NewReader(r)
+	magic, _ := buf.Peek(2)
+	if !gzipped && string(magic) != "\x1f\x8b" {
+		return buf, closeFn, nil
+	}
+	decompressor, err := gzip.NewReader(buf)
+	if err != nil {
+		closeFn()
+		return nil, nil, err
+	}
+	return decompressor, func() {
+		decompressor.Close()
+		closeFn()
+	}, nil
+}
+
+func readTar(term console.Console, opts tarOptions) error {
+	r, closeFn, err := openArchiveReader(term, opts.file, opts.gzip)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	dest := opts.dir
+	if dest == "" {
+		dest = "."
+	}
+	archive := tar.NewReader(r)
+	var dirs []*tar.Header
+	for {
+		if err := jobFromConsole(term).checkpoint(); err != nil {
+			return err
+		}
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !matchesArchivePaths(header.Name, opts.paths) {
+			continue
+		}
+		if opts.mode == 't' {
+			printArchiveEntry(term, opts.verbose, header.FileInfo(), header.Name)
+			continue
+		}
+		if opts.verbose {
+			fmt.Fprintln(term.Stdout(), header.Name)
+		}
+		filePath := extractPath(dest, header.Name)
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return err
+			}
+			header.Name = filePath
+			dirs = append(dirs, header) // set attributes after children are written, so times aren't changed
+		case tar.TypeReg:
+			if err := extractFile(archive, filePath, header.FileInfo().Mode(), header.ModTime); err != nil {
+				return err
+			}
+			if opts.sameOwner {
+				if err := os.Chown(filePath, header.Uid, header.Gid); err != nil {
+					return err
+				}
+			}
+		default:
+			fmt.Fprintf(term.Stderr(), "tar: Skipping unsupported file type: %s\n", header.Name)
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		header := dirs[i]
+		if opts.sameOwner {
+			if err := os.Chown(header.Name, header.Uid, header.Gid); err != nil {
+				return err
+			}
+		}
+		if err := applyExtractedAttrs(header.Name, header.FileInfo().Mode(), header.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesArchivePaths returns true if 'name' is one of 'paths' or inside one of them. Empty 'paths' matches everything.
+func matchesArchivePaths(name string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	name = archiveName(name)
+	for _, p := range paths {
+		p = archiveName(p)
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// printArchiveEntry prints the entry's name, or with 'verbose' its mode, size, and modified time too
+func printArchiveEntry(term console.Console, verbose bool, info os.FileInfo, name string) {
+	if !verbose {
+		fmt.Fprintln(term.Stdout(), name)
+		return
+	}
+	fmt.Fprintf(term.Stdout(), "%s %10d %s %s\n", info.Mode(), info.Size(), info.ModTime().Format("2006-01-02 15:04"), name)
+}
+
+func gzipBuiltin(term console.Console, args ...string) error {
+	return runGzip(term, "gzip", false, args)
+}
+
+func gunzip(term console.Console, args ...string) error {
+	return runGzip(term, "gunzip", true, args)
+}
+
+// runGzip compresses or decompresses each file in place, replacing 'file' with 'file.gz' or vice versa.
+// Without files, copies stdin to stdout.
+func runGzip(term console.Console, name string, decompress bool, args []string) error {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	set.BoolVar(&decompress, "d", decompress, "Decompress")
+	toStdout := set.Bool("c", false, "Write to stdout and keep the original files")
+	keep := set.Bool("k", false, "Keep the original files")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	if set.NArg() == 0 {
+		return gzipStream(term.Stdout(), getConsoleStdin(term), decompress, nil)
+	}
+	for _, src := range set.Args() {
+		if err := gzipFile(term, src, decompress, *toStdout, *keep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipStream compresses or decompresses 'r' into 'w'. Compressed streams are labeled with 'info', if set.
+func gzipStream(w io.Writer, r io.Reader, decompress bool, info os.FileInfo) error {
+	if decompress {
+		decompressor, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer decompressor.Close()
+		_, err = io.Copy(w, decompressor)
+		return err
+	}
+	compressor := gzip.NewWriter(w)
+	if info != nil {
+		compressor.Name = info.Name()
+		compressor.ModTime = info.ModTime()
+	}
+	if _, err := io.Copy(compressor, r); err != nil {
+		return err
+	}
+	return compressor.Close()
+}
+
+func gzipFile(term console.Console, src string, decompress, toStdout, keep bool) (returnedErr error) {
+	const suffix = ".gz"
+	dest := src + suffix
+	if decompress {
+		if !strings.HasSuffix(src, suffix) {
+			return errors.Errorf("%s: unknown suffix", src)
+		}
+		dest = strings.TrimSuffix(src, suffix)
+	} else if strings.HasSuffix(src, suffix) {
+		return errors.Errorf("%s already has %s suffix", src, suffix)
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.Errorf("%s: Is a directory", src)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if toStdout {
+		return gzipStream(term.Stdout(), in, decompress, info)
+	}
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = gzipStream(out, in, decompress, info)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dest)
+		return err
+	}
+	if err := preserveAttrs(dest, info); err != nil {
+		return err
+	}
+	if keep {
+		return nil
+	}
+	return os.Remove(src)
+}
+
+// zipBuiltin creates a zip archive. Existing archives are replaced, not updated.
+func zipBuiltin(term console.Console, args ...string) (returnedErr error) {
+	set := flag.NewFlagSet("zip", flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	recursive := set.Bool("r", false, "Add directories recursively")
+	quiet := set.Bool("q", false, "Don't print the names of added files")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if set.NArg() < 2 {
+		return errors.New("Usage: zip [-rq] archive.zip file...")
+	}
+	archivePath, paths := set.Arg(0), set.Args()[1:]
+	if path.Ext(archivePath) == "" {
+		archivePath += ".zip"
+	}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && returnedErr == nil {
+			returnedErr = err
+		}
+	}()
+	archive := zip.NewWriter(f)
+	err = walkArchivePaths(term, "", paths, *recursive, func(filePath, name string, info os.FileInfo) error {
+		if path.Clean(filePath) == path.Clean(archivePath) {
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = archiveName(name)
+		header.Method = zip.Deflate
+		if info.IsDir() {
+			header.Name += "/"
+			header.Method = zip.Store
+		}
+		if !*quiet {
+			fmt.Fprintf(term.Stdout(), "  adding: %s\n", header.Name)
+		}
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		in, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, in)
+		in.Close()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// unzip extracts or lists a zip archive
+func unzip(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("unzip", flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	list := set.Bool("l", false, "List files instead of extracting them")
+	quiet := set.Bool("q", false, "Don't print the names of extracted files")
+	dest := set.String("d", ".", "Directory to extract files into")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if set.NArg() < 1 {
+		return errors.New("Usage: unzip [-lq] [-d dir] archive.zip [file...]")
+	}
+	archivePath, paths := set.Arg(0), set.Args()[1:]
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		var t table
+		t.Align(rightAlign, leftAlign, leftAlign)
+		t.Add("Length", "Date", "Name")
+		var total uint64
+		for _, file := range archive.File {
+			if !matchesArchivePaths(file.Name, paths) {
+				continue
+			}
+			t.Add(file.UncompressedSize64, file.Modified.Format("2006-01-02 15:04"), file.Name)
+			total += file.UncompressedSize64
+		}
+		fmt.Fprint(term.Stdout(), t)
+		fmt.Fprintf(term.Stdout(), "%d bytes total\n", total)
+		return nil
+	}
+
+	var dirs []*zip.File
+	for _, file := range archive.File {
+		if err := jobFromConsole(term).checkpoint(); err != nil {
+			return err
+		}
+		if !matchesArchivePaths(file.Name, paths) {
+			continue
+		}
+		filePath := extractPath(*dest, file.Name)
+		if file.FileInfo().IsDir() {
+			if !*quiet {
+				fmt.Fprintf(term.Stdout(), "   creating: %s/\n", filePath)
+			}
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, file) // set attributes after children are written, so times aren't changed
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			fmt.Fprintf(term.Stderr(), "unzip: Skipping unsupported file type: %s\n", file.Name)
+			continue
+		}
+		if !*quiet {
+			fmt.Fprintf(term.Stdout(), "  inflating: %s\n", filePath)
+		}
+		r, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(r, filePath, file.Mode(), file.Modified)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		file := dirs[i]
+		if err := applyExtractedAttrs(extractPath(*dest, file.Name), file.Mode(), file.Modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveBuiltins(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "tar create and list",
+			line:        `mkdir d d/sub; echo a > d/f; echo b > d/sub/g; tar cf out.tar d; tar tf out.tar`,
+			expectOut:   "d/\nd/f\nd/sub/\nd/sub/g\n",
+		},
+		{
+			description: "tar gzip extract",
+			line:        `mkdir d; echo a > d/f; tar -czf out.tar.gz d; rm -r d; tar xzf out.tar.gz; cat d/f`,
+			expectOut:   "a\n",
+		},
+		{
+			description: "tar detects gzip",
+			line:        `mkdir d; echo a > d/f; tar czf out.tgz d; mkdir dest; tar -x -f out.tgz -C dest; cat dest/d/f`,
+			expectOut:   "a\n",
+		},
+		{
+			description: "tar create relative to directory",
+			line:        `mkdir d; echo a > d/f; tar -C d -cf out.tar f; tar tf out.tar`,
+			expectOut:   "f\n",
+		},
+		{
+			description: "tar pipe",
+			line:        `mkdir d; echo a > d/f; tar cz d | tar tv | grep -c d/f`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "tar extract selected paths",
+			line:        `mkdir d; echo a > d/f; echo b > d/g; tar cf out.tar d; rm -r d; tar xvf out.tar d/g; ls d`,
+			expectOut:   "d/g\ng\n",
+		},
+		{
+			description: "tar requires mode",
+			line:        `tar f out.tar`,
+			expectCode:  1,
+		},
+		{
+			description: "gzip and gunzip",
+			line:        `echo hello > f; gzip f; ls; gunzip f.gz; ls; cat f`,
+			expectOut:   "f.gz\nf\nhello\n",
+		},
+		{
+			description: "gzip keep",
+			line:        `echo hello > f; gzip -k f; ls`,
+			expectOut:   "f\nf.gz\n",
+		},
+		{
+			description: "gzip stream",
+			line:        `echo hello | gzip | gunzip`,
+			expectOut:   "hello\n",
+		},
+		{
+			description: "gzip to stdout",
+			line:        `echo hello > f; gzip -c f | gzip -d; ls`,
+			expectOut:   "hello\nf\n",
+		},
+		{
+			description: "gunzip unknown suffix",
+			line:        `echo hello > f; gunzip f`,
+			expectCode:  1,
+		},
+		{
+			description: "zip and unzip",
+			line:        `mkdir d d/sub; echo a > d/f; echo b > d/sub/g; zip -r out d; rm -r d; unzip out.zip; cat d/f d/sub/g`,
+			expectOut: `  adding: d/
+  adding: d/f
+  adding: d/sub/
+  adding: d/sub/g
+   creating: d/
+  inflating: d/f
+   creating: d/sub/
+  inflating: d/sub/g
+a
+b
+`,
+		},
+		{
+			description: "zip without recursion",
+			line:        `mkdir d; echo a > d/f; zip -q out.zip d; unzip -l out.zip | grep -c d/f`,
+			expectOut:   "0\n",
+			expectCode:  1,
+		},
+		{
+			description: "unzip into directory",
+			line:        `echo a > f; zip -q out.zip f; unzip -q -d dest out.zip; cat dest/f`,
+			expectOut:   "a\n",
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			chdirTemp(t)
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
+
+func TestTarOwners(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing owners requires root")
+	}
+	chdirTemp(t)
+	require.NoError(t, os.Mkdir("d", 0755))
+	require.NoError(t, ioutil.WriteFile("d/f", []byte("a"), 0644))
+	require.NoError(t, os.Chown("d", 1234, 5678))
+	require.NoError(t, os.Chown("d/f", 1234, 5678))
+	owner := func(path string) [2]uint32 {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		return [2]uint32{stat.Uid, stat.Gid}
+	}
+
+	_, code := runTestLine(t, `tar cf out.tar d; mkdir same other; tar xpf out.tar -C same; tar xf out.tar -C other`)
+	require.Equal(t, 0, code)
+	assert.Equal(t, [2]uint32{1234, 5678}, owner("same/d"))
+	assert.Equal(t, [2]uint32{1234, 5678}, owner("same/d/f"))
+	current := [2]uint32{uint32(os.Getuid()), uint32(os.Getgid())}
+	assert.Equal(t, current, owner("other/d"), "Owners should only be restored with -p")
+	assert.Equal(t, current, owner("other/d/f"), "Owners should only be restored with -p")
+}
+
+func TestExtractPath(t *testing.T) {
+	for _, tc := range []struct {
+		dest, name string
+		expect     string
+	}{
+		{dest: "dest", name: "f", expect: "dest/f"},
+		{dest: "dest", name: "d/", expect: "dest/d"},
+		{dest: "dest", name: "../f", expect: "dest/f"},
+		{dest: "dest", name: "/abs/../../f", expect: "dest/f"},
+		{dest: ".", name: "a/./b", expect: "a/b"},
+	} {
+		assert.Equal(t, tc.expect, extractPath(tc.dest, tc.name), "name: %s", tc.name)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func formatArithm(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
+
+func boolArithm(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// evalArithm evaluates an arithmetic expression, like the contents of $((...))
+func evalArithm(term console.Console, expr syntax.ArithmExpr) (int64, error) {
+	switch expr := expr.(type) {
+	case *syntax.Word:
+		value, err := evalWord(term, expr.Parts)
+		if err != nil {
+			return 0, err
+		}
+		return parseArithmValue(term, value)
+	case *syntax.ParenArithm:
+		return evalArithm(term, expr.X)
+	case *syntax.UnaryArithm:
+		return evalUnaryArithm(term, expr)
+	case *syntax.BinaryArithm:
+		return evalBinaryArithm(term, expr)
+	default:
+		return 0, errors.Errorf("Unrecognized arithmetic expression: %T %v", expr, expr)
+	}
+}
+
+const maxArithmRecursion = 1024
+
+// parseArithmValue parses a number, a variable name, or an expression, like the value of a variable or a quoted 'let' argument
+func parseArithmValue(term console.Console, value string) (int64, error) {
+	for i := 0; i < maxArithmRecursion; i++ {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return 0, nil
+		}
+		if !validVarName.MatchString(value) {
+			break
+		}
+		value, _ = lookupVar(term, value)
+	}
+	if validVarName.MatchString(value) {
+		return 0, errors.Errorf("%s: expression recursion level exceeded", value)
+	}
+
+	number, err := strconv.ParseInt(value, 0, 64)
+	if err == nil {
+		return number, nil
+	}
+	expr, err := parseArithmExpr(value)
+	if err != nil {
+		return 0, errors.Errorf("%s: syntax error: invalid arithmetic operand", value)
+	}
+	return evalArithm(term, expr)
+}
+
+// parseArithmExpr parses 'expr' as the contents of '((...))'
+func parseArithmExpr(expr string) (syntax.ArithmExpr, error) {
+	file, err := syntax.NewParser().Parse(strings.NewReader("(("+expr+"))"), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Stmts) == 1 {
+		if cmd, ok := file.Stmts[0].Cmd.(*syntax.ArithmCmd); ok {
+			return cmd.X, nil
+		}
+	}
+	return nil, errors.Errorf("%s: invalid arithmetic expression", expr)
+}
+
+// arithmVarName returns the variable name assigned to by ++, --, =, +=, etc.
+func arithmVarName(expr syntax.ArithmExpr) (string, error) {
+	word, ok := expr.(*syntax.Word)
+	if ok {
+		if name := word.Lit(); validVarName.MatchString(name) {
+			return name, nil
+		}
+	}
+	return "", errors.New("attempted assignment to non-variable")
+}
+
+func evalUnaryArithm(term console.Console, expr *syntax.UnaryArithm) (int64, error) {
+	switch expr.Op {
+	case syntax.Inc, syntax.Dec:
+		name, err := arithmVarName(expr.X)
+		if err != nil {
+			return 0, err
+		}
+		oldValue, err := evalArithm(term, expr.X)
+		if err != nil {
+			return 0, err
+		}
+		newValue := oldValue + 1
+		if expr.Op == syntax.Dec {
+			newValue = oldValue - 1
+		}
+		if err := setVar(term, name, formatArithm(newValue)); err != nil {
+			return 0, err
+		}
+		if expr.Post {
+			return oldValue, nil
+		}
+		return newValue, nil
+	}
+
+	value, err := evalArithm(term, expr.X)
+	if err != nil {
+		return 0, err
+	}
+	switch expr.Op {
+	case syntax.Not:
+		return boolArithm(value == 0), nil
+	case syntax.BitNegation:
+		return ^value, nil
+	case syntax.Plus:
+		return value, nil
+	case syntax.Minus:
+		return -value, nil
+	default:
+		return 0, errors.Errorf("Unsupported arithmetic operator: %s", expr.Op)
+	}
+}
+
+func evalBinaryArithm(term console.Console, expr *syntax.BinaryArithm) (int64, error) {
+	switch expr.Op {
+	case syntax.AndArit, syntax.OrArit: // short-circuit
+		x, err := evalArithm(term, expr.X)
+		if err != nil {
+			return 0, err
+		}
+		if (x != 0) == (expr.Op == syntax.OrArit) {
+			return boolArithm(x != 0), nil
+		}
+		y, err := evalArithm(term, expr.Y)
+		return boolArithm(y != 0), err
+	case syntax.TernQuest:
+		branches, ok := expr.Y.(*syntax.BinaryArithm)
+		if !ok || branches.Op != syntax.TernColon {
+			return 0, errors.New("ternary operator missing ':'")
+		}
+		cond, err := evalArithm(term, expr.X)
+		if err != nil {
+			return 0, err
+		}
+		if cond != 0 {
+			return evalArithm(term, branches.X)
+		}
+		return evalArithm(term, branches.Y)
+	case syntax.Assgn, syntax.AddAssgn, syntax.SubAssgn, syntax.MulAssgn, syntax.QuoAssgn, syntax.RemAssgn,
+		syntax.AndAssgn, syntax.OrAssgn, syntax.XorAssgn, syntax.ShlAssgn, syntax.ShrAssgn:
+		return evalAssignArithm(term, expr)
+	}
+
+	x, err := evalArithm(term, expr.X)
+	if err != nil {
+		return 0, err
+	}
+	y, err := evalArithm(term, expr.Y)
+	if err != nil {
+		return 0, err
+	}
+	return applyArithm(expr.Op, x, y)
+}
+
+func evalAssignArithm(term console.Console, expr *syntax.BinaryArithm) (int64, error) {
+	name, err := arithmVarName(expr.X)
+	if err != nil {
+		return 0, err
+	}
+	value, err := evalArithm(term, expr.Y)
+	if err != nil {
+		return 0, err
+	}
+	if expr.Op != syntax.Assgn {
+		oldValue, err := evalArithm(term, expr.X)
+		if err != nil {
+			return 0, err
+		}
+		op := map[syntax.BinAritOperator]syntax.BinAritOperator{
+			syntax.AddAssgn: syntax.Add,
+			syntax.SubAssgn: syntax.Sub,
+			syntax.MulAssgn: syntax.Mul,
+			syntax.QuoAssgn: syntax.Quo,
+			syntax.RemAssgn: syntax.Rem,
+			syntax.AndAssgn: syntax.And,
+			syntax.OrAssgn:  syntax.Or,
+			syntax.XorAssgn: syntax.Xor,
+			syntax.ShlAssgn: syntax.Shl,
+			syntax.ShrAssgn: syntax.Shr,
+		}[expr.Op]
+		value, err = applyArithm(op, oldValue, value)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return value, setVar(term, name, formatArithm(value))
+}
+
+func applyArithm(op syntax.BinAritOperator, x, y int64) (int64, error) {
+	switch op {
+	case syntax.Add:
+		return x + y, nil
+	case syntax.Sub:
+		return x - y, nil
+	case syntax.Mul:
+		return x * y, nil
+	case syntax.Quo, syntax.Rem:
+		if y == 0 {
+			return 0, errors.New("division by 0")
+		}
+		if op == syntax.Quo {
+			return x / y, nil
+		}
+		return x % y, nil
+	case syntax.Pow:
+		if y < 0 {
+			return 0, errors.New("exponent less than 0")
+		}
+		result := int64(1)
+		for ; y > 0; y-- {
+			result *= x
+		}
+		return result, nil
+	case syntax.Eql:
+		return boolArithm(x == y), nil
+	case syntax.Neq:
+		return boolArithm(x != y), nil
+	case syntax.Lss:
+		return boolArithm(x < y), nil
+	case syntax.Leq:
+		return boolArithm(x <= y), nil
+	case syntax.Gtr:
+		return boolArithm(x > y), nil
+	case syntax.Geq:
+		return boolArithm(x >= y), nil
+	case syntax.And:
+		return x & y, nil
+	case syntax.Or:
+		return x | y, nil
+	case syntax.Xor:
+		return x ^ y, nil
+	case syntax.Shl:
+		return x << uint64(y), nil
+	case syntax.Shr:
+		return x >> uint64(y), nil
+	case syntax.Comma:
+		return y, nil
+	default:
+		return 0, errors.Errorf("Unsupported arithmetic operator: %s", op)
+	}
+}
+
+// runArithm runs '((...))' and 'let' expressions. Fails if the last expression is 0.
+func runArithm(term console.Console, exprs ...syntax.ArithmExpr) error {
+	var value int64
+	for _, expr := range exprs {
+		var err error
+		value, err = evalArithm(term, expr)
+		if err != nil {
+			return err
+		}
+	}
+	if value == 0 {
+		return &statusErr{code: 1}
+	}
+	return nil
+}
+
+func runCStyleFor(term console.Console, line string, loop *syntax.CStyleLoop, body []*syntax.Stmt) error {
+	if loop.Init != nil {
+		if _, err := evalArithm(term, loop.Init); err != nil {
+			return err
+		}
+	}
+	var lastErr error
+	for {
+		if loop.Cond != nil {
+			cond, err := evalArithm(term, loop.Cond)
+			if err != nil {
+				return err
+			}
+			if cond == 0 {
+				return lastErr
+			}
+		}
+		stop, loopErr := loopControl(runStmts(term, line, body))
+		if stop {
+			return loopErr
+		}
+		lastErr = loopErr
+		if loop.Post != nil {
+			if _, err := evalArithm(term, loop.Post); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -34,22 +34,27 @@ func init() {
 		"echo":  echo,
 		"env":   env,
 		"exit":  exit,
+		"false": falseBuiltin,
 		"ls":    ls,
 		"mkdir": mkdir,
 		"mv":    mv,
 		"pwd":   pwd,
 		"rm":    rm,
 		"rmdir": rmdir,
+		"test":  test,
 		"touch": touch,
+		"true":  trueBuiltin,
 		"which": which,
+		"[":     testBracket,
+		":":     trueBuiltin,
 	} {
 		builtins[k] = v
 	}
 }
 
 func echo(term console.Console, args ...string) error {
-	fmt.Fprintln(term.Stdout(), strings.Join(args, " "))
-	return nil
+	_, err := fmt.Fprintln(term.Stdout(), strings.Join(args, " "))
+	return err
 }
 
 func pwd(term console.Console, args ...string) error {
@@ -135,7 +140,15 @@ func cd(term console.Console, args ...string) error {
 		if !info.IsDir() {
 			return errors.Errorf("Not a directory: %s", dir)
 		}
-		return os.Chdir(dir)
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		setDir(term, wd)
+		return nil
 	default:
 		return errors.New("Too many args")
 	}
@@ -315,7 +328,7 @@ func env(term console.Console, args ...string) error {
 	}
 
 	if len(args) == 0 {
-		for _, e := range os.Environ() {
+		for _, e := range environ(term) {
 			fmt.Fprintln(term.Stdout(), e)
 		}
 		return nil
@@ -335,10 +348,11 @@ func splitKeyValue(kv string) (key, value string) {
 
 func runWithEnv(term console.Console, env []string, args ...string) error {
 	cmd := exec.Command(args[0], args[1:]...) // nolint:gosec // Running any given process args is the whole point, so this isn't a security issue.
+	cmd.Stdin = getConsoleStdin(term)
 	cmd.Stdout = term.Stdout()
 	cmd.Stderr = term.Stderr()
-	cmd.Env = append(os.Environ(), env...)
-	return runCmd(cmd, cmdOptions{})
+	cmd.Env = append(environ(term), env...)
+	return runCmd(cmd, consoleCmdOptions(term))
 }
 
 func chmod(term console.Console, args ...string) error {
@@ -2,159 +2,118 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
-	"strconv"
-	"strings"
 
 	"github.com/johnstarich/go-wasm/internal/console"
 	"github.com/pkg/errors"
 	"mvdan.cc/sh/v3/syntax"
 )
 
-func runCallExpr(term console.Console, stmt *syntax.Stmt, node *syntax.CallExpr, isPipe bool) error {
+func runCallExpr(term console.Console, stmt *syntax.Stmt, node *syntax.CallExpr) error {
 	var env []string
-	for _, assign := range node.Assigns {
-		key := assign.Name.Value
-		value, err := evalWord(assign.Value.Parts)
-		if err != nil {
-			return err
+	if len(node.Args) > 0 {
+		for _, assign := range node.Assigns {
+			key := assign.Name.Value
+			value, err := evalAssignValue(term, assign)
+			if err != nil {
+				return err
+			}
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
 		}
-		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
 
-	var args []string
-	for _, arg := range node.Args {
-		argStr, err := evalWord(arg.Parts)
-		if err != nil {
-			return err
-		}
-		args = append(args, argStr)
+	args, err := evalFields(term, node.Args)
+	if err != nil {
+		return err
 	}
 	if len(args) == 0 {
-		return errors.New("Setting variables only is not supported")
+		return exitErrFromCmd(assignVars(term, node.Assigns), stmt.Negated)
 	}
 
-	commandName, args := args[0], args[1:]
+	return runArgs(term, stmt, env, args[0], args[1:])
+}
+
+// runDeclClause runs declarations like 'export' and 'local', which can take assignments as arguments
+func runDeclClause(term console.Console, stmt *syntax.Stmt, node *syntax.DeclClause) error {
+	var args []string
+	for _, assign := range node.Args {
+		switch {
+		case assign.Naked && assign.Name == nil: // options like -p
+			fields, err := evalFields(term, []*syntax.Word{assign.Value})
+			if err != nil {
+				return err
+			}
+			args = append(args, fields...)
+		case assign.Naked:
+			args = append(args, assign.Name.Value)
+		default:
+			value, err := evalAssignValue(term, assign)
+			if err != nil {
+				return err
+			}
+			if assign.Append {
+				oldValue, _ := lookupVar(term, assign.Name.Value)
+				value = oldValue + value
+			}
+			args = append(args, assign.Name.Value+"="+value)
+		}
+	}
+	return runArgs(term, stmt, nil, node.Variant.Value, args)
+}
+
+// runArgs runs a function, builtin, or program with the given prefix assignments in 'env'
+func runArgs(term console.Console, stmt *syntax.Stmt, env []string, commandName string, args []string) error {
 	cmd := exec.Command(commandName, args...)
-	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(environ(term), env...)
 	cmd.Stdin = getConsoleStdin(term)
 	cmd.Stdout = term.Stdout()
 	cmd.Stderr = term.Stderr()
 
-	for _, redir := range stmt.Redirs {
-		err := applyRedirection(cmd, redir)
-		if err != nil {
-			return err
-		}
+	traceCommand(term, env, commandName, args)
+	if fn, isFunc := lookupFunc(term, commandName); isFunc {
+		err := withEnv(term, env, func() error {
+			return runFunc(term, fn, args)
+		})
+		return exitErrFromCmd(err, stmt.Negated)
 	}
 
-	err := runCmd(cmd, cmdOptions{
-		Background: stmt.Background,
-		Pipe:       isPipe,
-	})
-	err = exitErrFromCmd(err, stmt.Negated)
-	return err
-
+	err := runCmd(cmd, consoleCmdOptions(term))
+	return exitErrFromCmd(err, stmt.Negated)
 }
 
-func applyRedirection(cmd *exec.Cmd, redir *syntax.Redirect) error {
-	var redirectPtr string
-	var err error
-	switch redir.Op {
-	case syntax.Hdoc, // <<
-		syntax.DashHdoc: // <<-
-		if redir.Hdoc == nil {
-			var word string
-			if redir.Word != nil {
-				word, _ = evalWord(redir.Word.Parts)
-				word = ": " + word
-			}
-			return errors.New("Invalid heredoc" + word)
-		}
-		redirectPtr, err = evalWord(redir.Hdoc.Parts)
-	case syntax.WordHdoc: // <<<
-		redirectPtr, err = evalWord(redir.Word.Parts)
-	default:
-		redirectPtr, err = evalWord(redir.Word.Parts)
+func evalAssignValue(term console.Console, assign *syntax.Assign) (string, error) {
+	if assign.Array != nil || assign.Index != nil {
+		return "", errors.Errorf("%s: arrays are not supported", assign.Name.Value)
 	}
-	if err != nil {
-		return err
+	if assign.Value == nil {
+		return "", nil
 	}
+	return evalWord(term, assign.Value.Parts)
+}
 
-	var fd int
-	switch redir.Op {
-	case syntax.RdrOut, // >
-		syntax.AppOut, // >>
-		syntax.RdrAll, // &>
-		syntax.AppAll: // &>>
-		fd = 1
-	default:
-		fd = 0
-	}
-	if redir.N != nil {
-		fdStr := redir.N.Value
-		parsedFD, err := strconv.ParseUint(fdStr, 10, 64)
-		if err != nil {
-			return err
-		}
-		fd = int(parsedFD)
-	}
-
-	switch redir.Op {
-	case syntax.RdrOut, // >
-		syntax.AppOut, // >>
-		syntax.RdrAll, // &>
-		syntax.AppAll: // &>>
-		if fd == 0 {
-			return errors.New("Can't redirect stdin to an output file")
-		}
-
-		flag := os.O_WRONLY | os.O_CREATE
-		if redir.Op == syntax.AppOut || redir.Op == syntax.AppAll {
-			flag |= os.O_APPEND
-		} else {
-			flag |= os.O_TRUNC
-		}
-		file, err := os.OpenFile(redirectPtr, flag, 0700)
+// assignVars sets shell variables for a command with only assignments, like 'a=1 b=2'.
+// Fails with the exit status of the last command substitution, if any.
+func assignVars(term console.Console, assigns []*syntax.Assign) error {
+	setLastStatus(term, 0)
+	for _, assign := range assigns {
+		value, err := evalAssignValue(term, assign)
 		if err != nil {
 			return err
 		}
-
-		switch fd {
-		case 1:
-			cmd.Stdout = file
-		case 2:
-			cmd.Stderr = file
-		default:
-			cmd.ExtraFiles = append(cmd.ExtraFiles, file)
-		}
-	case syntax.RdrIn: // <
-		if fd != 0 {
-			return errors.New("Can't redirect non-stdin to an input file")
-		}
-
-		file, err := os.OpenFile(redirectPtr, os.O_RDONLY, 0)
-		if err != nil {
+		if err := assignVar(term, assign.Name.Value, value, assign.Append); err != nil {
 			return err
 		}
-
-		cmd.Stdin = file
-	case syntax.Hdoc, // <<
-		syntax.DashHdoc, // <<-
-		syntax.WordHdoc: // <<<
-		file := strings.NewReader(redirectPtr)
-		if fd != 0 {
-			return errors.New("Can't redirect non-stdin to an input file")
-		}
-		cmd.Stdin = file
-	default:
-		return errors.Errorf("File redirect of type %q are not supported", redir.Op.String())
+	}
+	if code := getLastStatus(term); code != 0 {
+		return &statusErr{code: code}
 	}
 	return nil
 }
 
 func exitErrFromCmd(err error, negated bool) error {
+	if isControlFlow(err) {
+		return err
+	}
 	code := exitCodeFromCmd(err, negated)
 	if code == 0 {
 		return nil
@@ -162,7 +121,7 @@ func exitErrFromCmd(err error, negated bool) error {
 	if err != nil {
 		return err
 	}
-	return errors.New("Negated return code")
+	return &statusErr{code: code}
 }
 
 // exitCodeFromCmd tries to produce an exit code for the given error.
@@ -176,11 +135,18 @@ func exitCodeFromErr(err error) int {
 	if err == nil {
 		return 0
 	}
-	exitErr, ok := err.(*exec.ExitError)
-	if !ok {
+	switch err := errors.Cause(err).(type) {
+	case *exec.ExitError:
+		return err.ExitCode()
+	case *statusErr:
+		return err.code
+	case *interruptErr:
+		return interruptExitCode
+	case *ExitErr:
+		return err.Code
+	default:
 		return 1
 	}
-	return exitErr.ExitCode()
 }
 
 func negateExitCode(code int, negated bool) int {
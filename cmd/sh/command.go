@@ -6,7 +6,6 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,150 +14,113 @@ import (
 	"mvdan.cc/sh/v3/syntax"
 )
 
-const (
-	homeTilde = "~"
-)
-
 func runLine(term console.Console, line string) error {
 	parser := syntax.NewParser()
-	var cmdErr error
+	var stmts []*syntax.Stmt
 	err := parser.Stmts(strings.NewReader(line), func(stmt *syntax.Stmt) bool {
-		cmdErr = runCommand(term, line, stmt, false)
-		return cmdErr == nil
+		stmts = append(stmts, stmt)
+		return true
 	})
 	if err != nil {
 		return err
 	}
-	if cmdErr != nil {
-		return cmdErr
-	}
 	if parser.Incomplete() {
 		return errors.New("Incomplete command. Multi-line commands not supported.")
 	}
-	return nil
+	err = runStmts(term, line, stmts)
+	switch errors.Cause(err).(type) {
+	case *breakErr, *continueErr:
+		return nil // ignored outside of loops
+	default:
+		return err
+	}
 }
 
-func evalWord(parts []syntax.WordPart) (string, error) {
-	s := ""
-	for ix, part := range parts {
-		switch part := part.(type) {
-		case *syntax.Lit:
-			s += part.Value
-			if ix == 0 && (s == homeTilde || strings.HasPrefix(s, homeTilde+string(filepath.Separator))) {
-				homeDir, err := os.UserHomeDir()
-				if err != nil {
-					return "", err
-				}
-				s = homeDir + s[len(homeTilde):]
-			}
-		case *syntax.SglQuoted:
-			if part.Dollar {
-				return "", errors.Errorf("Dollar single-quotes not supported: %v", part)
-			}
-			s += part.Value
-		case *syntax.DblQuoted:
-			if part.Dollar {
-				return "", errors.Errorf("Dollar single-quotes not supported: %v", part)
-			}
-			dblQuoted, err := evalWord(part.Parts)
-			if err != nil {
-				return "", err
-			}
-			s += dblQuoted
-		case *syntax.ParamExp:
-			name := part.Param.Value
-			if part.Excl || part.Length || part.Width || part.Index != nil || part.Slice != nil || part.Repl != nil || part.Names != 0 || part.Exp != nil {
-				return "", errors.Errorf("Variable expansion type not supported: %s %v", name, part)
-			}
-			s += os.Getenv(name)
-		case *syntax.CmdSubst, *syntax.ArithmExp, *syntax.ProcSubst, *syntax.ExtGlob:
-			return "", errors.Errorf("Unrecognized word part type: %T %v", part, part)
-		default:
-			return "", errors.Errorf("Unrecognized word part type: %T %v", part, part)
-		}
+// runCommand runs the statement's command with its redirections applied
+func runCommand(term console.Console, line string, stmt *syntax.Stmt) error {
+	if len(stmt.Redirs) == 0 {
+		return runStmtCommand(term, line, stmt)
 	}
-	return s, nil
+	files, err := applyRedirects(term, stmt.Redirs)
+	if err != nil {
+		return err
+	}
+	defer files.Close()
+	redirected, err := files.console(term)
+	if err != nil {
+		return err
+	}
+	err = runStmtCommand(redirected, line, stmt)
+	if err == nil || isControlFlow(err) || isStatusErr(err) {
+		return err
+	}
+	// report errors to the redirected stderr, so they can be silenced with '2>/dev/null'
+	printErr(redirected, err)
+	return &statusErr{code: exitCodeFromErr(err)}
 }
 
-func runCommand(term console.Console, line string, stmt *syntax.Stmt, isPipe bool) error {
+func runStmtCommand(term console.Console, line string, stmt *syntax.Stmt) error {
 	switch node := stmt.Cmd.(type) {
 	case *syntax.CallExpr:
-		return runCallExpr(term, stmt, node, isPipe)
+		err := runCallExpr(term, stmt, node)
+		if stmt.Negated {
+			return err
+		}
+		return errExit(term, err)
 	case *syntax.BinaryCmd:
 		switch node.Op {
 		case syntax.AndStmt: // &&
-			err := runCommand(term, line, node.X, false)
+			err := runCommand(withoutErrExit(term), line, node.X)
 			if err != nil {
 				return err
 			}
-			return runCommand(term, line, node.Y, false)
+			setLastStatus(term, 0)
+			return runCommand(term, line, node.Y)
 		case syntax.OrStmt: // ||
-			err := runCommand(term, line, node.X, false)
-			if err == nil {
-				return nil
-			}
-			return runCommand(term, line, node.Y, false)
-		case syntax.Pipe: // |
-			r, w, err := os.Pipe()
-			if err != nil {
+			err := runCommand(withoutErrExit(term), line, node.X)
+			if err == nil || isControlFlow(err) {
 				return err
 			}
-			leftTerm := &redirectConsole{
-				stdin:  getConsoleStdin(term),
-				stdout: w,
-				stderr: term.Stderr(),
-			}
-			rightTerm := &redirectConsole{
-				stdin:  r,
-				stdout: term.Stdout(),
-				stderr: term.Stderr(),
-			}
-			errChan := make(chan error, 1)
-			go func() {
-				errChan <- runCommand(rightTerm, line, node.Y, true)
-			}()
-			err = runCommand(leftTerm, line, node.X, false)
-			if err != nil {
-				return err
-			}
-			w.Close()
-			return <-errChan
-		case syntax.PipeAll: // |&
-			r, w, err := os.Pipe()
-			if err != nil {
-				return err
-			}
-			leftTerm := &redirectConsole{
-				stdin:  getConsoleStdin(term),
-				stdout: w,
-				stderr: w,
-			}
-			rightTerm := &redirectConsole{
-				stdin:  r,
-				stdout: term.Stdout(),
-				stderr: term.Stderr(),
-			}
-			errChan := make(chan error, 1)
-			go func() {
-				errChan <- runCommand(rightTerm, line, node.Y, true)
-			}()
-			err = runCommand(leftTerm, line, node.X, false)
-			if err != nil {
-				return err
-			}
-			return <-errChan
+			printErr(term, err)
+			setLastStatus(term, exitCodeFromErr(err))
+			return runCommand(term, line, node.Y)
+		case syntax.Pipe, syntax.PipeAll: // | and |&
+			return errExit(term, exitErrFromCmd(runPipe(term, line, node), stmt.Negated))
 		default:
 			return errors.Errorf("Unknown binary operator: %v", node.Op)
 		}
 
 	case *syntax.TimeClause:
 		start := time.Now()
-		err := runCommand(term, line, node.Stmt, false)
+		err := runCommand(term, line, node.Stmt)
 		duration := time.Since(start)
 		fmt.Fprintf(term.Stdout(), "\n%s\t %v total\n", formatStmt(line, node.Stmt), duration)
 		return err
 
-	case *syntax.IfClause, *syntax.WhileClause, *syntax.ForClause, *syntax.CaseClause, *syntax.Block, *syntax.Subshell, *syntax.FuncDecl, *syntax.ArithmCmd, *syntax.TestClause, *syntax.DeclClause, *syntax.LetClause, *syntax.CoprocClause:
+	case *syntax.IfClause:
+		return exitErrFromCmd(runIf(term, line, node), stmt.Negated)
+	case *syntax.WhileClause:
+		return exitErrFromCmd(runWhile(term, line, node), stmt.Negated)
+	case *syntax.ForClause:
+		return exitErrFromCmd(runFor(term, line, node), stmt.Negated)
+	case *syntax.CaseClause:
+		return exitErrFromCmd(runCase(term, line, node), stmt.Negated)
+	case *syntax.Block:
+		return exitErrFromCmd(runStmts(term, line, node.Stmts), stmt.Negated)
+	case *syntax.Subshell:
+		return exitErrFromCmd(runSubshell(term, line, node.Stmts), stmt.Negated)
+	case *syntax.FuncDecl:
+		return declareFunc(term, line, node)
+
+	case *syntax.ArithmCmd:
+		return exitErrFromCmd(runArithm(term, node.X), stmt.Negated)
+	case *syntax.LetClause:
+		return exitErrFromCmd(runArithm(term, node.Exprs...), stmt.Negated)
+
+	case *syntax.DeclClause:
+		return runDeclClause(term, stmt, node)
+
+	case *syntax.TestClause, *syntax.CoprocClause:
 		return errors.Errorf("Unimplemented statement type: %T %v", stmt.Cmd, stmt.Cmd)
 	default:
 		return errors.Errorf("Unknown statement type: %T %v", stmt.Cmd, stmt.Cmd)
@@ -166,12 +128,63 @@ func runCommand(term console.Console, line string, stmt *syntax.Stmt, isPipe boo
 }
 
 func formatStmt(source string, s *syntax.Stmt) string {
-	return source[s.Pos().Offset():s.End().Offset()]
+	start, end := s.Pos().Offset(), s.End().Offset()
+	if end <= uint(len(source)) && start <= end {
+		return source[start:end]
+	}
+	// statements from command substitutions don't have their source line, so print them instead
+	var buf strings.Builder
+	_ = syntax.NewPrinter().Print(&buf, s)
+	return buf.String()
 }
 
 type cmdOptions struct {
-	Background bool
-	Pipe       bool
+	Job        *job          // the job running 'cmd', which can be interrupted
+	State      *shellState   // the shell state builtins run with
+	ExtraFiles []interface{} // descriptors above 2 from redirections. Processes can only be passed files.
+}
+
+// consoleCmdOptions returns the options to run a command in 'term's job, shell state, and redirections
+func consoleCmdOptions(term console.Console) cmdOptions {
+	return cmdOptions{
+		Job:        jobFromConsole(term),
+		State:      stateFromConsole(term),
+		ExtraFiles: consoleExtraFiles(term),
+	}
+}
+
+// runPipe runs both sides of a pipeline concurrently. Fails with the right side's exit status, or with 'pipefail' the last non-zero status.
+func runPipe(term console.Console, line string, node *syntax.BinaryCmd) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	leftStderr := term.Stderr()
+	if node.Op == syntax.PipeAll {
+		leftStderr = w
+	}
+	leftTerm := withoutErrExit(redirectTerm(term, getConsoleStdin(term), w, leftStderr))
+	rightTerm := withoutErrExit(redirectTerm(term, r, term.Stdout(), term.Stderr()))
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- runCommand(rightTerm, line, node.Y)
+		r.Close()
+	}()
+	leftErr := runCommand(leftTerm, line, node.X)
+	w.Close()
+	rightErr := <-errChan
+	switch {
+	case isControlFlow(leftErr):
+		return leftErr
+	case rightErr != nil:
+		return rightErr
+	case shellOption("pipefail"):
+		printErr(term, leftErr)
+		return exitErrFromCmd(leftErr, false)
+	default:
+		printErr(term, leftErr)
+		return nil
+	}
 }
 
 func runCmd(cmd *exec.Cmd, options cmdOptions) error {
@@ -187,37 +200,28 @@ func runCmd(cmd *exec.Cmd, options cmdOptions) error {
 	commandName, args := args[0], args[1:]
 
 	builtin, isBuiltin := builtins[commandName]
-	if options.Pipe || !isBuiltin {
-		if options.Background {
-			return cmd.Start()
-		} else {
-			return cmd.Run()
+	if !isBuiltin {
+		extraFiles, err := processExtraFiles(options.ExtraFiles)
+		if err != nil {
+			return err
 		}
+		cmd.ExtraFiles = extraFiles
+		return runProcess(cmd, options.Job)
 	}
 
-	var oldKV, unsetKV []string
-	// override env for builtin
-	for _, pair := range cmd.Env {
-		key, value := splitKeyValue(pair)
-		if oldValue, isSet := os.LookupEnv(key); isSet {
-			oldKV = append(oldKV, key+"="+oldValue)
-		} else {
-			unsetKV = append(unsetKV, key)
-		}
-		os.Setenv(key, value)
+	builtinTerm := &redirectConsole{
+		stdin:      cmd.Stdin,
+		stdout:     cmd.Stdout,
+		stderr:     cmd.Stderr,
+		job:        options.Job,
+		state:      options.State,
+		extraFiles: options.ExtraFiles,
 	}
-	err := builtin(&redirectConsole{
-		stdin:  cmd.Stdin,
-		stdout: cmd.Stdout,
-		stderr: cmd.Stderr,
-	}, args...)
-	// restore env
-	for _, pair := range oldKV {
-		key, value := splitKeyValue(pair)
-		os.Setenv(key, value)
-	}
-	for _, key := range unsetKV {
-		os.Unsetenv(key)
+	err := withEnv(builtinTerm, cmd.Env, func() error {
+		return builtin(builtinTerm, args...)
+	})
+	if isControlFlow(err) || isStatusErr(err) {
+		return err
 	}
 	return errors.Wrap(err, commandName)
 }
@@ -225,6 +229,26 @@ func runCmd(cmd *exec.Cmd, options cmdOptions) error {
 type redirectConsole struct {
 	stdin          io.Reader
 	stdout, stderr io.Writer
+	job            *job
+	state          *shellState   // variables and functions for commands, or nil for the top-level shell's
+	noErrExit      bool          // true if failed commands shouldn't exit the shell when 'errexit' is set
+	extraFiles     []interface{} // file descriptors above 2 from redirections, where entry i is descriptor 3+i
+}
+
+// redirectTerm returns a console in the same job as 'term', with the given standard files
+func redirectTerm(term console.Console, stdin io.Reader, stdout, stderr io.Writer) *redirectConsole {
+	redirected := &redirectConsole{
+		stdin:     stdin,
+		stdout:    stdout,
+		stderr:    stderr,
+		job:       jobFromConsole(term),
+		noErrExit: noErrExit(term),
+	}
+	if c, ok := term.(*redirectConsole); ok {
+		redirected.state = c.state
+		redirected.extraFiles = c.extraFiles
+	}
+	return redirected
 }
 
 func (c *redirectConsole) Stdin() io.Reader {
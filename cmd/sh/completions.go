@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/johnstarich/go-wasm/internal/console"
 	"github.com/johnstarich/go-wasm/log"
 	"mvdan.cc/sh/v3/syntax"
 )
@@ -14,8 +15,8 @@ type Completion struct {
 	Start, End int
 }
 
-func getCompletions(line string, cursor int) []Completion {
-	completions, err := getCompletionsErr(line, cursor)
+func getCompletions(term console.Console, line string, cursor int) []Completion {
+	completions, err := getCompletionsErr(term, line, cursor)
 	if err != nil {
 		log.Error("Failed completions: ", err)
 		return nil
@@ -23,7 +24,7 @@ func getCompletions(line string, cursor int) []Completion {
 	return completions
 }
 
-func getCompletionsErr(line string, cursor int) ([]Completion, error) {
+func getCompletionsErr(term console.Console, line string, cursor int) ([]Completion, error) {
 	parser := syntax.NewParser()
 	var stmts []*syntax.Stmt
 	err := parser.Stmts(strings.NewReader(line), func(stmt *syntax.Stmt) bool {
@@ -54,11 +55,11 @@ func getCompletionsErr(line string, cursor int) ([]Completion, error) {
 		return nil, err
 	}
 
-	commandWordStr, err := evalWord(commandWord.Parts)
+	commandWordStr, err := evalWord(term, commandWord.Parts)
 	if err != nil {
 		return nil, err
 	}
-	cursorWordStr, err := evalWord(cursorWord.Parts)
+	cursorWordStr, err := evalWord(term, cursorWord.Parts)
 	if err != nil {
 		return nil, err
 	}
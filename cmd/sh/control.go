@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/pattern"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// shellFunc is a function declared with 'name() { ... }'
+type shellFunc struct {
+	source string // the line the function was declared in, for formatting its statements
+	body   *syntax.Stmt
+}
+
+func init() {
+	for k, v := range map[string]builtinFunc{
+		"break":    breakBuiltin,
+		"continue": continueBuiltin,
+		"return":   returnBuiltin,
+	} {
+		builtins[k] = v
+	}
+}
+
+// breakErr exits the enclosing loop, or 'levels' enclosing loops
+type breakErr struct {
+	levels int
+}
+
+func (e *breakErr) Error() string {
+	return "break: only meaningful in a loop"
+}
+
+// continueErr resumes the next iteration of the enclosing loop, or the loop 'levels' out
+type continueErr struct {
+	levels int
+}
+
+func (e *continueErr) Error() string {
+	return "continue: only meaningful in a loop"
+}
+
+// returnErr exits the current function with an exit status
+type returnErr struct {
+	code    int
+	useLast bool // return the exit status of the last command run
+}
+
+func (e *returnErr) Error() string {
+	return "return: can only return from a function"
+}
+
+// isControlFlow returns true if 'err' should stop a list of statements, rather than indicate a failed command
+func isControlFlow(err error) bool {
+	switch errors.Cause(err).(type) {
+	case *breakErr, *continueErr, *returnErr, *ExitErr, *interruptErr:
+		return true
+	default:
+		return false
+	}
+}
+
+func parseLevels(name string, args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	levels, err := strconv.Atoi(args[0])
+	if err != nil || levels < 1 {
+		return 0, errors.Errorf("%s: %s: loop count out of range", name, args[0])
+	}
+	return levels, nil
+}
+
+func breakBuiltin(term console.Console, args ...string) error {
+	levels, err := parseLevels("break", args)
+	if err != nil {
+		return err
+	}
+	return &breakErr{levels: levels}
+}
+
+func continueBuiltin(term console.Console, args ...string) error {
+	levels, err := parseLevels("continue", args)
+	if err != nil {
+		return err
+	}
+	return &continueErr{levels: levels}
+}
+
+func returnBuiltin(term console.Console, args ...string) error {
+	if len(args) == 0 {
+		return &returnErr{useLast: true}
+	}
+	code, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errors.Errorf("return: %s: numeric argument required", args[0])
+	}
+	return &returnErr{code: code & 0xFF}
+}
+
+// runStmts runs each statement in order. Failed statements don't stop the list, but are reported to term.
+// Returns the last statement's error, or a control flow error like break or exit.
+func runStmts(term console.Console, line string, stmts []*syntax.Stmt) error {
+	var lastErr error
+	for _, stmt := range stmts {
+		if lastErr != nil {
+			printErr(term, lastErr)
+		}
+		if err := jobFromConsole(term).checkpoint(); err != nil {
+			return err
+		}
+		if stmt.Background {
+			runBackground(term, line, stmt)
+			setLastStatus(term, 0)
+			lastErr = nil
+			continue
+		}
+		err := runCommand(term, line, stmt)
+		if ret, ok := errors.Cause(err).(*returnErr); ok && ret.useLast {
+			err = &returnErr{code: exitCodeFromErr(lastErr)}
+		}
+		if isControlFlow(err) {
+			return err
+		}
+		setLastStatus(term, exitCodeFromErr(err))
+		lastErr = err
+	}
+	return lastErr
+}
+
+// runBackground starts 'stmt' as a background job
+func runBackground(term console.Console, line string, stmt *syntax.Stmt) {
+	command := strings.TrimSpace(strings.TrimSuffix(formatStmt(line, stmt), "&"))
+	subshell, exitSubshell := newSubshell(term)
+	j := startBackgroundJob(subshell, command, func(term console.Console) error {
+		defer exitSubshell()
+		return runCommand(term, line, stmt)
+	})
+	if interactive {
+		fmt.Fprintf(term.Stderr(), "[%d] %s\n", j.id, command)
+	}
+}
+
+// printErr prints a failed command's error message. Exit statuses are skipped, since the command reports its own errors.
+func printErr(term console.Console, err error) {
+	if err == nil || isStatusErr(err) {
+		return
+	}
+	term.Stderr().Write([]byte(color.RedString(err.Error()) + "\n"))
+}
+
+// runCondition runs 'stmts' as an if or while condition. Returns true if the condition succeeded.
+func runCondition(term console.Console, line string, stmts []*syntax.Stmt) (bool, error) {
+	err := runStmts(withoutErrExit(term), line, stmts)
+	if isControlFlow(err) {
+		return false, err
+	}
+	printErr(term, err)
+	return err == nil, nil
+}
+
+func runIf(term console.Console, line string, node *syntax.IfClause) error {
+	for clause := node; clause != nil; clause = clause.Else {
+		if len(clause.Cond) == 0 { // else
+			return runStmts(term, line, clause.Then)
+		}
+		ok, err := runCondition(term, line, clause.Cond)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return runStmts(term, line, clause.Then)
+		}
+	}
+	return nil
+}
+
+// loopControl handles break and continue errors from a loop body. Returns true if the loop should stop, and the error to return from the loop.
+func loopControl(err error) (stop bool, loopErr error) {
+	switch e := errors.Cause(err).(type) {
+	case *breakErr:
+		if e.levels > 1 {
+			return true, &breakErr{levels: e.levels - 1}
+		}
+		return true, nil
+	case *continueErr:
+		if e.levels > 1 {
+			return true, &continueErr{levels: e.levels - 1}
+		}
+		return false, nil
+	case *returnErr, *ExitErr, *interruptErr:
+		return true, err
+	default:
+		return false, err
+	}
+}
+
+func runWhile(term console.Console, line string, node *syntax.WhileClause) error {
+	var lastErr error
+	for {
+		ok, err := runCondition(term, line, node.Cond)
+		if err != nil {
+			stop, loopErr := loopControl(err)
+			if stop {
+				return loopErr
+			}
+			continue
+		}
+		if ok == node.Until {
+			return lastErr
+		}
+		stop, loopErr := loopControl(runStmts(term, line, node.Do))
+		if stop {
+			return loopErr
+		}
+		lastErr = loopErr
+	}
+}
+
+func runFor(term console.Console, line string, node *syntax.ForClause) error {
+	if node.Select {
+		return errors.New("select loops are not supported")
+	}
+	if loop, ok := node.Loop.(*syntax.CStyleLoop); ok {
+		return runCStyleFor(term, line, loop, node.Do)
+	}
+	loop, ok := node.Loop.(*syntax.WordIter)
+	if !ok {
+		return errors.Errorf("Unsupported for loop type: %T", node.Loop)
+	}
+	items := getPositional(term)
+	if loop.InPos.IsValid() {
+		var err error
+		items, err = evalFields(term, loop.Items)
+		if err != nil {
+			return err
+		}
+	}
+
+	var lastErr error
+	for _, item := range items {
+		if err := setVar(term, loop.Name.Value, item); err != nil {
+			return err
+		}
+		stop, loopErr := loopControl(runStmts(term, line, node.Do))
+		if stop {
+			return loopErr
+		}
+		lastErr = loopErr
+	}
+	return lastErr
+}
+
+func runCase(term console.Console, line string, node *syntax.CaseClause) error {
+	word, err := evalWord(term, node.Word.Parts)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	matched := false
+	for _, item := range node.Items {
+		if !matched {
+			matched, err = matchAnyPattern(term, word, item.Patterns)
+			if err != nil {
+				return err
+			}
+		}
+		if !matched {
+			continue
+		}
+		lastErr = runStmts(term, line, item.Stmts)
+		if isControlFlow(lastErr) {
+			return lastErr
+		}
+		switch item.Op {
+		case syntax.Fallthrough: // ;& runs the next item's statements without matching
+		case syntax.Resume, syntax.ResumeKorn: // ;;& tests the next item's patterns
+			matched = false
+		default: // ;;
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+func matchAnyPattern(term console.Console, word string, patterns []*syntax.Word) (bool, error) {
+	for _, patternWord := range patterns {
+		pat, err := evalPattern(term, patternWord.Parts)
+		if err != nil {
+			return false, err
+		}
+		expr, err := pattern.Regexp(pat, 0)
+		if err != nil {
+			return false, err
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return false, err
+		}
+		if re.MatchString(word) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// evalPattern evaluates a shell pattern word. Quoted parts match literally.
+func evalPattern(term console.Console, parts []syntax.WordPart) (string, error) {
+	s := ""
+	for _, part := range parts {
+		if lit, ok := part.(*syntax.Lit); ok {
+			s += lit.Value
+			continue
+		}
+		value, err := evalWord(term, []syntax.WordPart{part})
+		if err != nil {
+			return "", err
+		}
+		s += pattern.QuoteMeta(value, 0)
+	}
+	return s, nil
+}
+
+func declareFunc(term console.Console, line string, node *syntax.FuncDecl) error {
+	setFunc(term, node.Name.Value, shellFunc{source: line, body: node.Body})
+	return nil
+}
+
+// runFunc calls 'fn' with 'args'. A 'return' in the function sets its exit status.
+func runFunc(term console.Console, fn shellFunc, args []string) error {
+	callerArgs := setPositional(term, args)
+	pushScope(term)
+	err := runCommand(term, fn.source, fn.body)
+	popScope(term)
+	setPositional(term, callerArgs)
+	if ret, ok := errors.Cause(err).(*returnErr); ok {
+		if ret.code == 0 {
+			return nil
+		}
+		return &statusErr{code: ret.code}
+	}
+	return err
+}
+
+// runSubshell runs 'stmts' without affecting the shell's working directory, variables, or functions
+func runSubshell(term console.Console, line string, stmts []*syntax.Stmt) error {
+	subshell, exitSubshell := newSubshell(term)
+	err := runStmts(subshell, line, stmts)
+	exitSubshell()
+	switch e := errors.Cause(err).(type) {
+	case *ExitErr:
+		if e.Code == 0 {
+			return nil
+		}
+		return &statusErr{code: e.Code}
+	case *breakErr, *continueErr:
+		return nil
+	default:
+		return err
+	}
+}
+
+// newSubshell returns a console with a copy of the shell's variables and functions, and a function to call once the subshell is done.
+// The working directory is shared by the whole process, so 'exit' undoes the subshell's last 'cd', unless another job has changed directories since.
+func newSubshell(term console.Console) (subshell console.Console, exit func()) {
+	wd, wdErr := os.Getwd()
+	subshell = withState(term, stateFromConsole(term).copy())
+	return subshell, func() {
+		changedDir := getDir(subshell)
+		if wdErr != nil || changedDir == "" {
+			return
+		}
+		if currentDir, err := os.Getwd(); err == nil && currentDir == changedDir {
+			_ = os.Chdir(wd)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runTestLine(t *testing.T, line string) (string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	term := &redirectConsole{
+		stdin:  strings.NewReader(""),
+		stdout: &stdout,
+		stderr: &stderr,
+	}
+	err := runLine(term, line)
+	return stdout.String() + stderr.String(), exitCodeFromErr(err)
+}
+
+// saveVars returns a function which restores the top-level shell's local variables and positional arguments to their current values
+func saveVars() (restore func()) {
+	saved := rootState.copy()
+	return func() {
+		rootState.mu.Lock()
+		rootState.localVars = saved.localVars
+		rootState.positionalArgs = saved.positionalArgs
+		rootState.mu.Unlock()
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "if true",
+			line:        `if true; then echo yes; else echo no; fi`,
+			expectOut:   "yes\n",
+		},
+		{
+			description: "elif",
+			line:        `if false; then echo 1; elif true; then echo 2; else echo 3; fi`,
+			expectOut:   "2\n",
+		},
+		{
+			description: "else",
+			line:        `if false; then echo 1; elif false; then echo 2; else echo 3; fi`,
+			expectOut:   "3\n",
+		},
+		{
+			description: "if status is the branch's status",
+			line:        `if true; then false; fi`,
+			expectCode:  1,
+		},
+		{
+			description: "if with no branch run succeeds",
+			line:        `if false; then echo 1; fi`,
+		},
+		{
+			description: "negated",
+			line:        `! true`,
+			expectCode:  1,
+		},
+		{
+			description: "for loop",
+			line:        `for i in a b c; do echo $i; done`,
+			expectOut:   "a\nb\nc\n",
+		},
+		{
+			description: "for loop break and continue",
+			line:        `for i in a b c d; do if test $i = b; then continue; fi; if test $i = d; then break; fi; echo $i; done`,
+			expectOut:   "a\nc\n",
+		},
+		{
+			description: "nested break",
+			line:        `for i in a b; do for j in 1 2; do echo $i$j; break 2; done; done`,
+			expectOut:   "a1\n",
+		},
+		{
+			description: "while loop",
+			line:        `while true; do echo a; break; done`,
+			expectOut:   "a\n",
+		},
+		{
+			description: "until loop",
+			line:        `until true; do echo a; done; echo b`,
+			expectOut:   "b\n",
+		},
+		{
+			description: "case",
+			line:        `case foo.go in *.txt) echo text;; *.go) echo go;; *) echo other;; esac`,
+			expectOut:   "go\n",
+		},
+		{
+			description: "case quoted pattern",
+			line:        `case 'a*' in "a*") echo literal;; a*) echo glob;; esac`,
+			expectOut:   "literal\n",
+		},
+		{
+			description: "case fallthrough",
+			line:        `case a in a) echo 1;& b) echo 2;; c) echo 3;; esac`,
+			expectOut:   "1\n2\n",
+		},
+		{
+			description: "case resume",
+			line:        `case a in a) echo 1;;& b) echo 2;; a) echo 3;; esac`,
+			expectOut:   "1\n3\n",
+		},
+		{
+			description: "case no match",
+			line:        `case a in b) false;; esac`,
+		},
+		{
+			description: "block",
+			line:        `{ echo a; echo b; }`,
+			expectOut:   "a\nb\n",
+		},
+		{
+			description: "list continues after failure",
+			line:        `false; echo after`,
+			expectOut:   "after\n",
+		},
+		{
+			description: "list status is last command",
+			line:        `true; false`,
+			expectCode:  1,
+		},
+		{
+			description: "function",
+			line:        `greet() { echo hello; }; greet; greet`,
+			expectOut:   "hello\nhello\n",
+		},
+		{
+			description: "function return status",
+			line:        `f() { return 3; echo unreachable; }; f`,
+			expectCode:  3,
+		},
+		{
+			description: "function return last status",
+			line:        `f() { false; return; }; f`,
+			expectCode:  1,
+		},
+		{
+			description: "return from loop in function",
+			line:        `f() { for i in a b; do echo $i; return 0; done; }; f`,
+			expectOut:   "a\n",
+		},
+		{
+			description: "subshell isolates working directory",
+			line:        `cd /; (cd /tmp; pwd); pwd`,
+			expectOut:   "/tmp\n/\n",
+		},
+		{
+			description: "subshell exit",
+			line:        `(exit 4)`,
+			expectOut:   "Exited with code 4\n",
+			expectCode:  4,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
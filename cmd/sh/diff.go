@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+)
+
+type editOp int
+
+const (
+	editKeep editOp = iota
+	editDelete
+	editInsert
+)
+
+type edit struct {
+	op   editOp
+	a, b int // line indexes in the old and new files. For inserts, 'a' is where the line is inserted. For deletes, 'b' is where the line was deleted.
+}
+
+// diffLines returns the shortest edit script from 'a' to 'b', using Myers' diff algorithm
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // move down: insert from b
+			} else {
+				x = v[offset+k-1] + 1 // move right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackEdits(trace, offset, n, m, d)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrackEdits walks the saved diagonals from the end of both files back to the start to build the edit script
+func backtrackEdits(trace [][]int, offset, x, y, d int) []edit {
+	var edits []edit
+	for ; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{op: editKeep, a: x, b: y})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{op: editInsert, a: x, b: y})
+		} else {
+			x--
+			edits = append(edits, edit{op: editDelete, a: x, b: y})
+		}
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// formatRange formats a unified diff hunk range. 'start' is the 0-based index of the first line.
+func formatRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
+
+// writeUnifiedDiff writes the hunks of 'edits' with 'context' lines of unchanged text around each change
+func writeUnifiedDiff(w io.Writer, a, b []string, edits []edit, context int) error {
+	for start := 0; start < len(edits); {
+		if edits[start].op == editKeep {
+			start++
+			continue
+		}
+		// extend the hunk until there are more than 2*context unchanged lines in a row
+		end := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].op != editKeep {
+				end = i + 1
+			} else if i-end >= 2*context {
+				break
+			}
+		}
+		hunkStart, hunkEnd := start-context, end+context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		if hunkEnd > len(edits) {
+			hunkEnd = len(edits)
+		}
+
+		hunk := edits[hunkStart:hunkEnd]
+		aStart, bStart := hunk[0].a, hunk[0].b
+		var aCount, bCount int
+		var lines strings.Builder
+		for _, e := range hunk {
+			switch e.op {
+			case editKeep:
+				aCount++
+				bCount++
+				lines.WriteString(" " + a[e.a] + "\n")
+			case editDelete:
+				aCount++
+				lines.WriteString("-" + a[e.a] + "\n")
+			case editInsert:
+				bCount++
+				lines.WriteString("+" + b[e.b] + "\n")
+			}
+		}
+		_, err := fmt.Fprintf(w, "@@ -%s +%s @@\n%s", formatRange(aStart, aCount), formatRange(bStart, bCount), lines.String())
+		if err != nil {
+			return err
+		}
+		start = hunkEnd
+	}
+	return nil
+}
+
+func readAllLines(term console.Console, path string) ([]string, error) {
+	var lines []string
+	err := forEachInput(term, []string{path}, func(name string, r io.Reader) error {
+		return readLines(r, func(line string) bool {
+			lines = append(lines, line)
+			return true
+		})
+	})
+	return lines, err
+}
+
+// diff compares two files line by line, printing the differences in unified format. Exits with status 1 if the files differ, or 2 for errors.
+func diff(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("diff", flag.ContinueOnError)
+	context := set.Int("U", 3, "Number of lines of unchanged context")
+	set.Bool("u", true, "Print a unified diff, which is always enabled")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if set.NArg() != 2 {
+		return errors.New("Usage: diff [-u] [-U lines] file1 file2")
+	}
+	if *context < 0 {
+		return errors.Errorf("Invalid context length: %d", *context)
+	}
+	pathA, pathB := set.Arg(0), set.Arg(1)
+
+	a, err := readAllLines(term, pathA)
+	if err != nil {
+		return diffError(term, err)
+	}
+	b, err := readAllLines(term, pathB)
+	if err != nil {
+		return diffError(term, err)
+	}
+	edits := diffLines(a, b)
+	changed := false
+	for _, e := range edits {
+		changed = changed || e.op != editKeep
+	}
+	if !changed {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(term.Stdout(), "--- %s\n+++ %s\n", pathA, pathB); err != nil {
+		return err
+	}
+	if err := writeUnifiedDiff(term.Stdout(), a, b, edits, *context); err != nil {
+		return err
+	}
+	return &statusErr{code: 1}
+}
+
+// diffError reports 'err' and exits with status 2 like POSIX diff, since status 1 means the files differ
+func diffError(term console.Console, err error) error {
+	printErr(term, err)
+	return &statusErr{code: 2}
+}
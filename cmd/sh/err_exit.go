@@ -1,6 +1,11 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
 
 type ExitErr struct {
 	Code int
@@ -9,3 +14,22 @@ type ExitErr struct {
 func (e *ExitErr) Error() string {
 	return fmt.Sprintf("exit code %d", e.Code)
 }
+
+// statusErr is a non-zero exit status without an error message, like from 'false' or 'return 1'
+type statusErr struct {
+	code int
+}
+
+func (e *statusErr) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// isStatusErr returns true if 'err' only reports an exit status. The failed command has already printed any error messages.
+func isStatusErr(err error) bool {
+	switch errors.Cause(err).(type) {
+	case *statusErr, *exec.ExitError, *interruptErr:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/pattern"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+const (
+	homeTilde  = "~"
+	defaultIFS = " \t\n"
+)
+
+// fieldPart is a piece of an expanded field. Quoted parts are never split on IFS or matched as glob patterns.
+type fieldPart struct {
+	value  string
+	quoted bool
+}
+
+// expander builds fields from word parts
+type expander struct {
+	term       console.Console
+	split      bool // split unquoted expansions into separate fields
+	fields     [][]fieldPart
+	current    []fieldPart
+	hasCurrent bool // true if the current field should be kept, even if empty
+}
+
+func (e *expander) add(value string, quoted bool) {
+	e.current = append(e.current, fieldPart{value: value, quoted: quoted})
+	e.hasCurrent = e.hasCurrent || quoted || value != ""
+}
+
+// endField finishes the current field, dropping it if it's empty and unquoted
+func (e *expander) endField() {
+	if e.hasCurrent {
+		e.fields = append(e.fields, e.current)
+	}
+	e.current = nil
+	e.hasCurrent = false
+}
+
+// addExpansion adds the result of an expansion. Unquoted results are split on IFS when splitting is enabled.
+func (e *expander) addExpansion(value string, quoted bool) {
+	if quoted || !e.split {
+		e.add(value, quoted)
+		return
+	}
+	ifs, isSet := lookupVar(e.term, "IFS")
+	if !isSet {
+		ifs = defaultIFS
+	}
+	if ifs == "" {
+		e.add(value, false)
+		return
+	}
+	isSeparator := func(r rune) bool {
+		return strings.ContainsRune(ifs, r)
+	}
+	words := strings.FieldsFunc(value, isSeparator)
+	if len(words) == 0 {
+		if value != "" {
+			e.endField()
+		}
+		return
+	}
+	if first, _ := utf8.DecodeRuneInString(value); isSeparator(first) {
+		e.endField()
+	}
+	for i, word := range words {
+		if i > 0 {
+			e.endField()
+		}
+		e.add(word, false)
+	}
+	if last, _ := utf8.DecodeLastRuneInString(value); isSeparator(last) {
+		e.endField()
+	}
+}
+
+// addFields adds each value as its own field, like "$@". The first value joins the current field and the last value continues into the rest of the word.
+func (e *expander) addFields(values []string, quoted bool) {
+	for i, value := range values {
+		if i > 0 {
+			e.endField()
+		}
+		e.addExpansion(value, quoted)
+	}
+}
+
+func (e *expander) expand(parts []syntax.WordPart, quoted bool) error {
+	for ix, part := range parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			value := part.Value
+			if ix == 0 && !quoted && (value == homeTilde || strings.HasPrefix(value, homeTilde+string(filepath.Separator))) {
+				homeDir, err := os.UserHomeDir()
+				if err != nil {
+					return err
+				}
+				e.add(homeDir, true)
+				value = value[len(homeTilde):]
+			}
+			e.addLit(value, quoted)
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				return errors.Errorf("Dollar single-quotes not supported: %v", part)
+			}
+			e.add(part.Value, true)
+		case *syntax.DblQuoted:
+			if part.Dollar {
+				return errors.Errorf("Dollar double-quotes not supported: %v", part)
+			}
+			if !e.isEmptyArgs(part.Parts) {
+				e.add("", true)
+			}
+			if err := e.expand(part.Parts, true); err != nil {
+				return err
+			}
+		case *syntax.ParamExp:
+			if e.split && isAllArgs(part) && (part.Param.Value == "@" || !quoted) {
+				e.addFields(getPositional(e.term), quoted)
+				continue
+			}
+			value, err := evalParamExp(e.term, part)
+			if err != nil {
+				return err
+			}
+			e.addExpansion(value, quoted)
+		case *syntax.CmdSubst:
+			value, err := commandSubst(e.term, part)
+			if err != nil {
+				return err
+			}
+			e.addExpansion(value, quoted)
+		case *syntax.ArithmExp:
+			value, err := evalArithm(e.term, part.X)
+			if err != nil {
+				return err
+			}
+			e.addExpansion(formatArithm(value), quoted)
+		default:
+			return errors.Errorf("Unrecognized word part type: %T %v", part, part)
+		}
+	}
+	return nil
+}
+
+// addLit adds literal text, removing backslash escapes. Escaped characters are treated as quoted.
+func (e *expander) addLit(value string, quoted bool) {
+	for {
+		ix := strings.IndexRune(value, '\\')
+		if ix == -1 || ix == len(value)-1 {
+			e.add(value, quoted)
+			return
+		}
+		e.add(value[:ix], quoted)
+		escaped := value[ix+1 : ix+2]
+		value = value[ix+2:]
+		switch {
+		case escaped == "\n": // line continuation
+		case quoted && !strings.Contains("$`\"\\", escaped): // only some characters are escaped inside double quotes
+			e.add(`\`+escaped, true)
+		default:
+			e.add(escaped, true)
+		}
+	}
+}
+
+// isEmptyArgs returns true if 'parts' is only "$@" and there are no positional arguments, which expands to no fields
+func (e *expander) isEmptyArgs(parts []syntax.WordPart) bool {
+	if !e.split || len(parts) != 1 {
+		return false
+	}
+	param, ok := parts[0].(*syntax.ParamExp)
+	return ok && isAllArgs(param) && param.Param.Value == "@" && len(getPositional(e.term)) == 0
+}
+
+// isAllArgs returns true for $@ and $*, which expand to separate fields
+func isAllArgs(param *syntax.ParamExp) bool {
+	name := param.Param.Value
+	return (name == "@" || name == "*") && !param.Excl && !param.Length && param.Exp == nil && param.Slice == nil && param.Repl == nil
+}
+
+func joinField(field []fieldPart) string {
+	var s strings.Builder
+	for _, part := range field {
+		s.WriteString(part.value)
+	}
+	return s.String()
+}
+
+// evalWord evaluates word parts into a single string, without field splitting
+func evalWord(term console.Console, parts []syntax.WordPart) (string, error) {
+	e := &expander{term: term}
+	if err := e.expand(parts, false); err != nil {
+		return "", err
+	}
+	return joinField(e.current), nil
+}
+
+// evalFields evaluates each word into command arguments.
+// Words are brace expanded, unquoted expansions are split into separate fields, then unquoted patterns are matched against file paths.
+func evalFields(term console.Console, words []*syntax.Word) ([]string, error) {
+	e := &expander{term: term, split: true}
+	for _, word := range words {
+		for _, word := range expandBraces(word) {
+			if err := e.expand(word.Parts, false); err != nil {
+				return nil, err
+			}
+			e.endField()
+		}
+	}
+	fields := make([]string, 0, len(e.fields))
+	for _, field := range e.fields {
+		matches, err := globField(field)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, matches...)
+	}
+	return fields, nil
+}
+
+// evalHeredoc evaluates a here-document's body. If any part of the delimiter is quoted, the body is not expanded.
+func evalHeredoc(term console.Console, delim *syntax.Word, body *syntax.Word) (string, error) {
+	quotedDelim := false
+	for _, part := range delim.Parts {
+		lit, isLit := part.(*syntax.Lit)
+		if !isLit || strings.ContainsRune(lit.Value, '\\') {
+			quotedDelim = true
+		}
+	}
+	if quotedDelim {
+		var s strings.Builder
+		for _, part := range body.Parts {
+			if lit, isLit := part.(*syntax.Lit); isLit {
+				s.WriteString(lit.Value)
+			}
+		}
+		return s.String(), nil
+	}
+	e := &expander{term: term}
+	if err := e.expand(body.Parts, true); err != nil {
+		return "", err
+	}
+	return joinField(e.current), nil
+}
+
+func evalParamExp(term console.Console, param *syntax.ParamExp) (string, error) {
+	name := param.Param.Value
+	switch {
+	case param.Width, param.Index != nil, param.Names != 0:
+		return "", errors.Errorf("Variable expansion type not supported: %s", name)
+	}
+
+	value, isSet := lookupVar(term, name)
+	if param.Excl {
+		value, isSet = lookupVar(term, value)
+	}
+	switch {
+	case param.Length:
+		return formatArithm(int64(utf8.RuneCountInString(value))), nil
+	case param.Slice != nil:
+		return sliceParam(term, value, param.Slice)
+	case param.Repl != nil:
+		return replaceParam(term, value, param.Repl)
+	case param.Exp != nil:
+		return expandParamOp(term, name, value, isSet, param.Exp)
+	default:
+		return value, nil
+	}
+}
+
+func sliceParam(term console.Console, value string, slice *syntax.Slice) (string, error) {
+	runes := []rune(value)
+	offset, err := evalArithm(term, slice.Offset)
+	if err != nil {
+		return "", err
+	}
+	if offset < 0 {
+		offset += int64(len(runes))
+	}
+	if offset < 0 || offset > int64(len(runes)) {
+		return "", nil
+	}
+	runes = runes[offset:]
+	if slice.Length != nil {
+		length, err := evalArithm(term, slice.Length)
+		if err != nil {
+			return "", err
+		}
+		if length < 0 {
+			length += int64(len(runes))
+			if length < 0 {
+				return "", errors.Errorf("%d: substring expression < 0", length)
+			}
+		}
+		if length < int64(len(runes)) {
+			runes = runes[:length]
+		}
+	}
+	return string(runes), nil
+}
+
+func replaceParam(term console.Console, value string, repl *syntax.Replace) (string, error) {
+	var pat, with string
+	var err error
+	if repl.Orig != nil {
+		pat, err = evalPattern(term, repl.Orig.Parts)
+		if err != nil {
+			return "", err
+		}
+	}
+	if repl.With != nil {
+		with, err = evalWord(term, repl.With.Parts)
+		if err != nil {
+			return "", err
+		}
+	}
+	prefix, suffix := "", ""
+	switch {
+	case strings.HasPrefix(pat, "#"):
+		prefix, pat = "^", pat[1:]
+	case strings.HasPrefix(pat, "%"):
+		suffix, pat = "$", pat[1:]
+	}
+	if pat == "" {
+		return value, nil
+	}
+	expr, err := pattern.Regexp(pat, 0)
+	if err != nil {
+		return "", err
+	}
+	re, err := regexp.Compile(prefix + "(?s:" + expr + ")" + suffix)
+	if err != nil {
+		return "", err
+	}
+	re.Longest()
+	if repl.All {
+		return re.ReplaceAllLiteralString(value, with), nil
+	}
+	loc := re.FindStringIndex(value)
+	if loc == nil {
+		return value, nil
+	}
+	return value[:loc[0]] + with + value[loc[1]:], nil
+}
+
+func expandParamOp(term console.Console, name, value string, isSet bool, exp *syntax.Expansion) (string, error) {
+	var arg string
+	if exp.Word != nil {
+		var err error
+		switch exp.Op {
+		case syntax.RemSmallSuffix, syntax.RemLargeSuffix, syntax.RemSmallPrefix, syntax.RemLargePrefix:
+			arg, err = evalPattern(term, exp.Word.Parts)
+		default:
+			arg, err = evalWord(term, exp.Word.Parts)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	isNullOrUnset := !isSet || value == ""
+	switch exp.Op {
+	case syntax.AlternateUnset: // +
+		if isSet {
+			return arg, nil
+		}
+		return "", nil
+	case syntax.AlternateUnsetOrNull: // :+
+		if !isNullOrUnset {
+			return arg, nil
+		}
+		return "", nil
+	case syntax.DefaultUnset: // -
+		if !isSet {
+			return arg, nil
+		}
+		return value, nil
+	case syntax.DefaultUnsetOrNull: // :-
+		if isNullOrUnset {
+			return arg, nil
+		}
+		return value, nil
+	case syntax.ErrorUnset, syntax.ErrorUnsetOrNull: // ? :?
+		if !isSet || (exp.Op == syntax.ErrorUnsetOrNull && value == "") {
+			if arg == "" {
+				arg = "parameter null or not set"
+			}
+			return "", errors.Errorf("%s: %s", name, arg)
+		}
+		return value, nil
+	case syntax.AssignUnset, syntax.AssignUnsetOrNull: // = :=
+		if !isSet || (exp.Op == syntax.AssignUnsetOrNull && value == "") {
+			if err := setVar(term, name, arg); err != nil {
+				return "", err
+			}
+			return arg, nil
+		}
+		return value, nil
+	case syntax.RemSmallPrefix, syntax.RemLargePrefix: // # ##
+		return removeAffix(value, arg, true, exp.Op == syntax.RemLargePrefix)
+	case syntax.RemSmallSuffix, syntax.RemLargeSuffix: // % %%
+		return removeAffix(value, arg, false, exp.Op == syntax.RemLargeSuffix)
+	case syntax.UpperFirst, syntax.UpperAll, syntax.LowerFirst, syntax.LowerAll: // ^ ^^ , ,,
+		return changeCase(value, exp.Op), nil
+	default:
+		return "", errors.Errorf("Variable expansion type not supported: %s %s", name, exp.Op)
+	}
+}
+
+// removeAffix removes the shortest or longest prefix or suffix of 'value' matching the pattern 'pat'
+func removeAffix(value, pat string, prefix, longest bool) (string, error) {
+	expr, err := pattern.Regexp(pat, 0)
+	if err != nil {
+		return "", err
+	}
+	re, err := regexp.Compile("^(?s:" + expr + ")$")
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i <= len(value); i++ {
+		size := i
+		if longest {
+			size = len(value) - i
+		}
+		if prefix && re.MatchString(value[:size]) {
+			return value[size:], nil
+		}
+		if !prefix && re.MatchString(value[len(value)-size:]) {
+			return value[:len(value)-size], nil
+		}
+	}
+	return value, nil
+}
+
+func changeCase(value string, op syntax.ParExpOperator) string {
+	switch op {
+	case syntax.UpperAll:
+		return strings.ToUpper(value)
+	case syntax.LowerAll:
+		return strings.ToLower(value)
+	}
+	if value == "" {
+		return value
+	}
+	first, size := utf8.DecodeRuneInString(value)
+	if op == syntax.UpperFirst {
+		return strings.ToUpper(string(first)) + value[size:]
+	}
+	return strings.ToLower(string(first)) + value[size:]
+}
+
+// commandSubst runs the statements in a subshell and returns their output, without trailing newlines
+func commandSubst(term console.Console, node *syntax.CmdSubst) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	substTerm := &redirectConsole{
+		stdin:  os.Stdin,
+		stdout: w,
+		stderr: os.Stderr,
+		state:  stateFromConsole(term),
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		err := runSubshell(substTerm, "", node.Stmts)
+		w.Close()
+		errChan <- err
+	}()
+	output, readErr := ioutil.ReadAll(r)
+	r.Close()
+	err = <-errChan
+	printErr(substTerm, err)
+	setLastStatus(term, exitCodeFromErr(err))
+	if readErr != nil {
+		return "", readErr
+	}
+	return strings.TrimRight(string(output), "\n"), nil
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/johnstarich/go/datasize"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	for k, v := range map[string]builtinFunc{
+		"cp":   cp,
+		"du":   du,
+		"find": find,
+		"ln":   ln,
+		"stat": stat,
+		"tree": tree,
+	} {
+		builtins[k] = v
+	}
+}
+
+// splitShortFlags splits combined boolean flags like '-rp' into '-r -p', so they can be parsed by 'set'
+func splitShortFlags(set *flag.FlagSet, args []string) []string {
+	var result []string
+	for i, arg := range args {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			return append(result, args[i:]...)
+		}
+		if len(arg) <= 2 || strings.HasPrefix(arg, "--") || strings.ContainsRune(arg, '=') {
+			result = append(result, arg)
+			continue
+		}
+		isCombined := true
+		for _, r := range arg[1:] {
+			f := set.Lookup(string(r))
+			if f == nil {
+				isCombined = false
+				break
+			}
+			if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !boolFlag.IsBoolFlag() {
+				isCombined = false
+				break
+			}
+		}
+		if !isCombined {
+			result = append(result, arg)
+			continue
+		}
+		for _, r := range arg[1:] {
+			result = append(result, "-"+string(r))
+		}
+	}
+	return result
+}
+
+func cp(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("cp", flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	recursive := set.Bool("r", false, "Copy directories recursively")
+	preserve := set.Bool("p", false, "Preserve file modes and modification times")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	args = set.Args()
+	if len(args) < 2 {
+		return errors.New("Not enough args")
+	}
+
+	sources, dest := args[:len(args)-1], args[len(args)-1]
+	destIsDir := isDir(dest)
+	if len(sources) > 1 && !destIsDir {
+		return errors.Errorf("Target is not a directory: %s", dest)
+	}
+	for _, src := range sources {
+		target := dest
+		if destIsDir {
+			target = path.Join(dest, path.Base(src))
+		}
+		if err := copyPath(src, target, *recursive, *preserve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPath copies 'src' to 'dest'. Recursive copies keep symlinks as links, instead of copying what they point to.
+func copyPath(src, dest string, recursive, preserve bool) error {
+	stat := os.Stat
+	if recursive {
+		stat = os.Lstat
+	}
+	info, err := stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return copySymlink(src, dest)
+	}
+	if !info.IsDir() {
+		return copyFile(src, dest, info, preserve)
+	}
+	if !recursive {
+		return errors.Errorf("-r not specified; omitting directory %s", src)
+	}
+	cleanSrc, cleanDest := path.Clean(src), path.Clean(dest)
+	if cleanDest == cleanSrc || strings.HasPrefix(cleanDest, cleanSrc+"/") {
+		return errors.Errorf("Cannot copy a directory into itself: %s", src)
+	}
+
+	if err := os.MkdirAll(dest, info.Mode().Perm()); err != nil {
+		return err
+	}
+	names, err := readDirNames(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := copyPath(path.Join(src, name), path.Join(dest, name), recursive, preserve); err != nil {
+			return err
+		}
+	}
+	if preserve {
+		return preserveAttrs(dest, info)
+	}
+	return nil
+}
+
+// copySymlink creates a link at 'dest' with the same target as 'src', replacing any file at 'dest'
+func copySymlink(src, dest string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if destInfo, err := os.Lstat(dest); err == nil && !destInfo.IsDir() {
+		if err := os.Remove(dest); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, dest)
+}
+
+func copyFile(src, dest string, info os.FileInfo, preserve bool) (returnedErr error) {
+	if destInfo, err := os.Stat(dest); err == nil && sameFile(src, dest, info, destInfo) {
+		return errors.Errorf("%s and %s are the same file", src, dest)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := out.Close(); err != nil && returnedErr == nil {
+			returnedErr = err
+		}
+	}()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if preserve {
+		return preserveAttrs(dest, info)
+	}
+	return nil
+}
+
+// sameFile returns true if 'src' and 'dest' are the same file. Falls back to comparing resolved paths when inode numbers are unavailable, like on js/wasm.
+func sameFile(src, dest string, srcInfo, destInfo os.FileInfo) bool {
+	if !os.SameFile(srcInfo, destInfo) {
+		return false
+	}
+	if stat, ok := srcInfo.Sys().(*syscall.Stat_t); ok && stat.Ino != 0 {
+		return true
+	}
+	resolvedSrc, srcErr := resolvePath(src)
+	resolvedDest, destErr := resolvePath(dest)
+	return srcErr == nil && destErr == nil && resolvedSrc == resolvedDest
+}
+
+func resolvePath(p string) (string, error) {
+	p, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(p)
+}
+
+func preserveAttrs(path string, info os.FileInfo) error {
+	if err := os.Chmod(path, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// findExpr matches files for 'find'. Each option must match for a file to be printed or passed to -exec.
+type findExpr struct {
+	names     []string
+	fileType  rune
+	maxDepth  int
+	minDepth  int
+	exec      []string
+	execBatch bool // -exec ends with '+', so matches are passed to the command all at once
+}
+
+func parseFindExpr(args []string) (paths []string, expr findExpr, err error) {
+	expr.maxDepth = -1
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		paths = append(paths, args[0])
+		args = args[1:]
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	for len(args) > 0 {
+		option := args[0]
+		args = args[1:]
+		if option == "-print" {
+			continue
+		}
+		if len(args) == 0 {
+			return nil, expr, errors.Errorf("%s: missing argument", option)
+		}
+		switch option {
+		case "-name":
+			if _, err := path.Match(args[0], ""); err != nil {
+				return nil, expr, errors.Errorf("-name: invalid pattern: %s", args[0])
+			}
+			expr.names = append(expr.names, args[0])
+		case "-type":
+			switch args[0] {
+			case "f", "d", "l":
+				expr.fileType = rune(args[0][0])
+			default:
+				return nil, expr, errors.Errorf("-type: unknown type: %s", args[0])
+			}
+		case "-maxdepth", "-mindepth":
+			depth, err := strconv.Atoi(args[0])
+			if err != nil || depth < 0 {
+				return nil, expr, errors.Errorf("%s: invalid depth: %s", option, args[0])
+			}
+			if option == "-maxdepth" {
+				expr.maxDepth = depth
+			} else {
+				expr.minDepth = depth
+			}
+		case "-exec":
+			end := -1
+			for i, arg := range args {
+				if arg == ";" || (arg == "+" && i > 0 && args[i-1] == "{}") {
+					end = i
+					break
+				}
+			}
+			if end <= 0 {
+				return nil, expr, errors.New("-exec: missing terminating ';' or '+'")
+			}
+			expr.exec = args[:end]
+			expr.execBatch = args[end] == "+"
+			args = args[end+1:]
+			continue
+		default:
+			return nil, expr, errors.Errorf("Unknown option: %s", option)
+		}
+		args = args[1:]
+	}
+	return paths, expr, nil
+}
+
+func (e findExpr) match(name string, info os.FileInfo, depth int) bool {
+	if depth < e.minDepth {
+		return false
+	}
+	for _, pattern := range e.names {
+		if ok, _ := path.Match(pattern, name); !ok {
+			return false
+		}
+	}
+	switch e.fileType {
+	case 'f':
+		return info.Mode().IsRegular()
+	case 'd':
+		return info.IsDir()
+	case 'l':
+		return info.Mode()&os.ModeSymlink != 0
+	default:
+		return true
+	}
+}
+
+// find searches directory trees for files. Usage: find [path...] [-name pattern] [-type f|d|l] [-maxdepth n] [-mindepth n] [-exec cmd {} ;]
+func find(term console.Console, args ...string) error {
+	paths, expr, err := parseFindExpr(args)
+	if err != nil {
+		return err
+	}
+
+	var batch []string
+	var lastErr error
+	handleMatch := func(match string) error {
+		switch {
+		case expr.exec == nil:
+			_, err := fmt.Fprintln(term.Stdout(), match)
+			return err
+		case expr.execBatch:
+			batch = append(batch, match)
+			return nil
+		default:
+			return findExec(term, expr.exec, []string{match})
+		}
+	}
+
+	var walk func(filePath string, depth int) error
+	walk = func(filePath string, depth int) error {
+		if err := jobFromConsole(term).checkpoint(); err != nil {
+			return err
+		}
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return err
+		}
+		if expr.match(path.Base(filePath), info, depth) {
+			if err := handleMatch(filePath); err != nil {
+				return err
+			}
+		}
+		if !info.IsDir() || depth == expr.maxDepth {
+			return nil
+		}
+		names, err := readDirNames(filePath)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := walk(joinGlobPath(filePath, name), depth+1); err != nil {
+				if isControlFlow(err) {
+					return err
+				}
+				printErr(term, err)
+				lastErr = &statusErr{code: 1}
+			}
+		}
+		return nil
+	}
+
+	for _, p := range paths {
+		if err := walk(p, 0); err != nil {
+			if isControlFlow(err) {
+				return err
+			}
+			printErr(term, err)
+			lastErr = &statusErr{code: 1}
+		}
+	}
+	if len(batch) > 0 {
+		if err := findExec(term, expr.exec, batch); err != nil {
+			return err
+		}
+	}
+	return lastErr
+}
+
+// findExec runs 'command' with '{}' replaced by 'matches'. Failed commands are reported, but don't stop the search.
+func findExec(term console.Console, command []string, matches []string) error {
+	var args []string
+	for _, arg := range command {
+		if arg == "{}" {
+			args = append(args, matches...)
+		} else {
+			args = append(args, arg)
+		}
+	}
+	err := runWithEnv(term, nil, args...)
+	if isControlFlow(err) {
+		return err
+	}
+	printErr(term, err)
+	return nil
+}
+
+// du prints the disk usage of each directory, or each file with -a
+func du(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("du", flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	all := set.Bool("a", false, "Print sizes of files, not just directories")
+	human := set.Bool("h", false, "Print sizes in human readable format")
+	summarize := set.Bool("s", false, "Only print the total size of each argument")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	args = set.Args()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	printSize := func(size int64, path string) {
+		if *human {
+			value, units := formatBytes(datasize.Bytes(size))
+			fmt.Fprintf(term.Stdout(), "%s%s\t%s\n", value, units, path)
+			return
+		}
+		const kilobyte = 1024
+		fmt.Fprintf(term.Stdout(), "%d\t%s\n", (size+kilobyte-1)/kilobyte, path)
+	}
+
+	var walk func(filePath string, depth int) (int64, error)
+	walk = func(filePath string, depth int) (int64, error) {
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return 0, err
+		}
+		size := info.Size()
+		if info.IsDir() {
+			size = 0
+			names, err := readDirNames(filePath)
+			if err != nil {
+				return 0, err
+			}
+			for _, name := range names {
+				childSize, err := walk(joinGlobPath(filePath, name), depth+1)
+				if err != nil {
+					return 0, err
+				}
+				size += childSize
+			}
+		}
+		if depth == 0 || (!*summarize && (info.IsDir() || *all)) {
+			printSize(size, filePath)
+		}
+		return size, nil
+	}
+
+	for _, p := range args {
+		if _, err := walk(p, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ln creates links. Links may not be supported by every file system.
+func ln(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("ln", flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	symbolic := set.Bool("s", false, "Create symbolic links instead of hard links")
+	force := set.Bool("f", false, "Remove existing destination files")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	args = set.Args()
+	if len(args) < 2 {
+		return errors.New("Not enough args")
+	}
+
+	targets, dest := args[:len(args)-1], args[len(args)-1]
+	destIsDir := isDir(dest)
+	if len(targets) > 1 && !destIsDir {
+		return errors.Errorf("Target is not a directory: %s", dest)
+	}
+	link := os.Link
+	if *symbolic {
+		link = os.Symlink
+	}
+	for _, target := range targets {
+		name := dest
+		if destIsDir {
+			name = path.Join(dest, path.Base(target))
+		}
+		if *force {
+			if err := replaceWithLink(link, target, name); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := link(target, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceWithLink creates the link at a temporary name, then renames it over 'name'. If linking fails, 'name' is left as it was.
+func replaceWithLink(link func(target, name string) error, target, name string) error {
+	tempName := path.Join(path.Dir(name), fmt.Sprintf(".%s.ln-%d", path.Base(name), time.Now().UnixNano()))
+	if err := link(target, tempName); err != nil {
+		if linkErr, ok := err.(*os.LinkError); ok {
+			linkErr.New = name // report the requested name, not the temporary one
+		}
+		return err
+	}
+	if err := os.Rename(tempName, name); err != nil {
+		_ = os.Remove(tempName)
+		return err
+	}
+	return nil
+}
+
+func fileTypeName(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symbolic link"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode.IsRegular():
+		return "regular file"
+	default:
+		return "unknown"
+	}
+}
+
+// stat prints details about each file
+func stat(term console.Console, args ...string) error {
+	if len(args) == 0 {
+		return errors.New("Not enough args")
+	}
+	for _, path := range args {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(term.Stdout(), "  File: %s\n", path)
+		fmt.Fprintf(term.Stdout(), "  Size: %d\t%s\n", info.Size(), fileTypeName(info.Mode()))
+		fmt.Fprintf(term.Stdout(), "  Mode: (%04o/%s)\n", info.Mode().Perm(), info.Mode())
+		fmt.Fprintf(term.Stdout(), "Modify: %s\n", info.ModTime().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// tree prints the contents of directories as a tree
+func tree(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("tree", flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	all := set.Bool("a", false, "Include hidden files")
+	dirsOnly := set.Bool("d", false, "Only list directories")
+	maxLevel := set.Int("L", 0, "Max display depth of the directory tree")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	args = set.Args()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	var dirs, files int
+	var lastErr error
+	// walk prints the entries in 'dir'. Links are printed with their targets, but not followed.
+	var walk func(dir, prefix string, level int)
+	walk = func(dir, prefix string, level int) {
+		if *maxLevel > 0 && level > *maxLevel {
+			return
+		}
+		dirNames, err := readDirNames(dir)
+		if err != nil {
+			printErr(term, err)
+			lastErr = &statusErr{code: 1}
+		}
+		type entry struct {
+			name string
+			info os.FileInfo
+		}
+		var entries []entry
+		for _, name := range dirNames {
+			if !*all && strings.HasPrefix(name, ".") {
+				continue
+			}
+			info, err := os.Lstat(joinGlobPath(dir, name))
+			if err != nil {
+				printErr(term, err)
+				lastErr = &statusErr{code: 1}
+				continue
+			}
+			if *dirsOnly && !info.IsDir() {
+				continue
+			}
+			entries = append(entries, entry{name: name, info: info})
+		}
+		for i, e := range entries {
+			branch, indent := "├── ", "│   "
+			if i == len(entries)-1 {
+				branch, indent = "└── ", "    "
+			}
+			childPath := joinGlobPath(dir, e.name)
+			line := prefix + branch + e.name
+			if e.info.Mode()&os.ModeSymlink != 0 {
+				if target, err := os.Readlink(childPath); err == nil {
+					line += " -> " + target
+				}
+			}
+			fmt.Fprintln(term.Stdout(), line)
+			if e.info.IsDir() {
+				dirs++
+				walk(childPath, prefix+indent, level+1)
+			} else {
+				files++
+			}
+		}
+	}
+
+	for _, dir := range args {
+		if !isDir(dir) {
+			return errors.Errorf("Not a directory: %s", dir)
+		}
+		fmt.Fprintln(term.Stdout(), dir)
+		walk(dir, "", 1)
+	}
+	if *dirsOnly {
+		fmt.Fprintf(term.Stdout(), "\n%d directories\n", dirs)
+	} else {
+		fmt.Fprintf(term.Stdout(), "\n%d directories, %d files\n", dirs, files)
+	}
+	return lastErr
+}
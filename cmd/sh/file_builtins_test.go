@@ -0,0 +1,178 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	return dir
+}
+
+func TestFileBuiltins(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "cp file",
+			line:        `echo a > f; cp f g; cat g`,
+			expectOut:   "a\n",
+		},
+		{
+			description: "cp into directory",
+			line:        `echo a > f; echo b > g; mkdir d; cp f g d; cat d/f d/g`,
+			expectOut:   "a\nb\n",
+		},
+		{
+			description: "cp directory requires -r",
+			line:        `mkdir d; cp d e`,
+			expectCode:  1,
+		},
+		{
+			description: "cp recursive",
+			line:        `mkdir d d/sub; echo a > d/sub/f; cp -r d e; cat e/sub/f`,
+			expectOut:   "a\n",
+		},
+		{
+			description: "cp recursive keeps links",
+			line:        `mkdir d; echo a > d/f; ln -s .. d/up; ln -s f d/link; cp -r d e; find e -type l; cat e/link`,
+			expectOut:   "e/link\ne/up\na\n",
+		},
+		{
+			description: "cp into itself",
+			line:        `mkdir d; cp -r d d/sub`,
+			expectCode:  1,
+		},
+		{
+			description: "cp onto itself",
+			line:        `echo a > f; cp f ./f; cat f`,
+			expectOut:   "a\ncp: f and ./f are the same file\n",
+		},
+		{
+			description: "find",
+			line:        `mkdir d d/sub; touch d/a.txt d/sub/b.txt d/sub/c.go; find d`,
+			expectOut:   "d\nd/a.txt\nd/sub\nd/sub/b.txt\nd/sub/c.go\n",
+		},
+		{
+			description: "find name and type",
+			line:        `mkdir d d/sub.txt; touch d/a.txt d/b.go; find d -name '*.txt' -type f`,
+			expectOut:   "d/a.txt\n",
+		},
+		{
+			description: "find max depth",
+			line:        `mkdir d d/sub; touch d/sub/a; find d -maxdepth 1`,
+			expectOut:   "d\nd/sub\n",
+		},
+		{
+			description: "find exec",
+			line:        `mkdir d; echo a > d/f; echo b > d/g; find d -type f -exec cat {} \;`,
+			expectOut:   "a\nb\n",
+		},
+		{
+			description: "find exec batch",
+			line:        `mkdir d; touch d/f d/g; find d -type f -exec echo files: {} +`,
+			expectOut:   "files: d/f d/g\n",
+		},
+		{
+			description: "ln",
+			line:        `echo a > f; ln f hard; ln -s f soft; cat hard soft; find . -type l`,
+			expectOut:   "a\na\n./soft\n",
+		},
+		{
+			description: "ln force replaces files",
+			line:        `echo a > f; echo b > g; ln -sf f g; cat g; find . -type l`,
+			expectOut:   "a\n./g\n",
+		},
+		{
+			description: "ln force keeps files when linking fails",
+			line:        `echo a > f; ln -f missing f; cat f; ls`,
+			expectOut:   "a\nf\nln: link missing f: no such file or directory\n",
+		},
+		{
+			description: "du",
+			line:        `mkdir d d/sub; echo a > d/sub/f; du d; du -s d; du -a d/sub`,
+			expectOut:   "1\td/sub\n1\td\n1\td\n1\td/sub/f\n1\td/sub\n",
+		},
+		{
+			description: "du human readable",
+			line:        `echo abc > f; du -h f`,
+			expectOut:   "4B\tf\n",
+		},
+		{
+			description: "tree",
+			line:        `mkdir d d/sub; touch d/a d/sub/b d/.hidden; tree d; tree -d d`,
+			expectOut: `d
+├── a
+└── sub
+    └── b
+
+1 directories, 2 files
+d
+└── sub
+
+1 directories
+`,
+		},
+		{
+			description: "tree doesn't follow links",
+			line:        `mkdir d d/sub; ln -s .. d/sub/up; tree d`,
+			expectOut:   "d\n└── sub\n    └── up -> ..\n\n1 directories, 1 files\n",
+		},
+		{
+			description: "tree max level",
+			line:        `mkdir d d/sub; touch d/sub/b; tree -L 1 d`,
+			expectOut:   "d\n└── sub\n\n1 directories, 0 files\n",
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			chdirTemp(t)
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
+
+func TestCopyPreserve(t *testing.T) {
+	chdirTemp(t)
+	require.NoError(t, ioutil.WriteFile("f", []byte("a"), 0600))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes("f", modTime, modTime))
+
+	_, code := runTestLine(t, `cp -rp f g`)
+	assert.Zero(t, code)
+	info, err := os.Stat("g")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.True(t, modTime.Equal(info.ModTime()), "Expected mod time %s, got %s", modTime, info.ModTime())
+}
+
+func TestStat(t *testing.T) {
+	chdirTemp(t)
+	require.NoError(t, ioutil.WriteFile("f", []byte("abc"), 0640))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes("f", modTime, modTime))
+
+	out, code := runTestLine(t, `stat f`)
+	assert.Zero(t, code)
+	assert.Equal(t, `  File: f
+  Size: 3	regular file
+  Mode: (0640/-rw-r-----)
+Modify: `+modTime.Local().Format(time.RFC3339)+"\n", out)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/pattern"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Glob options, set with 'shopt'
+var (
+	globMu      sync.RWMutex
+	globOptions = map[string]bool{
+		"dotglob":  false, // patterns match names starting with '.'
+		"failglob": false, // patterns without matches fail the command
+		"globstar": true,  // '**' matches files in all subdirectories
+		"nullglob": false, // patterns without matches expand to nothing
+	}
+)
+
+func init() {
+	builtins["shopt"] = shopt
+}
+
+func globOption(name string) bool {
+	globMu.RLock()
+	defer globMu.RUnlock()
+	return globOptions[name]
+}
+
+// shopt prints or sets shell options. Usage: shopt [-s|-u] [name...]
+func shopt(term console.Console, args ...string) error {
+	var enable *bool
+	if len(args) > 0 {
+		switch args[0] {
+		case "-s", "-u":
+			value := args[0] == "-s"
+			enable = &value
+			args = args[1:]
+		}
+	}
+
+	globMu.Lock()
+	defer globMu.Unlock()
+	for _, name := range args {
+		if _, ok := globOptions[name]; !ok {
+			return errors.Errorf("%s: invalid shell option name", name)
+		}
+	}
+	if enable != nil {
+		for _, name := range args {
+			globOptions[name] = *enable
+		}
+		return nil
+	}
+
+	if len(args) == 0 {
+		for name := range globOptions {
+			args = append(args, name)
+		}
+		sort.Strings(args)
+	}
+	for _, name := range args {
+		state := "off"
+		if globOptions[name] {
+			state = "on"
+		}
+		fmt.Fprintf(term.Stdout(), "%s\t%s\n", name, state)
+	}
+	return nil
+}
+
+// expandBraces returns the words produced by brace expansion, like 'a{b,c}' to 'ab' and 'ac'
+func expandBraces(word *syntax.Word) []*syntax.Word {
+	split := *word // SplitBraces replaces the Parts slice, so copy the word to keep the original intact
+	if !syntax.SplitBraces(&split) {
+		return []*syntax.Word{word}
+	}
+	return expand.Braces(&split)
+}
+
+// globPattern returns 'field' as a glob pattern, with quoted parts escaped. Returns false if no unquoted parts contain pattern characters.
+func globPattern(field []fieldPart) (string, bool) {
+	var pat strings.Builder
+	isGlob := false
+	for _, part := range field {
+		if part.quoted {
+			pat.WriteString(pattern.QuoteMeta(part.value, 0))
+			continue
+		}
+		pat.WriteString(part.value)
+		isGlob = isGlob || pattern.HasMeta(part.value, 0)
+	}
+	return pat.String(), isGlob
+}
+
+// globField expands 'field' into matching file paths. Fields without unquoted pattern characters are returned as-is.
+func globField(field []fieldPart) ([]string, error) {
+	pat, isGlob := globPattern(field)
+	if !isGlob {
+		return []string{joinField(field)}, nil
+	}
+	matches, err := glob(pat)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(matches) > 0:
+		return matches, nil
+	case globOption("nullglob"):
+		return nil, nil
+	case globOption("failglob"):
+		return nil, errors.Errorf("no match: %s", joinField(field))
+	default:
+		return []string{joinField(field)}, nil
+	}
+}
+
+// glob returns the sorted paths matching the pattern 'pat'
+func glob(pat string) ([]string, error) {
+	prefix := ""
+	if strings.HasPrefix(pat, "/") {
+		prefix = "/"
+		pat = strings.TrimLeft(pat, "/")
+	}
+	g := &globber{
+		dotglob:  globOption("dotglob"),
+		globstar: globOption("globstar"),
+	}
+	if err := g.match(prefix, strings.Split(pat, "/")); err != nil {
+		return nil, err
+	}
+	sort.Strings(g.matches)
+	return g.matches, nil
+}
+
+type globber struct {
+	dotglob, globstar bool
+	matches           []string
+}
+
+func joinGlobPath(prefix, name string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix + name
+	}
+	return prefix + "/" + name
+}
+
+// readDirNames returns the sorted names in 'dir'
+func readDirNames(dir string) ([]string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	sort.Strings(names)
+	return names, err
+}
+
+// isDir returns true if 'path' is a directory or a link to one
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// match adds paths under 'prefix' which match the remaining path 'components'
+func (g *globber) match(prefix string, components []string) error {
+	if len(components) == 0 {
+		g.matches = append(g.matches, prefix)
+		return nil
+	}
+	component, rest := components[0], components[1:]
+	switch {
+	case component == "": // trailing slash only matches directories
+		if len(rest) == 0 {
+			if isDir(prefix) {
+				g.matches = append(g.matches, prefix+"/")
+			}
+			return nil
+		}
+		return g.match(prefix, rest)
+	case !pattern.HasMeta(component, 0):
+		path := joinGlobPath(prefix, unescapePattern(component))
+		if len(rest) == 0 {
+			if _, err := os.Lstat(path); err != nil {
+				return nil
+			}
+		}
+		return g.match(path, rest)
+	case component == "**" && g.globstar:
+		return g.matchRecursive(prefix, rest)
+	}
+
+	expr, err := pattern.Regexp(component, pattern.Filenames)
+	if err != nil {
+		return nil // invalid patterns, like a lone '[', don't match anything
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil
+	}
+	names, _ := readDirNames(prefix) // unreadable directories don't match anything
+	for _, name := range names {
+		if !g.showHidden(name, component) || !re.MatchString(name) {
+			continue
+		}
+		path := joinGlobPath(prefix, name)
+		if len(rest) > 0 && !isDir(path) {
+			continue
+		}
+		if err := g.match(path, rest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchRecursive matches '**', which is zero or more directories. At the end of a pattern, it matches all files and directories.
+func (g *globber) matchRecursive(prefix string, rest []string) error {
+	if len(rest) > 0 {
+		if err := g.match(prefix, rest); err != nil {
+			return err
+		}
+	}
+	names, _ := readDirNames(prefix) // unreadable directories don't match anything
+	for _, name := range names {
+		if !g.showHidden(name, "") {
+			continue
+		}
+		path := joinGlobPath(prefix, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if len(rest) == 0 {
+			g.matches = append(g.matches, path)
+		}
+		if info.IsDir() {
+			if err := g.matchRecursive(path, rest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// showHidden returns true if 'name' can match 'component'. Names starting with '.' must be matched explicitly, unless dotglob is set.
+func (g *globber) showHidden(name, component string) bool {
+	if !strings.HasPrefix(name, ".") {
+		return true
+	}
+	return strings.HasPrefix(component, ".") || g.dotglob
+}
+
+// unescapePattern removes the backslash escapes from a pattern without any special characters
+func unescapePattern(pat string) string {
+	var s strings.Builder
+	for i := 0; i < len(pat); i++ {
+		if pat[i] == '\\' && i+1 < len(pat) {
+			i++
+		}
+		s.WriteByte(pat[i])
+	}
+	return s.String()
+}
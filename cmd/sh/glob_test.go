@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlob(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	for _, path := range []string{
+		"a.go",
+		"b.go",
+		"c.txt",
+		".hidden.go",
+		"cmd/x/main.go",
+		"cmd/y/main.go",
+		"cmd/y/other.go",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+	}
+
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "star",
+			line:        `echo *.go`,
+			expectOut:   "a.go b.go\n",
+		},
+		{
+			description: "question mark and brackets",
+			line:        `echo ?.go [bc].*`,
+			expectOut:   "a.go b.go b.go c.txt\n",
+		},
+		{
+			description: "quoted patterns are literal",
+			line:        `echo "*.go" '*.go' \*.go`,
+			expectOut:   "*.go *.go *.go\n",
+		},
+		{
+			description: "partly quoted pattern",
+			line:        `echo "cmd"/*/main.go`,
+			expectOut:   "cmd/x/main.go cmd/y/main.go\n",
+		},
+		{
+			description: "globstar",
+			line:        `echo cmd/**/main.go; echo **/other.go`,
+			expectOut:   "cmd/x/main.go cmd/y/main.go\ncmd/y/other.go\n",
+		},
+		{
+			description: "directories only",
+			line:        `echo cmd/*/`,
+			expectOut:   "cmd/x/ cmd/y/\n",
+		},
+		{
+			description: "hidden files",
+			line:        `echo .*.go; shopt -s dotglob; echo *.go; shopt -u dotglob`,
+			expectOut:   ".hidden.go\n.hidden.go a.go b.go\n",
+		},
+		{
+			description: "no match is literal",
+			line:        `echo *.none`,
+			expectOut:   "*.none\n",
+		},
+		{
+			description: "nullglob",
+			line:        `shopt -s nullglob; echo start *.none end; shopt -u nullglob`,
+			expectOut:   "start end\n",
+		},
+		{
+			description: "failglob",
+			line:        `shopt -s failglob; echo *.none; echo $?; shopt -u failglob`,
+			expectOut:   "1\nno match: *.none\n", // stdout, then stderr
+		},
+		{
+			description: "expanded variables are globbed",
+			line:        `pat='*.txt'; echo $pat "$pat"`,
+			expectOut:   "c.txt *.txt\n",
+		},
+		{
+			description: "test bracket is not a pattern",
+			line:        `[ -f a.go ] && echo yes`,
+			expectOut:   "yes\n",
+		},
+		{
+			description: "brace expansion",
+			line:        `echo x{a,b}y {1..3} "{a,b}"`,
+			expectOut:   "xay xby 1 2 3 {a,b}\n",
+		},
+		{
+			description: "brace expansion with glob",
+			line:        `echo *.{go,txt}`,
+			expectOut:   "a.go b.go c.txt\n",
+		},
+		{
+			description: "shopt prints options",
+			line:        `shopt nullglob globstar`,
+			expectOut:   "nullglob\toff\nglobstar\ton\n",
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+)
+
+const (
+	interruptExitCode = 130 // 128 + SIGINT
+	stoppedExitCode   = 148 // 128 + SIGTSTP
+)
+
+type jobState string
+
+const (
+	jobRunning jobState = "Running"
+	jobStopped jobState = "Stopped"
+	jobDone    jobState = "Done"
+)
+
+// job is a command run in its own goroutine, either a foreground command line or a background command started with '&'.
+// Stopped jobs block on writing output and between statements until resumed.
+type job struct {
+	id      int // set once added to the job table
+	command string
+	input   *inputBuffer // typed input forwarded from the terminal, or nil if the job can't read from it
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	state       jobState
+	err         error
+	isInterrupt bool
+	interruptCh chan struct{}
+	done        chan struct{}
+}
+
+// interruptErr stops a job interrupted with Ctrl-C
+type interruptErr struct{}
+
+func (e *interruptErr) Error() string {
+	return "interrupted"
+}
+
+// The job table holds background and stopped jobs
+var (
+	jobsMu            sync.Mutex
+	jobTable          []*job
+	foregroundJob     *job
+	lastBackgroundJob int
+	// interactive is true when reading commands from a terminal. Job control notices are only printed when interactive.
+	interactive bool
+)
+
+func init() {
+	for k, v := range map[string]builtinFunc{
+		"bg":   bgBuiltin,
+		"fg":   fgBuiltin,
+		"jobs": jobsBuiltin,
+		"wait": waitBuiltin,
+	} {
+		builtins[k] = v
+	}
+}
+
+func newJob(command string, input *inputBuffer) *job {
+	j := &job{
+		command:     command,
+		input:       input,
+		state:       jobRunning,
+		interruptCh: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	j.cond = sync.NewCond(&j.mu)
+	return j
+}
+
+// startJob runs 'run' in a new goroutine with 'stdin' as its input, writing to 'term's output
+func startJob(term console.Console, command string, stdin io.Reader, input *inputBuffer, run func(term console.Console) error) *job {
+	j := newJob(command, input)
+	jobTerm := &redirectConsole{
+		stdin:  stdin,
+		stdout: &jobWriter{job: j, writer: term.Stdout()},
+		stderr: &jobWriter{job: j, writer: term.Stderr()},
+		job:    j,
+		state:  stateFromConsole(term),
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				j.finish(errors.Errorf("panic: %s\n%s", r, string(debug.Stack())))
+			}
+		}()
+		j.finish(run(jobTerm))
+	}()
+	return j
+}
+
+// startBackgroundJob runs 'run' as a job in the job table. The job can't read from the terminal.
+func startBackgroundJob(term console.Console, command string, run func(term console.Console) error) *job {
+	j := startJob(term, command, strings.NewReader(""), nil, func(term console.Console) error {
+		err := run(term)
+		if exitErr, ok := errors.Cause(err).(*ExitErr); ok {
+			// 'exit' only ends the background job
+			return exitErrFromCmd(&statusErr{code: exitErr.Code}, false)
+		}
+		return err
+	})
+	addJob(j)
+	jobsMu.Lock()
+	lastBackgroundJob = j.id
+	jobsMu.Unlock()
+	return j
+}
+
+func (j *job) finish(err error) {
+	j.mu.Lock()
+	j.state = jobDone
+	j.err = err
+	j.cond.Broadcast()
+	j.mu.Unlock()
+	close(j.done)
+	if j.input != nil {
+		j.input.Close()
+	}
+}
+
+// interrupt stops the job at the next statement and kills its running processes
+func (j *job) interrupt() {
+	j.mu.Lock()
+	if !j.isInterrupt {
+		j.isInterrupt = true
+		close(j.interruptCh)
+	}
+	if j.state == jobStopped {
+		j.state = jobRunning
+	}
+	j.cond.Broadcast()
+	j.mu.Unlock()
+	if j.input != nil {
+		j.input.Close()
+	}
+}
+
+// interrupted returns a channel which is closed when the job is interrupted. Never closes for a nil job.
+func (j *job) interrupted() <-chan struct{} {
+	if j == nil {
+		return nil
+	}
+	return j.interruptCh
+}
+
+func (j *job) stop() {
+	j.setState(jobRunning, jobStopped)
+}
+
+func (j *job) resume() {
+	j.setState(jobStopped, jobRunning)
+}
+
+func (j *job) setState(from, to jobState) {
+	j.mu.Lock()
+	if j.state == from {
+		j.state = to
+		j.cond.Broadcast()
+	}
+	j.mu.Unlock()
+}
+
+// checkpoint blocks while the job is stopped. Returns an interruptErr if the job was interrupted.
+func (j *job) checkpoint() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for j.state == jobStopped && !j.isInterrupt {
+		j.cond.Wait()
+	}
+	if j.isInterrupt {
+		return &interruptErr{}
+	}
+	return nil
+}
+
+// waitForeground blocks until the job completes or is stopped. Returns true if the job was stopped.
+func (j *job) waitForeground() (stopped bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for j.state == jobRunning {
+		j.cond.Wait()
+	}
+	return j.state == jobStopped
+}
+
+func (j *job) status() (jobState, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state, j.err
+}
+
+// jobWriter writes a job's output, blocking while the job is stopped. Output from interrupted jobs is discarded.
+type jobWriter struct {
+	job    *job
+	writer io.Writer
+}
+
+func (w *jobWriter) Write(b []byte) (int, error) {
+	j := w.job
+	j.mu.Lock()
+	for j.state == jobStopped && !j.isInterrupt {
+		j.cond.Wait()
+	}
+	discard := j.isInterrupt
+	j.mu.Unlock()
+	if discard {
+		return len(b), nil
+	}
+	return w.writer.Write(b)
+}
+
+// inputBuffer holds terminal input for a foreground job. Writes never block, so the terminal can keep handling Ctrl-C and Ctrl-Z.
+type inputBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newInputBuffer() *inputBuffer {
+	b := &inputBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *inputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.cond.Broadcast()
+	return b.buf.Write(p)
+}
+
+func (b *inputBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.buf.Len() == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return b.buf.Read(p)
+}
+
+func (b *inputBuffer) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return nil
+}
+
+// jobFromConsole returns the job 'term' belongs to, or nil if it isn't running in a job
+func jobFromConsole(term console.Console) *job {
+	if c, ok := term.(*redirectConsole); ok {
+		return c.job
+	}
+	return nil
+}
+
+// runProcess runs 'cmd' to completion, or kills it if 'j' is interrupted
+func runProcess(cmd *exec.Cmd, j *job) error {
+	if j == nil {
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+	select {
+	case err := <-waitErr:
+		return err
+	case <-j.interrupted():
+		// killing isn't supported everywhere, so the job's writers discard any remaining output
+		_ = cmd.Process.Kill()
+		return &interruptErr{}
+	}
+}
+
+func addJob(j *job) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if j.id != 0 {
+		return
+	}
+	j.id = 1
+	for _, other := range jobTable {
+		if other.id >= j.id {
+			j.id = other.id + 1
+		}
+	}
+	jobTable = append(jobTable, j)
+}
+
+func removeJob(j *job) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for i, other := range jobTable {
+		if other == j {
+			jobTable = append(jobTable[:i], jobTable[i+1:]...)
+			return
+		}
+	}
+}
+
+func getForeground() *job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return foregroundJob
+}
+
+func setForeground(j *job) {
+	jobsMu.Lock()
+	foregroundJob = j
+	jobsMu.Unlock()
+}
+
+// lastBackgroundSpec returns the job spec of the last background job for '$!'
+func lastBackgroundSpec() (string, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if lastBackgroundJob == 0 {
+		return "", false
+	}
+	return "%" + strconv.Itoa(lastBackgroundJob), true
+}
+
+// findJob looks up a job by spec, like '%1' or '1'. An empty spec, '%%' or '%+' is the most recent job.
+func findJob(spec string) (*job, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if len(jobTable) == 0 {
+		return nil, errors.New("no current job")
+	}
+	switch spec {
+	case "", "%", "%%", "%+":
+		return jobTable[len(jobTable)-1], nil
+	case "%-":
+		if len(jobTable) < 2 {
+			return nil, errors.New("no previous job")
+		}
+		return jobTable[len(jobTable)-2], nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err == nil {
+		for _, j := range jobTable {
+			if j.id == id {
+				return j, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("%s: no such job", spec)
+}
+
+// formatJob formats a job's status line, like '[1]+  Running    sleep 10 &'
+func formatJob(j *job, marker rune) string {
+	state, err := j.status()
+	status, command := string(state), j.command
+	if state == jobRunning {
+		command += " &"
+	}
+	if state == jobDone {
+		if code := exitCodeFromErr(err); code != 0 {
+			status = fmt.Sprintf("Exit %d", code)
+		}
+	}
+	return fmt.Sprintf("[%d]%c  %-10s %s", j.id, marker, status, command)
+}
+
+// jobMarker returns '+' for the current job and '-' for the previous one
+func jobMarker(index, count int) rune {
+	switch index {
+	case count - 1:
+		return '+'
+	case count - 2:
+		return '-'
+	default:
+		return ' '
+	}
+}
+
+// takeJobNotices returns status lines for completed jobs and removes them from the job table
+func takeJobNotices() []string {
+	jobsMu.Lock()
+	table := append([]*job(nil), jobTable...)
+	jobsMu.Unlock()
+
+	var notices []string
+	for i, j := range table {
+		if state, _ := j.status(); state == jobDone {
+			notices = append(notices, formatJob(j, jobMarker(i, len(table))))
+			removeJob(j)
+		}
+	}
+	return notices
+}
+
+func jobsBuiltin(term console.Console, args ...string) error {
+	jobsMu.Lock()
+	table := append([]*job(nil), jobTable...)
+	jobsMu.Unlock()
+	for i, j := range table {
+		fmt.Fprintln(term.Stdout(), formatJob(j, jobMarker(i, len(table))))
+		if state, _ := j.status(); state == jobDone {
+			removeJob(j)
+		}
+	}
+	return nil
+}
+
+// fgBuiltin resumes a job and waits for it, forwarding terminal input to it
+func fgBuiltin(term console.Console, args ...string) error {
+	spec := ""
+	if len(args) > 0 {
+		spec = args[0]
+	}
+	j, err := findJob(spec)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(term.Stdout(), j.command)
+
+	previous := getForeground()
+	setForeground(j)
+	j.resume()
+	stopped := j.waitForeground()
+	if getForeground() == j {
+		setForeground(previous)
+	}
+	if stopped {
+		return &statusErr{code: stoppedExitCode}
+	}
+	removeJob(j)
+	_, err = j.status()
+	if exitErr, ok := errors.Cause(err).(*ExitErr); ok {
+		return exitErrFromCmd(&statusErr{code: exitErr.Code}, false)
+	}
+	return err
+}
+
+// bgBuiltin resumes a stopped job in the background
+func bgBuiltin(term console.Console, args ...string) error {
+	spec := ""
+	if len(args) > 0 {
+		spec = args[0]
+	}
+	j, err := findJob(spec)
+	if err != nil {
+		return err
+	}
+	j.resume()
+	fmt.Fprintf(term.Stdout(), "[%d]+ %s &\n", j.id, j.command)
+	return nil
+}
+
+// waitBuiltin waits for the given jobs, or all running jobs, to complete. Returns the exit status of the last job given.
+func waitBuiltin(term console.Console, args ...string) error {
+	var targets []*job
+	if len(args) == 0 {
+		jobsMu.Lock()
+		for _, j := range jobTable {
+			if state, _ := j.status(); state != jobStopped {
+				targets = append(targets, j)
+			}
+		}
+		jobsMu.Unlock()
+	}
+	for _, spec := range args {
+		j, err := findJob(spec)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, j)
+	}
+
+	self := jobFromConsole(term)
+	var lastErr error
+	for _, j := range targets {
+		select {
+		case <-j.done:
+		case <-self.interrupted():
+			return &interruptErr{}
+		}
+		_, lastErr = j.status()
+		removeJob(j)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	if code := exitCodeFromErr(lastErr); code != 0 {
+		return &statusErr{code: code}
+	}
+	return nil
+}
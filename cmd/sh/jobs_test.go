@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetJobs(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		jobsMu.Lock()
+		jobTable = nil
+		foregroundJob = nil
+		lastBackgroundJob = 0
+		jobsMu.Unlock()
+	})
+}
+
+func TestBackgroundJobs(t *testing.T) {
+	t.Cleanup(saveVars())
+
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "wait for all",
+			line:        `echo a & wait; echo b`,
+			expectOut:   "a\nb\n",
+		},
+		{
+			description: "wait returns job status",
+			line:        `false & wait %1; echo $?`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "last background job",
+			line:        `true & echo $!; wait $!`,
+			expectOut:   "%1\n",
+		},
+		{
+			description: "background list",
+			line:        `{ echo a; echo b; } & wait`,
+			expectOut:   "a\nb\n",
+		},
+		{
+			description: "exit only ends the job",
+			line:        `exit 3 & wait %1; echo $?`,
+			expectOut:   "3\nExited with code 3\n",
+		},
+		{
+			description: "fg waits for the job",
+			line:        `true & fg %1; echo $?`,
+			expectOut:   "true\n0\n",
+		},
+		{
+			description: "variables are copied",
+			line:        `job_var=1; job_var=2 & wait; echo $job_var`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "functions are copied",
+			line:        `job_func() { echo outer; }; { job_func() { echo inner; }; } & wait; job_func; unset -f job_func`,
+			expectOut:   "outer\n",
+		},
+		{
+			description: "exports while a job runs are kept",
+			line:        `(sleep 0.1) & export JOB_TEST_VAR=1; wait; echo $JOB_TEST_VAR; unset JOB_TEST_VAR`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "no such job",
+			line:        `wait %5`,
+			expectCode:  1,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			resetJobs(t)
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
+
+func TestBackgroundJobDirectory(t *testing.T) {
+	resetJobs(t)
+	chdirTemp(t)
+	out, code := runTestLine(t, `mkdir d; cd d & wait; echo *`)
+	assert.Equal(t, "d\n", out)
+	assert.Zero(t, code)
+}
+
+func TestJobsBuiltin(t *testing.T) {
+	resetJobs(t)
+	j := newJob("sleep 10", nil)
+	addJob(j)
+	j.stop()
+	done := newJob("echo hi", nil)
+	addJob(done)
+	done.finish(&statusErr{code: 2})
+
+	out, code := runTestLine(t, `jobs`)
+	assert.Equal(t, "[1]-  Stopped    sleep 10\n[2]+  Exit 2     echo hi\n", out)
+	assert.Zero(t, code)
+
+	out, _ = runTestLine(t, `jobs`)
+	assert.Equal(t, "[1]+  Stopped    sleep 10\n", out, "Done jobs should be removed after they're reported")
+}
+
+func TestStoppedJobBlocksOutput(t *testing.T) {
+	var buf bytes.Buffer
+	j := newJob("", nil)
+	j.stop()
+	writer := &jobWriter{job: j, writer: &buf}
+
+	written := make(chan struct{})
+	go func() {
+		_, _ = writer.Write([]byte("hello"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("Write should block while the job is stopped")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	j.resume()
+	<-written
+	assert.Equal(t, "hello", buf.String())
+}
+
+func TestInterruptJob(t *testing.T) {
+	var buf bytes.Buffer
+	j := newJob("", nil)
+	j.stop()
+	j.interrupt()
+	assert.IsType(t, &interruptErr{}, j.checkpoint(), "Interrupted jobs should stop at the next checkpoint, even if stopped")
+
+	writer := &jobWriter{job: j, writer: &buf}
+	n, err := writer.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Empty(t, buf.String(), "Output from interrupted jobs should be discarded")
+}
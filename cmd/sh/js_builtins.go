@@ -3,6 +3,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"strings"
@@ -24,6 +25,7 @@ func init() {
 	builtins["jseval"] = jseval
 	builtins["wpk"] = wpk
 	builtins["jsdownload"] = jsdownload
+	builtins["fsck"] = fsck
 	color.NoColor = false // override, since wasm isn't considered a "tty"
 }
 
@@ -71,3 +73,49 @@ func jsdownload(term console.Console, args ...string) error {
 	interop.StartDownload("", filePath, fileContents)
 	return nil
 }
+
+func fsck(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := set.Bool("repair", false, "Repair problems found")
+	if err := set.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if set.NArg() == 0 {
+		return errors.New(strings.TrimSpace(`
+Usage: fsck [-repair] MOUNT_PATH...
+
+Checks IndexedDB mounts for inconsistencies, like orphaned files and incorrect sizes.
+`))
+	}
+
+	var unrepaired int
+	for _, mountPath := range set.Args() {
+		prom := promise.From(goWasm.Call("fsck", mountPath, map[string]interface{}{"repair": *repair}))
+		result, err := prom.Await()
+		if err != nil {
+			return errors.Wrap(err, mountPath)
+		}
+		report := result.(js.Value)
+		problems := report.Get("problems")
+		for i := 0; i < problems.Length(); i++ {
+			problem := problems.Index(i)
+			fmt.Fprintf(term.Stdout(), "%s: %s: %s\n", problem.Get("path"), problem.Get("kind"), problem.Get("detail"))
+		}
+		status := "clean"
+		switch {
+		case report.Get("repaired").Bool():
+			status = fmt.Sprintf("%d problems repaired", problems.Length())
+		case problems.Length() > 0:
+			status = fmt.Sprintf("%d problems found", problems.Length())
+			unrepaired += problems.Length()
+		}
+		fmt.Fprintf(term.Stdout(), "%s: checked %d records, %s\n", mountPath, report.Get("checked").Int(), status)
+	}
+	if unrepaired > 0 {
+		return errors.New("Problems found. Run 'fsck -repair' to fix them.")
+	}
+	return nil
+}
@@ -2,9 +2,7 @@ package main
 
 import (
 	"flag"
-	"io"
 	"os"
-	"strings"
 )
 
 func main() {
@@ -12,21 +10,36 @@ func main() {
 }
 
 func run() int {
+	command := flag.String("c", "", "Read and execute commands from the given string value.")
+	forceInteractive := flag.Bool("i", false, "Run an interactive shell, even if standard input is not a terminal.")
+	flag.Parse()
+	args := flag.Args()
+
+	scriptTerm := &redirectConsole{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	switch {
+	case isFlagSet("c"):
+		// like other shells, the first argument after the command string is $0
+		if len(args) > 0 {
+			shellName, args = args[0], args[1:]
+		}
+		return runScript(scriptTerm, *command, args)
+	case len(args) > 0:
+		return runScriptFile(scriptTerm, args[0], args[1:])
+	case !*forceInteractive && !isTerminal(os.Stdin):
+		return runScriptStdin(scriptTerm, nil)
+	}
+
 	cancel, err := ttySetup()
 	if err != nil {
 		panic(err)
 	}
 	defer cancel()
 
-	command := flag.String("c", "", "Read and execute commands from the given string value.")
-	flag.Parse()
-
-	var reader io.RuneReader
-	if *command != "" {
-		reader = newRuneReader(strings.NewReader(*command))
-	} else {
-		reader = newRuneReader(os.Stdin)
-	}
+	reader := newRuneReader(os.Stdin)
 	os.Stdout, err = newCarriageReturnWriter(os.Stdout)
 	if err != nil {
 		panic(err)
@@ -39,3 +52,11 @@ func run() int {
 
 	return term.ReadEvalPrintLoop(reader)
 }
+
+func isFlagSet(name string) bool {
+	isSet := false
+	flag.Visit(func(f *flag.Flag) {
+		isSet = isSet || f.Name == name
+	})
+	return isSet
+}
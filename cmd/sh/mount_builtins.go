@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/johnstarich/go/datasize"
+	"github.com/pkg/errors"
+)
+
+// mountType describes a type of file system 'mount' can create
+type mountType struct {
+	overlayFunc string   // the goWasm function which creates the mount
+	needsSource bool     // the mount is downloaded from a URL path
+	options     []string // supported -o options, besides quotas
+	quota       bool     // supports the 'size' and 'inodes' options
+}
+
+var mountTypes = map[string]mountType{
+	"indexeddb": {overlayFunc: "overlayIndexedDB", options: []string{"cache", "dedup", "noperm"}, quota: true},
+	"memory":    {overlayFunc: "overlayMemory", quota: true},
+	"tar.gz":    {overlayFunc: "overlayTarGzip", needsSource: true, options: []string{"persist", "writable", "noperm"}, quota: true},
+	"zip":       {overlayFunc: "overlayZip", needsSource: true},
+}
+
+// mountRequest is a parsed 'mount' command
+type mountRequest struct {
+	fsType  string
+	source  string
+	path    string
+	options map[string]interface{} // options for the overlay function
+}
+
+// overlayArgs returns the arguments for the goWasm overlay function
+func (m mountRequest) overlayArgs() []interface{} {
+	args := []interface{}{m.path}
+	if m.source != "" {
+		args = append(args, m.source)
+	}
+	if len(m.options) > 0 {
+		args = append(args, m.options)
+	}
+	return args
+}
+
+func mountTypeNames() string {
+	var names []string
+	for name := range mountTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// parseMountArgs parses 'mount -t type [-o options] [source] path'. Returns flag.ErrHelp if usage was printed to 'output'.
+func parseMountArgs(output io.Writer, args []string) (mountRequest, error) {
+	set := flag.NewFlagSet("mount", flag.ContinueOnError)
+	set.SetOutput(output)
+	fsType := set.String("t", "", "File system type. One of: "+mountTypeNames())
+	options := set.String("o", "", "Comma-separated mount options")
+	if err := set.Parse(args); err != nil {
+		return mountRequest{}, err
+	}
+	usage := errors.New("Usage: mount -t type [-o options] [source] path")
+	mt, ok := mountTypes[*fsType]
+	switch {
+	case *fsType == "":
+		return mountRequest{}, usage
+	case !ok:
+		return mountRequest{}, errors.Errorf("Unknown file system type %q. Must be one of: %s", *fsType, mountTypeNames())
+	}
+
+	req := mountRequest{fsType: *fsType}
+	switch {
+	case mt.needsSource && set.NArg() == 2:
+		req.source, req.path = set.Arg(0), set.Arg(1)
+	case !mt.needsSource && set.NArg() == 1:
+		req.path = set.Arg(0)
+	default:
+		return mountRequest{}, usage
+	}
+	var err error
+	req.options, err = parseMountOptions(mt, *options)
+	return req, err
+}
+
+// parseMountOptions converts options like 'cache,size=10M' into their overlay function options
+func parseMountOptions(mt mountType, options string) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	quota := make(map[string]interface{})
+	for _, option := range strings.Split(options, ",") {
+		if option == "" {
+			continue
+		}
+		key, value := splitKeyValue(option)
+		supported := false
+		for _, name := range mt.options {
+			supported = supported || name == key
+		}
+		if mt.quota && (key == "size" || key == "inodes") {
+			supported = true
+		}
+		if !supported {
+			return nil, errors.Errorf("Unsupported mount option: %s", option)
+		}
+
+		switch key {
+		case "cache":
+			result["cacheInfo"] = true
+		case "dedup":
+			result["dedup"] = true
+		case "noperm":
+			result["checkPermissions"] = false
+		case "persist":
+			result["persist"] = true
+		case "writable":
+			if value != "memory" && value != "indexeddb" {
+				return nil, errors.Errorf("Invalid writable layer %q. Must be one of: memory, indexeddb", value)
+			}
+			result["writable"] = value
+		case "size":
+			size, err := parseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			quota["bytes"] = size
+		case "inodes":
+			inodes, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || inodes <= 0 {
+				return nil, errors.Errorf("Invalid inode limit: %s", value)
+			}
+			quota["inodes"] = inodes
+		}
+	}
+	if len(quota) > 0 {
+		result["quota"] = quota
+	}
+	return result, nil
+}
+
+// parseSize parses a byte count with an optional binary unit suffix, like 512, 10K, 20M, or 1G
+func parseSize(s string) (int64, error) {
+	units := map[string]func(float64) datasize.Size{
+		"":  func(b float64) datasize.Size { return datasize.Bytes(int64(b)) },
+		"K": datasize.Kibibytes,
+		"M": datasize.Mebibytes,
+		"G": datasize.Gibibytes,
+	}
+	number := strings.TrimRight(s, "KMGkmg")
+	unit, ok := units[strings.ToUpper(s[len(number):])]
+	value, err := strconv.ParseFloat(number, 64)
+	if !ok || err != nil || value <= 0 {
+		return 0, errors.Errorf("Invalid size: %q", s)
+	}
+	return unit(value).Bytes(), nil
+}
+
+// mountTypeName returns the 'mount' type for a mounted file system's name, like 'storer.Fs(*fs.indexedDBStorer)'
+func mountTypeName(fsName string) string {
+	switch {
+	case strings.HasPrefix(fsName, "quota.Fs("):
+		return mountTypeName(strings.TrimSuffix(strings.TrimPrefix(fsName, "quota.Fs("), ")"))
+	case strings.HasPrefix(fsName, "unionfs.Fs("):
+		var upper, lower string
+		_, err := fmt.Sscanf(strings.TrimPrefix(fsName, "unionfs.Fs"), "(%q, %q)", &upper, &lower)
+		if err != nil {
+			return fsName
+		}
+		return fmt.Sprintf("%s (writable: %s)", mountTypeName(lower), mountTypeName(upper))
+	case fsName == "MemMapFS":
+		return "memory"
+	case fsName == "tmpfs":
+		return "tmpfs"
+	case fsName == "zipfs":
+		return "zip"
+	case strings.HasPrefix(fsName, "tarfs.Fs("), fsName == "tarindex.Fs":
+		return "tar.gz"
+	case fsName == "storer.Fs(*fs.indexedDBStorer)", fsName == "storer.Fs(*storer.ContentAddressed)":
+		return "indexeddb"
+	case fsName == "storer.Fs(*fs.localStorer)":
+		return "localstorage"
+	default:
+		return fsName
+	}
+}
+
+// writeMounts prints each mount path and type, sorted by path
+func writeMounts(w io.Writer, mounts map[string]string) {
+	var t table
+	t.Add("Mounted on", "Type")
+	for _, mountPath := range sortedKeys(mounts) {
+		t.Add(mountPath, mountTypeName(mounts[mountPath]))
+	}
+	fmt.Fprint(w, t)
+}
+
+// containingMount returns the path of the mount in 'mounts' which contains the absolute path 'p'
+func containingMount(mounts map[string]string, p string) string {
+	containing := "/"
+	for mountPath := range mounts {
+		if (p == mountPath || strings.HasPrefix(p, mountPath+"/")) && len(mountPath) > len(containing) {
+			containing = mountPath
+		}
+	}
+	return containing
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mountUsage is the usage and limits of a mount. Limits <= 0 are unlimited.
+type mountUsage struct {
+	Bytes, Inodes       int64
+	MaxBytes, MaxInodes int64
+}
+
+// writeDiskUsage prints the usage of each mount, in 1K blocks, human readable sizes, or inodes. Mounts without usage tracking print '-'.
+func writeDiskUsage(w io.Writer, mounts map[string]string, usages map[string]mountUsage, human, inodes bool) {
+	formatSize := func(size int64) string {
+		if inodes {
+			return strconv.FormatInt(size, 10)
+		}
+		if human {
+			value, units := formatBytes(datasize.Bytes(size))
+			return value + units
+		}
+		const kilobyte = 1024
+		return strconv.FormatInt((size+kilobyte-1)/kilobyte, 10)
+	}
+
+	var t table
+	t.Align(leftAlign, leftAlign, rightAlign, rightAlign, rightAlign, rightAlign)
+	sizeHeader := "1K-blocks"
+	switch {
+	case inodes:
+		sizeHeader = "Inodes"
+	case human:
+		sizeHeader = "Size"
+	}
+	t.Add("Mounted on", "Type", sizeHeader, "Used", "Avail", "Use%")
+	for _, mountPath := range sortedKeys(mounts) {
+		fsType := mountTypeName(mounts[mountPath])
+		usage, ok := usages[mountPath]
+		if !ok {
+			t.Add(mountPath, fsType, "-", "-", "-", "-")
+			continue
+		}
+		used, max := usage.Bytes, usage.MaxBytes
+		if inodes {
+			used, max = usage.Inodes, usage.MaxInodes
+		}
+		if max <= 0 {
+			t.Add(mountPath, fsType, "-", formatSize(used), "-", "-")
+			continue
+		}
+		avail := max - used
+		if avail < 0 {
+			avail = 0
+		}
+		percent := (100*used + max - 1) / max // round up, like df
+		t.Add(mountPath, fsType, formatSize(max), formatSize(used), formatSize(avail), fmt.Sprintf("%d%%", percent))
+	}
+	fmt.Fprint(w, t)
+}
@@ -0,0 +1,113 @@
+// +build js,wasm
+
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"syscall/js"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/promise"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	builtins["df"] = df
+	builtins["mount"] = mount
+	builtins["umount"] = umount
+}
+
+func getMounts() map[string]string {
+	mounts := make(map[string]string)
+	for mountPath, fsName := range interop.Entries(goWasm.Call("getMounts")) {
+		mounts[mountPath] = fsName.String()
+	}
+	return mounts
+}
+
+// mount lists mounts and their types, or creates a new mount
+func mount(term console.Console, args ...string) error {
+	if len(args) == 0 {
+		writeMounts(term.Stdout(), getMounts())
+		return nil
+	}
+	req, err := parseMountArgs(term.Stderr(), args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	req.path, err = filepath.Abs(req.path)
+	if err != nil {
+		return err
+	}
+	prom := promise.From(goWasm.Call(mountTypes[req.fsType].overlayFunc, req.overlayArgs()...))
+	_, err = prom.Await()
+	return errors.Wrap(err, req.path)
+}
+
+// umount removes mounts, leaving their data intact
+func umount(term console.Console, args ...string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: umount path...")
+	}
+	for _, mountPath := range args {
+		mountPath, err := filepath.Abs(mountPath)
+		if err != nil {
+			return err
+		}
+		prom := promise.From(goWasm.Call("unmount", mountPath))
+		if _, err := prom.Await(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// df prints the usage and limits of each mount
+func df(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("df", flag.ContinueOnError)
+	set.SetOutput(term.Stderr())
+	human := set.Bool("h", false, "Print sizes in human readable format")
+	inodes := set.Bool("i", false, "Print inode usage instead of bytes")
+	if err := set.Parse(splitShortFlags(set, args)); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	mounts := getMounts()
+	if set.NArg() > 0 {
+		// only show the mounts containing the given paths
+		selected := make(map[string]string)
+		for _, p := range set.Args() {
+			p, err := filepath.Abs(p)
+			if err != nil {
+				return err
+			}
+			mountPath := containingMount(mounts, p)
+			selected[mountPath] = mounts[mountPath]
+		}
+		mounts = selected
+	}
+
+	result, err := promise.From(goWasm.Call("getMountUsage")).Await()
+	if err != nil {
+		return err
+	}
+	usages := make(map[string]mountUsage)
+	for mountPath, usage := range interop.Entries(result.(js.Value)) {
+		usages[mountPath] = mountUsage{
+			Bytes:     int64(usage.Get("bytes").Float()),
+			Inodes:    int64(usage.Get("inodes").Float()),
+			MaxBytes:  int64(usage.Get("maxBytes").Float()),
+			MaxInodes: int64(usage.Get("maxInodes").Float()),
+		}
+	}
+	writeDiskUsage(term.Stdout(), mounts, usages, *human, *inodes)
+	return nil
+}
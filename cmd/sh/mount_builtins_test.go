@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMountArgs(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		args        []string
+		expect      mountRequest
+		expectArgs  []interface{}
+		expectErr   string
+	}{
+		{
+			description: "indexeddb",
+			args:        []string{"-t", "indexeddb", "-o", "cache,dedup,size=10M,inodes=100", "/mnt"},
+			expect: mountRequest{
+				fsType: "indexeddb",
+				path:   "/mnt",
+				options: map[string]interface{}{
+					"cacheInfo": true,
+					"dedup":     true,
+					"quota": map[string]interface{}{
+						"bytes":  int64(10 << 20),
+						"inodes": int64(100),
+					},
+				},
+			},
+			expectArgs: []interface{}{"/mnt", map[string]interface{}{
+				"cacheInfo": true,
+				"dedup":     true,
+				"quota": map[string]interface{}{
+					"bytes":  int64(10 << 20),
+					"inodes": int64(100),
+				},
+			}},
+		},
+		{
+			description: "tar.gz",
+			args:        []string{"-t", "tar.gz", "-o", "persist,writable=memory,noperm", "wasm/go.tar.gz", "/usr/local/go"},
+			expect: mountRequest{
+				fsType: "tar.gz",
+				source: "wasm/go.tar.gz",
+				path:   "/usr/local/go",
+				options: map[string]interface{}{
+					"persist":          true,
+					"writable":         "memory",
+					"checkPermissions": false,
+				},
+			},
+			expectArgs: []interface{}{"/usr/local/go", "wasm/go.tar.gz", map[string]interface{}{
+				"persist":          true,
+				"writable":         "memory",
+				"checkPermissions": false,
+			}},
+		},
+		{
+			description: "zip",
+			args:        []string{"-t", "zip", "files.zip", "/mnt"},
+			expect: mountRequest{
+				fsType:  "zip",
+				source:  "files.zip",
+				path:    "/mnt",
+				options: map[string]interface{}{},
+			},
+			expectArgs: []interface{}{"/mnt", "files.zip"},
+		},
+		{
+			description: "memory",
+			args:        []string{"-t", "memory", "/mnt"},
+			expect: mountRequest{
+				fsType:  "memory",
+				path:    "/mnt",
+				options: map[string]interface{}{},
+			},
+			expectArgs: []interface{}{"/mnt"},
+		},
+		{
+			description: "missing type",
+			args:        []string{"/mnt"},
+			expectErr:   "Usage: mount -t type [-o options] [source] path",
+		},
+		{
+			description: "unknown type",
+			args:        []string{"-t", "nfs", "/mnt"},
+			expectErr:   `Unknown file system type "nfs". Must be one of: indexeddb, memory, tar.gz, zip`,
+		},
+		{
+			description: "missing source",
+			args:        []string{"-t", "zip", "/mnt"},
+			expectErr:   "Usage: mount -t type [-o options] [source] path",
+		},
+		{
+			description: "unsupported option",
+			args:        []string{"-t", "zip", "-o", "size=1M", "files.zip", "/mnt"},
+			expectErr:   "Unsupported mount option: size=1M",
+		},
+		{
+			description: "invalid writable layer",
+			args:        []string{"-t", "tar.gz", "-o", "writable=disk", "a.tar.gz", "/mnt"},
+			expectErr:   `Invalid writable layer "disk". Must be one of: memory, indexeddb`,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			req, err := parseMountArgs(ioutil.Discard, tc.args)
+			if tc.expectErr != "" {
+				assert.EqualError(t, err, tc.expectErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expect, req)
+			assert.Equal(t, tc.expectArgs, req.overlayArgs())
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		size      string
+		expect    int64
+		expectErr bool
+	}{
+		{size: "512", expect: 512},
+		{size: "10K", expect: 10 << 10},
+		{size: "1.5m", expect: 3 << 19},
+		{size: "2G", expect: 2 << 30},
+		{size: "", expectErr: true},
+		{size: "0", expectErr: true},
+		{size: "10T", expectErr: true},
+		{size: "M", expectErr: true},
+	} {
+		size, err := parseSize(tc.size)
+		if tc.expectErr {
+			assert.Error(t, err, "size: %q", tc.size)
+			continue
+		}
+		assert.NoError(t, err, "size: %q", tc.size)
+		assert.Equal(t, tc.expect, size, "size: %q", tc.size)
+	}
+}
+
+func TestMountTypeName(t *testing.T) {
+	for fsName, expect := range map[string]string{
+		"quota.Fs(MemMapFS)":                  "memory",
+		"tmpfs":                               "tmpfs",
+		"zipfs":                               "zip",
+		`tarfs.Fs("quota.Fs(MemMapFS)")`:      "tar.gz",
+		"tarindex.Fs":                         "tar.gz",
+		"storer.Fs(*fs.indexedDBStorer)":      "indexeddb",
+		"storer.Fs(*storer.ContentAddressed)": "indexeddb",
+		"storer.Fs(*fs.localStorer)":          "localstorage",
+		`unionfs.Fs("quota.Fs(MemMapFS)", "tarindex.Fs")`: "tar.gz (writable: memory)",
+		"custom.Fs": "custom.Fs",
+	} {
+		assert.Equal(t, expect, mountTypeName(fsName), "name: %s", fsName)
+	}
+}
+
+func TestContainingMount(t *testing.T) {
+	mounts := map[string]string{"/": "", "/home": "", "/home/me": "", "/tmp": ""}
+	assert.Equal(t, "/home/me", containingMount(mounts, "/home/me/project"))
+	assert.Equal(t, "/home/me", containingMount(mounts, "/home/me"))
+	assert.Equal(t, "/home", containingMount(mounts, "/home/mel"))
+	assert.Equal(t, "/", containingMount(mounts, "/usr"))
+}
+
+// trimTrailingSpace removes the trailing spaces of each line in a table
+func trimTrailingSpace(s string) string {
+	return regexp.MustCompile(`(?m) +$`).ReplaceAllString(s, "")
+}
+
+func TestWriteMounts(t *testing.T) {
+	var buf bytes.Buffer
+	writeMounts(&buf, map[string]string{
+		"/":    "quota.Fs(MemMapFS)",
+		"/tmp": "tmpfs",
+		"/bin": "storer.Fs(*fs.indexedDBStorer)",
+	})
+	assert.Equal(t, `Mounted on Type
+/          memory
+/bin       indexeddb
+/tmp       tmpfs
+`, trimTrailingSpace(buf.String()))
+}
+
+func TestWriteDiskUsage(t *testing.T) {
+	mounts := map[string]string{
+		"/":    "quota.Fs(MemMapFS)",
+		"/tmp": "tmpfs",
+		"/mnt": "zipfs",
+	}
+	usages := map[string]mountUsage{
+		"/":    {Bytes: 3000, Inodes: 10},
+		"/tmp": {Bytes: 1 << 20, Inodes: 5, MaxBytes: 4 << 20, MaxInodes: 20},
+	}
+	for _, tc := range []struct {
+		description  string
+		human, inode bool
+		expect       string
+	}{
+		{
+			description: "blocks",
+			expect: `Mounted on Type   1K-blocks Used Avail Use%
+/          memory         -    3     -    -
+/mnt       zip            -    -     -    -
+/tmp       tmpfs       4096 1024  3072  25%
+`,
+		},
+		{
+			description: "human",
+			human:       true,
+			expect: `Mounted on Type    Size Used Avail Use%
+/          memory     -  3kB     -    -
+/mnt       zip        -    -     -    -
+/tmp       tmpfs  4.2MB  1MB 3.1MB  25%
+`,
+		},
+		{
+			description: "inodes",
+			inode:       true,
+			expect: `Mounted on Type   Inodes Used Avail Use%
+/          memory      -   10     -    -
+/mnt       zip         -    -     -    -
+/tmp       tmpfs      20    5    15  25%
+`,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			var buf bytes.Buffer
+			writeDiskUsage(&buf, mounts, usages, tc.human, tc.inode)
+			assert.Equal(t, tc.expect, trimTrailingSpace(buf.String()))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+)
+
+// Shell options, set with 'set -o name' or the short flags in shortOptions
+var (
+	optionsMu    sync.RWMutex
+	shellOptions = map[string]bool{
+		"errexit":  false, // exit when a command fails
+		"pipefail": false, // pipelines fail with the last non-zero exit status of any command
+		"xtrace":   false, // print commands before running them
+	}
+	shortOptions = map[rune]string{
+		'e': "errexit",
+		'x': "xtrace",
+	}
+)
+
+func shellOption(name string) bool {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+	return shellOptions[name]
+}
+
+func setShellOption(name string, enable bool) error {
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+	if _, ok := shellOptions[name]; !ok {
+		return errors.Errorf("%s: invalid option name", name)
+	}
+	shellOptions[name] = enable
+	return nil
+}
+
+// setOptions parses and applies options for 'set', like '-e', '+x' or '-o pipefail'.
+// Returns the remaining arguments, and true if they should replace the positional arguments.
+func setOptions(term console.Console, args []string) ([]string, bool, error) {
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			return args[1:], true, nil
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			return args, true, nil
+		}
+		args = args[1:]
+		enable := arg[0] == '-'
+		for _, flag := range arg[1:] {
+			if flag == 'o' {
+				if len(args) == 0 {
+					printShellOptions(term, enable)
+					continue
+				}
+				if err := setShellOption(args[0], enable); err != nil {
+					return nil, false, err
+				}
+				args = args[1:]
+				continue
+			}
+			name, ok := shortOptions[flag]
+			if !ok {
+				return nil, false, errors.Errorf("%s: invalid option", arg)
+			}
+			if err := setShellOption(name, enable); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	return nil, false, nil
+}
+
+// printShellOptions prints each option's state, either for reading by people with 'set -o' or the shell with 'set +o'
+func printShellOptions(term console.Console, readable bool) {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+	names := make([]string, 0, len(shellOptions))
+	for name := range shellOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		enabled := shellOptions[name]
+		switch {
+		case readable && enabled:
+			fmt.Fprintf(term.Stdout(), "%-15s\ton\n", name)
+		case readable:
+			fmt.Fprintf(term.Stdout(), "%-15s\toff\n", name)
+		case enabled:
+			fmt.Fprintf(term.Stdout(), "set -o %s\n", name)
+		default:
+			fmt.Fprintf(term.Stdout(), "set +o %s\n", name)
+		}
+	}
+}
+
+// withoutErrExit returns a console where failed commands don't exit the shell, like in if conditions or the left side of '&&'
+func withoutErrExit(term console.Console) console.Console {
+	c := redirectTerm(term, getConsoleStdin(term), term.Stdout(), term.Stderr())
+	c.noErrExit = true
+	return c
+}
+
+func noErrExit(term console.Console) bool {
+	c, ok := term.(*redirectConsole)
+	return ok && c.noErrExit
+}
+
+// errExit returns an ExitErr for a failed command if 'errexit' is set. Commands run with withoutErrExit are ignored.
+func errExit(term console.Console, err error) error {
+	if err == nil || isControlFlow(err) || !shellOption("errexit") {
+		return err
+	}
+	if noErrExit(term) {
+		return err
+	}
+	printErr(term, err)
+	return &ExitErr{Code: exitCodeFromErr(err)}
+}
+
+// traceCommand prints a command and its arguments to stderr if 'xtrace' is set
+func traceCommand(term console.Console, env []string, commandName string, args []string) {
+	if !shellOption("xtrace") {
+		return
+	}
+	words := make([]string, 0, len(env)+len(args)+1)
+	for _, pair := range env {
+		key, value := splitKeyValue(pair)
+		words = append(words, key+"="+shellQuote(value))
+	}
+	words = append(words, shellQuote(commandName))
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	fmt.Fprintf(term.Stderr(), "+ %s\n", strings.Join(words, " "))
+}
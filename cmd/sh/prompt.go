@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
 
 	"github.com/fatih/color"
@@ -23,7 +24,16 @@ func prompt(term *terminal) string {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to render prompt: ", err)
 	}
-	return s
+	return jobNotices() + s
+}
+
+// jobNotices reports background jobs which completed since the last prompt
+func jobNotices() string {
+	var s strings.Builder
+	for _, notice := range takeJobNotices() {
+		s.WriteString(notice + "\n")
+	}
+	return s.String()
 }
 
 func promptErr(term *terminal) (string, error) {
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// fileTable holds a command's file descriptors, starting with 0, 1, and 2 for stdin, stdout, and stderr.
+// Entries are an io.Reader, an io.Writer, or both like *os.File. Closed descriptors are nil.
+type fileTable struct {
+	files   []interface{}
+	closers []io.Closer // files opened by redirections, closed once the command completes
+}
+
+// closedFile fails all reads and writes, like a descriptor closed with '>&-'
+type closedFile struct {
+	fd int
+}
+
+func (c closedFile) Read([]byte) (int, error) {
+	return 0, errors.Errorf("%d: Bad file descriptor", c.fd)
+}
+
+func (c closedFile) Write([]byte) (int, error) {
+	return 0, errors.Errorf("%d: Bad file descriptor", c.fd)
+}
+
+// applyRedirects opens the files for 'redirs' in order, starting from the descriptors of 'term'
+func applyRedirects(term console.Console, redirs []*syntax.Redirect) (*fileTable, error) {
+	files := &fileTable{
+		files: []interface{}{getConsoleStdin(term), term.Stdout(), term.Stderr()},
+	}
+	if c, ok := term.(*redirectConsole); ok {
+		files.files = append(files.files, c.extraFiles...)
+	}
+	for _, redir := range redirs {
+		if err := files.apply(term, redir); err != nil {
+			files.Close()
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (t *fileTable) Close() error {
+	var firstErr error
+	for _, closer := range t.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	t.closers = nil
+	return firstErr
+}
+
+func (t *fileTable) get(fd int) (interface{}, error) {
+	if fd < len(t.files) && t.files[fd] != nil {
+		if _, isClosed := t.files[fd].(closedFile); !isClosed {
+			return t.files[fd], nil
+		}
+	}
+	return nil, errors.Errorf("%d: Bad file descriptor", fd)
+}
+
+func (t *fileTable) set(fd int, file interface{}) {
+	for fd >= len(t.files) {
+		t.files = append(t.files, nil)
+	}
+	t.files[fd] = file
+}
+
+func (t *fileTable) open(path string, flag int) (*os.File, error) {
+	const createMode = 0666
+	file, err := os.OpenFile(path, flag, createMode)
+	if err != nil {
+		return nil, err
+	}
+	t.closers = append(t.closers, file)
+	return file, nil
+}
+
+func (t *fileTable) apply(term console.Console, redir *syntax.Redirect) error {
+	var target string
+	var err error
+	switch redir.Op {
+	case syntax.Hdoc, // <<
+		syntax.DashHdoc: // <<-
+		if redir.Hdoc == nil {
+			var word string
+			if redir.Word != nil {
+				word, _ = evalWord(term, redir.Word.Parts)
+				word = ": " + word
+			}
+			return errors.New("Invalid heredoc" + word)
+		}
+		target, err = evalHeredoc(term, redir.Word, redir.Hdoc)
+	default:
+		target, err = evalWord(term, redir.Word.Parts)
+	}
+	if err != nil {
+		return err
+	}
+
+	fd := 1
+	switch redir.Op {
+	case syntax.RdrIn, // <
+		syntax.RdrInOut, // <>
+		syntax.DplIn,    // <&
+		syntax.Hdoc,     // <<
+		syntax.DashHdoc, // <<-
+		syntax.WordHdoc: // <<<
+		fd = 0
+	}
+	if redir.N != nil {
+		fd, err = strconv.Atoi(redir.N.Value)
+		if err != nil {
+			return errors.Errorf("%s: Bad file descriptor", redir.N.Value)
+		}
+	}
+
+	switch redir.Op {
+	case syntax.RdrOut, // >
+		syntax.ClbOut, // >|
+		syntax.AppOut: // >>
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if redir.Op == syntax.AppOut {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := t.open(target, flag)
+		if err != nil {
+			return err
+		}
+		t.set(fd, file)
+	case syntax.RdrAll, // &>
+		syntax.AppAll: // &>>
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if redir.Op == syntax.AppAll {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		file, err := t.open(target, flag)
+		if err != nil {
+			return err
+		}
+		t.set(1, file)
+		t.set(2, file)
+	case syntax.RdrIn: // <
+		file, err := t.open(target, os.O_RDONLY)
+		if err != nil {
+			return err
+		}
+		t.set(fd, file)
+	case syntax.RdrInOut: // <>
+		file, err := t.open(target, os.O_RDWR|os.O_CREATE)
+		if err != nil {
+			return err
+		}
+		t.set(fd, file)
+	case syntax.DplIn, // <&
+		syntax.DplOut: // >&
+		return t.duplicate(redir, fd, target)
+	case syntax.Hdoc, // <<
+		syntax.DashHdoc, // <<-
+		syntax.WordHdoc: // <<<
+		if redir.Op == syntax.WordHdoc {
+			target += "\n"
+		}
+		t.set(fd, strings.NewReader(target))
+	default:
+		return errors.Errorf("File redirect of type %q are not supported", redir.Op.String())
+	}
+	return nil
+}
+
+// duplicate runs '<&' and '>&', which copy or close file descriptors. Also handles the '>&file' form of '&>file'.
+func (t *fileTable) duplicate(redir *syntax.Redirect, fd int, target string) error {
+	if target == "-" {
+		t.set(fd, closedFile{fd: fd})
+		return nil
+	}
+	sourceFD, err := strconv.Atoi(target)
+	if err != nil {
+		if redir.Op == syntax.DplOut && redir.N == nil {
+			file, err := t.open(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+			if err != nil {
+				return err
+			}
+			t.set(1, file)
+			t.set(2, file)
+			return nil
+		}
+		return errors.Errorf("%s: ambiguous redirect", target)
+	}
+	file, err := t.get(sourceFD)
+	if err != nil {
+		return err
+	}
+	t.set(fd, file)
+	return nil
+}
+
+// console returns a console for the redirected descriptors, in the same job as 'term'
+func (t *fileTable) console(term console.Console) (*redirectConsole, error) {
+	var std [3]interface{}
+	copy(std[:], t.files)
+	for fd, file := range std {
+		if file == nil {
+			std[fd] = closedFile{fd: fd}
+		}
+	}
+	stdin, isReader := std[0].(io.Reader)
+	if !isReader {
+		return nil, errors.New("0: Bad file descriptor")
+	}
+	stdout, isWriter := std[1].(io.Writer)
+	if !isWriter {
+		return nil, errors.New("1: Bad file descriptor")
+	}
+	stderr, isWriter := std[2].(io.Writer)
+	if !isWriter {
+		return nil, errors.New("2: Bad file descriptor")
+	}
+	c := redirectTerm(term, stdin, stdout, stderr)
+	if len(t.files) > 3 {
+		c.extraFiles = append([]interface{}(nil), t.files[3:]...)
+	}
+	return c, nil
+}
+
+// consoleExtraFiles returns the descriptors above 2 redirected in 'term', where entry i is descriptor 3+i
+func consoleExtraFiles(term console.Console) []interface{} {
+	if c, ok := term.(*redirectConsole); ok {
+		return c.extraFiles
+	}
+	return nil
+}
+
+// processExtraFiles converts descriptors above 2 into files for a process, where entry i is descriptor 3+i
+func processExtraFiles(extraFiles []interface{}) ([]*os.File, error) {
+	var files []*os.File
+	for i, file := range extraFiles {
+		switch file := file.(type) {
+		case nil, closedFile:
+			files = append(files, nil)
+		case *os.File:
+			files = append(files, file)
+		default:
+			return nil, errors.Errorf("%d: only files can be passed to commands as extra descriptors", i+3)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirects(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+		expectFiles map[string]string
+	}{
+		{
+			description: "output and append",
+			line:        `echo a > out; echo b >> out; echo c >| out2`,
+			expectFiles: map[string]string{"out": "a\nb\n", "out2": "c\n"},
+		},
+		{
+			description: "input",
+			line:        `echo a > in; cat < in`,
+			expectOut:   "a\n",
+		},
+		{
+			description: "stderr to stdout",
+			line:        `{ echo out; echo err >&2; } > both 2>&1`,
+			expectFiles: map[string]string{"both": "out\nerr\n"},
+		},
+		{
+			description: "redirects run in order",
+			line:        `{ echo out; echo err >&2; } 2>&1 > out`,
+			expectOut:   "err\n",
+			expectFiles: map[string]string{"out": "out\n"},
+		},
+		{
+			description: "all output",
+			line:        `{ echo out; echo err >&2; } &> both; { echo more >&2; } &>> both`,
+			expectFiles: map[string]string{"both": "out\nerr\nmore\n"},
+		},
+		{
+			description: "output to file with >&",
+			line:        `{ echo out; echo err >&2; } >& both`,
+			expectFiles: map[string]string{"both": "out\nerr\n"},
+		},
+		{
+			description: "stderr in pipes",
+			line:        `{ echo err >&2; } 2>&1 | cat > piped`,
+			expectFiles: map[string]string{"piped": "err\n"},
+		},
+		{
+			description: "closed stdout",
+			line:        `echo a >&-`,
+			expectOut:   "echo: 1: Bad file descriptor\n",
+			expectCode:  1,
+		},
+		{
+			description: "closed stderr",
+			line:        `{ echo a; echo b >&2; } 2>&-`,
+			expectOut:   "a\n",
+			expectCode:  1, // writing to stderr fails
+		},
+		{
+			description: "read and write",
+			line:        `echo abc > rw; cat <> rw`,
+			expectOut:   "abc\n",
+		},
+		{
+			description: "descriptors above 2",
+			line:        `{ echo three >&3; echo out; } 3> three`,
+			expectOut:   "out\n",
+			expectFiles: map[string]string{"three": "three\n"},
+		},
+		{
+			description: "swap stdout and stderr",
+			line:        `{ echo out; echo err >&2; } 2> swapped 3>&1 1>&2 2>&3`,
+			expectOut:   "err\n",
+			expectFiles: map[string]string{"swapped": "out\n"},
+		},
+		{
+			description: "builtin with extra pipe descriptor",
+			line:        `echo hi 3>&1 1>&2 2>&3`,
+			expectOut:   "hi\n",
+		},
+		{
+			description: "function with extra pipe descriptor",
+			line:        `f() { echo three >&3; }; f 3>&1 | cat; unset -f f`,
+			expectOut:   "three\n",
+		},
+		{
+			description: "duplicate input",
+			line:        `echo in > in; cat <&3 3< in`,
+			expectCode:  1,
+		},
+		{
+			description: "duplicate input in order",
+			line:        `echo in > in; cat 3< in <&3`,
+			expectOut:   "in\n",
+		},
+		{
+			description: "bad descriptor",
+			line:        `echo a >&5`,
+			expectCode:  1,
+		},
+		{
+			description: "here string",
+			line:        `cat <<< "a b"`,
+			expectOut:   "a b\n",
+		},
+		{
+			description: "loop output",
+			line:        `for i in 1 2; do echo $i; done > loop`,
+			expectFiles: map[string]string{"loop": "1\n2\n"},
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+			for name, contents := range tc.expectFiles {
+				data, err := ioutil.ReadFile(name)
+				require.NoError(t, err)
+				assert.Equal(t, contents, string(data), name)
+			}
+		})
+	}
+}
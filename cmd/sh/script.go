@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+const syntaxErrExitCode = 2
+
+// runScript runs 'script' non-interactively with the positional arguments 'args', like 'sh -c' or 'sh file.sh'. Returns the exit code.
+func runScript(term console.Console, script string, args []string) int {
+	setPositional(term, args)
+	err := runLine(term, script)
+	switch err := errors.Cause(err).(type) {
+	case *ExitErr:
+		return err.Code
+	case syntax.ParseError:
+		fmt.Fprintf(term.Stderr(), "%s: %s\n", shellName, err)
+		return syntaxErrExitCode
+	}
+	printErr(term, err)
+	return exitCodeFromErr(err)
+}
+
+// runScriptFile runs the script at 'path', using 'path' as '$0'
+func runScriptFile(term console.Console, path string, args []string) int {
+	script, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(term.Stderr(), "%s: %s\n", shellName, err)
+		return 127
+	}
+	shellName = path
+	return runScript(term, string(script), args)
+}
+
+// runScriptStdin runs the script read from standard input
+func runScriptStdin(term console.Console, args []string) int {
+	script, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(term.Stderr(), "%s: %s\n", shellName, err)
+		return 1
+	}
+	return runScript(term, string(script), args)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetShellOptions(t *testing.T) {
+	t.Helper()
+	t.Cleanup(saveVars())
+	t.Cleanup(func() {
+		optionsMu.Lock()
+		for name := range shellOptions {
+			shellOptions[name] = false
+		}
+		optionsMu.Unlock()
+	})
+}
+
+func runTestScript(t *testing.T, script string, args ...string) (string, int) {
+	t.Helper()
+	var output bytes.Buffer
+	term := &redirectConsole{
+		stdin:  strings.NewReader(""),
+		stdout: &output,
+		stderr: &output,
+	}
+	code := runScript(term, script, args)
+	return output.String(), code
+}
+
+func TestRunScript(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		script      string
+		args        []string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "multiple lines",
+			script:      "echo a\nif true; then\n  echo b\nfi\n",
+			expectOut:   "a\nb\n",
+		},
+		{
+			description: "positional parameters",
+			script:      `echo $# $1 "$2"`,
+			args:        []string{"a", "b c"},
+			expectOut:   "2 a b c\n",
+		},
+		{
+			description: "last status is the exit code",
+			script:      "echo a\nfalse",
+			expectOut:   "a\n",
+			expectCode:  1,
+		},
+		{
+			description: "exit",
+			script:      "exit 3\necho unreachable",
+			expectOut:   "Exited with code 3\n",
+			expectCode:  3,
+		},
+		{
+			description: "syntax error",
+			script:      "if true; then",
+			expectOut:   "sh: 1:10: \"then\" must be followed by a statement list\n",
+			expectCode:  2,
+		},
+		{
+			description: "errexit",
+			script:      "set -e\necho a\nfalse\necho b",
+			expectOut:   "a\n",
+			expectCode:  1,
+		},
+		{
+			description: "errexit ignores conditions",
+			script:      "set -e\nif false; then echo a; fi\nfalse || echo b\nfalse && echo c\n! true\nwhile false; do :; done\necho d",
+			expectOut:   "b\nd\n",
+		},
+		{
+			description: "errexit in functions",
+			script:      "set -e\nf() { false; echo a; }\nf\necho b",
+			expectCode:  1,
+		},
+		{
+			description: "errexit disabled",
+			script:      "set -e\nset +e\nfalse\necho a",
+			expectOut:   "a\n",
+		},
+		{
+			description: "xtrace",
+			script:      "set -x\na='b c'\necho \"$a\" d\nset +x\necho e",
+			expectOut:   "+ echo 'b c' d\nb c d\n+ set +x\ne\n",
+		},
+		{
+			description: "pipeline status is the last command",
+			script:      "false | true; echo $?; true | false; echo $?",
+			expectOut:   "0\n1\n",
+		},
+		{
+			description: "pipefail",
+			script:      "set -o pipefail\nfalse | true; echo $?",
+			expectOut:   "1\n",
+		},
+		{
+			description: "errexit with pipefail",
+			script:      "set -eo pipefail\nfalse | true\necho a",
+			expectCode:  1,
+		},
+		{
+			description: "set options and positional parameters",
+			script:      "set -e -- a b\necho $#",
+			expectOut:   "2\n",
+		},
+		{
+			description: "invalid option",
+			script:      "set -o nope",
+			expectOut:   "set: nope: invalid option name\n",
+			expectCode:  1,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			resetShellOptions(t)
+			out, code := runTestScript(t, tc.script, tc.args...)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
+
+func TestPrintShellOptions(t *testing.T) {
+	resetShellOptions(t)
+	out, code := runTestScript(t, "set -o pipefail\nset -o\nset +o")
+	assert.Equal(t, `errexit        	off
+pipefail       	on
+xtrace         	off
+set +o errexit
+set -o pipefail
+set +o xtrace
+`, out)
+	assert.Zero(t, code)
+}
+
+func TestRunScriptFile(t *testing.T) {
+	resetShellOptions(t)
+	defer func(name string) {
+		shellName = name
+	}(shellName)
+
+	path := filepath.Join(t.TempDir(), "script.sh")
+	require.NoError(t, ioutil.WriteFile(path, []byte("echo $0 $1\n"), 0600))
+	var output bytes.Buffer
+	term := &redirectConsole{
+		stdin:  strings.NewReader(""),
+		stdout: &output,
+		stderr: &output,
+	}
+	code := runScriptFile(term, path, []string{"arg"})
+	assert.Equal(t, path+" arg\n", output.String())
+	assert.Zero(t, code)
+}
@@ -5,12 +5,13 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"runtime/debug"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
+	"github.com/johnstarich/go-wasm/internal/console"
 	"github.com/johnstarich/go-wasm/log"
 	"github.com/pkg/errors"
 )
@@ -20,6 +21,7 @@ const (
 	controlClear          = '\f'
 	controlCloseStdin     = '\x04'
 	controlSigTStop       = '\x03'
+	controlSuspend        = '\x1A'
 	controlCursorBackward = 'D'
 	controlCursorDown     = 'B'
 	controlCursorForward  = 'C'
@@ -42,6 +44,7 @@ type terminal struct {
 	// command state
 	lastExitCode int
 	history      *history
+	lineJob      *job // the running command line, or nil if waiting for input
 }
 
 func newTerminal() *terminal {
@@ -79,9 +82,35 @@ func (t *terminal) ErrPrint(args ...interface{}) {
 }
 
 func (t *terminal) ReadEvalPrintLoop(reader io.RuneReader) int {
+	interactive = true
 	fmt.Fprint(t.Stdout(), prompt(t))
+	runes := newChanRuneReader(reader)
 	for {
-		err := t.ReadEvalPrint(reader)
+		var lineDone <-chan struct{}
+		if t.lineJob != nil {
+			lineDone = t.lineJob.done
+		}
+		select {
+		case <-lineDone:
+			if exitErr := t.finishLine(); exitErr != nil {
+				return exitErr.Code
+			}
+			continue
+		case result := <-runes.results:
+			runes.pending = &result
+		}
+
+		err := t.ReadEvalPrint(runes)
+		if err == io.EOF && t.lineJob != nil {
+			// finish running the last command before exiting
+			if t.lineJob.input != nil {
+				t.lineJob.input.Close()
+			}
+			<-t.lineJob.done
+			if exitErr := t.finishLine(); exitErr != nil {
+				return exitErr.Code
+			}
+		}
 		if exitErr, ok := err.(*ExitErr); ok {
 			return exitErr.Code
 		}
@@ -130,6 +159,11 @@ func (t *terminal) ReadEvalPrint(reader io.RuneReader) error {
 		return err
 	}
 
+	if t.lineJob != nil {
+		t.foregroundInput(r)
+		return nil
+	}
+
 	switch r {
 	case escapeCSI:
 		err := t.ReadEvalEscape(r, reader)
@@ -157,20 +191,15 @@ func (t *terminal) ReadEvalPrint(reader io.RuneReader) error {
 		command := string(t.line)
 		t.line = nil
 		t.cursor = 0
-		err = runLine(t, command)
-		t.lastExitCode = 0
+		err := t.history.Push(command)
 		if err != nil {
 			t.ErrPrint(color.RedString(err.Error()) + "\n")
-			t.lastExitCode = 1
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				t.lastExitCode = exitErr.ExitCode()
-			}
 		}
-		err := t.history.Push(command)
+		err = t.startLine(command)
 		if err != nil {
 			t.ErrPrint(color.RedString(err.Error()) + "\n")
+			t.Print(prompt(t))
 		}
-		t.Print(prompt(t))
 	case controlDeleteWord:
 		t.deleteWord()
 	case controlEnd:
@@ -190,7 +219,7 @@ func (t *terminal) ReadEvalPrint(reader io.RuneReader) error {
 		t.Print("^C\n\r")
 		t.Print(prompt(t))
 	case '\t':
-		completions := getCompletions(string(t.line), t.cursor)
+		completions := getCompletions(t, string(t.line), t.cursor)
 		t.eraseBelowPrompt()
 		if len(completions) == 1 {
 			completion := completions[0]
@@ -227,6 +256,115 @@ func (t *terminal) ReadEvalPrint(reader io.RuneReader) error {
 	return nil
 }
 
+// startLine runs 'command' as the foreground job. Terminal input is forwarded to the job until it completes or is stopped.
+func (t *terminal) startLine(command string) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	input := newInputBuffer()
+	go func() {
+		_, _ = io.Copy(w, input)
+		w.Close()
+	}()
+	t.lineJob = startJob(t, command, r, input, func(term console.Console) error {
+		defer r.Close()
+		return runLine(term, command)
+	})
+	setForeground(t.lineJob)
+	return nil
+}
+
+// finishLine reports the result of the completed command line, then prints the prompt. Returns an ExitErr if the shell should exit.
+func (t *terminal) finishLine() *ExitErr {
+	_, err := t.lineJob.status()
+	t.lineJob = nil
+	setForeground(nil)
+	if exitErr, ok := errors.Cause(err).(*ExitErr); ok {
+		return exitErr
+	}
+	t.lastExitCode = exitCodeFromErr(err)
+	if _, isInterrupt := errors.Cause(err).(*interruptErr); err != nil && !isInterrupt {
+		t.ErrPrint(color.RedString(err.Error()) + "\n")
+	}
+	t.Print(prompt(t))
+	return nil
+}
+
+// foregroundInput handles input while a command line is running. Ctrl-C interrupts and Ctrl-Z stops the foreground job.
+func (t *terminal) foregroundInput(r rune) {
+	fg := getForeground()
+	switch r {
+	case controlSigTStop:
+		t.Print("^C\n")
+		if fg != nil {
+			fg.interrupt()
+		}
+	case controlSuspend:
+		t.Print("^Z\n")
+		t.lineJob = nil
+		setForeground(nil)
+		if fg != nil {
+			fg.stop()
+			addJob(fg)
+			t.Print(formatJob(fg, '+'), "\n")
+		}
+		t.lastExitCode = stoppedExitCode
+		t.Print(prompt(t))
+	case controlCloseStdin:
+		if fg != nil && fg.input != nil {
+			fg.input.Close()
+		}
+	default:
+		if fg != nil && fg.input != nil {
+			_, _ = fg.input.Write([]byte(string(r)))
+		}
+	}
+}
+
+// chanRuneReader reads runes in the background, so the terminal can wait for input and running commands at the same time
+type chanRuneReader struct {
+	results chan runeResult
+	pending *runeResult
+	err     error
+}
+
+type runeResult struct {
+	r   rune
+	err error
+}
+
+func newChanRuneReader(reader io.RuneReader) *chanRuneReader {
+	c := &chanRuneReader{results: make(chan runeResult)}
+	go func() {
+		for {
+			r, _, err := reader.ReadRune()
+			c.results <- runeResult{r: r, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *chanRuneReader) ReadRune() (rune, int, error) {
+	if c.err != nil {
+		return utf8.RuneError, 0, c.err
+	}
+	result := c.pending
+	c.pending = nil
+	if result == nil {
+		next := <-c.results
+		result = &next
+	}
+	if result.err != nil {
+		c.err = result.err
+		return utf8.RuneError, 0, result.err
+	}
+	return result.r, utf8.RuneLen(result.r), nil
+}
+
 func splitRunes(runes []rune, i int) (a, b []rune) {
 	a = append([]rune{}, runes[:i]...)
 	b = append([]rune{}, runes[i:]...)
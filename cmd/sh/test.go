@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+)
+
+func trueBuiltin(term console.Console, args ...string) error {
+	return nil
+}
+
+func falseBuiltin(term console.Console, args ...string) error {
+	return &statusErr{code: 1}
+}
+
+func testBracket(term console.Console, args ...string) error {
+	if len(args) == 0 || args[len(args)-1] != "]" {
+		return errors.New("[: missing ']'")
+	}
+	return test(term, args[:len(args)-1]...)
+}
+
+// test evaluates a conditional expression, like 'test -f file' or 'test "$a" = b'
+func test(term console.Console, args ...string) error {
+	result, err := evalTest(args)
+	if err != nil {
+		fmt.Fprintln(term.Stderr(), err)
+		return &statusErr{code: 2} // match POSIX test's exit status for errors
+	}
+	if !result {
+		return &statusErr{code: 1}
+	}
+	return nil
+}
+
+func evalTest(args []string) (bool, error) {
+	if len(args) > 0 && args[0] == "!" {
+		result, err := evalTest(args[1:])
+		return !result, err
+	}
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		return evalUnaryTest(args[0], args[1])
+	case 3:
+		return evalBinaryTest(args[0], args[1], args[2])
+	default:
+		return false, errors.New("test: too many arguments")
+	}
+}
+
+func evalUnaryTest(op, operand string) (bool, error) {
+	switch op {
+	case "-n":
+		return operand != "", nil
+	case "-z":
+		return operand == "", nil
+	}
+
+	info, statErr := os.Stat(operand)
+	exists := statErr == nil
+	switch op {
+	case "-e":
+		return exists, nil
+	case "-f":
+		return exists && info.Mode().IsRegular(), nil
+	case "-d":
+		return exists && info.IsDir(), nil
+	case "-s":
+		return exists && info.Size() > 0, nil
+	case "-r":
+		return exists && info.Mode().Perm()&0444 != 0, nil
+	case "-w":
+		return exists && info.Mode().Perm()&0222 != 0, nil
+	case "-x":
+		return exists && info.Mode().Perm()&0111 != 0, nil
+	case "-L", "-h":
+		info, err := os.Lstat(operand)
+		return err == nil && info.Mode()&os.ModeSymlink != 0, nil
+	default:
+		return false, errors.Errorf("test: %s: unary operator expected", op)
+	}
+}
+
+func evalBinaryTest(left, op, right string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	}
+
+	a, err := strconv.ParseInt(left, 10, 64)
+	if err != nil {
+		return false, errors.Errorf("test: %s: integer expression expected", left)
+	}
+	b, err := strconv.ParseInt(right, 10, 64)
+	if err != nil {
+		return false, errors.Errorf("test: %s: integer expression expected", right)
+	}
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	case "-ge":
+		return a >= b, nil
+	default:
+		return false, errors.Errorf("test: %s: binary operator expected", op)
+	}
+}
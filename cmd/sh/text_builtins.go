@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	for k, v := range map[string]builtinFunc{
+		"diff":  diff,
+		"grep":  grep,
+		"head":  head,
+		"sort":  sortBuiltin,
+		"tail":  tail,
+		"tee":   tee,
+		"uniq":  uniq,
+		"wc":    wc,
+		"xargs": xargs,
+	} {
+		builtins[k] = v
+	}
+}
+
+// parseFlags parses 'args' with 'set', like ls. Returns flag.ErrHelp if usage was printed.
+func parseFlags(term console.Console, set *flag.FlagSet, args []string) error {
+	set.SetOutput(term.Stderr())
+	return set.Parse(splitShortFlags(set, args))
+}
+
+// forEachInput calls 'fn' with each file in 'paths', or with stdin if there are none. The path '-' is also stdin.
+// Files which can't be opened are reported and skipped, then fails with status 1 once the rest are done.
+func forEachInput(term console.Console, paths []string, fn func(name string, r io.Reader) error) error {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var lastErr error
+	for _, path := range paths {
+		if path == "-" {
+			if err := fn(path, getConsoleStdin(term)); err != nil {
+				return err
+			}
+			continue
+		}
+		if isDir(path) {
+			printErr(term, errors.Errorf("%s: Is a directory", path))
+			lastErr = &statusErr{code: 1}
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			printErr(term, err)
+			lastErr = &statusErr{code: 1}
+			continue
+		}
+		err = fn(path, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return lastErr
+}
+
+// readLines calls 'fn' with each line from 'r', without the trailing newline. Stops early if 'fn' returns false.
+func readLines(r io.Reader, fn func(line string) bool) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" && !fn(strings.TrimSuffix(line, "\n")) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// grep prints lines matching a regular expression. Usage: grep [-rnivclqFE] pattern [file...]
+func grep(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("grep", flag.ContinueOnError)
+	recursive := set.Bool("r", false, "Search directories recursively")
+	lineNumbers := set.Bool("n", false, "Print line numbers")
+	ignoreCase := set.Bool("i", false, "Ignore case")
+	invert := set.Bool("v", false, "Print lines which don't match")
+	count := set.Bool("c", false, "Only print the number of matching lines")
+	filesOnly := set.Bool("l", false, "Only print the names of files with matches")
+	quiet := set.Bool("q", false, "Don't print anything, only exit with status 0 if there's a match")
+	fixed := set.Bool("F", false, "Match the pattern as a fixed string")
+	set.Bool("E", false, "Use extended regular expressions, which is always enabled")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	args = set.Args()
+	if len(args) == 0 {
+		return errors.New("Usage: grep [-rnivclqFE] pattern [file...]")
+	}
+
+	pattern, paths := args[0], args[1:]
+	if *fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	failed := false
+	if *recursive {
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		paths, err = walkFiles(term, paths)
+		failed = err != nil
+	}
+	showNames := len(paths) > 1 || *recursive
+
+	matched := false
+	err = forEachInput(term, paths, func(name string, r io.Reader) error {
+		if name == "-" {
+			name = "(standard input)"
+		}
+		matches := 0
+		lineNumber := 0
+		var writeErr error
+		err := readLines(r, func(line string) bool {
+			lineNumber++
+			if re.MatchString(line) == *invert {
+				return true
+			}
+			matches++
+			matched = true
+			switch {
+			case *quiet:
+				return false
+			case *filesOnly:
+				_, writeErr = fmt.Fprintln(term.Stdout(), name)
+				return false
+			case *count:
+				return true
+			}
+			var prefix string
+			if showNames {
+				prefix = name + ":"
+			}
+			if *lineNumbers {
+				prefix += strconv.Itoa(lineNumber) + ":"
+			}
+			_, writeErr = fmt.Fprintln(term.Stdout(), prefix+line)
+			return writeErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		if *count && !*quiet && !*filesOnly {
+			if showNames {
+				_, writeErr = fmt.Fprintf(term.Stdout(), "%s:%d\n", name, matches)
+			} else {
+				_, writeErr = fmt.Fprintln(term.Stdout(), matches)
+			}
+		}
+		return writeErr
+	})
+	switch {
+	case isStatusErr(err):
+		failed = true
+	case err != nil:
+		return err
+	}
+	switch {
+	case *quiet && matched:
+		return nil
+	case failed:
+		return &statusErr{code: 2} // match POSIX grep's exit status for errors
+	case !matched:
+		return &statusErr{code: 1}
+	default:
+		return nil
+	}
+}
+
+// walkFiles returns the files in 'paths', including all files inside directories.
+// Links inside directories aren't followed, so links to a parent directory don't loop.
+// Unreadable directories are reported and skipped, then fails with status 1 once the rest are walked.
+func walkFiles(term console.Console, paths []string) ([]string, error) {
+	var files []string
+	var lastErr error
+	var walk func(dir string)
+	walk = func(dir string) {
+		names, err := readDirNames(dir)
+		if err != nil {
+			printErr(term, err)
+			lastErr = &statusErr{code: 1}
+		}
+		for _, name := range names {
+			p := joinGlobPath(dir, name)
+			info, err := os.Lstat(p)
+			switch {
+			case err != nil:
+				printErr(term, err)
+				lastErr = &statusErr{code: 1}
+			case info.IsDir():
+				walk(p)
+			case info.Mode().IsRegular():
+				files = append(files, p)
+			}
+		}
+	}
+	for _, p := range paths {
+		if isDir(p) {
+			walk(p)
+		} else {
+			files = append(files, p)
+		}
+	}
+	return files, lastErr
+}
+
+// parseLineCount parses the count for head and tail's -n. A leading '+' means starting from that line number.
+func parseLineCount(value string) (count int, fromStart bool, err error) {
+	fromStart = strings.HasPrefix(value, "+")
+	count, err = strconv.Atoi(strings.TrimPrefix(value, "+"))
+	if err != nil || count < 0 {
+		return 0, false, errors.Errorf("Invalid number of lines: %s", value)
+	}
+	return count, fromStart, nil
+}
+
+// printFileHeader prints a file name header, like '==> file <==', when showing more than one file
+func printFileHeader(term console.Console, paths []string, name string, first bool) {
+	if len(paths) < 2 {
+		return
+	}
+	if !first {
+		fmt.Fprintln(term.Stdout())
+	}
+	fmt.Fprintf(term.Stdout(), "==> %s <==\n", name)
+}
+
+// head prints the first lines of each file. Usage: head [-n lines] [file...]
+func head(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("head", flag.ContinueOnError)
+	lines := set.Int("n", 10, "Number of lines to print")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if *lines < 0 {
+		return errors.Errorf("Invalid number of lines: %d", *lines)
+	}
+	paths := set.Args()
+	first := true
+	return forEachInput(term, paths, func(name string, r io.Reader) error {
+		printFileHeader(term, paths, name, first)
+		first = false
+		if *lines == 0 {
+			return nil
+		}
+		printed := 0
+		var writeErr error
+		err := readLines(r, func(line string) bool {
+			_, writeErr = fmt.Fprintln(term.Stdout(), line)
+			printed++
+			return writeErr == nil && printed < *lines
+		})
+		if err != nil {
+			return err
+		}
+		return writeErr
+	})
+}
+
+// tail prints the last lines of each file, then with -f prints lines as they're added. Usage: tail [-f] [-n [+]lines] [file...]
+func tail(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("tail", flag.ContinueOnError)
+	lineCount := set.String("n", "10", "Number of lines to print, or with a leading '+' the line to start from")
+	follow := set.Bool("f", false, "Keep printing data as the file grows")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	count, fromStart, err := parseLineCount(*lineCount)
+	if err != nil {
+		return err
+	}
+	paths := set.Args()
+
+	var followFiles []*os.File
+	defer func() {
+		for _, f := range followFiles {
+			f.Close()
+		}
+	}()
+	for i, path := range paths {
+		if path == "-" {
+			continue
+		}
+		if isDir(path) {
+			return errors.Errorf("%s: Is a directory", path)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		printFileHeader(term, paths, path, i == 0)
+		err = printTail(term, f, count, fromStart)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if *follow {
+			followFiles = append(followFiles, f)
+		} else {
+			f.Close()
+		}
+	}
+	if len(paths) == 0 {
+		if err := printTail(term, getConsoleStdin(term), count, fromStart); err != nil {
+			return err
+		}
+	}
+	if len(followFiles) == 0 {
+		return nil
+	}
+
+	const pollInterval = 250 * time.Millisecond
+	interrupted := jobFromConsole(term).interrupted()
+	for {
+		select {
+		case <-interrupted:
+			return &interruptErr{}
+		case <-time.After(pollInterval):
+		}
+		for _, f := range followFiles {
+			if _, err := io.Copy(term.Stdout(), f); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// printTail prints the last 'count' lines, or from line number 'count' onward if 'fromStart' is true. Only keeps 'count' lines in memory.
+func printTail(term console.Console, r io.Reader, count int, fromStart bool) error {
+	var writeErr error
+	if fromStart {
+		lineNumber := 0
+		err := readLines(r, func(line string) bool {
+			lineNumber++
+			if lineNumber >= count {
+				_, writeErr = fmt.Fprintln(term.Stdout(), line)
+			}
+			return writeErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return writeErr
+	}
+
+	if count == 0 {
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	}
+	ring := make([]string, 0, count)
+	next := 0
+	err := readLines(r, func(line string) bool {
+		if len(ring) < count {
+			ring = append(ring, line)
+		} else {
+			ring[next] = line
+			next = (next + 1) % count
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for i := range ring {
+		if _, err := fmt.Fprintln(term.Stdout(), ring[(next+i)%len(ring)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wc prints the number of lines, words, and bytes in each file
+func wc(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("wc", flag.ContinueOnError)
+	countLines := set.Bool("l", false, "Print the number of lines")
+	countWords := set.Bool("w", false, "Print the number of words")
+	countBytes := set.Bool("c", false, "Print the number of bytes")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if !*countLines && !*countWords && !*countBytes {
+		*countLines, *countWords, *countBytes = true, true, true
+	}
+	paths := set.Args()
+
+	printCounts := func(lines, words, bytes int, name string) {
+		var s strings.Builder
+		for _, column := range []struct {
+			enabled bool
+			count   int
+		}{
+			{*countLines, lines},
+			{*countWords, words},
+			{*countBytes, bytes},
+		} {
+			if column.enabled {
+				fmt.Fprintf(&s, "%8d", column.count)
+			}
+		}
+		if name != "-" {
+			s.WriteString(" " + name)
+		}
+		fmt.Fprintln(term.Stdout(), s.String())
+	}
+
+	var totalLines, totalWords, totalBytes int
+	err := forEachInput(term, paths, func(name string, r io.Reader) error {
+		var lines, words, bytes int
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			bytes += len(line)
+			words += len(strings.Fields(line))
+			if strings.HasSuffix(line, "\n") {
+				lines++
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		printCounts(lines, words, bytes, name)
+		totalLines += lines
+		totalWords += words
+		totalBytes += bytes
+		return nil
+	})
+	if err != nil && !isStatusErr(err) {
+		return err
+	}
+	if len(paths) > 1 {
+		printCounts(totalLines, totalWords, totalBytes, "total")
+	}
+	return err
+}
+
+var leadingNumber = regexp.MustCompile(`^\s*[-+]?(\d+(\.\d*)?|\.\d+)`)
+
+func parseLeadingNumber(s string) float64 {
+	number, _ := strconv.ParseFloat(strings.TrimSpace(leadingNumber.FindString(s)), 64)
+	return number
+}
+
+// sortBuiltin prints the sorted lines of all files
+func sortBuiltin(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("sort", flag.ContinueOnError)
+	reverse := set.Bool("r", false, "Reverse the sort order")
+	numeric := set.Bool("n", false, "Sort by numeric value")
+	unique := set.Bool("u", false, "Only print the first of equal lines")
+	ignoreCase := set.Bool("f", false, "Ignore case")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	var lines []string
+	err := forEachInput(term, set.Args(), func(name string, r io.Reader) error {
+		return readLines(r, func(line string) bool {
+			lines = append(lines, line)
+			return true
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	compare := func(a, b string) int {
+		if *numeric {
+			x, y := parseLeadingNumber(a), parseLeadingNumber(b)
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+		if *ignoreCase {
+			a, b = strings.ToLower(a), strings.ToLower(b)
+		}
+		return strings.Compare(a, b)
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		result := compare(lines[i], lines[j])
+		if *reverse {
+			return result > 0
+		}
+		return result < 0
+	})
+	for i, line := range lines {
+		if *unique && i > 0 && compare(lines[i-1], line) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintln(term.Stdout(), line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniq prints lines without adjacent duplicates
+func uniq(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("uniq", flag.ContinueOnError)
+	count := set.Bool("c", false, "Prefix lines with the number of times they occurred")
+	duplicates := set.Bool("d", false, "Only print duplicated lines")
+	unique := set.Bool("u", false, "Only print lines which aren't duplicated")
+	ignoreCase := set.Bool("i", false, "Ignore case")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if set.NArg() > 1 {
+		return errors.New("Too many args")
+	}
+
+	var previous string
+	occurrences := 0
+	printPrevious := func() error {
+		if occurrences == 0 || (*duplicates && occurrences < 2) || (*unique && occurrences > 1) {
+			return nil
+		}
+		if *count {
+			_, err := fmt.Fprintf(term.Stdout(), "%7d %s\n", occurrences, previous)
+			return err
+		}
+		_, err := fmt.Fprintln(term.Stdout(), previous)
+		return err
+	}
+	equal := func(a, b string) bool {
+		if *ignoreCase {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	}
+
+	var writeErr error
+	err := forEachInput(term, set.Args(), func(name string, r io.Reader) error {
+		return readLines(r, func(line string) bool {
+			if occurrences > 0 && equal(previous, line) {
+				occurrences++
+				return true
+			}
+			writeErr = printPrevious()
+			previous, occurrences = line, 1
+			return writeErr == nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	return printPrevious()
+}
+
+// tee copies stdin to stdout and each file
+func tee(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("tee", flag.ContinueOnError)
+	appendFiles := set.Bool("a", false, "Append to files instead of overwriting them")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	openFlag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if *appendFiles {
+		openFlag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	writers := []io.Writer{term.Stdout()}
+	for _, path := range set.Args() {
+		f, err := os.OpenFile(path, openFlag, 0666)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		writers = append(writers, f)
+	}
+	_, err := io.Copy(io.MultiWriter(writers...), getConsoleStdin(term))
+	return err
+}
+
+// xargs runs a command with arguments read from stdin. Usage: xargs [-n max] [-I replace] [command [args...]]
+func xargs(term console.Console, args ...string) error {
+	set := flag.NewFlagSet("xargs", flag.ContinueOnError)
+	maxArgs := set.Int("n", 0, "Max number of arguments per command")
+	replace := set.String("I", "", "Run the command for each input line, replacing this string in the arguments")
+	if err := parseFlags(term, set, args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	command := set.Args()
+	if len(command) == 0 {
+		command = []string{"echo"}
+	}
+	// commands shouldn't read the arguments meant for later commands
+	commandTerm := redirectTerm(term, strings.NewReader(""), term.Stdout(), term.Stderr())
+
+	var lastErr error
+	run := func(commandArgs []string) error {
+		err := runWithEnv(commandTerm, nil, commandArgs...)
+		if isControlFlow(err) {
+			return err
+		}
+		if err != nil {
+			printErr(term, err)
+			lastErr = &statusErr{code: 123} // match xargs' exit status when any command fails
+		}
+		return nil
+	}
+
+	if *replace != "" {
+		var runErr error
+		err := readLines(getConsoleStdin(term), func(line string) bool {
+			if strings.TrimSpace(line) == "" {
+				return true
+			}
+			commandArgs := make([]string, len(command))
+			for i, arg := range command {
+				commandArgs[i] = strings.ReplaceAll(arg, *replace, line)
+			}
+			runErr = run(commandArgs)
+			return runErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		if runErr != nil {
+			return runErr
+		}
+		return lastErr
+	}
+
+	withItems := func(items []string) []string {
+		return append(append([]string(nil), command...), items...)
+	}
+	scanner := bufio.NewScanner(getConsoleStdin(term))
+	scanner.Split(bufio.ScanWords)
+	var items []string
+	for scanner.Scan() {
+		items = append(items, scanner.Text())
+		if *maxArgs > 0 && len(items) == *maxArgs {
+			if err := run(withItems(items)); err != nil {
+				return err
+			}
+			items = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(items) > 0 || *maxArgs == 0 {
+		if err := run(withItems(items)); err != nil {
+			return err
+		}
+	}
+	return lastErr
+}
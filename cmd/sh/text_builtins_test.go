@@ -0,0 +1,193 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextBuiltins(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "grep",
+			line:        `{ echo apple; echo banana; echo cherry; } > f; grep an f`,
+			expectOut:   "banana\n",
+		},
+		{
+			description: "grep line numbers and ignore case",
+			line:        `{ echo Apple; echo banana; echo apple; } > f; grep -n -i apple f`,
+			expectOut:   "1:Apple\n3:apple\n",
+		},
+		{
+			description: "grep invert and count",
+			line:        `{ echo a; echo b; echo a; } > f; grep -vc a f`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "grep recursive",
+			line:        `mkdir d d/sub; echo hi > d/a; echo bye > d/sub/b; echo hi there > d/sub/c; grep -r hi d`,
+			expectOut:   "d/a:hi\nd/sub/c:hi there\n",
+		},
+		{
+			description: "grep recursive skips links",
+			line:        `mkdir d; echo hi > d/a; ln -s .. d/up; ln -s a d/link; grep -r hi d`,
+			expectOut:   "d/a:hi\n",
+		},
+		{
+			description: "grep continues after missing files",
+			line:        `echo x > f; grep -c x nonexist f 2>/dev/null`,
+			expectOut:   "f:1\n",
+			expectCode:  2,
+		},
+		{
+			description: "grep quiet match ignores missing files",
+			line:        `echo x > f; grep -q x nonexist f 2>/dev/null`,
+		},
+		{
+			description: "grep no match",
+			line:        `echo a | grep b`,
+			expectCode:  1,
+		},
+		{
+			description: "grep stdin",
+			line:        `{ echo a1; echo b2; echo a3; } | grep '^a'`,
+			expectOut:   "a1\na3\n",
+		},
+		{
+			description: "head",
+			line:        `{ echo 1; echo 2; echo 3; echo 4; } > f; head -n 2 f`,
+			expectOut:   "1\n2\n",
+		},
+		{
+			description: "tail",
+			line:        `{ echo 1; echo 2; echo 3; echo 4; } | tail -n 2`,
+			expectOut:   "3\n4\n",
+		},
+		{
+			description: "tail from line",
+			line:        `{ echo 1; echo 2; echo 3; echo 4; } | tail -n +3`,
+			expectOut:   "3\n4\n",
+		},
+		{
+			description: "wc",
+			line:        `{ echo a b; echo c; } > f; wc f`,
+			expectOut:   "       2       3       6 f\n",
+		},
+		{
+			description: "wc lines stdin",
+			line:        `{ echo a; echo b; echo c; } | wc -l`,
+			expectOut:   "       3\n",
+		},
+		{
+			description: "sort",
+			line:        `{ echo b; echo c; echo a; } | sort`,
+			expectOut:   "a\nb\nc\n",
+		},
+		{
+			description: "sort numeric reverse unique",
+			line:        `{ echo 10; echo 9; echo 10; echo 100; } | sort -n -r -u`,
+			expectOut:   "100\n10\n9\n",
+		},
+		{
+			description: "uniq count",
+			line:        `{ echo a; echo a; echo b; echo a; } | uniq -c`,
+			expectOut:   "      2 a\n      1 b\n      1 a\n",
+		},
+		{
+			description: "tee",
+			line:        `echo hi | tee f; cat f`,
+			expectOut:   "hi\nhi\n",
+		},
+		{
+			description: "tee append",
+			line:        `echo a > f; echo b | tee -a f > /dev/null; cat f`,
+			expectOut:   "a\nb\n",
+		},
+		{
+			description: "xargs",
+			line:        `{ echo a; echo b c; } | xargs echo x`,
+			expectOut:   "x a b c\n",
+		},
+		{
+			description: "xargs max args",
+			line:        `echo b a c | xargs -n 1 | sort`,
+			expectOut:   "a\nb\nc\n",
+		},
+		{
+			description: "xargs replace",
+			line:        `{ echo a; echo b; } | xargs -I {} echo [{}]`,
+			expectOut:   "[a]\n[b]\n",
+		},
+		{
+			description: "xargs failed command",
+			line:        `echo a | xargs false`,
+			expectCode:  123,
+		},
+		{
+			description: "diff same",
+			line:        `echo a > f; echo a > g; diff f g`,
+		},
+		{
+			description: "diff",
+			line:        `{ echo 1; echo 2; echo 3; echo 4; echo 5; echo 6; echo 7; echo 8; echo 9; } > f; { echo 1; echo 2; echo 3; echo 4; echo five; echo 6; echo 7; echo 8; echo 9; echo 10; } > g; diff -u f g`,
+			expectOut: `--- f
++++ g
+@@ -2,8 +2,9 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+ 9
++10
+`,
+			expectCode: 1,
+		},
+		{
+			description: "diff separate hunks",
+			line:        `{ echo 1; echo 2; echo 3; echo 4; echo 5; echo 6; echo 7; echo 8; echo 9; echo 10; } > f; { echo 0; echo 1; echo 2; echo 3; echo 4; echo 5; echo 6; echo 7; echo 8; echo 9; } > g; diff -U 1 f g`,
+			expectOut: `--- f
++++ g
+@@ -1 +1,2 @@
++0
+ 1
+@@ -9,2 +10 @@
+ 9
+-10
+`,
+			expectCode: 1,
+		},
+		{
+			description: "diff missing file",
+			line:        `echo a > f; diff f nonexist 2>/dev/null`,
+			expectCode:  2,
+		},
+		{
+			description: "wc continues after missing files",
+			line:        `echo a b > f; wc f nonexist 2>/dev/null`,
+			expectOut:   "       1       2       4 f\n       1       2       4 total\n",
+			expectCode:  1,
+		},
+		{
+			description: "head continues after directories",
+			line:        `mkdir d; echo a > f; head d f 2>/dev/null`,
+			expectOut:   "==> f <==\na\n",
+			expectCode:  1,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			chdirTemp(t)
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
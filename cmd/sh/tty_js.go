@@ -2,8 +2,17 @@
 
 package main
 
-import "context"
+import (
+	"context"
+	"os"
+)
 
 func ttySetup() (context.CancelFunc, error) {
 	return func() {}, nil
 }
+
+// isTerminal returns true if 'f' is a terminal. Terminals in the browser are connected with pipes, so they must run the shell with '-i'.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/mattn/go-isatty"
 	gotty "github.com/mattn/go-tty"
 )
 
@@ -28,3 +29,7 @@ func ttySetup() (context.CancelFunc, error) {
 		tty.Close()
 	}, nil
 }
+
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
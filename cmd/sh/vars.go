@@ -0,0 +1,549 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/console"
+	"github.com/pkg/errors"
+)
+
+// shellState holds a shell's variables and functions.
+// Subshells and background jobs run with a copy, so their changes don't affect the shell which started them.
+type shellState struct {
+	mu sync.RWMutex
+	// Shell variables are either local to the shell or exported.
+	// Exported variables are passed to commands, local variables are only visible to expansions and builtins.
+	localVars      map[string]string
+	env            map[string]string // exported variables, or nil to use the process environment
+	positionalArgs []string
+	lastStatus     int
+	// funcScopes holds the values that variables declared 'local' had before each running function
+	funcScopes []map[string]savedVar
+	functions  map[string]shellFunc
+	wd         string // the directory last changed to with 'cd', so subshells can change back
+}
+
+// rootState is the top-level shell's state. Its exported variables are the process environment, so builtins see them too.
+var rootState = newShellState()
+
+var shellName = "sh"
+
+type savedVar struct {
+	value    string
+	isSet    bool
+	exported bool
+}
+
+var validVarName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func init() {
+	for k, v := range map[string]builtinFunc{
+		"declare": declare,
+		"export":  export,
+		"local":   local,
+		"set":     set,
+		"typeset": declare,
+		"unset":   unset,
+	} {
+		builtins[k] = v
+	}
+}
+
+func newShellState() *shellState {
+	return &shellState{
+		localVars: map[string]string{},
+		functions: map[string]shellFunc{},
+	}
+}
+
+// stateFromConsole returns the shell state commands on 'term' run with
+func stateFromConsole(term console.Console) *shellState {
+	if c, ok := term.(*redirectConsole); ok && c.state != nil {
+		return c.state
+	}
+	return rootState
+}
+
+// withState returns a console like 'term' which runs commands with 'state'
+func withState(term console.Console, state *shellState) console.Console {
+	c := redirectTerm(term, getConsoleStdin(term), term.Stdout(), term.Stderr())
+	c.state = state
+	return c
+}
+
+// copy returns an independent copy of the state, for a subshell
+func (s *shellState) copy() *shellState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := &shellState{
+		localVars:      make(map[string]string, len(s.localVars)),
+		env:            make(map[string]string),
+		positionalArgs: s.positionalArgs, // never modified in place
+		lastStatus:     s.lastStatus,
+		functions:      make(map[string]shellFunc, len(s.functions)),
+	}
+	for name, value := range s.localVars {
+		c.localVars[name] = value
+	}
+	for _, kv := range s.environ() {
+		key, value := splitKeyValue(kv)
+		c.env[key] = value
+	}
+	for _, scope := range s.funcScopes {
+		scopeCopy := make(map[string]savedVar, len(scope))
+		for name, saved := range scope {
+			scopeCopy[name] = saved
+		}
+		c.funcScopes = append(c.funcScopes, scopeCopy)
+	}
+	for name, fn := range s.functions {
+		c.functions[name] = fn
+	}
+	return c
+}
+
+// The env methods access exported variables. Callers must hold s.mu.
+
+func (s *shellState) lookupEnv(name string) (string, bool) {
+	if s.env == nil {
+		return os.LookupEnv(name)
+	}
+	value, isSet := s.env[name]
+	return value, isSet
+}
+
+func (s *shellState) setEnv(name, value string) error {
+	if s.env == nil {
+		return os.Setenv(name, value)
+	}
+	s.env[name] = value
+	return nil
+}
+
+func (s *shellState) unsetEnv(name string) {
+	if s.env == nil {
+		os.Unsetenv(name)
+		return
+	}
+	delete(s.env, name)
+}
+
+func (s *shellState) environ() []string {
+	if s.env == nil {
+		return os.Environ()
+	}
+	env := make([]string, 0, len(s.env))
+	for key, value := range s.env {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// environ returns the exported variables as 'key=value' pairs, for running commands
+func environ(term console.Console) []string {
+	s := stateFromConsole(term)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.environ()
+}
+
+// withEnv runs 'fn' with the 'key=value' pairs in 'env' exported, then restores the previous values
+func withEnv(term console.Console, env []string, fn func() error) error {
+	s := stateFromConsole(term)
+	var oldKV, unsetKV []string
+	s.mu.Lock()
+	for _, pair := range env {
+		key, value := splitKeyValue(pair)
+		if oldValue, isSet := s.lookupEnv(key); isSet {
+			if oldValue == value {
+				continue // leave unchanged variables alone, so builtins like 'export' and 'unset' can change them
+			}
+			oldKV = append(oldKV, key+"="+oldValue)
+		} else {
+			unsetKV = append(unsetKV, key)
+		}
+		_ = s.setEnv(key, value)
+	}
+	s.mu.Unlock()
+
+	err := fn()
+
+	s.mu.Lock()
+	for _, pair := range oldKV {
+		key, value := splitKeyValue(pair)
+		_ = s.setEnv(key, value)
+	}
+	for _, key := range unsetKV {
+		s.unsetEnv(key)
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// lookupVar returns the value of the variable or special parameter 'name', and whether it is set
+func lookupVar(term console.Console, name string) (string, bool) {
+	if name == "!" {
+		return lastBackgroundSpec()
+	}
+	s := stateFromConsole(term)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	switch name {
+	case "?":
+		return strconv.Itoa(s.lastStatus), true
+	case "#":
+		return strconv.Itoa(len(s.positionalArgs)), true
+	case "@", "*":
+		return strings.Join(s.positionalArgs, " "), len(s.positionalArgs) > 0
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "0":
+		return shellName, true
+	}
+	if index, err := strconv.Atoi(name); err == nil {
+		if index < 1 || index > len(s.positionalArgs) {
+			return "", false
+		}
+		return s.positionalArgs[index-1], true
+	}
+	if value, isSet := s.localVars[name]; isSet {
+		return value, true
+	}
+	return s.lookupEnv(name)
+}
+
+// setVar assigns 'value' to the shell variable 'name'. Exported variables stay exported.
+func setVar(term console.Console, name, value string) error {
+	if !validVarName.MatchString(name) {
+		return errors.Errorf("%s: not a valid identifier", name)
+	}
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, isExported := s.lookupEnv(name); isExported {
+		return s.setEnv(name, value)
+	}
+	s.localVars[name] = value
+	return nil
+}
+
+// exportVar moves the shell variable 'name' into the environment
+func exportVar(term console.Console, name string) error {
+	if !validVarName.MatchString(name) {
+		return errors.Errorf("%s: not a valid identifier", name)
+	}
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, isSet := s.localVars[name]
+	if !isSet {
+		if _, isExported := s.lookupEnv(name); isExported {
+			return nil
+		}
+	}
+	delete(s.localVars, name)
+	return s.setEnv(name, value)
+}
+
+func unsetVar(term console.Console, name string) {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.localVars, name)
+	s.unsetEnv(name)
+}
+
+// setLastStatus records the exit status of the last command for '$?'
+func setLastStatus(term console.Console, code int) {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	s.lastStatus = code
+	s.mu.Unlock()
+}
+
+func getLastStatus(term console.Console) int {
+	s := stateFromConsole(term)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastStatus
+}
+
+// setPositional replaces '$1', '$2', etc. with 'args'. Returns the previous arguments.
+func setPositional(term console.Console, args []string) []string {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.positionalArgs
+	s.positionalArgs = append([]string(nil), args...)
+	return previous
+}
+
+func getPositional(term console.Console) []string {
+	s := stateFromConsole(term)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.positionalArgs...)
+}
+
+// pushScope starts a function's scope for local variables
+func pushScope(term console.Console) {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	s.funcScopes = append(s.funcScopes, map[string]savedVar{})
+	s.mu.Unlock()
+}
+
+// popScope restores variables declared local in the current function's scope
+func popScope(term console.Console) {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scope := s.funcScopes[len(s.funcScopes)-1]
+	s.funcScopes = s.funcScopes[:len(s.funcScopes)-1]
+	for name, saved := range scope {
+		delete(s.localVars, name)
+		s.unsetEnv(name)
+		switch {
+		case !saved.isSet:
+		case saved.exported:
+			_ = s.setEnv(name, saved.value)
+		default:
+			s.localVars[name] = saved.value
+		}
+	}
+}
+
+// inFunc returns true if a function is running
+func inFunc(term console.Console) bool {
+	s := stateFromConsole(term)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.funcScopes) > 0
+}
+
+// declareLocal saves the current value of 'name', to be restored when the current function returns
+func declareLocal(term console.Console, name string) error {
+	if !validVarName.MatchString(name) {
+		return errors.Errorf("%s: not a valid identifier", name)
+	}
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.funcScopes) == 0 {
+		return errors.New("can only be used in a function")
+	}
+	scope := s.funcScopes[len(s.funcScopes)-1]
+	if _, saved := scope[name]; saved {
+		return nil
+	}
+	value, isSet := s.localVars[name]
+	exported := false
+	if !isSet {
+		value, exported = s.lookupEnv(name)
+		isSet = exported
+	}
+	scope[name] = savedVar{value: value, isSet: isSet, exported: exported}
+	return nil
+}
+
+// assignVar runs a 'name=value' or 'name+=value' assignment
+func assignVar(term console.Console, name, value string, isAppend bool) error {
+	if isAppend {
+		oldValue, _ := lookupVar(term, name)
+		value = oldValue + value
+	}
+	return setVar(term, name, value)
+}
+
+// lookupFunc returns the function declared as 'name'
+func lookupFunc(term console.Console, name string) (shellFunc, bool) {
+	s := stateFromConsole(term)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn, isFunc := s.functions[name]
+	return fn, isFunc
+}
+
+func setFunc(term console.Console, name string, fn shellFunc) {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	s.functions[name] = fn
+	s.mu.Unlock()
+}
+
+func unsetFunc(term console.Console, name string) {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	delete(s.functions, name)
+	s.mu.Unlock()
+}
+
+// setDir records 'dir' as the directory last changed to with 'cd'
+func setDir(term console.Console, dir string) {
+	s := stateFromConsole(term)
+	s.mu.Lock()
+	s.wd = dir
+	s.mu.Unlock()
+}
+
+func getDir(term console.Console) string {
+	s := stateFromConsole(term)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.wd
+}
+
+// shellQuote quotes 'value' so it can be read back by the shell
+func shellQuote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n'\"\\$`|&;<>()*?[]{}~#!") {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func export(term console.Console, args ...string) error {
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		env := environ(term)
+		sort.Strings(env)
+		for _, kv := range env {
+			key, value := splitKeyValue(kv)
+			fmt.Fprintf(term.Stdout(), "export %s=%s\n", key, shellQuote(value))
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		name, value := splitKeyValue(arg)
+		if strings.ContainsRune(arg, '=') {
+			if err := setVar(term, name, value); err != nil {
+				return err
+			}
+		}
+		if err := exportVar(term, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// local declares variables scoped to the current function
+func local(term console.Console, args ...string) error {
+	for _, arg := range args {
+		name, value := splitKeyValue(arg)
+		if err := declareLocal(term, name); err != nil {
+			return err
+		}
+		if err := setVar(term, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declare sets variables, or exports them with -x. In a function, the variables are local.
+func declare(term console.Console, args ...string) error {
+	exportVars := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-x":
+			exportVars = true
+		case "--":
+		default:
+			return errors.Errorf("%s: invalid option", args[0])
+		}
+		args = args[1:]
+	}
+	isLocal := inFunc(term)
+
+	for _, arg := range args {
+		name, value := splitKeyValue(arg)
+		if isLocal {
+			if err := declareLocal(term, name); err != nil {
+				return err
+			}
+		}
+		if strings.ContainsRune(arg, '=') {
+			if err := setVar(term, name, value); err != nil {
+				return err
+			}
+		} else if _, isSet := lookupVar(term, name); !isSet {
+			if err := setVar(term, name, ""); err != nil {
+				return err
+			}
+		}
+		if exportVars {
+			if err := exportVar(term, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unset(term console.Console, args ...string) error {
+	unsetFuncs := false
+	if len(args) > 0 {
+		switch args[0] {
+		case "-f":
+			unsetFuncs = true
+			args = args[1:]
+		case "-v":
+			args = args[1:]
+		}
+	}
+	for _, name := range args {
+		if unsetFuncs {
+			unsetFunc(term, name)
+			continue
+		}
+		if !validVarName.MatchString(name) {
+			return errors.Errorf("%s: not a valid identifier", name)
+		}
+		unsetVar(term, name)
+	}
+	return nil
+}
+
+// set prints all variables, or sets shell options and the positional arguments
+func set(term console.Console, args ...string) error {
+	if len(args) == 0 {
+		vars := make(map[string]string)
+		for _, kv := range environ(term) {
+			key, value := splitKeyValue(kv)
+			vars[key] = value
+		}
+		state := stateFromConsole(term)
+		state.mu.RLock()
+		for key, value := range state.localVars {
+			vars[key] = value
+		}
+		state.mu.RUnlock()
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(term.Stdout(), "%s=%s\n", name, shellQuote(vars[name]))
+		}
+		return nil
+	}
+
+	args, isPositional, err := setOptions(term, args)
+	if err != nil {
+		return err
+	}
+	if isPositional {
+		setPositional(term, args)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpansion(t *testing.T) {
+	t.Cleanup(saveVars())
+
+	for _, tc := range []struct {
+		description string
+		line        string
+		expectOut   string
+		expectCode  int
+	}{
+		{
+			description: "assign and expand",
+			line:        `a=1; echo $a ${a}`,
+			expectOut:   "1 1\n",
+		},
+		{
+			description: "append",
+			line:        `a=1; a+=2; echo $a`,
+			expectOut:   "12\n",
+		},
+		{
+			description: "local variables are not exported",
+			line:        `local_var=1; env | grep -c local_var=`,
+			expectOut:   "0\n",
+			expectCode:  1,
+		},
+		{
+			description: "export",
+			line:        `exported_var=1; export exported_var; env | grep exported_var=; unset exported_var`,
+			expectOut:   "exported_var=1\n",
+		},
+		{
+			description: "local variables in functions",
+			line:        `j=outer; f() { local j=inner k=1; echo $j $k; }; f; echo $j "[$k]"`,
+			expectOut:   "inner 1\nouter []\n",
+		},
+		{
+			description: "prefix assignment is temporary",
+			line:        `b=1; b=2 true; echo $b`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "unset",
+			line:        `c=1; unset c; echo "[$c]"`,
+			expectOut:   "[]\n",
+		},
+		{
+			description: "last status",
+			line:        `false; echo $?; true; echo $?`,
+			expectOut:   "1\n0\n",
+		},
+		{
+			description: "last status after or",
+			line:        `false || echo $?`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "positional parameters",
+			line:        `set -- a 'b c'; echo $# $1 "$2"`,
+			expectOut:   "2 a b c\n",
+		},
+		{
+			description: "quoted all args are separate fields",
+			line:        `set -- a 'b c'; for arg in "$@"; do echo "[$arg]"; done`,
+			expectOut:   "[a]\n[b c]\n",
+		},
+		{
+			description: "quoted star joins args",
+			line:        `set -- a 'b c'; for arg in "$*"; do echo "[$arg]"; done`,
+			expectOut:   "[a b c]\n",
+		},
+		{
+			description: "function args",
+			line:        `set -- outer; f() { echo $1 $#; }; f x y; echo $1`,
+			expectOut:   "x 2\nouter\n",
+		},
+		{
+			description: "field splitting",
+			line:        `d='x  y'; for i in $d; do echo $i; done; for i in "$d"; do echo "$i"; done`,
+			expectOut:   "x\ny\nx  y\n",
+		},
+		{
+			description: "empty unquoted expansion is dropped",
+			line:        `set -- $unset_var ""; echo $#`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "defaults",
+			line:        `e=; echo ${e:-default} [${e-default}] ${unset_var-unset} ${e:+alt} ${unset_var:=assigned} $unset_var; unset unset_var`,
+			expectOut:   "default [] unset assigned assigned\n",
+		},
+		{
+			description: "error if unset",
+			line:        `echo ${unset_var:?missing}`,
+			expectCode:  1,
+		},
+		{
+			description: "length",
+			line:        `f=hello; echo ${#f}`,
+			expectOut:   "5\n",
+		},
+		{
+			description: "remove prefix and suffix",
+			line:        `p=dir/sub/file.tar.gz; echo ${p#*/} ${p##*/} ${p%.*} ${p%%.*}`,
+			expectOut:   "sub/file.tar.gz file.tar.gz dir/sub/file.tar dir/sub/file\n",
+		},
+		{
+			description: "replace",
+			line:        `r=aXbXc; echo ${r/X/-} ${r//X/-} ${r/#a/A} ${r/%c/C}`,
+			expectOut:   "a-bXc a-b-c AXbXc aXbXC\n",
+		},
+		{
+			description: "slice",
+			line:        `s=abcdef; echo ${s:2} ${s:1:3} ${s: -2}`,
+			expectOut:   "cdef bcd ef\n",
+		},
+		{
+			description: "case change",
+			line:        `u=hello; echo ${u^} ${u^^}`,
+			expectOut:   "Hello HELLO\n",
+		},
+		{
+			description: "escapes",
+			line:        `echo \$a "\$a \"q\" \n"`,
+			expectOut:   "$a $a \"q\" \\n\n",
+		},
+		{
+			description: "command substitution",
+			line:        `echo "[$(echo a; echo b)]" $(echo c)`,
+			expectOut:   "[a\nb] c\n",
+		},
+		{
+			description: "command substitution status",
+			line:        `g=$(false); echo $?`,
+			expectOut:   "1\n",
+		},
+		{
+			description: "command substitution is a subshell",
+			line:        `h=1; i=$(h=2; echo $h); echo $h $i`,
+			expectOut:   "1 2\n",
+		},
+		{
+			description: "arithmetic",
+			line:        `n=3; echo $((n * 2 + 1)) $(( (1 + 2) ** 2 )) $((7 / 2)) $((7 % 2)) $((n > 2 ? 10 : 20))`,
+			expectOut:   "7 9 3 1 10\n",
+		},
+		{
+			description: "arithmetic assignment",
+			line:        `n=1; echo $((n += 2)) $((n++)) $n`,
+			expectOut:   "3 3 4\n",
+		},
+		{
+			description: "arithmetic division by zero",
+			line:        `echo $((1 / 0))`,
+			expectCode:  1,
+		},
+		{
+			description: "arithmetic command",
+			line:        `((1 > 2)); echo $?; let 'x = 2 + 2'; echo $x`,
+			expectOut:   "1\n4\n",
+		},
+		{
+			description: "c-style for",
+			line:        `for ((i = 0; i < 3; i++)); do echo $i; done`,
+			expectOut:   "0\n1\n2\n",
+		},
+		{
+			description: "heredoc expansion",
+			line:        "v=1; cat <<EOF\n$v \\$v\nEOF\ncat <<'EOF'\n$v\nEOF\n",
+			expectOut:   "1 $v\n$v\n",
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			out, code := runTestLine(t, tc.line)
+			assert.Equal(t, tc.expectOut, out)
+			assert.Equal(t, tc.expectCode, code)
+		})
+	}
+}
+
+func TestSetPrintsVariables(t *testing.T) {
+	t.Cleanup(saveVars())
+	os.Unsetenv("set_test_var")
+
+	out, code := runTestLine(t, `set_test_var='a b'; set | grep set_test_var`)
+	assert.Equal(t, "set_test_var='a b'\n", out)
+	assert.Zero(t, code)
+}
@@ -7,6 +7,7 @@ require (
 	github.com/fatih/color v1.9.0
 	github.com/johnstarich/go/datasize v0.0.1
 	github.com/machinebox/progress v0.2.0
+	github.com/mattn/go-isatty v0.0.11
 	github.com/mattn/go-tty v0.0.3
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/afero v1.3.0
@@ -0,0 +1,266 @@
+package blob
+
+import (
+	"sort"
+	"sync"
+)
+
+// ChunkFetcher returns the chunk at 'index'. Returns a nil Blob if the chunk does not exist, which reads as zeroes.
+type ChunkFetcher func(index int64) (Blob, error)
+
+// Chunked is a Blob split into fixed size chunks. Chunks are fetched on first use and changed chunks are tracked, enabling partial writes.
+type Chunked struct {
+	mu        sync.Mutex
+	chunkSize int64
+	length    int64
+	chunks    map[int64]Blob
+	dirty     map[int64]uint64 // the write count when each chunk last changed
+	writes    uint64
+	fetch     ChunkFetcher
+}
+
+var _ Blob = &Chunked{}
+
+// NewChunked returns a Blob of 'length' bytes, lazily fetching 'chunkSize' chunks with 'fetch'
+func NewChunked(length, chunkSize int64, fetch ChunkFetcher) *Chunked {
+	return &Chunked{
+		chunkSize: chunkSize,
+		length:    length,
+		chunks:    make(map[int64]Blob),
+		dirty:     make(map[int64]uint64),
+		fetch:     fetch,
+	}
+}
+
+// ChunkSize returns the size of every chunk, except possibly the last one
+func (c *Chunked) ChunkSize() int64 {
+	return c.chunkSize
+}
+
+// ChunkCount returns the number of chunks needed to hold the current length
+func (c *Chunked) ChunkCount() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.chunkCount()
+}
+
+func (c *Chunked) chunkCount() int64 {
+	return (c.length + c.chunkSize - 1) / c.chunkSize
+}
+
+// chunkLen returns the expected length of chunk 'index'
+func (c *Chunked) chunkLen(index int64) int64 {
+	remaining := c.length - index*c.chunkSize
+	if remaining > c.chunkSize {
+		return c.chunkSize
+	}
+	return remaining
+}
+
+// Chunk returns the chunk at 'index', fetching it if necessary
+func (c *Chunked) Chunk(index int64) (Blob, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.chunk(index)
+}
+
+func (c *Chunked) chunk(index int64) (Blob, error) {
+	if chunk, ok := c.chunks[index]; ok {
+		return chunk, nil
+	}
+	chunk, err := c.fetch(index)
+	if err != nil {
+		return nil, err
+	}
+	length := c.chunkLen(index)
+	switch {
+	case chunk == nil:
+		chunk = NewBytesLength(int(length))
+	case int64(chunk.Len()) < length:
+		if err := chunk.Grow(length - int64(chunk.Len())); err != nil {
+			return nil, err
+		}
+	case int64(chunk.Len()) > length:
+		chunk.Truncate(length)
+	}
+	c.chunks[index] = chunk
+	return chunk, nil
+}
+
+// DirtyChunks returns the sorted indexes of changed chunks, and a marker to pass to ClearDirty once they're saved
+func (c *Chunked) DirtyChunks() (indexes []int64, marker uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	indexes = make([]int64, 0, len(c.dirty))
+	for index := range c.dirty {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		return indexes[a] < indexes[b]
+	})
+	return indexes, c.writes
+}
+
+// ClearDirty marks chunks as unchanged, if they haven't changed since DirtyChunks returned 'marker'
+func (c *Chunked) ClearDirty(marker uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for index, written := range c.dirty {
+		if written <= marker {
+			delete(c.dirty, index)
+		}
+	}
+}
+
+func (c *Chunked) markDirty(index int64) {
+	c.writes++
+	c.dirty[index] = c.writes
+}
+
+func (c *Chunked) Bytes() []byte {
+	b, err := c.View(0, int64(c.Len()))
+	if err != nil {
+		panic(err) // chunk fetchers should never fail. IDB chunks will only fail if types are wrong
+	}
+	return b.Bytes()
+}
+
+func (c *Chunked) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.length)
+}
+
+func (c *Chunked) View(start, end int64) (Blob, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if start/c.chunkSize == (end-1)/c.chunkSize && end > start {
+		// fast path: view is inside a single chunk
+		index := start / c.chunkSize
+		chunk, err := c.chunk(index)
+		if err != nil {
+			return nil, err
+		}
+		offset := index * c.chunkSize
+		return chunk.View(start-offset, end-offset)
+	}
+
+	buf := make([]byte, 0, end-start)
+	for index := start / c.chunkSize; index*c.chunkSize < end; index++ {
+		chunk, err := c.chunk(index)
+		if err != nil {
+			return nil, err
+		}
+		offset := index * c.chunkSize
+		chunkStart, chunkEnd := max(start-offset, 0), min(end-offset, int64(chunk.Len()))
+		view, err := chunk.View(chunkStart, chunkEnd)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, view.Bytes()...)
+	}
+	return NewFromBytes(buf), nil
+}
+
+func (c *Chunked) Slice(start, end int64) (Blob, error) {
+	view, err := c.View(start, end)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, view.Len())
+	copy(buf, view.Bytes())
+	return NewFromBytes(buf), nil
+}
+
+func (c *Chunked) Set(w Blob, off int64) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := off + int64(w.Len())
+	if end > c.length {
+		end = c.length
+	}
+	for index := off / c.chunkSize; index*c.chunkSize < end; index++ {
+		chunk, err := c.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		offset := index * c.chunkSize
+		chunkStart := max(off-offset, 0)
+		srcStart := offset + chunkStart - off
+		srcEnd := min(end-off, srcStart+int64(chunk.Len())-chunkStart)
+		src, err := w.View(srcStart, srcEnd)
+		if err != nil {
+			return n, err
+		}
+		written, err := chunk.Set(src, chunkStart)
+		n += written
+		c.markDirty(index)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (c *Chunked) Grow(off int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	oldCount := c.chunkCount()
+	if oldCount > 0 && c.chunkLen(oldCount-1) < c.chunkSize {
+		// fill out the last partial chunk before growing
+		lastIndex := oldCount - 1
+		chunk, err := c.chunk(lastIndex)
+		if err != nil {
+			return err
+		}
+		growBy := min(c.chunkSize-int64(chunk.Len()), off)
+		if err := chunk.Grow(growBy); err != nil {
+			return err
+		}
+		c.markDirty(lastIndex)
+	}
+	c.length += off
+	for index := oldCount; index < c.chunkCount(); index++ {
+		c.chunks[index] = NewBytesLength(int(c.chunkLen(index)))
+		c.markDirty(index)
+	}
+	return nil
+}
+
+func (c *Chunked) Truncate(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.length < size {
+		return
+	}
+	c.length = size
+	count := c.chunkCount()
+	for index := range c.chunks {
+		if index >= count {
+			delete(c.chunks, index)
+			delete(c.dirty, index)
+		}
+	}
+	if count > 0 && c.chunkLen(count-1) < c.chunkSize {
+		lastIndex := count - 1
+		if chunk, ok := c.chunks[lastIndex]; ok {
+			chunk.Truncate(c.chunkLen(lastIndex))
+		}
+		// an unfetched last chunk is truncated on fetch
+		c.markDirty(lastIndex)
+	}
+}
+
+func min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,19 @@
+// +build js
+
+package blob
+
+import "syscall/js"
+
+func (c *Chunked) JSValue() js.Value {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf := uint8Array.New(c.length)
+	for index := int64(0); index < c.chunkCount(); index++ {
+		chunk, err := c.chunk(index)
+		if err != nil {
+			panic(err) // chunk fetchers should never fail. IDB chunks will only fail if types are wrong
+		}
+		buf.Call("set", chunk.JSValue(), index*c.chunkSize)
+	}
+	return buf
+}
@@ -0,0 +1,59 @@
+package blob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunked(t *testing.T) {
+	const chunkSize = 4
+	stored := map[int64][]byte{
+		0: []byte("abcd"),
+		1: []byte("efgh"),
+		2: []byte("ij"),
+	}
+	var fetched []int64
+	c := NewChunked(10, chunkSize, func(index int64) (Blob, error) {
+		fetched = append(fetched, index)
+		chunk, ok := stored[index]
+		if !ok {
+			return nil, nil
+		}
+		return NewFromBytes(append([]byte(nil), chunk...)), nil
+	})
+
+	view, err := c.View(5, 7)
+	require.NoError(t, err)
+	assert.Equal(t, "fg", string(view.Bytes()))
+	assert.Equal(t, []int64{1}, fetched, "Only the needed chunk should be fetched")
+
+	n, err := c.Set(NewFromBytes([]byte("XYZ")), 3)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "abcXYZghij", string(c.Bytes()))
+	dirty, marker := c.DirtyChunks()
+	assert.Equal(t, []int64{0, 1}, dirty)
+
+	c.ClearDirty(marker)
+	require.NoError(t, c.Grow(3))
+	assert.Equal(t, 13, c.Len())
+	assert.EqualValues(t, 4, c.ChunkCount())
+	assert.Equal(t, "abcXYZghij\x00\x00\x00", string(c.Bytes()))
+	dirty, marker = c.DirtyChunks()
+	assert.Equal(t, []int64{2, 3}, dirty)
+
+	_, err = c.Set(NewFromBytes([]byte("!")), 12)
+	require.NoError(t, err)
+	c.ClearDirty(marker)
+	dirty, marker = c.DirtyChunks()
+	assert.Equal(t, []int64{3}, dirty, "Chunks changed after DirtyChunks should stay dirty")
+
+	c.ClearDirty(marker)
+	c.Truncate(6)
+	assert.Equal(t, "abcXYZ", string(c.Bytes()))
+	assert.EqualValues(t, 2, c.ChunkCount())
+	dirty, _ = c.DirtyChunks()
+	assert.Equal(t, []int64{1}, dirty)
+}
@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/johnstarich/go-wasm/internal/tarindex"
 	"github.com/johnstarich/go/datasize"
 )
 
@@ -27,13 +28,9 @@ func main() {
 }
 
 func archiveGo(goRoot string, w io.Writer) error {
-	compressor, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
-	if err != nil {
-		return err
-	}
-	archive := tar.NewWriter(compressor)
+	archive := tarindex.NewWriterLevel(w, gzip.BestSpeed)
 
-	goRoot, err = filepath.Abs(goRoot)
+	goRoot, err := filepath.Abs(goRoot)
 	if err != nil {
 		return err
 	}
@@ -76,11 +73,7 @@ func archiveGo(goRoot string, w io.Writer) error {
 		return err
 	}
 
-	err = archive.Close()
-	if err != nil {
-		return err
-	}
-	return compressor.Close()
+	return archive.Close()
 }
 
 type Int64Slice []int64
@@ -0,0 +1,184 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ArchiveFormat is a type of archive supported by Extract
+type ArchiveFormat int
+
+const (
+	// UnknownArchive is an unrecognized archive format
+	UnknownArchive ArchiveFormat = iota
+	// ZipArchive is a .zip archive
+	ZipArchive
+	// TarArchive is an uncompressed .tar archive
+	TarArchive
+	// TarGzipArchive is a gzip compressed .tar.gz archive
+	TarGzipArchive
+)
+
+func (a ArchiveFormat) String() string {
+	switch a {
+	case ZipArchive:
+		return "zip"
+	case TarArchive:
+		return "tar"
+	case TarGzipArchive:
+		return "tar.gz"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectArchiveFormat returns the archive format of 'r' from its leading magic bytes
+func DetectArchiveFormat(r io.ReaderAt) ArchiveFormat {
+	const tarMagicOffset = 257
+	header := make([]byte, tarMagicOffset+5)
+	n, _ := r.ReadAt(header, 0)
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return ZipArchive
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return TarGzipArchive
+	case len(header) == tarMagicOffset+5 && string(header[tarMagicOffset:]) == "ustar":
+		return TarArchive
+	default:
+		return UnknownArchive
+	}
+}
+
+// Extract unpacks the zip, tar, or tar.gz archive in 'r' into 'dest', creating it if needed.
+// 'progress' is called with the percentage of the archive extracted so far, if not nil.
+func Extract(r io.ReaderAt, size int64, dest string, progress func(percent float64)) error {
+	if progress == nil {
+		progress = func(float64) {}
+	}
+	format := DetectArchiveFormat(r)
+	var err error
+	switch format {
+	case ZipArchive:
+		err = extractZip(r, size, dest, progress)
+	case TarArchive, TarGzipArchive:
+		err = extractTarArchive(r, size, format, dest, progress)
+	default:
+		return errors.New("Unrecognized archive format, must be a zip, tar, or tar.gz file")
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Failed to extract %s archive", format)
+	}
+	progress(100)
+	return nil
+}
+
+// ExtractFile unpacks the archive at 'archivePath' into 'dest'. See Extract for details.
+func ExtractFile(archivePath, dest string, progress func(percent float64)) error {
+	f, err := filesystem.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return Extract(f, info.Size(), dest, progress)
+}
+
+func extractTarArchive(r io.ReaderAt, size int64, format ArchiveFormat, dest string, progress func(float64)) error {
+	counter := &progressReader{Reader: io.NewSectionReader(r, 0, size), total: size, progress: progress}
+	var archive io.Reader = counter
+	if format == TarGzipArchive {
+		gzipReader, err := gzip.NewReader(counter)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		archive = gzipReader
+	}
+	return extractTar(tar.NewReader(archive), dest, false)
+}
+
+func extractZip(r io.ReaderAt, size int64, dest string, progress func(float64)) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	if err := filesystem.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+
+	var total, done uint64
+	for _, f := range z.File {
+		total += f.CompressedSize64
+	}
+	var dirs []*zip.File
+	for _, f := range z.File {
+		path := extractPath(dest, f.Name)
+		if f.FileInfo().IsDir() {
+			if err := filesystem.MkdirAll(path, 0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, f) // set attributes after children are written, so times aren't changed
+			continue
+		}
+		if err := extractZipFile(f, path); err != nil {
+			return err
+		}
+		done += f.CompressedSize64
+		if total > 0 {
+			progress(100 * float64(done) / float64(total))
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		f := dirs[i]
+		if err := applyAttributes(extractPath(dest, f.Name), f.Mode(), -1, -1, f.Modified, f.Modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, path string) error {
+	if !f.Mode().IsRegular() {
+		return nil // only files and directories are supported
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := extractFile(r, path); err != nil {
+		return err
+	}
+	return applyAttributes(path, f.Mode(), -1, -1, f.Modified, f.Modified)
+}
+
+// progressReader reports the percentage of 'total' bytes read, each time it increases by at least 1%
+type progressReader struct {
+	io.Reader
+	total       int64
+	read        int64
+	lastPercent int
+	progress    func(float64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	p.read += int64(n)
+	if p.total > 0 {
+		percent := 100 * float64(p.read) / float64(p.total)
+		if int(percent) > p.lastPercent {
+			p.lastPercent = int(percent)
+			p.progress(percent)
+		}
+	}
+	return n, err
+}
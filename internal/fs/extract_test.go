@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/mountfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtract(t *testing.T) {
+	oldFilesystem := filesystem
+	filesystem = mountfs.New(afero.NewMemMapFs())
+	t.Cleanup(func() {
+		filesystem = oldFilesystem
+	})
+	modTime := time.Unix(1000, 0).UTC()
+
+	var zipBuf bytes.Buffer
+	z := zip.NewWriter(&zipBuf)
+	header := &zip.FileHeader{Name: "dir/file", Modified: modTime}
+	header.SetMode(0640)
+	w, err := z.CreateHeader(header)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("zipped"))
+	require.NoError(t, err)
+	_, err = z.Create("empty/")
+	require.NoError(t, err)
+	require.NoError(t, z.Close())
+
+	var tarBuf bytes.Buffer
+	gz := gzip.NewWriter(&tarBuf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/file", Typeflag: tar.TypeReg, Mode: 0640, Size: 3, ModTime: modTime, Uid: 501}))
+	_, err = tw.Write([]byte("tar"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0600}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	for _, tc := range []struct {
+		description string
+		archive     []byte
+		format      ArchiveFormat
+		contents    string
+	}{
+		{description: "zip", archive: zipBuf.Bytes(), format: ZipArchive, contents: "zipped"},
+		{description: "tar.gz", archive: tarBuf.Bytes(), format: TarGzipArchive, contents: "tar"},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			r := bytes.NewReader(tc.archive)
+			assert.Equal(t, tc.format, DetectArchiveFormat(r))
+			var progress []float64
+			require.NoError(t, Extract(r, r.Size(), "/"+tc.description, func(percent float64) {
+				progress = append(progress, percent)
+			}))
+			assert.Equal(t, float64(100), progress[len(progress)-1])
+
+			contents, err := afero.ReadFile(filesystem, "/"+tc.description+"/dir/file")
+			require.NoError(t, err)
+			assert.Equal(t, tc.contents, string(contents))
+			info, err := filesystem.Stat("/" + tc.description + "/dir/file")
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0640), info.Mode())
+			assert.True(t, modTime.Equal(info.ModTime()))
+		})
+	}
+
+	_, err = filesystem.Stat("/tar.gz/escape")
+	assert.NoError(t, err, "Entries outside the destination should be extracted inside it")
+	_, err = filesystem.Stat("/zip/empty")
+	assert.NoError(t, err, "Empty directories should be extracted")
+
+	err = Extract(bytes.NewReader([]byte("not an archive")), 14, "/bad", nil)
+	assert.EqualError(t, err, "Unrecognized archive format, must be a zip, tar, or tar.gz file")
+}
@@ -3,6 +3,7 @@ package fs
 import (
 	"io"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -11,9 +12,12 @@ import (
 	"time"
 
 	"github.com/johnstarich/go-wasm/internal/common"
+	"github.com/johnstarich/go-wasm/internal/fswatch"
 	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/storer"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
+	"go.uber.org/atomic"
 )
 
 var (
@@ -26,6 +30,9 @@ type FileDescriptors struct {
 	files            map[FID]*fileDescriptor
 	mu               sync.Mutex
 	workingDirectory *workingDirectory
+
+	uid, gid int
+	umask    atomic.Uint32
 }
 
 func NewStdFileDescriptors(parentPID common.PID, workingDirectory string) (*FileDescriptors, error) {
@@ -35,6 +42,7 @@ func NewStdFileDescriptors(parentPID common.PID, workingDirectory string) (*File
 		files:            make(map[FID]*fileDescriptor),
 		workingDirectory: newWorkingDirectory(workingDirectory),
 	}
+	f.umask.Store(defaultUmask)
 	// order matters
 	_, err := f.Open("/dev/stdin", syscall.O_RDONLY, 0)
 	if err != nil {
@@ -54,7 +62,10 @@ func NewFileDescriptors(parentPID common.PID, workingDirectory string, parentFil
 		previousFID:      0,
 		files:            make(map[FID]*fileDescriptor),
 		workingDirectory: newWorkingDirectory(workingDirectory),
+		uid:              parentFiles.uid,
+		gid:              parentFiles.gid,
 	}
+	f.umask.Store(parentFiles.umask.Load())
 	if len(inheritFDs) == 0 {
 		inheritFDs = []Attr{{FID: 0}, {FID: 1}, {FID: 2}}
 	}
@@ -107,6 +118,11 @@ func (f *FileDescriptors) newFID() FID {
 
 func (f *FileDescriptors) Open(path string, flags int, mode os.FileMode) (fd FID, err error) {
 	path = f.resolvePath(path)
+	create, err := f.checkOpen(path, flags)
+	if err != nil {
+		return 0, err
+	}
+	mode = f.applyUmask(mode)
 
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -114,6 +130,12 @@ func (f *FileDescriptors) Open(path string, flags int, mode os.FileMode) (fd FID
 	if err != nil {
 		return 0, err
 	}
+	if create {
+		if err := f.applyCreds(path); err != nil {
+			_ = descriptor.file.Close()
+			return 0, err
+		}
+	}
 	f.addFileDescriptor(descriptor)
 	descriptor.Open(f.parentPID)
 	return descriptor.id, nil
@@ -168,7 +190,14 @@ func (f *FileDescriptors) Fstat(fd FID) (os.FileInfo, error) {
 }
 
 func (f *FileDescriptors) ReadDir(path string) ([]os.FileInfo, error) {
-	return afero.ReadDir(filesystem, f.resolvePath(path))
+	path = f.resolvePath(path)
+	if err := f.checkTraverse("readdir", path); err != nil {
+		return nil, err
+	}
+	if err := f.checkAccess("readdir", path, AccessRead); err != nil {
+		return nil, err
+	}
+	return afero.ReadDir(filesystem, path)
 }
 
 func (f *FileDescriptors) RemoveDir(path string) error {
@@ -180,6 +209,9 @@ func (f *FileDescriptors) RemoveDir(path string) error {
 	if !info.IsDir() {
 		return ErrNotDir
 	}
+	if err := f.checkModifyDir("rmdir", path); err != nil {
+		return err
+	}
 	return filesystem.Remove(path)
 }
 
@@ -187,6 +219,10 @@ func (f *FileDescriptors) Chmod(path string, mode os.FileMode) error {
 	return filesystem.Chmod(f.resolvePath(path), mode)
 }
 
+func (f *FileDescriptors) Chown(path string, uid, gid int) error {
+	return filesystem.Chown(f.resolvePath(path), uid, gid)
+}
+
 func (f *FileDescriptors) Stat(path string) (os.FileInfo, error) {
 	return filesystem.Stat(f.resolvePath(path))
 }
@@ -197,11 +233,43 @@ func (f *FileDescriptors) Lstat(path string) (os.FileInfo, error) {
 }
 
 func (f *FileDescriptors) Mkdir(path string, mode os.FileMode) error {
-	return filesystem.Mkdir(f.resolvePath(path), mode)
+	path = f.resolvePath(path)
+	if err := f.checkModifyDir("mkdir", path); err != nil {
+		return err
+	}
+	if err := filesystem.Mkdir(path, f.applyUmask(mode)); err != nil {
+		return err
+	}
+	return f.applyCreds(path)
 }
 
 func (f *FileDescriptors) MkdirAll(path string, mode os.FileMode) error {
-	return filesystem.MkdirAll(f.resolvePath(path), mode)
+	path = f.resolvePath(path)
+	// find the first missing directory, then check permissions on its parent
+	missing := path
+	for ; missing != afero.FilePathSeparator; missing = filepath.Dir(missing) {
+		_, err := filesystem.Stat(filepath.Dir(missing))
+		if err == nil {
+			break
+		}
+	}
+	if _, err := filesystem.Stat(path); os.IsNotExist(err) {
+		if err := f.checkModifyDir("mkdir", missing); err != nil {
+			return err
+		}
+	}
+	if err := filesystem.MkdirAll(path, f.applyUmask(mode)); err != nil {
+		return err
+	}
+	if f.uid == 0 && f.gid == 0 {
+		return nil
+	}
+	for dir := path; dir != filepath.Dir(missing); dir = filepath.Dir(dir) {
+		if err := f.applyCreds(dir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (f *FileDescriptors) Unlink(path string) error {
@@ -213,6 +281,9 @@ func (f *FileDescriptors) Unlink(path string) error {
 	if info.IsDir() {
 		return os.ErrPermission
 	}
+	if err := f.checkModifyDir("unlink", path); err != nil {
+		return err
+	}
 	return filesystem.Remove(path)
 }
 
@@ -254,9 +325,38 @@ func (f *FileDescriptors) Fsync(fd FID) error {
 func (f *FileDescriptors) Rename(oldPath, newPath string) error {
 	oldPath = f.resolvePath(oldPath)
 	newPath = f.resolvePath(newPath)
+	if err := f.checkModifyDir("rename", oldPath); err != nil {
+		return err
+	}
+	if err := f.checkModifyDir("rename", newPath); err != nil {
+		return err
+	}
 	return filesystem.Rename(oldPath, newPath)
 }
 
+// CopyFile copies the file 'oldPath' to 'newPath', keeping its mode and times.
+// New files are owned by the process's user and group, and replaced files keep their owner.
+func (f *FileDescriptors) CopyFile(oldPath, newPath string) error {
+	oldPath = f.resolvePath(oldPath)
+	newPath = f.resolvePath(newPath)
+	if _, err := f.checkOpen(oldPath, syscall.O_RDONLY); err != nil {
+		return err
+	}
+	if _, err := f.checkOpen(newPath, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_TRUNC); err != nil {
+		return err
+	}
+	uid, gid := f.uid, f.gid
+	if info, err := filesystem.Stat(newPath); err == nil {
+		if stat, ok := info.Sys().(*storer.Stat); ok {
+			uid, gid = stat.Uid, stat.Gid
+		}
+	}
+	if err := filesystem.Copy(oldPath, newPath); err != nil {
+		return err
+	}
+	return filesystem.Chown(newPath, uid, gid)
+}
+
 func (f *FileDescriptors) Fchmod(fd FID, mode os.FileMode) error {
 	fileDescriptor := f.files[fd]
 	if fileDescriptor == nil {
@@ -265,6 +365,14 @@ func (f *FileDescriptors) Fchmod(fd FID, mode os.FileMode) error {
 	return filesystem.Chmod(f.resolvePath(fileDescriptor.FileName()), mode)
 }
 
+func (f *FileDescriptors) Fchown(fd FID, uid, gid int) error {
+	fileDescriptor := f.files[fd]
+	if fileDescriptor == nil {
+		return interop.BadFileNumber(fd)
+	}
+	return filesystem.Chown(f.resolvePath(fileDescriptor.FileName()), uid, gid)
+}
+
 type LockAction int
 
 const (
@@ -318,3 +426,8 @@ func (f *FileDescriptors) RawFIDs() []io.ReadWriter {
 	}
 	return results
 }
+
+// Watch starts watching 'path' for create, write, remove, and rename events on any mount
+func (f *FileDescriptors) Watch(path string, recursive bool) *fswatch.Watcher {
+	return filesystem.Watch(f.resolvePath(path), recursive)
+}
@@ -4,27 +4,41 @@ import (
 	"archive/zip"
 	"io"
 	"os"
+	"time"
 
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/fswatch"
 	"github.com/johnstarich/go-wasm/internal/mountfs"
+	"github.com/johnstarich/go-wasm/internal/quota"
 	"github.com/johnstarich/go-wasm/internal/storer"
 	"github.com/johnstarich/go-wasm/internal/tarfs"
+	"github.com/johnstarich/go-wasm/internal/tarindex"
+	"github.com/johnstarich/go-wasm/internal/tmpfs"
+	"github.com/johnstarich/go-wasm/internal/unionfs"
 	"github.com/johnstarich/go-wasm/log"
 	"github.com/johnstarich/go/datasize"
+	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 	"github.com/spf13/afero/zipfs"
 )
 
 var (
-	filesystem rootFs = mountfs.New(afero.NewMemMapFs())
+	filesystem rootFs = mountfs.New(quota.New(afero.NewMemMapFs()))
 )
 
 type rootFs interface {
 	afero.Fs
 	afero.Lstater
+	fsutil.Chowner
+	fsutil.Copier
 	Mounts() map[string]string
 	DestroyMount(string) error
 	Mount(string, afero.Fs) error
+	Unmount(string) error
 	FSForPath(string) afero.Fs
+	MountPath(string) string
+	Mounted(string) afero.Fs
+	Watch(path string, recursive bool) *fswatch.Watcher
 }
 
 func Mounts() (pathsToFSName map[string]string) {
@@ -32,7 +46,20 @@ func Mounts() (pathsToFSName map[string]string) {
 }
 
 func DestroyMount(path string) error {
-	return filesystem.DestroyMount(path)
+	if err := filesystem.DestroyMount(path); err != nil {
+		return err
+	}
+	resetMountOptions(path)
+	return nil
+}
+
+// Unmount removes the mount at 'path' without deleting its data, so persistent mounts can be mounted again later
+func Unmount(path string) error {
+	if err := filesystem.Unmount(path); err != nil {
+		return err
+	}
+	resetMountOptions(path)
+	return nil
 }
 
 func OverlayStorage(mountPath string, s storer.Storer) error {
@@ -47,16 +74,79 @@ func OverlayZip(mountPath string, z *zip.Reader) error {
 	return filesystem.Mount(mountPath, zipfs.New(z))
 }
 
+// OverlayMemory mounts an empty in-memory file system at 'mountPath'. The contents are discarded on reload.
+func OverlayMemory(mountPath string) error {
+	return filesystem.Mount(mountPath, quota.New(afero.NewMemMapFs()))
+}
+
+var (
+	// DefaultTmpfsSize is the default size cap for temporary files, like go build's work directories
+	DefaultTmpfsSize = datasize.Mebibytes(256).Bytes()
+	// DefaultTmpfsMaxAge is the default time before unused temporary files are removed
+	DefaultTmpfsMaxAge = time.Hour
+)
+
+// OverlayTmpfs mounts an in-memory file system at 'mountPath' with a 'maxBytes' size cap, removing entries unused for 'maxAge'.
+// When a write exceeds the cap, the least recently used entries are evicted if they're stale, otherwise the write fails. The contents are discarded on reload.
+func OverlayTmpfs(mountPath string, maxBytes int64, maxAge time.Duration) error {
+	fs, err := tmpfs.New(maxBytes, maxAge)
+	if err != nil {
+		return err
+	}
+	if err := fs.Chmod(afero.FilePathSeparator, os.ModeSticky|0777); err != nil {
+		return err
+	}
+	return filesystem.Mount(mountPath, fs)
+}
+
 type ShouldCacher func(string) bool
 
-func OverlayTarGzip(mountPath string, r io.ReadCloser, persist bool) error {
+// WritableLayer selects where changes to a read-only mount are stored
+type WritableLayer int
+
+const (
+	// ReadOnlyLayer mounts without a writable layer
+	ReadOnlyLayer WritableLayer = iota
+	// MemoryLayer stores changes in memory, which are discarded on reload
+	MemoryLayer
+	// PersistLayer stores changes in persistent storage
+	PersistLayer
+)
+
+const writableLayerDBSuffix = "#writable"
+
+// mountReadOnly mounts 'fs' at 'mountPath', with a copy-on-write union layer on top if requested
+func mountReadOnly(mountPath string, fs afero.Fs, writable WritableLayer) error {
+	var upper afero.Fs
+	switch writable {
+	case ReadOnlyLayer:
+		return filesystem.Mount(mountPath, fs)
+	case MemoryLayer:
+		upper = quota.New(afero.NewMemMapFs())
+	case PersistLayer:
+		db, err := newPersistDB(mountPath+writableLayerDBSuffix, func(string) bool { return false })
+		if err != nil {
+			return err
+		}
+		upper = db
+	default:
+		return errors.Errorf("Unknown writable layer type: %d", writable)
+	}
+	union, err := unionfs.New(upper, fs)
+	if err != nil {
+		return err
+	}
+	return filesystem.Mount(mountPath, union)
+}
+
+func OverlayTarGzip(mountPath string, r io.ReadCloser, persist bool, writable WritableLayer) error {
 	if !persist {
 		underlyingFs := afero.NewMemMapFs()
 		fs, err := tarfs.New(r, underlyingFs)
 		if err != nil {
 			return err
 		}
-		return filesystem.Mount(mountPath, fs)
+		return mountReadOnly(mountPath, fs, writable)
 	}
 
 	const tarfsDoneMarker = ".tarfs-complete"
@@ -71,7 +161,7 @@ func OverlayTarGzip(mountPath string, r io.ReadCloser, persist bool) error {
 		// tarfs already completed successfully and is persisted,
 		// so close tarfs reader and mount the existing files
 		r.Close()
-		return filesystem.Mount(mountPath, afero.NewReadOnlyFs(underlyingFs))
+		return mountReadOnly(mountPath, afero.NewReadOnlyFs(underlyingFs), writable)
 	} else {
 		// either never untar'd or did not finish untaring, so start again
 		// should be idempotent, but rewriting buffers from JS is expensive, so just delete everything
@@ -99,7 +189,26 @@ func OverlayTarGzip(mountPath string, r io.ReadCloser, persist bool) error {
 		}
 		f.Close()
 	}()
-	return filesystem.Mount(mountPath, fs)
+	return mountReadOnly(mountPath, fs, writable)
+}
+
+// OverlayIndexedTar mounts an indexed .tar.gz archive, like those written by tarindex.Writer, at 'mountPath'.
+// Files are fetched from 'archive' on first open. If 'persist' is true, fetched files are kept in persistent storage across reloads.
+// Returns tarindex.ErrNotIndexed if 'archive' has no table of contents.
+func OverlayIndexedTar(mountPath string, archive io.ReaderAt, size int64, persist bool, writable WritableLayer) error {
+	var cache afero.Fs = afero.NewMemMapFs()
+	if persist {
+		db, err := newPersistDB(mountPath, func(string) bool { return true })
+		if err != nil {
+			return err
+		}
+		cache = db
+	}
+	fs, err := tarindex.Open(archive, size, cache)
+	if err != nil {
+		return err
+	}
+	return mountReadOnly(mountPath, fs, writable)
 }
 
 // Dump prints out file system statistics
@@ -0,0 +1,423 @@
+// +build js
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"syscall/js"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/blob"
+	"github.com/johnstarich/go-wasm/internal/common"
+	"github.com/johnstarich/go-wasm/internal/indexeddb"
+	"github.com/johnstarich/go-wasm/internal/storer"
+	"github.com/spf13/afero"
+)
+
+// FsckProblemKind is a type of inconsistency found by Fsck
+type FsckProblemKind string
+
+const (
+	// FsckMissingRoot means the root directory record is missing
+	FsckMissingRoot FsckProblemKind = "missing-root"
+	// FsckOrphan means a record's parent directory is missing or isn't a directory, so it can't be listed or reached
+	FsckOrphan FsckProblemKind = "orphan"
+	// FsckParentMismatch means a record's Parent index doesn't match its path, so it's listed in the wrong directory or not at all
+	FsckParentMismatch FsckProblemKind = "parent-mismatch"
+	// FsckSizeMismatch means a file record's size doesn't match its stored contents
+	FsckSizeMismatch FsckProblemKind = "size-mismatch"
+	// FsckMissingChunks means chunks before a file's last chunk are missing, so they read as zeroes
+	FsckMissingChunks FsckProblemKind = "missing-chunks"
+	// FsckOrphanChunks means file chunks exist without a matching file record
+	FsckOrphanChunks FsckProblemKind = "orphan-chunks"
+	// FsckOrphanContents means legacy file contents remain, though they were converted to chunks and are no longer read
+	FsckOrphanContents FsckProblemKind = "orphan-contents"
+	// FsckRefCountMismatch means a content's reference count doesn't match the number of paths pointing to it, in a deduplicated mount
+	FsckRefCountMismatch = FsckProblemKind(storer.RefCountMismatch)
+	// FsckMissingContent means paths point to content which isn't stored, in a deduplicated mount
+	FsckMissingContent = FsckProblemKind(storer.RefMissingContent)
+	// FsckUnreferencedContent means content is stored, but no paths point to it, in a deduplicated mount
+	FsckUnreferencedContent = FsckProblemKind(storer.RefUnreferenced)
+)
+
+// FsckProblem is an inconsistency found at Path
+type FsckProblem struct {
+	Kind   FsckProblemKind
+	Path   string
+	Detail string
+}
+
+// FsckReport is the result of a consistency check
+type FsckReport struct {
+	Checked  int // number of records checked
+	Problems []FsckProblem
+	Repaired bool
+}
+
+// Fsck checks the IndexedDB mount at 'mountPath' for inconsistencies left by interrupted writes. If 'repair' is true, problems are fixed:
+// missing parent directories are recreated, records under non-directories and unreferenced contents are deleted, missing chunks are filled with zeroes,
+// and indexes, sizes, and reference counts are corrected to match the stored data.
+func Fsck(mountPath string, repair bool) (FsckReport, error) {
+	fs := filesystem.Mounted(mountPath)
+	if fs == nil {
+		return FsckReport{}, &os.PathError{Op: "fsck", Path: mountPath, Err: os.ErrNotExist}
+	}
+	idb, ok := fs.(*IndexedDBFs)
+	if !ok {
+		return FsckReport{}, &os.PathError{Op: "fsck", Path: mountPath, Err: syscall.ENOTSUP}
+	}
+	return idb.Fsck(repair)
+}
+
+// Fsck checks each underlying database for inconsistencies, repairing them if 'repair' is true. See Fsck for details.
+func (i *IndexedDBFs) Fsck(repair bool) (FsckReport, error) {
+	var report FsckReport
+	files := make([][]string, len(i.storers))
+	for ix, s := range i.storers {
+		checked, problems, storerFiles, err := s.fsck(repair)
+		report.Checked += checked
+		report.Problems = append(report.Problems, problems...)
+		if err != nil {
+			return report, err
+		}
+		files[ix] = storerFiles
+	}
+	if i.content != nil {
+		// storers are the paths and contents databases
+		refProblems, err := i.content.CheckRefs(files[0], files[1], repair)
+		for _, problem := range refProblems {
+			report.Problems = append(report.Problems, FsckProblem{Kind: FsckProblemKind(problem.Kind), Path: problem.Hash, Detail: problem.Detail})
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	report.Repaired = repair && len(report.Problems) > 0
+	return report, nil
+}
+
+type fsckRecord struct {
+	value     js.Value
+	mode      os.FileMode
+	size      int64
+	chunkSize int64
+	dirty     bool // needs to be rewritten during repair
+}
+
+// fsckRepair is a write to run during repair
+type fsckRepair func(infos, chunks, contents *indexeddb.ObjectStore) (*indexeddb.Request, error)
+
+// fsck checks the database for problems, repairing them if 'repair' is true. Returns the paths of the files which remain.
+func (i *indexedDBStorer) fsck(repair bool) (checked int, problems []FsckProblem, files []string, err error) {
+	defer common.CatchException(&err)
+
+	records, chunkIndexes, contentPaths, err := i.fsckLoad()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	sizes, err := i.fsckStoredSizes(records, chunkIndexes)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	var repairs []fsckRepair
+	report := func(kind FsckProblemKind, path, format string, args ...interface{}) {
+		problems = append(problems, FsckProblem{Kind: kind, Path: path, Detail: fmt.Sprintf(format, args...)})
+	}
+	putRecord := func(path string, value js.Value) {
+		repairs = append(repairs, func(infos, _, _ *indexeddb.ObjectStore) (*indexeddb.Request, error) {
+			return infos.Put(i.jsPaths.Value(path), value)
+		})
+	}
+	deleteFile := func(path string) {
+		repairs = append(repairs, func(infos, chunks, contents *indexeddb.ObjectStore) (*indexeddb.Request, error) {
+			if _, err := chunks.Delete(chunkKeysFrom(path, 0)); err != nil {
+				return nil, err
+			}
+			if _, err := contents.Delete(i.jsPaths.Value(path)); err != nil {
+				return nil, err
+			}
+			return infos.Delete(i.jsPaths.Value(path))
+		})
+	}
+
+	paths := make([]string, 0, len(records))
+	for path := range records {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths) // parents sort before their descendants
+	checked = len(paths)
+
+	if _, ok := records[afero.FilePathSeparator]; !ok {
+		report(FsckMissingRoot, afero.FilePathSeparator, "root directory record is missing")
+		records[afero.FilePathSeparator] = newFsckDir(afero.FilePathSeparator)
+		putRecord(afero.FilePathSeparator, records[afero.FilePathSeparator].value)
+	}
+
+	removed := make(map[string]bool)
+	for _, path := range paths {
+		if path == afero.FilePathSeparator {
+			continue
+		}
+		record := records[path]
+		parentPath := filepath.Dir(path)
+		parent, parentExists := records[parentPath]
+		switch {
+		case removed[parentPath]:
+			report(FsckOrphan, path, "parent directory %q is an orphan", parentPath)
+			removed[path] = true
+			deleteFile(path)
+			continue
+		case !parentExists:
+			report(FsckOrphan, path, "parent directory %q does not exist", parentPath)
+			for dir := parentPath; records[dir] == nil; dir = filepath.Dir(dir) {
+				records[dir] = newFsckDir(dir)
+				putRecord(dir, records[dir].value)
+			}
+		case !parent.mode.IsDir():
+			report(FsckOrphan, path, "parent %q is not a directory", parentPath)
+			removed[path] = true
+			deleteFile(path)
+			continue
+		}
+
+		if indexed := i.jsProperties.GetProperty(record.value, idbParentKey); indexed.Type() != js.TypeString || indexed.String() != parentPath {
+			report(FsckParentMismatch, path, "listed under %q instead of %q", indexed, parentPath)
+			record.value.Set(idbParentKey, parentPath)
+			record.dirty = true
+		}
+		if missing := missingChunks(chunkIndexes[path]); len(missing) > 0 && !record.mode.IsDir() {
+			report(FsckMissingChunks, path, "chunks %v are missing and read as zeroes", missing)
+			path, chunkSize := path, record.chunkSize
+			repairs = append(repairs, func(_, chunks, _ *indexeddb.ObjectStore) (*indexeddb.Request, error) {
+				var req *indexeddb.Request
+				for _, index := range missing {
+					var err error
+					req, err = chunks.Put(chunkKey(path, index), blob.NewBytesLength(int(chunkSize)).JSValue())
+					if err != nil {
+						return nil, err
+					}
+				}
+				return req, nil
+			})
+		}
+		if stored, ok := sizes[path]; ok && stored != record.size {
+			report(FsckSizeMismatch, path, "record size is %d bytes, but %d bytes are stored", record.size, stored)
+			record.value.Set("Size", stored)
+			record.dirty = true
+		}
+		if record.dirty {
+			putRecord(path, record.value)
+		}
+		if !record.mode.IsDir() {
+			files = append(files, path)
+		}
+	}
+
+	// removed records' chunks and contents are already deleted
+	chunkPaths := make([]string, 0, len(chunkIndexes))
+	for path := range chunkIndexes {
+		chunkPaths = append(chunkPaths, path)
+	}
+	sort.Strings(chunkPaths)
+	for _, path := range chunkPaths {
+		path := path
+		if record := records[path]; !removed[path] && (record == nil || record.mode.IsDir()) {
+			report(FsckOrphanChunks, path, "%d chunks have no file record", len(chunkIndexes[path]))
+			repairs = append(repairs, func(_, chunks, _ *indexeddb.ObjectStore) (*indexeddb.Request, error) {
+				return chunks.Delete(chunkKeysFrom(path, 0))
+			})
+		}
+	}
+	for _, path := range contentPaths {
+		path := path
+		if !removed[path] {
+			report(FsckOrphanContents, path, "legacy contents were not converted to chunks")
+			repairs = append(repairs, func(_, _, contents *indexeddb.ObjectStore) (*indexeddb.Request, error) {
+				return contents.Delete(i.jsPaths.Value(path))
+			})
+		}
+	}
+
+	if !repair || len(repairs) == 0 {
+		return checked, problems, files, nil
+	}
+	return checked, problems, files, i.fsckRepair(repairs)
+}
+
+// missingChunks returns the indexes missing before the last of the sorted chunk 'indexes'
+func missingChunks(indexes []int64) []int64 {
+	var missing []int64
+	next := int64(0)
+	for _, index := range indexes {
+		for ; next < index; next++ {
+			missing = append(missing, next)
+		}
+		next = index + 1
+	}
+	return missing
+}
+
+func newFsckDir(path string) *fsckRecord {
+	info := map[string]interface{}{
+		"ModTime": time.Now().Unix(),
+		"Mode":    uint32(os.ModeDir | 0755),
+		"Size":    0,
+	}
+	if path != afero.FilePathSeparator {
+		info[idbParentKey] = filepath.Dir(path)
+	}
+	return &fsckRecord{value: js.ValueOf(info), mode: os.ModeDir | 0755}
+}
+
+// fsckLoad reads all file records, the chunk indexes stored for each path, and the paths with legacy contents
+func (i *indexedDBStorer) fsckLoad() (map[string]*fsckRecord, map[string][]int64, []string, error) {
+	txn, err := i.db.Transaction(indexeddb.TransactionReadOnly, idbFileInfoStore, idbFileChunksStore, idbFileContentsStore)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var requests []*indexeddb.Request
+	for _, get := range []func() (*indexeddb.Request, error){
+		func() (*indexeddb.Request, error) { return getAllKeys(txn, idbFileInfoStore) },
+		func() (*indexeddb.Request, error) {
+			infos, err := txn.ObjectStore(idbFileInfoStore)
+			if err != nil {
+				return nil, err
+			}
+			return infos.GetAll(js.Undefined())
+		},
+		func() (*indexeddb.Request, error) { return getAllKeys(txn, idbFileChunksStore) },
+		func() (*indexeddb.Request, error) { return getAllKeys(txn, idbFileContentsStore) },
+	} {
+		req, err := get()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		requests = append(requests, req)
+	}
+	results := make([]js.Value, len(requests))
+	for ix, req := range requests {
+		results[ix], err = req.Await()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	infoKeys, infoValues, chunkKeys, contentKeys := results[0], results[1], results[2], results[3]
+
+	records := make(map[string]*fsckRecord, infoKeys.Length())
+	for ix := 0; ix < infoKeys.Length(); ix++ {
+		value := infoValues.Index(ix)
+		record := &fsckRecord{
+			value: value,
+			mode:  i.getMode(value),
+			size:  int64(i.jsProperties.GetProperty(value, "Size").Int()),
+		}
+		record.chunkSize = idbChunkSize // legacy files without stored contents were never chunked
+		if chunkSize := i.jsProperties.GetProperty(value, idbChunkSizeKey); chunkSize.Truthy() {
+			record.chunkSize = int64(chunkSize.Int())
+		}
+		records[infoKeys.Index(ix).String()] = record
+	}
+	chunkIndexes := make(map[string][]int64)
+	for ix := 0; ix < chunkKeys.Length(); ix++ {
+		key := chunkKeys.Index(ix)
+		path := key.Index(0).String()
+		chunkIndexes[path] = append(chunkIndexes[path], int64(key.Index(1).Int()))
+	}
+	contentPaths := make([]string, 0, contentKeys.Length())
+	for ix := 0; ix < contentKeys.Length(); ix++ {
+		contentPaths = append(contentPaths, contentKeys.Index(ix).String())
+	}
+	return records, chunkIndexes, contentPaths, nil
+}
+
+func getAllKeys(txn *indexeddb.Transaction, storeName string) (*indexeddb.Request, error) {
+	store, err := txn.ObjectStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetAllKeys(js.Undefined())
+}
+
+// fsckStoredSizes returns the size of each file's stored chunks. Only the last chunk of each file is read, since missing chunks are checked by their keys.
+func (i *indexedDBStorer) fsckStoredSizes(records map[string]*fsckRecord, chunkIndexes map[string][]int64) (map[string]int64, error) {
+	txn, err := i.db.Transaction(indexeddb.TransactionReadOnly, idbFileChunksStore)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := txn.ObjectStore(idbFileChunksStore)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64)
+	offsets := make(map[string]int64) // bytes stored before the last chunk
+	requests := make(map[string]*indexeddb.Request)
+	for path, record := range records {
+		if record.mode.IsDir() {
+			continue
+		}
+		indexes := chunkIndexes[path]
+		if len(indexes) == 0 {
+			sizes[path] = 0
+			continue
+		}
+		last := indexes[len(indexes)-1]
+		offsets[path] = last * record.chunkSize
+		req, err := chunks.Get(chunkKey(path, last))
+		if err != nil {
+			return nil, err
+		}
+		requests[path] = req
+	}
+	for path, req := range requests {
+		value, err := req.Await()
+		if err != nil {
+			return nil, err
+		}
+		var length int64
+		if !value.IsUndefined() {
+			length = int64(value.Get("byteLength").Int())
+		}
+		sizes[path] = offsets[path] + length
+	}
+	return sizes, nil
+}
+
+func (i *indexedDBStorer) fsckRepair(repairs []fsckRepair) error {
+	txn, err := i.db.Transaction(indexeddb.TransactionReadWrite, idbFileInfoStore, idbFileChunksStore, idbFileContentsStore)
+	if err != nil {
+		return err
+	}
+	infos, err := txn.ObjectStore(idbFileInfoStore)
+	if err != nil {
+		return err
+	}
+	chunks, err := txn.ObjectStore(idbFileChunksStore)
+	if err != nil {
+		return err
+	}
+	contents, err := txn.ObjectStore(idbFileContentsStore)
+	if err != nil {
+		return err
+	}
+	for _, repair := range repairs {
+		if _, err := repair(infos, chunks, contents); err != nil {
+			_ = txn.Abort()
+			return err
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	err = txn.Await()
+	i.infoCache.Range(func(key, _ interface{}) bool {
+		i.infoCache.Delete(key)
+		return true
+	})
+	return err
+}
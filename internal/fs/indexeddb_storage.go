@@ -5,6 +5,7 @@ package fs
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
@@ -18,26 +19,40 @@ import (
 	"github.com/johnstarich/go-wasm/internal/indexeddb"
 	"github.com/johnstarich/go-wasm/internal/indexeddb/queue"
 	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/migrate"
 	"github.com/johnstarich/go-wasm/internal/storer"
 	"github.com/johnstarich/go-wasm/log"
 	"github.com/spf13/afero"
 )
 
 const (
-	idbVersion           = 1
-	idbFileContentsStore = "contents"
+	idbFileContentsStore = "contents" // legacy single-blob file contents, converted to idbFileChunksStore in version 3
+	idbFileChunksStore   = "chunks"
 	idbFileInfoStore     = "info"
 	idbParentKey         = "Parent"
+	idbChunkSizeKey      = "ChunkSize"
+
+	idbChunkSize = 256 << 10
 )
 
+var jsIDBKeyRange = js.Global().Get("IDBKeyRange")
+
 const (
 	maxSetQueue      = 64
 	setQueueInterval = 20 * time.Millisecond
 )
 
+// Content addressed mounts use their own databases, since their path records hold content hashes instead of file data.
+// Toggling 'dedup' on a mount starts from an empty store instead of misreading the other format.
+const (
+	idbDedupPathsDBSuffix    = "#dedup"
+	idbDedupContentsDBSuffix = "#dedup-contents"
+)
+
 type IndexedDBFs struct {
 	*storer.Fs
-	db *indexeddb.DB
+	storers []*indexedDBStorer
+	content *storer.ContentAddressed // set if file data is deduplicated
 }
 
 func newPersistDB(name string, shouldCache ShouldCacher) (*IndexedDBFs, error) {
@@ -45,32 +60,150 @@ func newPersistDB(name string, shouldCache ShouldCacher) (*IndexedDBFs, error) {
 	return NewIndexedDBFs(name, shouldCache)
 }
 
-func NewIndexedDBFs(name string, shouldCache ShouldCacher) (_ *IndexedDBFs, err error) {
-	db, err := indexeddb.New(name, idbVersion, func(db *indexeddb.DB, oldVersion, newVersion int) error {
-		_, err := db.CreateObjectStore(idbFileContentsStore, indexeddb.ObjectStoreOptions{})
+func NewIndexedDBFs(name string, shouldCache ShouldCacher) (*IndexedDBFs, error) {
+	db, err := openIndexedDB(name)
+	if err != nil {
+		return nil, err
+	}
+	s := newIndexedDBStorer(db, shouldCache)
+	return &IndexedDBFs{
+		Fs:      storer.New(s),
+		storers: []*indexedDBStorer{s},
+	}, nil
+}
+
+// NewContentAddressedIndexedDBFs is like NewIndexedDBFs, but stores identical file data only once. See storer.ContentAddressed.
+func NewContentAddressedIndexedDBFs(name string, shouldCache ShouldCacher) (*IndexedDBFs, error) {
+	pathsDB, err := openIndexedDB(name + idbDedupPathsDBSuffix)
+	if err != nil {
+		return nil, err
+	}
+	contentsDB, err := openIndexedDB(name + idbDedupContentsDBSuffix)
+	if err != nil {
+		return nil, err
+	}
+	paths := newIndexedDBStorer(pathsDB, shouldCache)
+	contents := newIndexedDBStorer(contentsDB, func(string) bool { return false })
+	content := storer.NewContentAddressed(paths, contents)
+	return &IndexedDBFs{
+		Fs:      storer.New(content),
+		storers: []*indexedDBStorer{paths, contents},
+		content: content,
+	}, nil
+}
+
+func openIndexedDB(name string) (*indexeddb.DB, error) {
+	return indexeddb.New(name, idbVersion, func(db *indexeddb.DB, oldVersion, newVersion int) error {
+		_, err := migrate.Run(idbMigrations(db), oldVersion)
+		return err
+	})
+}
+
+// idbVersion is the current IndexedDB schema version
+var idbVersion = migrate.Latest(idbMigrations(nil))
+
+// idbMigrations returns the schema upgrade steps for 'db'. Append a step to change the schema or data format, never edit existing steps.
+// Steps run during the versionchange transaction, so they must queue requests without awaiting them.
+func idbMigrations(db *indexeddb.DB) []migrate.Step {
+	return []migrate.Step{
+		{
+			Version:     1,
+			Description: "create file info and contents stores",
+			Upgrade: func() error {
+				_, err := db.CreateObjectStore(idbFileContentsStore, indexeddb.ObjectStoreOptions{})
+				if err != nil {
+					return err
+				}
+				infos, err := db.CreateObjectStore(idbFileInfoStore, indexeddb.ObjectStoreOptions{})
+				if err != nil {
+					return err
+				}
+				_, err = infos.CreateIndex(idbParentKey, js.ValueOf(idbParentKey), indexeddb.IndexOptions{})
+				return err
+			},
+		},
+		{
+			Version:     2,
+			Description: "create file chunks store",
+			Upgrade: func() error {
+				_, err := db.CreateObjectStore(idbFileChunksStore, indexeddb.ObjectStoreOptions{})
+				return err
+			},
+		},
+		{
+			Version:     3,
+			Description: "convert single-blob file contents to chunks",
+			Upgrade: func() error {
+				return queueChunkLegacyContents(db.UpgradeTransaction())
+			},
+		},
+	}
+}
+
+// queueChunkLegacyContents moves each record in idbFileContentsStore into chunks, and records the chunk size in its file info.
+// Contents without a file's info are removed.
+func queueChunkLegacyContents(txn *indexeddb.Transaction) error {
+	contents, err := txn.ObjectStore(idbFileContentsStore)
+	if err != nil {
+		return err
+	}
+	chunks, err := txn.ObjectStore(idbFileChunksStore)
+	if err != nil {
+		return err
+	}
+	infos, err := txn.ObjectStore(idbFileInfoStore)
+	if err != nil {
+		return err
+	}
+	return contents.ForEach(js.Undefined(), func(cursor *indexeddb.Cursor) error {
+		key := cursor.Key()
+		data, err := blob.NewFromJS(cursor.Value())
 		if err != nil {
 			return err
 		}
-		infos, err := db.CreateObjectStore(idbFileInfoStore, indexeddb.ObjectStoreOptions{})
+		if _, err := cursor.Delete(); err != nil {
+			return err
+		}
+		infoReq, err := infos.Get(key)
 		if err != nil {
 			return err
 		}
-		_, err = infos.CreateIndex(idbParentKey, js.ValueOf(idbParentKey), indexeddb.IndexOptions{})
-		return err
+		infoReq.ListenSuccess(func() {
+			info, err := infoReq.Result()
+			if err != nil {
+				panic(err)
+			}
+			if info.IsUndefined() || os.FileMode(info.Get("Mode").Int()).IsDir() {
+				return
+			}
+			err = forEachChunk(data, func(index int64, chunk blob.Blob) error {
+				_, err := chunks.Put(chunkKey(key.String(), index), chunk.JSValue())
+				return err
+			})
+			if err != nil {
+				panic(err)
+			}
+			info.Set(idbChunkSizeKey, idbChunkSize)
+			if _, err := infos.Put(key, info); err != nil {
+				panic(err)
+			}
+		})
+		return nil
 	})
-	if err != nil {
-		return nil, err
-	}
-	fs := storer.New(newIndexedDBStorer(db, shouldCache))
-	return &IndexedDBFs{
-		Fs: fs,
-		db: db,
-	}, nil
 }
 
 func (i *IndexedDBFs) Clear() error {
-	stores := []string{idbFileContentsStore, idbFileInfoStore}
-	txn, err := i.db.Transaction(indexeddb.TransactionReadWrite, stores...)
+	for _, s := range i.storers {
+		if err := clearIndexedDB(s.db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearIndexedDB(db *indexeddb.DB) error {
+	stores := []string{idbFileContentsStore, idbFileChunksStore, idbFileInfoStore}
+	txn, err := db.Transaction(indexeddb.TransactionReadWrite, stores...)
 	if err != nil {
 		return err
 	}
@@ -101,7 +234,7 @@ type indexedDBStorer struct {
 	shouldCache ShouldCacher
 }
 
-func newIndexedDBStorer(db *indexeddb.DB, shouldCache ShouldCacher) storer.Storer {
+func newIndexedDBStorer(db *indexeddb.DB, shouldCache ShouldCacher) *indexedDBStorer {
 	setQueue := queue.New(maxSetQueue)
 	setQueue.StartAsync(context.Background(), setQueueInterval, db)
 	return &indexedDBStorer{
@@ -154,6 +287,7 @@ func (i *indexedDBStorer) extractFileRecord(path string, value js.Value, err err
 	dest.InitialSize = int64(i.jsProperties.GetProperty(value, "Size").Int())
 	dest.ModTime = time.Unix(int64(i.jsProperties.GetProperty(value, "ModTime").Int()), 0)
 	dest.Mode = i.getMode(value)
+	i.extractAttributes(value, dest)
 	if dest.Mode.IsDir() {
 		log.Debug("Setting directory data fetchers for path ", path)
 		dest.DataFn = func() (blob.Blob, error) {
@@ -162,7 +296,11 @@ func (i *indexedDBStorer) extractFileRecord(path string, value js.Value, err err
 		dest.DirNamesFn = i.getDirNames(path)
 	} else {
 		log.Debug("Setting file data fetchers for path ", path)
-		dest.DataFn = i.getFileData(path)
+		chunkSize := int64(idbChunkSize) // legacy files without stored contents were never chunked
+		if size := i.jsProperties.GetProperty(value, idbChunkSizeKey); size.Truthy() {
+			chunkSize = int64(size.Int())
+		}
+		dest.DataFn = i.getFileChunks(path, dest.InitialSize, chunkSize)
 		dest.DirNamesFn = func() ([]string, error) {
 			return nil, nil
 		}
@@ -171,33 +309,58 @@ func (i *indexedDBStorer) extractFileRecord(path string, value js.Value, err err
 	return nil
 }
 
-func (i *indexedDBStorer) getFileData(path string) func() (blob.Blob, error) {
+// fileChunks is a file's chunked contents. Tracks the source path to only write changed chunks back to the same path.
+type fileChunks struct {
+	*blob.Chunked
+	path string
+}
+
+func (i *indexedDBStorer) getFileChunks(path string, size, chunkSize int64) func() (blob.Blob, error) {
 	path = fsutil.NormalizePath(path)
 	return func() (blob.Blob, error) {
-		txn, err := i.db.Transaction(indexeddb.TransactionReadOnly, idbFileContentsStore)
+		return &fileChunks{
+			Chunked: blob.NewChunked(size, chunkSize, i.getFileChunk(path)),
+			path:    path,
+		}, nil
+	}
+}
+
+func (i *indexedDBStorer) getFileChunk(path string) blob.ChunkFetcher {
+	return func(index int64) (_ blob.Blob, err error) {
+		defer common.CatchException(&err)
+		txn, err := i.db.Transaction(indexeddb.TransactionReadOnly, idbFileChunksStore)
 		if err != nil {
 			return nil, err
 		}
-		files, err := txn.ObjectStore(idbFileContentsStore)
+		chunks, err := txn.ObjectStore(idbFileChunksStore)
 		if err != nil {
 			return nil, err
 		}
-		log.Debug("Loading file contents from JS: ", path)
-		req, err := files.Get(i.jsPaths.Value(path))
+		log.Debug("Loading file chunk from JS: ", path, " ", index)
+		req, err := chunks.Get(chunkKey(path, index))
 		if err != nil {
 			return nil, err
 		}
 		value, err := req.Await()
-		if value.IsUndefined() {
-			return nil, os.ErrNotExist
-		}
 		if err != nil {
 			return nil, err
 		}
+		if value.IsUndefined() {
+			return nil, nil
+		}
 		return blob.NewFromJS(value)
 	}
 }
 
+func chunkKey(path string, index int64) js.Value {
+	return js.ValueOf([]interface{}{path, index})
+}
+
+// chunkKeysFrom returns a key range of all chunks for 'path', starting at chunk 'index'
+func chunkKeysFrom(path string, index int64) js.Value {
+	return jsIDBKeyRange.Call("bound", chunkKey(path, index), chunkKey(path, math.MaxInt32))
+}
+
 func (i *indexedDBStorer) getDirNames(path string) func() ([]string, error) {
 	path = fsutil.NormalizePath(path)
 	return func() (_ []string, err error) {
@@ -232,6 +395,38 @@ func (i *indexedDBStorer) getDirNames(path string) func() ([]string, error) {
 	}
 }
 
+// extractAttributes sets dest's owner, access and change times, and extended attributes. Records saved before these were added only have zero values.
+func (i *indexedDBStorer) extractAttributes(value js.Value, dest *storer.FileRecord) {
+	intProperty := func(key string) int {
+		prop := i.jsProperties.GetProperty(value, key)
+		if prop.Type() != js.TypeNumber {
+			return 0
+		}
+		return prop.Int()
+	}
+	dest.Uid = intProperty("Uid")
+	dest.Gid = intProperty("Gid")
+	dest.AccessTime = dest.ModTime
+	if accessTime := intProperty("AccessTime"); accessTime != 0 {
+		dest.AccessTime = time.Unix(int64(accessTime), 0)
+	}
+	dest.ChangeTime = dest.ModTime
+	if changeTime := intProperty("ChangeTime"); changeTime != 0 {
+		dest.ChangeTime = time.Unix(int64(changeTime), 0)
+	}
+	dest.Xattrs = nil
+	if xattrs := i.jsProperties.GetProperty(value, "Xattrs"); xattrs.Type() == js.TypeObject {
+		dest.Xattrs = make(map[string][]byte)
+		for key, jsValue := range interop.Entries(xattrs) {
+			b, err := blob.NewFromJS(jsValue)
+			if err != nil {
+				continue
+			}
+			dest.Xattrs[key] = b.Bytes()
+		}
+	}
+}
+
 func (i *indexedDBStorer) getMode(fileRecord js.Value) os.FileMode {
 	mode := i.jsProperties.GetProperty(fileRecord, "Mode")
 	return os.FileMode(mode.Int())
@@ -316,7 +511,7 @@ func (i *indexedDBStorer) setFile(path string, data *storer.FileRecord) error {
 	q := queue.New(maxQueue)
 	_ = i.queueSetFile(q, path, data)
 	_, err := q.Do(i.db)
-	if err != nil {
+	if err != nil && err != syscall.ENOSPC {
 		// TODO Verify if AbortError type. If it isn't, then don't replace with syscall.ENOTDIR.
 		// Should be the only reason for an abort. Later use an error handling mechanism in indexeddb pkg.
 		log.Error("Aborted set file: ", err)
@@ -329,21 +524,31 @@ func (i *indexedDBStorer) queueSetFile(q *queue.Queue, path string, data *storer
 	i.infoCache.Delete(path)
 	if data == nil {
 		q.Push(indexeddb.TransactionReadWrite, []string{idbFileInfoStore}, indexeddb.DeleteOp(idbFileInfoStore, i.jsPaths.Value(path)))
-		_, err := q.Push(indexeddb.TransactionReadWrite, []string{idbFileContentsStore}, indexeddb.DeleteOp(idbFileContentsStore, i.jsPaths.Value(path)))
+		_, err := q.Push(indexeddb.TransactionReadWrite, []string{idbFileChunksStore}, indexeddb.DeleteOp(idbFileChunksStore, chunkKeysFrom(path, 0)))
 		return err
 	}
 
-	if !data.Mode.IsDir() {
-		// this is a file, so include file contents
-		q.Push(indexeddb.TransactionReadWrite, []string{idbFileContentsStore}, indexeddb.PutOp(
-			idbFileContentsStore,
-			i.jsPaths.Value(path), data.Data().JSValue(),
-		))
-	}
 	fileInfo := map[string]interface{}{
 		"ModTime": data.ModTime.Unix(),
 		"Mode":    uint32(data.Mode),
 		"Size":    data.Size(),
+
+		"Uid":        data.Uid,
+		"Gid":        data.Gid,
+		"AccessTime": data.AccessTime.Unix(),
+		"ChangeTime": data.ChangeTime.Unix(),
+	}
+	if len(data.Xattrs) > 0 {
+		xattrs := make(map[string]interface{}, len(data.Xattrs))
+		for key, value := range data.Xattrs {
+			xattrs[key] = blob.NewFromBytes(value).JSValue()
+		}
+		fileInfo["Xattrs"] = xattrs
+	}
+	if !data.Mode.IsDir() {
+		// this is a file, so include file contents
+		i.queueSetFileChunks(q, path, data.Data())
+		fileInfo[idbChunkSizeKey] = idbChunkSize
 	}
 	if path != afero.FilePathSeparator {
 		fileInfo[idbParentKey] = filepath.Dir(path)
@@ -363,6 +568,64 @@ func (i *indexedDBStorer) queueSetFile(q *queue.Queue, path string, data *storer
 	return err
 }
 
+// queueSetFileChunks writes the changed chunks of 'data', or all chunks if 'data' came from somewhere else
+func (i *indexedDBStorer) queueSetFileChunks(q *queue.Queue, path string, data blob.Blob) {
+	var chunkErrs []<-chan error
+	pushChunk := func(index int64, chunk blob.Blob) error {
+		_, err := q.Push(indexeddb.TransactionReadWrite, []string{idbFileChunksStore}, indexeddb.PutOp(
+			idbFileChunksStore,
+			chunkKey(path, index), chunk.JSValue(),
+		))
+		chunkErrs = append(chunkErrs, err)
+		return nil
+	}
+
+	size := int64(data.Len())
+	chunkCount := (size + idbChunkSize - 1) / idbChunkSize
+	if chunks, ok := data.(*fileChunks); ok && chunks.path == path && chunks.ChunkSize() == idbChunkSize {
+		dirty, marker := chunks.DirtyChunks()
+		for _, index := range dirty {
+			chunk, err := chunks.Chunk(index)
+			if err != nil {
+				panic(err) // chunk fetchers should never fail. IDB chunks will only fail if types are wrong
+			}
+			_ = pushChunk(index, chunk)
+		}
+		go func() {
+			// only mark chunks as saved once they're written, so failed writes are retried on the next save
+			for _, err := range chunkErrs {
+				if <-err != nil {
+					return
+				}
+			}
+			chunks.ClearDirty(marker)
+		}()
+	} else if err := forEachChunk(data, pushChunk); err != nil {
+		panic(err) // views inside a blob's length should never fail
+	}
+	// remove chunks past the end of the file
+	q.Push(indexeddb.TransactionReadWrite, []string{idbFileChunksStore}, indexeddb.DeleteOp(idbFileChunksStore, chunkKeysFrom(path, chunkCount)))
+}
+
+// forEachChunk calls 'fn' with each idbChunkSize view of 'data'
+func forEachChunk(data blob.Blob, fn func(index int64, chunk blob.Blob) error) error {
+	size := int64(data.Len())
+	for index := int64(0); index*idbChunkSize < size; index++ {
+		end := (index + 1) * idbChunkSize
+		if end > size {
+			end = size
+		}
+		chunk, err := data.View(index*idbChunkSize, end)
+		if err != nil {
+			return err
+		}
+		if err := fn(index, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (i *indexedDBStorer) batchRequireDir(path string) func(*indexeddb.Transaction) (*indexeddb.Request, error) {
 	return func(txn *indexeddb.Transaction) (_ *indexeddb.Request, err error) {
 		o, err := txn.ObjectStore(idbFileInfoStore)
@@ -14,34 +14,67 @@ import (
 	"github.com/johnstarich/go-wasm/log"
 )
 
-const (
-	localStorageKeyPrefix = "storer$1$"
-)
-
 // TODO consider adding "locks": https://balpha.de/2012/03/javascript-concurrency-and-locking-the-html5-localstorage/
 
 type LocalStorageFs struct {
 	*storer.Fs
 }
 
-func NewJSStorage(s js.Value) *LocalStorageFs {
-	fs := storer.New(&localStorer{
+// NewJSStorage returns a file system stored in 's', like window.localStorage, upgrading older formats. Fails if 's' was written in a newer format.
+func NewJSStorage(s js.Value) (*LocalStorageFs, error) {
+	l := &localStorer{
+		storage:    s,
 		getItem:    s.Get("getItem"),
 		setItem:    s.Get("setItem"),
 		removeItem: s.Get("removeItem"),
-	})
-	return &LocalStorageFs{fs}
+	}
+	if err := migrateLocalStorage(l); err != nil {
+		return nil, err
+	}
+	return &LocalStorageFs{storer.New(l)}, nil
 }
 
 type localStorer struct {
+	storage                      js.Value
 	getItem, setItem, removeItem js.Value
 }
 
+var _ itemStorage = &localStorer{}
+
+func (l *localStorer) GetItem(key string) (string, bool) {
+	value := l.getItem.Call("call", l.storage, key)
+	if value.IsNull() {
+		return "", false
+	}
+	return value.String(), true
+}
+
+func (l *localStorer) SetItem(key, value string) {
+	l.setItem.Call("call", l.storage, key, value)
+}
+
+func (l *localStorer) Keys() []string {
+	length := l.storage.Get("length").Int()
+	keys := make([]string, 0, length)
+	for i := 0; i < length; i++ {
+		if key := l.storage.Call("key", i); !key.IsNull() {
+			keys = append(keys, key.String())
+		}
+	}
+	return keys
+}
+
 type jsonFileRecord struct {
 	Data     []byte
 	DirNames []string
 	ModTime  time.Time
 	Mode     os.FileMode
+
+	Uid        int               `json:",omitempty"`
+	Gid        int               `json:",omitempty"`
+	AccessTime time.Time         `json:",omitempty"`
+	ChangeTime time.Time         `json:",omitempty"`
+	Xattrs     map[string][]byte `json:",omitempty"`
 }
 
 func (l *localStorer) GetFileRecord(path string, dest *storer.FileRecord) error {
@@ -65,6 +98,11 @@ func (l *localStorer) GetFileRecord(path string, dest *storer.FileRecord) error
 	}
 	dest.ModTime = jDest.ModTime
 	dest.Mode = jDest.Mode
+	dest.Uid = jDest.Uid
+	dest.Gid = jDest.Gid
+	dest.AccessTime = jDest.AccessTime
+	dest.ChangeTime = jDest.ChangeTime
+	dest.Xattrs = jDest.Xattrs
 	return err
 }
 
@@ -80,6 +118,12 @@ func (l *localStorer) SetFileRecord(path string, data *storer.FileRecord) error
 		DirNames: data.DirNames(),
 		ModTime:  data.ModTime,
 		Mode:     data.Mode,
+
+		Uid:        data.Uid,
+		Gid:        data.Gid,
+		AccessTime: data.AccessTime,
+		ChangeTime: data.ChangeTime,
+		Xattrs:     data.Xattrs,
 	}
 	buf, err := json.Marshal(jFileRecord)
 	if err == nil {
@@ -0,0 +1,91 @@
+package fs
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/johnstarich/go-wasm/internal/migrate"
+	"github.com/pkg/errors"
+)
+
+const (
+	localStorageKeyPrefix  = "storer$1$"
+	localStorageVersionKey = "storer$version"
+)
+
+// itemStorage is a string key-value store, like the Web Storage API
+type itemStorage interface {
+	GetItem(key string) (string, bool)
+	SetItem(key, value string)
+	Keys() []string
+}
+
+// localStorageMigrations returns the upgrade steps for 's'. Data written before versions were recorded is version 0.
+// Append a step to change the format, never edit existing steps.
+func localStorageMigrations(s itemStorage) []migrate.Step {
+	return []migrate.Step{
+		{
+			Version:     1,
+			Description: "fill in access and change times from modification times",
+			Upgrade: func() error {
+				return updateLocalStorageRecords(s, func(record map[string]json.RawMessage) bool {
+					changed := false
+					for _, key := range []string{"AccessTime", "ChangeTime"} {
+						if _, ok := record[key]; !ok {
+							record[key] = record["ModTime"]
+							changed = true
+						}
+					}
+					return changed
+				})
+			},
+		},
+	}
+}
+
+// updateLocalStorageRecords calls 'update' with each file record in 's', saving the record if it returns true.
+// Records which don't parse are skipped, leaving them for reads to report.
+func updateLocalStorageRecords(s itemStorage, update func(record map[string]json.RawMessage) bool) error {
+	for _, key := range s.Keys() {
+		if !strings.HasPrefix(key, localStorageKeyPrefix) {
+			continue
+		}
+		value, ok := s.GetItem(key)
+		if !ok {
+			continue
+		}
+		var record map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(value), &record); err != nil || record == nil {
+			continue
+		}
+		if !update(record) {
+			continue
+		}
+		buf, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		s.SetItem(key, string(buf))
+	}
+	return nil
+}
+
+// migrateLocalStorage upgrades 's' to the latest format and records the new version.
+// Returns an error if 's' was written in a newer format.
+func migrateLocalStorage(s itemStorage) error {
+	version := 0
+	versionStr, versioned := s.GetItem(localStorageVersionKey)
+	if versioned {
+		var err error
+		version, err = strconv.Atoi(versionStr)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid storage version %q", versionStr)
+		}
+	}
+	newVersion, err := migrate.Run(localStorageMigrations(s), version)
+	if newVersion != version || !versioned {
+		s.SetItem(localStorageVersionKey, strconv.Itoa(newVersion))
+	}
+	return err
+}
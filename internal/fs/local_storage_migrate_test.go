@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/johnstarich/go-wasm/internal/migrate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mapItemStorage map[string]string
+
+func (m mapItemStorage) GetItem(key string) (string, bool) {
+	value, ok := m[key]
+	return value, ok
+}
+
+func (m mapItemStorage) SetItem(key, value string) {
+	m[key] = value
+}
+
+func (m mapItemStorage) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestLocalStorageMigrations(t *testing.T) {
+	assert.NoError(t, migrate.Validate(localStorageMigrations(mapItemStorage{})))
+}
+
+func TestMigrateLocalStorage(t *testing.T) {
+	const (
+		legacyRecord   = `{"Data":null,"DirNames":["foo"],"ModTime":"2020-01-01T00:00:00Z","Mode":2147484141}`
+		upgradedRecord = `{"AccessTime":"2020-01-01T00:00:00Z","ChangeTime":"2020-01-01T00:00:00Z","Data":null,"DirNames":["foo"],"ModTime":"2020-01-01T00:00:00Z","Mode":2147484141}`
+		currentRecord  = `{"Data":null,"DirNames":null,"ModTime":"2020-01-01T00:00:00Z","Mode":420,"AccessTime":"2021-01-01T00:00:00Z","ChangeTime":"2022-01-01T00:00:00Z"}`
+	)
+
+	for _, tc := range []struct {
+		description string
+		items       mapItemStorage
+		expectErr   string
+		expectItems mapItemStorage
+	}{
+		{
+			description: "empty storage",
+			items:       mapItemStorage{},
+			expectItems: mapItemStorage{localStorageVersionKey: "1"},
+		},
+		{
+			description: "unversioned legacy data",
+			items: mapItemStorage{
+				localStorageKeyPrefix + "/":    legacyRecord,
+				localStorageKeyPrefix + "/foo": currentRecord,
+				localStorageKeyPrefix + "/bad": "not json",
+				"other":                        legacyRecord,
+			},
+			expectItems: mapItemStorage{
+				localStorageKeyPrefix + "/":    upgradedRecord,
+				localStorageKeyPrefix + "/foo": currentRecord,
+				localStorageKeyPrefix + "/bad": "not json",
+				"other":                        legacyRecord,
+				localStorageVersionKey:         "1",
+			},
+		},
+		{
+			description: "latest version",
+			items: mapItemStorage{
+				localStorageKeyPrefix + "/": legacyRecord,
+				localStorageVersionKey:      "1",
+			},
+			expectItems: mapItemStorage{
+				localStorageKeyPrefix + "/": legacyRecord,
+				localStorageVersionKey:      "1",
+			},
+		},
+		{
+			description: "newer version",
+			items:       mapItemStorage{localStorageVersionKey: "2"},
+			expectErr:   "version 2, latest is 1: migrate: stored data is newer than this version supports",
+			expectItems: mapItemStorage{localStorageVersionKey: "2"},
+		},
+		{
+			description: "invalid version",
+			items:       mapItemStorage{localStorageVersionKey: "foo"},
+			expectErr:   `Invalid storage version "foo": strconv.Atoi: parsing "foo": invalid syntax`,
+			expectItems: mapItemStorage{localStorageVersionKey: "foo"},
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			require.Equal(t, 1, migrate.Latest(localStorageMigrations(tc.items)), "Update test cases for new versions")
+			err := migrateLocalStorage(tc.items)
+			if tc.expectErr != "" {
+				assert.EqualError(t, err, tc.expectErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectItems, tc.items)
+		})
+	}
+}
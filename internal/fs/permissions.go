@@ -0,0 +1,194 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/storer"
+	"github.com/spf13/afero"
+)
+
+// Access bits for permission checks, matching the X_OK, W_OK, and R_OK bits used by access(2)
+const (
+	AccessExecute os.FileMode = 1 << iota
+	AccessWrite
+	AccessRead
+)
+
+const defaultUmask = 0022
+
+var (
+	ErrPermission = interop.NewError("permission denied", "EACCES")
+
+	uncheckedMounts sync.Map // mount paths with permission checks disabled
+)
+
+// SetCheckPermissions enables or disables permission checks for files in the mount at 'mountPath'.
+// Checks are enabled by default. Disabling them skips a few stats per file operation, which helps performance on large trees like the Go toolchain.
+func SetCheckPermissions(mountPath string, enabled bool) {
+	mountPath = filesystem.MountPath(mountPath)
+	if enabled {
+		uncheckedMounts.Delete(mountPath)
+	} else {
+		uncheckedMounts.Store(mountPath, true)
+	}
+}
+
+// resetMountOptions re-enables permission checks for the mount at 'mountPath', so later mounts there start with the defaults
+func resetMountOptions(mountPath string) {
+	uncheckedMounts.Delete(fsutil.NormalizePath(mountPath))
+}
+
+func checksPermissions(path string) bool {
+	_, unchecked := uncheckedMounts.Load(filesystem.MountPath(path))
+	return !unchecked
+}
+
+// hasAccess returns true if 'uid' and 'gid' are granted all 'access' bits on 'info'.
+// There is no superuser, since all processes run as the same user. Files without ownership information are treated as owned by the caller.
+func hasAccess(info os.FileInfo, uid, gid int, access os.FileMode) bool {
+	perm := info.Mode().Perm()
+	ownerUID, ownerGID := uid, gid
+	if stat, ok := info.Sys().(*storer.Stat); ok {
+		ownerUID, ownerGID = stat.Uid, stat.Gid
+	}
+	switch {
+	case ownerUID == uid:
+		perm >>= 6
+	case ownerGID == gid:
+		perm >>= 3
+	}
+	return perm&access == access
+}
+
+// Access returns an error if the process can't access 'path' with all 'access' bits, including execute access on every parent directory
+func (f *FileDescriptors) Access(path string, access os.FileMode) error {
+	path = f.resolvePath(path)
+	if !checksPermissions(path) {
+		_, err := filesystem.Stat(path)
+		return err
+	}
+	if err := f.checkTraverse("access", path); err != nil {
+		return err
+	}
+	return f.checkAccess("access", path, access)
+}
+
+// checkAccess returns an error if 'path' can't be accessed with all 'access' bits. Does not check parent directories.
+func (f *FileDescriptors) checkAccess(op, path string, access os.FileMode) error {
+	if !checksPermissions(path) {
+		return nil
+	}
+	info, err := filesystem.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !hasAccess(info, f.uid, f.gid, access) {
+		return &os.PathError{Op: op, Path: path, Err: ErrPermission}
+	}
+	return nil
+}
+
+// checkTraverse returns an error if any parent directory of 'path' can't be searched
+func (f *FileDescriptors) checkTraverse(op, path string) error {
+	if path == afero.FilePathSeparator {
+		return nil
+	}
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == afero.FilePathSeparator {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- { // check from the root down
+		err := f.checkAccess(op, dirs[i], AccessExecute)
+		if os.IsNotExist(err) {
+			return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkModifyDir returns an error if entries in 'path's parent directory can't be created or removed
+func (f *FileDescriptors) checkModifyDir(op, path string) error {
+	if err := f.checkTraverse(op, path); err != nil {
+		return err
+	}
+	return f.checkAccess(op, filepath.Dir(path), AccessWrite|AccessExecute)
+}
+
+// checkOpen returns an error if 'path' can't be opened with 'flags'. Returns true if 'path' will be created.
+func (f *FileDescriptors) checkOpen(path string, flags int) (create bool, err error) {
+	if isDeviceFile(path) || !checksPermissions(path) {
+		return false, nil
+	}
+	if err := f.checkTraverse("open", path); err != nil {
+		return false, err
+	}
+
+	var access os.FileMode
+	switch {
+	case flags&syscall.O_RDWR != 0:
+		access = AccessRead | AccessWrite
+	case flags&syscall.O_WRONLY != 0:
+		access = AccessWrite
+	default:
+		access = AccessRead
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		access |= AccessWrite
+	}
+	err = f.checkAccess("open", path, access)
+	if os.IsNotExist(err) && flags&syscall.O_CREAT != 0 {
+		return true, f.checkAccess("open", filepath.Dir(path), AccessWrite|AccessExecute)
+	}
+	if os.IsNotExist(err) {
+		return false, nil // let the file system return a consistent error
+	}
+	return false, err
+}
+
+// applyCreds sets the owner of a newly created 'path' to the process's user and group
+func (f *FileDescriptors) applyCreds(path string) error {
+	if f.uid == 0 && f.gid == 0 {
+		// new files are already owned by uid and gid 0, skip an extra save
+		return nil
+	}
+	return filesystem.Chown(path, f.uid, f.gid)
+}
+
+// Umask sets the process's file mode creation mask and returns the previous mask
+func (f *FileDescriptors) Umask(mask os.FileMode) os.FileMode {
+	return os.FileMode(f.umask.Swap(uint32(mask & os.ModePerm)))
+}
+
+// Uid returns the process's user ID
+func (f *FileDescriptors) Uid() int {
+	return f.uid
+}
+
+// Gid returns the process's group ID
+func (f *FileDescriptors) Gid() int {
+	return f.gid
+}
+
+func (f *FileDescriptors) applyUmask(mode os.FileMode) os.FileMode {
+	return mode &^ os.FileMode(f.umask.Load())
+}
+
+func isDeviceFile(path string) bool {
+	switch path {
+	case "/dev/null", "/dev/stdin", "/dev/stdout", "/dev/stderr":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,81 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/johnstarich/go-wasm/internal/mountfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFileDescriptors(t *testing.T) *FileDescriptors {
+	t.Helper()
+	oldFilesystem := filesystem
+	filesystem = mountfs.New(afero.NewMemMapFs())
+	t.Cleanup(func() {
+		filesystem = oldFilesystem
+	})
+	f := &FileDescriptors{
+		files:            make(map[FID]*fileDescriptor),
+		workingDirectory: newWorkingDirectory("/"),
+	}
+	f.umask.Store(defaultUmask)
+	return f
+}
+
+func TestPermissions(t *testing.T) {
+	f := newTestFileDescriptors(t)
+	require.NoError(t, f.Mkdir("/dir", 0777))
+	info, err := f.Stat("/dir")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0755, info.Mode(), "Umask should apply to new directories")
+
+	fid, err := f.Open("/dir/readonly", syscall.O_WRONLY|syscall.O_CREAT, 0444)
+	require.NoError(t, err)
+	require.NoError(t, f.Close(fid))
+
+	_, err = f.Open("/dir/readonly", syscall.O_WRONLY, 0)
+	assert.Equal(t, &os.PathError{Op: "open", Path: "/dir/readonly", Err: ErrPermission}, err)
+	fid, err = f.Open("/dir/readonly", syscall.O_RDONLY, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close(fid))
+	assert.Equal(t, ErrPermission, errorCause(f.Access("/dir/readonly", AccessExecute)))
+
+	require.NoError(t, f.Chmod("/dir", 0600))
+	_, err = f.Open("/dir/readonly", syscall.O_RDONLY, 0)
+	assert.Equal(t, &os.PathError{Op: "open", Path: "/dir", Err: ErrPermission}, err, "Directories without execute permission can't be traversed")
+	_, err = f.ReadDir("/dir")
+	assert.NoError(t, err)
+	require.NoError(t, f.Chmod("/dir", 0500))
+	_, err = f.Open("/dir/new", syscall.O_WRONLY|syscall.O_CREAT, 0644)
+	assert.Equal(t, &os.PathError{Op: "open", Path: "/dir", Err: ErrPermission}, err, "Directories without write permission can't have new files")
+	assert.Equal(t, &os.PathError{Op: "unlink", Path: "/dir", Err: ErrPermission}, f.Unlink("/dir/readonly"))
+
+	SetCheckPermissions("/", false)
+	defer SetCheckPermissions("/", true)
+	fid, err = f.Open("/dir/readonly", syscall.O_WRONLY, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close(fid))
+}
+
+func TestUnmountResetsPermissionChecks(t *testing.T) {
+	f := newTestFileDescriptors(t)
+	require.NoError(t, f.Mkdir("/x", 0755))
+	require.NoError(t, filesystem.Mount("/x", afero.NewMemMapFs()))
+	SetCheckPermissions("/x", false)
+	assert.False(t, checksPermissions("/x/file"))
+
+	require.NoError(t, Unmount("/x"))
+	require.NoError(t, filesystem.Mount("/x", afero.NewMemMapFs()))
+	assert.True(t, checksPermissions("/x/file"), "New mounts at an unmounted path should check permissions")
+}
+
+func errorCause(err error) error {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err
+	}
+	return err
+}
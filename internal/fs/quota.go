@@ -0,0 +1,52 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/johnstarich/go-wasm/internal/quota"
+	"github.com/johnstarich/go-wasm/log"
+)
+
+// SetQuota limits the bytes and inodes used by the mount at 'mountPath'. Limits <= 0 are unlimited.
+// Writes exceeding a limit fail with ENOSPC.
+func SetQuota(mountPath string, maxBytes, maxInodes int64) error {
+	limiter, err := mountLimiter(mountPath)
+	if err != nil {
+		return err
+	}
+	return limiter.SetLimits(maxBytes, maxInodes)
+}
+
+func mountLimiter(mountPath string) (quota.Limiter, error) {
+	fs := filesystem.Mounted(mountPath)
+	if fs == nil {
+		return nil, &os.PathError{Op: "quota", Path: mountPath, Err: os.ErrNotExist}
+	}
+	limiter, ok := fs.(quota.Limiter)
+	if !ok {
+		return nil, &os.PathError{Op: "quota", Path: mountPath, Err: syscall.ENOTSUP}
+	}
+	return limiter, nil
+}
+
+// MountUsage returns the usage and limits of each mount which tracks usage, keyed by mount path
+func MountUsage() map[string]quota.Usage {
+	usages := make(map[string]quota.Usage)
+	for mountPath := range filesystem.Mounts() {
+		limiter, err := mountLimiter(mountPath)
+		if err != nil {
+			continue
+		}
+		usage, err := limiter.Usage()
+		if err == syscall.ENOTSUP {
+			continue
+		}
+		if err != nil {
+			log.Warnf("Failed to get usage for mount %q: %v", mountPath, err)
+			continue
+		}
+		usages[mountPath] = usage
+	}
+	return usages
+}
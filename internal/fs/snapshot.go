@@ -0,0 +1,427 @@
+package fs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/storer"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+const restoreStagingPrefix = ".restore-"
+
+// Snapshot writes a gzipped tar archive of 'path' to 'w', including modes, owners, times, and empty directories.
+// Snapshotting "/" captures every mount. Entries are named relative to 'path', so the archive can be restored anywhere.
+func Snapshot(w io.Writer, path string) error {
+	path = fsutil.NormalizePath(path)
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err := afero.Walk(filesystem, path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if isRestoreStaging(filePath) {
+			return filepath.SkipDir
+		}
+		return writeSnapshotEntry(tarWriter, path, filePath, info)
+	})
+	if err != nil {
+		return err
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func writeSnapshotEntry(w *tar.Writer, basePath, path string, info os.FileInfo) error {
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return nil // only files and directories can be restored
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Format = tar.FormatPAX
+	header.Name, err = filepath.Rel(basePath, path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if stat, ok := info.Sys().(*storer.Stat); ok {
+		header.Uid, header.Gid = stat.Uid, stat.Gid
+		header.AccessTime, header.ChangeTime = stat.Atime, stat.Ctime
+	}
+	if err := w.WriteHeader(header); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	f, err := filesystem.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Restore replaces 'path' with the contents of a Snapshot archive read from 'r'.
+// The archive is fully extracted to a staging directory before 'path' is changed, so a corrupt or truncated archive leaves 'path' untouched.
+// Replaced entries are moved aside until the restore succeeds, and moved back if it fails partway through.
+// Mount points inside 'path' are preserved, and their contents are replaced.
+func Restore(r io.Reader, path string) error {
+	path = fsutil.NormalizePath(path)
+	staging := restoreStagingPath(path)
+	if err := extractSnapshot(r, staging); err != nil {
+		_ = fsutil.RemoveAll(filesystem, staging)
+		return errors.Wrap(err, "Failed to read snapshot")
+	}
+	swap := &treeSwap{}
+	err := swap.replaceTree(staging, path)
+	if err == nil {
+		err = swap.commit()
+	} else if rollbackErr := swap.rollback(); rollbackErr != nil {
+		err = errors.Wrapf(rollbackErr, "Failed to undo restore after error: %v", err)
+	}
+	if removeErr := fsutil.RemoveAll(filesystem, staging); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// restoreStagingPath returns a new path on the same mount as 'path' for extracting a snapshot
+func restoreStagingPath(path string) string {
+	name := fmt.Sprintf("%s%d", restoreStagingPrefix, time.Now().UnixNano())
+	if filesystem.MountPath(path) == path {
+		// a mount's root can't be renamed, so stage inside it instead
+		return filepath.Join(path, name)
+	}
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+name)
+}
+
+func isRestoreStaging(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".") && strings.Contains(name, restoreStagingPrefix)
+}
+
+func extractSnapshot(r io.Reader, dest string) error {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	if err := extractTar(tar.NewReader(gzipReader), dest, true); err != nil {
+		return err
+	}
+	return gzipReader.Close() // verify checksum
+}
+
+// extractTar writes all files and directories from 'r' into 'dest', including modes and times. Other entry types are skipped.
+// Owners are only restored if 'keepOwners' is true, otherwise new files are owned by the current user.
+func extractTar(r *tar.Reader, dest string, keepOwners bool) error {
+	if err := filesystem.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+	var dirs []*tar.Header
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		path := extractPath(dest, header.Name)
+		if !keepOwners {
+			header.Uid, header.Gid = -1, -1
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := filesystem.MkdirAll(path, 0700); err != nil {
+				return err
+			}
+			header.Name = path
+			dirs = append(dirs, header) // set attributes after children are written, so times aren't changed
+		case tar.TypeReg:
+			if err := extractFile(r, path); err != nil {
+				return err
+			}
+			if err := applyAttributes(path, header.FileInfo().Mode(), header.Uid, header.Gid, header.AccessTime, header.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		header := dirs[i]
+		if err := applyAttributes(header.Name, header.FileInfo().Mode(), header.Uid, header.Gid, header.AccessTime, header.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractPath returns the path for archive entry 'name' inside 'dest'
+func extractPath(dest, name string) string {
+	return filepath.Join(dest, filepath.Join(afero.FilePathSeparator, name)) // joining with "/" first prevents escaping 'dest'
+}
+
+func extractFile(r io.Reader, path string) error {
+	if err := filesystem.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := filesystem.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// applyAttributes sets the mode, owner, and times of 'path'. A uid or gid of -1 is left unchanged.
+func applyAttributes(path string, mode os.FileMode, uid, gid int, atime, mtime time.Time) error {
+	if err := filesystem.Chmod(path, mode); err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := filesystem.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	if atime.IsZero() {
+		atime = mtime
+	}
+	return filesystem.Chtimes(path, atime, mtime)
+}
+
+func applyInfoAttributes(path string, info os.FileInfo) error {
+	uid, gid := 0, 0
+	atime := info.ModTime()
+	if stat, ok := info.Sys().(*storer.Stat); ok {
+		uid, gid, atime = stat.Uid, stat.Gid, stat.Atime
+	}
+	return applyAttributes(path, info.Mode(), uid, gid, atime, info.ModTime())
+}
+
+// containsMount returns true if 'path' is or contains a mount point, which can't be removed or renamed
+func containsMount(path string) bool {
+	for mountPath := range filesystem.Mounts() {
+		if mountPath == path || strings.HasPrefix(mountPath, path+afero.FilePathSeparator) || path == afero.FilePathSeparator {
+			return true
+		}
+	}
+	return false
+}
+
+// treeSwap replaces a tree, keeping the replaced entries until the swap is committed or rolled back
+type treeSwap struct {
+	backups []string       // replaced entries, moved aside
+	undo    []func() error // reverts each change, in the order they were made
+}
+
+// replaceTree replaces 'dest' with 'src', moving entries where possible
+func (s *treeSwap) replaceTree(src, dest string) error {
+	srcInfo, err := filesystem.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !containsMount(dest) {
+		if _, err := filesystem.Stat(dest); err == nil {
+			if err := s.moveAside(dest); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		// added before moving, so a partial copy across mounts is removed too
+		s.undo = append(s.undo, func() error {
+			return fsutil.RemoveAll(filesystem, dest)
+		})
+		return moveTree(src, dest)
+	}
+	if !srcInfo.IsDir() {
+		return &os.PathError{Op: "restore", Path: dest, Err: syscall.EBUSY} // can't replace a mount point with a file
+	}
+
+	srcNames, err := readDirNames(src)
+	if err != nil {
+		return err
+	}
+	destNames, err := readDirNames(dest)
+	if err != nil {
+		return err
+	}
+	srcNameSet := make(map[string]bool, len(srcNames))
+	for _, name := range srcNames {
+		srcNameSet[name] = true
+	}
+	for _, name := range destNames {
+		destPath := filepath.Join(dest, name)
+		if !srcNameSet[name] && !isRestoreStaging(destPath) {
+			if err := s.clearTree(destPath); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range srcNames {
+		if err := s.replaceTree(filepath.Join(src, name), filepath.Join(dest, name)); err != nil {
+			return err
+		}
+	}
+	destInfo, err := filesystem.Stat(dest)
+	if err != nil {
+		return err
+	}
+	s.undo = append(s.undo, func() error {
+		return applyInfoAttributes(dest, destInfo)
+	})
+	return applyInfoAttributes(dest, srcInfo)
+}
+
+// clearTree moves 'path' and its contents aside, except for mount points
+func (s *treeSwap) clearTree(path string) error {
+	if !containsMount(path) {
+		return s.moveAside(path)
+	}
+	names, err := readDirNames(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := s.clearTree(filepath.Join(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveAside renames 'path' to a hidden sibling on the same mount, which is removed when the swap is committed
+func (s *treeSwap) moveAside(path string) error {
+	info, err := filesystem.Stat(path)
+	if err != nil {
+		return err
+	}
+	backup := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s%sbackup-%d", filepath.Base(path), restoreStagingPrefix, time.Now().UnixNano()))
+	if err := filesystem.Rename(path, backup); err != nil {
+		return err
+	}
+	s.backups = append(s.backups, backup)
+	s.undo = append(s.undo, func() error {
+		if err := filesystem.Rename(backup, path); err != nil {
+			return err
+		}
+		return applyInfoAttributes(path, info) // file renames may not keep times
+	})
+	return nil
+}
+
+// commit removes the replaced entries
+func (s *treeSwap) commit() error {
+	var firstErr error
+	for _, backup := range s.backups {
+		if err := fsutil.RemoveAll(filesystem, backup); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// rollback reverts all changes in reverse order, moving the replaced entries back. Continues past errors to restore as much as possible.
+func (s *treeSwap) rollback() error {
+	var firstErr error
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		if err := s.undo[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// moveTree renames 'src' to 'dest', or copies and removes 'src' if they're on different mounts
+func moveTree(src, dest string) error {
+	err := filesystem.Rename(src, dest)
+	if !isCrossDevice(err) {
+		return err
+	}
+	if err := copyTree(src, dest); err != nil {
+		return err
+	}
+	return fsutil.RemoveAll(filesystem, src)
+}
+
+func isCrossDevice(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	return err == syscall.EXDEV
+}
+
+func copyTree(src, dest string) error {
+	info, err := filesystem.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		if err := copyFile(src, dest); err != nil {
+			return err
+		}
+		return applyInfoAttributes(dest, info)
+	}
+	if err := filesystem.MkdirAll(dest, 0700); err != nil {
+		return err
+	}
+	names, err := readDirNames(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := copyTree(filepath.Join(src, name), filepath.Join(dest, name)); err != nil {
+			return err
+		}
+	}
+	return applyInfoAttributes(dest, info)
+}
+
+func copyFile(src, dest string) error {
+	r, err := filesystem.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := filesystem.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func readDirNames(path string) ([]string, error) {
+	dir, err := filesystem.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(-1)
+}
@@ -0,0 +1,97 @@
+package fs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/mountfs"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	oldFilesystem := filesystem
+	filesystem = mountfs.New(afero.NewMemMapFs())
+	t.Cleanup(func() {
+		filesystem = oldFilesystem
+	})
+	modTime := time.Unix(1000, 0)
+	require.NoError(t, filesystem.MkdirAll("/src/empty", 0750))
+	require.NoError(t, filesystem.MkdirAll("/src/mnt", 0755))
+	require.NoError(t, filesystem.Mount("/src/mnt", afero.NewMemMapFs()))
+	require.NoError(t, afero.WriteFile(filesystem, "/src/file", []byte("hello"), 0640))
+	require.NoError(t, afero.WriteFile(filesystem, "/src/mnt/mounted", []byte("world"), 0600))
+	require.NoError(t, filesystem.Chtimes("/src/file", modTime, modTime))
+	require.NoError(t, filesystem.Chtimes("/src/empty", modTime, modTime))
+
+	var snapshot bytes.Buffer
+	require.NoError(t, Snapshot(&snapshot, "/src"))
+
+	t.Run("new path", func(t *testing.T) {
+		require.NoError(t, Restore(bytes.NewReader(snapshot.Bytes()), "/dest"))
+		contents, err := afero.ReadFile(filesystem, "/dest/file")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(contents))
+		contents, err = afero.ReadFile(filesystem, "/dest/mnt/mounted")
+		require.NoError(t, err)
+		assert.Equal(t, "world", string(contents))
+
+		info, err := filesystem.Stat("/dest/file")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode())
+		assert.Equal(t, modTime, info.ModTime())
+		info, err = filesystem.Stat("/dest/empty")
+		require.NoError(t, err)
+		assert.Equal(t, os.ModeDir|0750, info.Mode())
+		assert.Equal(t, modTime, info.ModTime())
+	})
+
+	t.Run("replace with mounts", func(t *testing.T) {
+		require.NoError(t, afero.WriteFile(filesystem, "/src/extra", []byte("extra"), 0600))
+		require.NoError(t, afero.WriteFile(filesystem, "/src/mnt/extra", []byte("extra"), 0600))
+		require.NoError(t, filesystem.Remove("/src/file"))
+
+		require.NoError(t, Restore(bytes.NewReader(snapshot.Bytes()), "/src"))
+		names, err := readDirNames("/src")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"empty", "file", "mnt"}, names)
+		names, err = readDirNames("/src/mnt")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"mounted"}, names)
+		assert.Equal(t, "/src/mnt", filesystem.MountPath("/src/mnt/mounted"), "Mount should be preserved")
+	})
+
+	t.Run("corrupt snapshot", func(t *testing.T) {
+		truncated := snapshot.Bytes()[:snapshot.Len()/2]
+		assert.Error(t, Restore(bytes.NewReader(truncated), "/src"))
+		names, err := readDirNames("/")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"dest", "src"}, names, "Staging directory should be removed")
+		contents, err := afero.ReadFile(filesystem, "/src/file")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(contents))
+	})
+
+	t.Run("failed restore rolls back", func(t *testing.T) {
+		require.NoError(t, afero.WriteFile(filesystem, "/src/file", []byte("changed"), 0600))
+		require.NoError(t, afero.WriteFile(filesystem, "/src/extra", []byte("extra"), 0600))
+		mounted := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(mounted, "/extra", []byte("extra"), 0600))
+		require.NoError(t, filesystem.Unmount("/src/mnt"))
+		require.NoError(t, filesystem.Mount("/src/mnt", afero.NewReadOnlyFs(mounted)))
+
+		assert.Error(t, Restore(bytes.NewReader(snapshot.Bytes()), "/src"))
+		names, err := readDirNames("/src")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"empty", "extra", "file", "mnt"}, names, "Replaced entries should be moved back")
+		contents, err := afero.ReadFile(filesystem, "/src/file")
+		require.NoError(t, err)
+		assert.Equal(t, "changed", string(contents))
+		contents, err = afero.ReadFile(filesystem, "/src/extra")
+		require.NoError(t, err)
+		assert.Equal(t, "extra", string(contents))
+	})
+}
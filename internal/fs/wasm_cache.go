@@ -155,6 +155,17 @@ func (w *wasmCacheFs) Rename(oldname, newname string) error {
 	return w.rootFs.Rename(oldname, newname)
 }
 
+func (w *wasmCacheFs) Copy(oldname, newname string) error {
+	if err := w.dropModuleCache(fsutil.NormalizePath(newname)); err != nil {
+		return err
+	}
+	return w.rootFs.Copy(oldname, newname)
+}
+
+func (w *wasmCacheFs) Chown(name string, uid, gid int) error {
+	return w.rootFs.Chown(name, uid, gid)
+}
+
 func (w *wasmCacheFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return w.rootFs.Chtimes(name, atime, mtime)
 }
@@ -0,0 +1,214 @@
+package fstest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const concurrency = 16
+
+// RunConcurrent runs tests which use 'fs' from many goroutines at once. Run with the race detector enabled for best results.
+// 'cleanUp' is run after every subtest and once before the first test.
+func RunConcurrent(t *testing.T, fs afero.Fs, cleanUp CleanFunc) {
+	t.Helper()
+	undertest := NewTester(t, fs, cleanUp).(*fsTester).withName("undertest")
+	undertest.Clean()
+
+	for _, tc := range []struct {
+		name string
+		test func(*testing.T, FSTester)
+	}{
+		{"create and write files", TestConcurrentCreateWrite},
+		{"shared file handle", TestConcurrentSharedFile},
+		{"separate file handles", TestConcurrentSeparateHandles},
+		{"rename and readdir", TestConcurrentRenameReaddir},
+		{"create and remove", TestConcurrentCreateRemove},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			defer undertest.Clean()
+			tc.test(t, undertest)
+		})
+	}
+}
+
+// runParallel runs 'fn' on 'concurrency' goroutines, then waits for them to finish
+func runParallel(fn func(i int)) {
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentCreateWrite(t *testing.T, undertest FSTester) {
+	fs := undertest.FS()
+	runParallel(func(i int) {
+		dir := fmt.Sprintf("foo/%d", i)
+		if !assert.NoError(t, fs.MkdirAll(dir, 0700)) {
+			return
+		}
+		path := filepath.Join(dir, "bar")
+		contents := []byte(fmt.Sprintf("hello %d", i))
+		if !assert.NoError(t, afero.WriteFile(fs, path, contents, 0600)) {
+			return
+		}
+		readContents, err := afero.ReadFile(fs, path)
+		assert.NoError(t, err)
+		assert.Equal(t, string(contents), string(readContents))
+	})
+
+	names := readdirnames(t, fs, "foo")
+	assert.Len(t, names, concurrency)
+}
+
+func TestConcurrentSharedFile(t *testing.T, undertest FSTester) {
+	fs := undertest.FS()
+	const chunkSize = 8
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+
+	runParallel(func(i int) {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, chunkSize)
+		_, err := f.WriteAt(chunk, int64(i*chunkSize))
+		assert.NoError(t, err)
+
+		readChunk := make([]byte, chunkSize)
+		_, err = f.ReadAt(readChunk, int64(i*chunkSize))
+		if err == io.EOF {
+			err = nil
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, string(chunk), string(readChunk))
+
+		_, err = f.Stat()
+		assert.NoError(t, err)
+	})
+	require.NoError(t, f.Close())
+
+	contents, err := afero.ReadFile(fs, "foo")
+	require.NoError(t, err)
+	require.Len(t, contents, concurrency*chunkSize)
+	for i := 0; i < concurrency; i++ {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, chunkSize)
+		assert.Equal(t, string(chunk), string(contents[i*chunkSize:(i+1)*chunkSize]))
+	}
+}
+
+func TestConcurrentSeparateHandles(t *testing.T, undertest FSTester) {
+	fs := undertest.FS()
+	const chunkSize = 4
+	a, err := fs.Create("foo")
+	require.NoError(t, err)
+	b, err := fs.OpenFile("foo", os.O_RDWR, 0)
+	require.NoError(t, err)
+	_, err = a.WriteAt([]byte("AAAA"), 0)
+	require.NoError(t, err)
+	_, err = b.WriteAt([]byte("BBBB"), chunkSize)
+	require.NoError(t, err)
+	require.NoError(t, a.Close())
+	require.NoError(t, b.Close())
+	contents, err := afero.ReadFile(fs, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, "AAAABBBB", string(contents), "Writes from every handle should be kept")
+
+	runParallel(func(i int) {
+		f, err := fs.OpenFile("foo", os.O_RDWR, 0)
+		if !assert.NoError(t, err) {
+			return
+		}
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, chunkSize)
+		_, err = f.WriteAt(chunk, int64(i*chunkSize))
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+	})
+
+	contents, err = afero.ReadFile(fs, "foo")
+	require.NoError(t, err)
+	require.Len(t, contents, concurrency*chunkSize)
+	for i := 0; i < concurrency; i++ {
+		chunk := bytes.Repeat([]byte{byte('a' + i)}, chunkSize)
+		assert.Equal(t, string(chunk), string(contents[i*chunkSize:(i+1)*chunkSize]))
+	}
+}
+
+func TestConcurrentRenameReaddir(t *testing.T, undertest FSTester) {
+	fs := undertest.FS()
+	require.NoError(t, fs.Mkdir("foo", 0700))
+	require.NoError(t, fs.Mkdir("bar", 0700))
+	for i := 0; i < concurrency; i++ {
+		require.NoError(t, afero.WriteFile(fs, fmt.Sprintf("foo/%d", i), []byte("baz"), 0600))
+	}
+
+	runParallel(func(i int) {
+		if i%2 == 0 {
+			// readers
+			for _, dir := range []string{"foo", "bar"} {
+				f, err := fs.Open(dir)
+				if !assert.NoError(t, err) {
+					return
+				}
+				_, err = f.Readdir(-1)
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+			}
+		}
+		name := fmt.Sprint(i)
+		assert.NoError(t, fs.Rename(filepath.Join("foo", name), filepath.Join("bar", name)))
+	})
+
+	assert.Empty(t, readdirnames(t, fs, "foo"))
+	assert.Len(t, readdirnames(t, fs, "bar"), concurrency)
+}
+
+func TestConcurrentCreateRemove(t *testing.T, undertest FSTester) {
+	fs := undertest.FS()
+	runParallel(func(i int) {
+		if i%2 == 0 {
+			f, err := fs.OpenFile("foo", os.O_WRONLY|os.O_CREATE, 0600)
+			if assert.NoError(t, err) {
+				_, err = f.Write([]byte("bar"))
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+			}
+		} else {
+			err := fs.Remove("foo")
+			if err != nil {
+				assert.True(t, os.IsNotExist(err), "Unexpected error: %v", err)
+			}
+		}
+	})
+
+	// the file either exists and is intact or was removed
+	info, err := fs.Stat("foo")
+	if err == nil {
+		assert.Equal(t, os.FileMode(0600), info.Mode())
+	} else {
+		assert.True(t, os.IsNotExist(err), "Unexpected error: %v", err)
+	}
+}
+
+func readdirnames(t *testing.T, fs afero.Fs, path string) []string {
+	t.Helper()
+	f, err := fs.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, f.Close())
+	}()
+	names, err := f.Readdirnames(-1)
+	require.NoError(t, err)
+	return names
+}
@@ -1,8 +1,11 @@
 package fsutil
 
 import (
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/spf13/afero"
 )
@@ -22,3 +25,88 @@ func NormalizePath(path string) string {
 		return path
 	}
 }
+
+// Chowner is implemented by file systems which support file ownership
+type Chowner interface {
+	Chown(name string, uid, gid int) error
+}
+
+// Chown changes the owner and group of 'name' if 'fs' supports ownership, otherwise does nothing
+func Chown(fs afero.Fs, name string, uid, gid int) error {
+	if chowner, ok := fs.(Chowner); ok {
+		return chowner.Chown(name, uid, gid)
+	}
+	return nil
+}
+
+// Copier is implemented by file systems which can copy files without reading and rewriting their data
+type Copier interface {
+	Copy(oldname, newname string) error
+}
+
+// Copy copies the file 'oldname' to 'newname', replacing 'newname' if it exists.
+// Uses 'fs's Copy if available, otherwise copies with CopyData.
+func Copy(fs afero.Fs, oldname, newname string) error {
+	if copier, ok := fs.(Copier); ok {
+		return copier.Copy(oldname, newname)
+	}
+	return CopyData(fs, fs, oldname, newname)
+}
+
+// CopyData copies the data and mode of file 'oldname' in 'srcFs' to 'newname' in 'destFs', replacing 'newname' if it exists
+func CopyData(srcFs, destFs afero.Fs, oldname, newname string) error {
+	info, err := srcFs.Stat(oldname)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return &os.LinkError{Op: "copy", Old: oldname, New: newname, Err: syscall.EISDIR}
+	}
+	src, err := srcFs.Open(oldname)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest, err := destFs.OpenFile(newname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dest, src)
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RemoveAll removes 'path' and any children it contains, one at a time.
+// Useful for file systems which don't implement RemoveAll, like storer.Fs. Returns nil if 'path' doesn't exist.
+func RemoveAll(fs afero.Fs, path string) error {
+	info, err := fs.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		dir, err := fs.Open(path)
+		if err != nil {
+			return err
+		}
+		names, err := dir.Readdirnames(-1)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := RemoveAll(fs, filepath.Join(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	err = fs.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
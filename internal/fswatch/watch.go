@@ -0,0 +1,172 @@
+package fswatch
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/log"
+	"github.com/spf13/afero"
+)
+
+// Op is a file system change operation
+type Op uint8
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+)
+
+func (o Op) String() string {
+	switch o {
+	case Create:
+		return "create"
+	case Write:
+		return "write"
+	case Remove:
+		return "remove"
+	case Rename:
+		return "rename"
+	default:
+		return "unknown"
+	}
+}
+
+// Event describes a change to the file at Path. OldPath is set for Rename events.
+type Event struct {
+	Op      Op
+	Path    string
+	OldPath string
+}
+
+// eventBufferSize is the number of undelivered events a Watcher holds before dropping new events
+const eventBufferSize = 256
+
+// Watchers tracks file system watches and delivers events to them. The zero value is ready to use.
+type Watchers struct {
+	mu       sync.RWMutex
+	watchers map[*Watcher]struct{}
+}
+
+// Watch starts watching 'path' for changes.
+// Watching a directory reports changes to the directory and its direct children, or all descendants if 'recursive' is set.
+// Removing or renaming a parent directory of 'path' is also reported.
+func (w *Watchers) Watch(path string, recursive bool) *Watcher {
+	watcher := &Watcher{
+		path:      fsutil.NormalizePath(path),
+		recursive: recursive,
+		events:    make(chan Event, eventBufferSize),
+		watchers:  w,
+	}
+	w.mu.Lock()
+	if w.watchers == nil {
+		w.watchers = make(map[*Watcher]struct{})
+	}
+	w.watchers[watcher] = struct{}{}
+	w.mu.Unlock()
+	return watcher
+}
+
+// Empty returns true if there are no active watches
+func (w *Watchers) Empty() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.watchers) == 0
+}
+
+// Emit delivers 'event' to all matching watches. Never blocks: if a watch's event buffer is full, the event is dropped for that watch.
+func (w *Watchers) Emit(event Event) {
+	event.Path = fsutil.NormalizePath(event.Path)
+	if event.OldPath != "" {
+		event.OldPath = fsutil.NormalizePath(event.OldPath)
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for watcher := range w.watchers {
+		if !watcher.matches(event) {
+			continue
+		}
+		select {
+		case watcher.events <- event:
+		default:
+			log.Warnf("Dropped %s event for %q: watcher for %q is full", event.Op, event.Path, watcher.path)
+		}
+	}
+}
+
+func (w *Watchers) remove(watcher *Watcher) {
+	w.mu.Lock()
+	delete(w.watchers, watcher)
+	w.mu.Unlock()
+}
+
+// Watcher receives events for a watched path
+type Watcher struct {
+	path      string
+	recursive bool
+	events    chan Event
+	closeOnce sync.Once
+	watchers  *Watchers
+}
+
+// Events returns a channel of events for this watch. The channel is closed when the watch is closed.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Path returns the watched path
+func (w *Watcher) Path() string {
+	return w.path
+}
+
+// Close stops watching for changes
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		w.watchers.remove(w)
+		close(w.events)
+	})
+	return nil
+}
+
+func (w *Watcher) matches(event Event) bool {
+	if w.matchesPath(event.Path) {
+		return true
+	}
+	if event.Op == Rename && w.matchesPath(event.OldPath) {
+		return true
+	}
+	if event.Op == Remove || event.Op == Rename {
+		// removing or renaming a parent directory also removes the watched path
+		removedPath := event.Path
+		if event.Op == Rename {
+			removedPath = event.OldPath
+		}
+		return isParent(removedPath, w.path)
+	}
+	return false
+}
+
+func (w *Watcher) matchesPath(path string) bool {
+	if path == "" {
+		return false
+	}
+	if path == w.path {
+		return true
+	}
+	if w.recursive {
+		return isParent(w.path, path)
+	}
+	return filepath.Dir(path) == w.path
+}
+
+// isParent returns true if 'parent' is an ancestor directory of 'path'
+func isParent(parent, path string) bool {
+	if parent == afero.FilePathSeparator {
+		return path != afero.FilePathSeparator
+	}
+	return strings.HasPrefix(path, parent+afero.FilePathSeparator)
+}
@@ -0,0 +1,192 @@
+// Package httprange reads remote files on demand with HTTP Range requests
+package httprange
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	blockSize       = 64 << 10
+	maxCachedBlocks = 256 // caches up to 16 MiB
+)
+
+// ErrRangeNotSupported is returned by New when the server doesn't support Range requests for the file
+var ErrRangeNotSupported = errors.New("httprange: server does not support range requests")
+
+var _ io.ReaderAt = &Reader{}
+
+// Reader is an io.ReaderAt for a remote file. Blocks are fetched with Range requests on first read, and recently read blocks are cached.
+// Safe for concurrent use.
+type Reader struct {
+	client *http.Client
+	url    string
+	size   int64
+
+	mu     sync.Mutex
+	blocks map[int64][]byte // block index to block data
+	order  []int64          // cached block indexes, oldest first
+}
+
+// New returns a Reader for 'url'. Returns ErrRangeNotSupported if the server responds with the full file instead of a range.
+func New(client *http.Client, url string) (*Reader, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	r := &Reader{
+		client: client,
+		url:    url,
+		blocks: make(map[int64][]byte),
+	}
+	size, err := r.fetchSize()
+	if err != nil {
+		return nil, err
+	}
+	r.size = size
+	return r, nil
+}
+
+// fetchSize requests the first byte to verify range support and read the total size from Content-Range
+func (r *Reader) fetchSize() (int64, error) {
+	resp, err := r.get("bytes=0-0")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, nil // empty file
+	case http.StatusOK:
+		return 0, ErrRangeNotSupported
+	default:
+		return 0, errors.Errorf("httprange: Unexpected response fetching %q: %s", r.url, resp.Status)
+	}
+	contentRange := resp.Header.Get("Content-Range")
+	sizeIndex := strings.LastIndex(contentRange, "/")
+	if sizeIndex == -1 {
+		return 0, ErrRangeNotSupported
+	}
+	size, err := strconv.ParseInt(contentRange[sizeIndex+1:], 10, 64)
+	if err != nil {
+		return 0, ErrRangeNotSupported // size is unknown, i.e. "*"
+	}
+	return size, nil
+}
+
+func (r *Reader) get(byteRange string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", byteRange)
+	return r.client.Do(req)
+}
+
+// Size returns the total size of the remote file
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("httprange: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+		err = io.EOF
+	}
+	firstBlock, lastBlock := off/blockSize, (end-1)/blockSize
+	blocks, fetchErr := r.getBlocks(firstBlock, lastBlock)
+	if fetchErr != nil {
+		return 0, fetchErr
+	}
+	for i, block := range blocks {
+		blockStart := (firstBlock + int64(i)) * blockSize
+		start := off + int64(n) - blockStart
+		n += copy(p[n:], block[start:])
+	}
+	return n, err
+}
+
+// getBlocks returns blocks 'first' through 'last', fetching any missing blocks in a single request
+func (r *Reader) getBlocks(first, last int64) ([][]byte, error) {
+	blocks := make([][]byte, last-first+1)
+	fetchFirst, fetchLast := int64(-1), int64(-1)
+	r.mu.Lock()
+	for i := first; i <= last; i++ {
+		block, ok := r.blocks[i]
+		if !ok {
+			if fetchFirst == -1 {
+				fetchFirst = i
+			}
+			fetchLast = i
+		}
+		blocks[i-first] = block
+	}
+	r.mu.Unlock()
+	if fetchFirst == -1 {
+		return blocks, nil
+	}
+
+	fetched, err := r.fetchBlocks(fetchFirst, fetchLast)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, block := range fetched {
+		index := fetchFirst + int64(i)
+		blocks[index-first] = block
+		r.cacheBlock(index, block)
+	}
+	return blocks, nil
+}
+
+func (r *Reader) fetchBlocks(first, last int64) ([][]byte, error) {
+	start, end := first*blockSize, (last+1)*blockSize
+	if end > r.size {
+		end = r.size
+	}
+	resp, err := r.get(fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, errors.Errorf("httprange: Unexpected response fetching %q: %s", r.url, resp.Status)
+	}
+	buf := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return nil, err
+	}
+	blocks := make([][]byte, 0, last-first+1)
+	for len(buf) > blockSize {
+		blocks = append(blocks, buf[:blockSize:blockSize])
+		buf = buf[blockSize:]
+	}
+	return append(blocks, buf), nil
+}
+
+// cacheBlock stores 'block', evicting the oldest block if the cache is full. Must be called with r.mu held.
+func (r *Reader) cacheBlock(index int64, block []byte) {
+	if _, exists := r.blocks[index]; exists {
+		return
+	}
+	if len(r.order) >= maxCachedBlocks {
+		delete(r.blocks, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.blocks[index] = block
+	r.order = append(r.order, index)
+}
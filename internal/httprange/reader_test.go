@@ -0,0 +1,115 @@
+package httprange
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// newTestServer serves 'data' and counts the bytes sent. If ranges is false, Range headers are ignored.
+func newTestServer(t *testing.T, data []byte, ranges bool) (*httptest.Server, *atomic.Int64) {
+	var sent atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(countingWriter{ResponseWriter: w, sent: &sent}, r, "file", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+	return server, &sent
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	sent *atomic.Int64
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	c.sent.Add(int64(len(b)))
+	return c.ResponseWriter.Write(b)
+}
+
+func TestReadAt(t *testing.T) {
+	data := make([]byte, 3*blockSize+10)
+	rand.New(rand.NewSource(1)).Read(data)
+	server, _ := newTestServer(t, data, true)
+
+	r, err := New(server.Client(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), r.Size())
+
+	for _, tc := range []struct {
+		description string
+		off, length int64
+	}{
+		{description: "within block", off: 10, length: 20},
+		{description: "across blocks", off: blockSize - 5, length: blockSize + 10},
+		{description: "last block", off: 3 * blockSize, length: 10},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			buf := make([]byte, tc.length)
+			n, err := r.ReadAt(buf, tc.off)
+			assert.NoError(t, err)
+			assert.Equal(t, int(tc.length), n)
+			assert.Equal(t, data[tc.off:tc.off+tc.length], buf)
+		})
+	}
+
+	buf := make([]byte, 20)
+	n, err := r.ReadAt(buf, int64(len(data))-5)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "EOF", err.Error())
+	assert.Equal(t, data[len(data)-5:], buf[:n])
+}
+
+func TestZipLazyRead(t *testing.T) {
+	var zipBuf bytes.Buffer
+	z := zip.NewWriter(&zipBuf)
+	contents := make(map[string][]byte)
+	random := rand.New(rand.NewSource(1))
+	for _, name := range []string{"a", "b", "c"} {
+		data := make([]byte, 4*blockSize)
+		random.Read(data)
+		contents[name] = data
+		w, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, z.Close())
+	server, sent := newTestServer(t, zipBuf.Bytes(), true)
+
+	r, err := New(server.Client(), server.URL)
+	require.NoError(t, err)
+	zipReader, err := zip.NewReader(r, r.Size())
+	require.NoError(t, err)
+	for _, f := range zipReader.File {
+		if f.Name != "b" {
+			continue
+		}
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		assert.Equal(t, contents["b"], data)
+	}
+	const blockOverhead = 3 * blockSize // partial blocks around the directory and file 'b'
+	assert.Less(t, sent.Load(), int64(len(contents["b"])+blockOverhead), "Only the directory and file 'b' should be fetched")
+	assert.Less(t, int64(len(contents["b"])+blockOverhead), int64(zipBuf.Len()))
+}
+
+func TestRangeNotSupported(t *testing.T) {
+	server, _ := newTestServer(t, []byte("hello"), false)
+	_, err := New(server.Client(), server.URL)
+	assert.Equal(t, ErrRangeNotSupported, err)
+}
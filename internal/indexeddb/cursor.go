@@ -34,6 +34,14 @@ type Cursor struct {
 	jsCursor js.Value
 }
 
+func (c *Cursor) Key() js.Value {
+	return c.jsCursor.Get("key")
+}
+
+func (c *Cursor) Value() js.Value {
+	return c.jsCursor.Get("value")
+}
+
 func (c *Cursor) Advance(count uint) (err error) {
 	defer common.CatchException(&err)
 	c.jsCursor.Call("advance", count)
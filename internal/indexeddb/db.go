@@ -15,8 +15,9 @@ var (
 )
 
 type DB struct {
-	jsDB    js.Value
-	jsFuncs interop.CallCache
+	jsDB       js.Value
+	jsFuncs    interop.CallCache
+	upgradeTxn *Transaction
 }
 
 func DeleteDatabase(name string) error {
@@ -57,7 +58,9 @@ func New(name string, version int, upgrader func(db *DB, oldVersion, newVersion
 		if err != nil {
 			panic(err)
 		}
+		db.upgradeTxn = request.transaction()
 		err = upgrader(db, event.Get("oldVersion").Int(), event.Get("newVersion").Int())
+		db.upgradeTxn = nil
 		if err != nil {
 			panic(err)
 		}
@@ -80,6 +83,12 @@ func (db *DB) CreateObjectStore(name string, options ObjectStoreOptions) (_ *Obj
 	return newObjectStore(jsObjectStore), nil
 }
 
+// UpgradeTransaction returns the versionchange transaction while the upgrader runs, or nil otherwise.
+// Requests in it can't be awaited, since the upgrader must return before they run. Use callbacks like Request.ListenSuccess instead.
+func (db *DB) UpgradeTransaction() *Transaction {
+	return db.upgradeTxn
+}
+
 func (db *DB) DeleteObjectStore(name string) (err error) {
 	defer common.CatchException(&err)
 	db.jsDB.Call("deleteObjectStore", name)
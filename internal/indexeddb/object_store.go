@@ -74,6 +74,11 @@ func (o *ObjectStore) Get(key js.Value) (_ *Request, err error) {
 	return newRequest(o.jsObjectStore.Call("get", key)), nil
 }
 
+func (o *ObjectStore) GetAll(query js.Value) (vals *Request, err error) {
+	defer common.CatchException(&err)
+	return newRequest(o.jsObjectStore.Call("getAll", query)), nil
+}
+
 func (o *ObjectStore) GetAllKeys(query js.Value) (vals *Request, err error) {
 	defer common.CatchException(&err)
 	return newRequest(o.jsObjectStore.Call("getAllKeys", query)), nil
@@ -108,6 +113,49 @@ func (o *ObjectStore) OpenCursor(key js.Value, direction CursorDirection) (_ <-c
 	return cursor, nil
 }
 
+// ForEach calls 'fn' with a cursor at each record matching 'key', in key order, then continues the cursor.
+// Doesn't block, so it's safe to use in an upgrade transaction. If 'fn' fails, the transaction is aborted.
+func (o *ObjectStore) ForEach(key js.Value, fn func(cursor *Cursor) error) (err error) {
+	defer common.CatchException(&err)
+	req := newRequest(o.jsObjectStore.Call("openCursor", key))
+	var successFunc, errFunc js.Func
+	release := func() {
+		successFunc.Release()
+		errFunc.Release()
+	}
+	abort := func(err error) {
+		log.Error("Failed iterating cursor: ", err)
+		release()
+		_ = req.transaction().Abort()
+	}
+	successFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		defer common.CatchExceptionHandler(abort)
+		result, err := req.Result()
+		if err == nil && result.IsNull() {
+			release() // no more records
+			return nil
+		}
+		if err == nil {
+			cursor := &Cursor{jsCursor: result}
+			err = fn(cursor)
+			if err == nil {
+				err = cursor.Continue()
+			}
+		}
+		if err != nil {
+			abort(err)
+		}
+		return nil
+	})
+	errFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		release()
+		return nil
+	})
+	req.jsRequest.Call("addEventListener", "success", successFunc)
+	req.jsRequest.Call("addEventListener", "error", errFunc)
+	return nil
+}
+
 /*
 func (o *ObjectStore) OpenKeyCursor(keyRange KeyRange, direction CursorDirection) (*Cursor, error) {
 	panic("not implemented")
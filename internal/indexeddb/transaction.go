@@ -3,13 +3,17 @@
 package indexeddb
 
 import (
+	"syscall"
 	"syscall/js"
 
 	"github.com/johnstarich/go-wasm/internal/common"
 	"github.com/johnstarich/go-wasm/internal/interop"
 	"github.com/johnstarich/go-wasm/internal/promise"
+	"github.com/pkg/errors"
 )
 
+var errAborted = errors.New("transaction aborted")
+
 var supportsTransactionCommit = js.Global().Get("IDBTransaction").Get("prototype").Get("commit").Truthy()
 
 type TransactionMode int
@@ -81,26 +85,58 @@ func (t *Transaction) Await() error {
 func (t *Transaction) prepareAwait() promise.Promise {
 	resolve, reject, prom := promise.NewGo()
 
-	var errFunc, completeFunc js.Func
-	errFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-		err := t.jsTransaction.Get("error")
-		t.jsTransaction.Call("abort")
+	var errFunc, abortFunc, completeFunc js.Func
+	done := false // event handlers run on the JS event loop, so no lock is needed
+	release := func() {
+		errFunc.Release()
+		abortFunc.Release()
+		completeFunc.Release()
+	}
+	fail := func() {
+		if done {
+			return
+		}
+		done = true
+		err := transactionError(t.jsTransaction.Get("error"))
 		go func() {
-			errFunc.Release()
-			completeFunc.Release()
+			release()
 			reject(err)
 		}()
+	}
+	errFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		fail()
+		t.jsTransaction.Call("abort")
+		return nil
+	})
+	abortFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		// some failures only abort without an error event, like exceeding the storage quota
+		fail()
 		return nil
 	})
 	completeFunc = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if done {
+			return nil
+		}
+		done = true
 		go func() {
-			errFunc.Release()
-			completeFunc.Release()
+			release()
 			resolve(nil)
 		}()
 		return nil
 	})
 	t.jsTransaction.Call("addEventListener", "error", errFunc)
+	t.jsTransaction.Call("addEventListener", "abort", abortFunc)
 	t.jsTransaction.Call("addEventListener", "complete", completeFunc)
 	return prom
 }
+
+// transactionError converts a transaction's DOMException into a Go error. Exceeding the browser's storage quota returns ENOSPC.
+func transactionError(jsErr js.Value) error {
+	if !jsErr.Truthy() {
+		return errAborted
+	}
+	if jsErr.Get("name").String() == "QuotaExceededError" {
+		return syscall.ENOSPC
+	}
+	return js.Error{Value: jsErr}
+}
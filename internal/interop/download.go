@@ -15,15 +15,20 @@ var (
 )
 
 func StartDownload(contentType, fileName string, buf []byte) {
+	blobInstance := NewBlob(contentType, buf)
+	link := jsDocument.Call("createElement", "a")
+	link.Set("href", jsURL.Call("createObjectURL", blobInstance))
+	link.Set("download", fileName)
+	link.Call("click")
+}
+
+// NewBlob returns a new JS Blob containing 'buf'
+func NewBlob(contentType string, buf []byte) js.Value {
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
 	b := blob.NewFromBytes(buf)
-	blobInstance := jsBlob.New([]interface{}{b}, map[string]interface{}{
+	return jsBlob.New([]interface{}{b}, map[string]interface{}{
 		"type": contentType,
 	})
-	link := jsDocument.Call("createElement", "a")
-	link.Set("href", jsURL.Call("createObjectURL", blobInstance))
-	link.Set("download", fileName)
-	link.Call("click")
 }
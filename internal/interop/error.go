@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"syscall"
 
 	"github.com/johnstarich/go-wasm/internal/common"
 	"github.com/johnstarich/go-wasm/log"
@@ -61,6 +62,8 @@ func mapToErrNo(err error, debugMessage string) string {
 		return "EEXIST"
 	case os.ErrPermission:
 		return "EPERM"
+	case syscall.ENOSPC:
+		return "ENOSPC"
 	}
 	switch err.Error() {
 	case os.ErrClosed.Error(), afero.ErrFileClosed.Error():
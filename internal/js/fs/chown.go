@@ -5,6 +5,7 @@ package fs
 import (
 	"syscall/js"
 
+	"github.com/johnstarich/go-wasm/internal/process"
 	"github.com/pkg/errors"
 )
 
@@ -25,6 +26,6 @@ func chownSync(args []js.Value) (interface{}, error) {
 }
 
 func Chown(path string, uid, gid int) error {
-	// TODO no-op, consider adding user and group ID support to afero
-	return nil
+	p := process.Current()
+	return p.Files().Chown(path, uid, gid)
 }
@@ -0,0 +1,38 @@
+// +build js
+
+package fs
+
+import (
+	"os"
+	"syscall/js"
+
+	"github.com/johnstarich/go-wasm/internal/process"
+	"github.com/pkg/errors"
+)
+
+// copyFileExcl is Node's fs.constants.COPYFILE_EXCL, which fails if the destination exists
+const copyFileExcl = 1
+
+func copyFile(args []js.Value) ([]interface{}, error) {
+	_, err := copyFileSync(args)
+	return nil, err
+}
+
+func copyFileSync(args []js.Value) (interface{}, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.Errorf("Invalid number of args, expected 2 or 3: %v", args)
+	}
+	src := args[0].String()
+	dest := args[1].String()
+	mode := 0
+	if len(args) == 3 && args[2].Type() == js.TypeNumber {
+		mode = args[2].Int()
+	}
+	p := process.Current()
+	if mode&copyFileExcl != 0 {
+		if _, err := p.Files().Lstat(dest); err == nil {
+			return nil, &os.PathError{Op: "copyfile", Path: dest, Err: os.ErrExist}
+		}
+	}
+	return nil, p.Files().CopyFile(src, dest)
+}
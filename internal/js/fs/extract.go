@@ -0,0 +1,60 @@
+// +build js
+
+package fs
+
+import (
+	"bytes"
+	"syscall/js"
+
+	"github.com/johnstarich/go-wasm/internal/common"
+	"github.com/johnstarich/go-wasm/internal/fs"
+	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/process"
+	"github.com/johnstarich/go-wasm/internal/promise"
+	"github.com/pkg/errors"
+)
+
+// extract unpacks an archive from a file path or a Blob, ArrayBuffer, or Uint8Array into a destination path
+func extract(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return interop.WrapAsJSError(errors.New("extract: archive and destination path are required"), "EINVAL")
+	}
+	source := args[0]
+	workingDirectory := process.Current().WorkingDirectory()
+	dest := common.ResolvePath(workingDirectory, args[1].String())
+	var progress func(float64)
+	if len(args) >= 3 && args[2].Type() == js.TypeObject {
+		if progressCallback := args[2].Get("progress"); progressCallback.Type() == js.TypeFunction {
+			progress = func(percent float64) {
+				progressCallback.Invoke(percent)
+			}
+		}
+	}
+
+	resolve, reject, prom := promise.New()
+	go func() {
+		// create the destination as the current process, so permissions are checked
+		err := process.Current().Files().MkdirAll(dest, 0777)
+		switch {
+		case err != nil:
+		case source.Type() == js.TypeString:
+			err = fs.ExtractFile(common.ResolvePath(workingDirectory, source.String()), dest, progress)
+		default:
+			err = extractJSData(source, dest, progress)
+		}
+		if err != nil {
+			reject(interop.WrapAsJSError(err, "extract"))
+		} else {
+			resolve(nil)
+		}
+	}()
+	return prom
+}
+
+func extractJSData(data js.Value, dest string, progress func(float64)) error {
+	buf, err := readJSBytes(data)
+	if err != nil {
+		return err
+	}
+	return fs.Extract(bytes.NewReader(buf), int64(len(buf)), dest, progress)
+}
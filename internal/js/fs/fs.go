@@ -17,8 +17,6 @@ import (
 )
 
 /*
-fchown(fd, uid, gid, callback) { callback(enosys()); },
-lchown(path, uid, gid, callback) { callback(enosys()); },
 link(path, link, callback) { callback(enosys()); },
 readlink(path, callback) { callback(enosys()); },
 symlink(path, link, callback) { callback(enosys()); },
@@ -41,8 +39,12 @@ func Init() {
 	interop.SetFunc(fs, "chownSync", chownSync)
 	interop.SetFunc(fs, "close", closeFn)
 	interop.SetFunc(fs, "closeSync", closeSync)
+	interop.SetFunc(fs, "copyFile", copyFile)
+	interop.SetFunc(fs, "copyFileSync", copyFileSync)
 	interop.SetFunc(fs, "fchmod", fchmod)
 	interop.SetFunc(fs, "fchmodSync", fchmodSync)
+	interop.SetFunc(fs, "fchown", fchown)
+	interop.SetFunc(fs, "fchownSync", fchownSync)
 	interop.SetFunc(fs, "flock", flock)
 	interop.SetFunc(fs, "flockSync", flockSync)
 	interop.SetFunc(fs, "fstat", fstat)
@@ -51,6 +53,8 @@ func Init() {
 	interop.SetFunc(fs, "fsyncSync", fsyncSync)
 	interop.SetFunc(fs, "ftruncate", ftruncate)
 	interop.SetFunc(fs, "ftruncateSync", ftruncateSync)
+	interop.SetFunc(fs, "lchown", chown) // symlinks are not supported, so lchown is chown
+	interop.SetFunc(fs, "lchownSync", chownSync)
 	interop.SetFunc(fs, "lstat", lstat)
 	interop.SetFunc(fs, "lstatSync", lstatSync)
 	interop.SetFunc(fs, "mkdir", mkdir)
@@ -78,17 +82,34 @@ func Init() {
 
 	global.Set("getMounts", js.FuncOf(getMounts))
 	global.Set("destroyMount", js.FuncOf(destroyMount))
+	global.Set("unmount", js.FuncOf(unmount))
+	global.Set("overlayMemory", js.FuncOf(overlayMemory))
 	global.Set("overlayZip", js.FuncOf(overlayZip))
 	global.Set("overlayTarGzip", js.FuncOf(overlayTarGzip))
 	global.Set("overlayStorage", js.FuncOf(overlayStorage))
 	global.Set("overlayIndexedDB", js.FuncOf(overlayIndexedDB))
 	global.Set("dumpZip", js.FuncOf(dumpZip))
+	global.Set("snapshot", js.FuncOf(snapshot))
+	global.Set("restoreSnapshot", js.FuncOf(restoreSnapshot))
+	global.Set("extract", js.FuncOf(extract))
+	global.Set("watch", js.FuncOf(watch))
+	global.Set("setQuota", js.FuncOf(setQuotaFn))
+	global.Set("getMountUsage", js.FuncOf(getMountUsage))
+	global.Set("fsck", js.FuncOf(fsck))
 
 	// Set up system directories
 	files := process.Current().Files()
 	if err := files.MkdirAll(os.TempDir(), 0777); err != nil {
 		panic(err)
 	}
+	if err := overlayTmpfs(); err != nil {
+		panic(err)
+	}
+}
+
+// overlayTmpfs mounts a size-capped tmpfs at the temp dir, so temporary files can't exhaust memory
+func overlayTmpfs() error {
+	return fs.OverlayTmpfs(os.TempDir(), fs.DefaultTmpfsSize, fs.DefaultTmpfsMaxAge)
 }
 
 func Dump(basePath string) interface{} {
@@ -129,3 +150,20 @@ func destroyMount(this js.Value, args []js.Value) interface{} {
 	}()
 	return prom
 }
+
+func unmount(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return interop.WrapAsJSError(errors.New("unmount: mount path is required"), "EINVAL")
+	}
+	resolve, reject, prom := promise.New()
+	mountPath := args[0].String()
+	go func() {
+		err := interop.WrapAsJSError(fs.Unmount(mountPath), "unmount")
+		if err != nil {
+			reject(err)
+		} else {
+			resolve(nil)
+		}
+	}()
+	return prom
+}
@@ -0,0 +1,48 @@
+// +build js
+
+package fs
+
+import (
+	"syscall/js"
+
+	"github.com/johnstarich/go-wasm/internal/fs"
+	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/promise"
+	"github.com/pkg/errors"
+)
+
+// fsck checks an IndexedDB mount for inconsistencies. Usage: fsck(mountPath, {repair: true})
+// Resolves to a report of the problems found, and whether they were repaired.
+func fsck(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return interop.WrapAsJSError(errors.New("fsck: mount path is required"), "EINVAL")
+	}
+	mountPath := args[0].String()
+	var repair bool
+	if len(args) >= 2 && args[1].Type() == js.TypeObject {
+		repair = interop.Entries(args[1])["repair"].Truthy()
+	}
+
+	resolve, reject, prom := promise.New()
+	go func() {
+		report, err := fs.Fsck(mountPath, repair)
+		if err != nil {
+			reject(interop.WrapAsJSError(err, "fsck"))
+			return
+		}
+		problems := make([]interface{}, 0, len(report.Problems))
+		for _, problem := range report.Problems {
+			problems = append(problems, map[string]interface{}{
+				"kind":   string(problem.Kind),
+				"path":   problem.Path,
+				"detail": problem.Detail,
+			})
+		}
+		resolve(map[string]interface{}{
+			"checked":  report.Checked,
+			"problems": problems,
+			"repaired": report.Repaired,
+		})
+	}()
+	return prom
+}
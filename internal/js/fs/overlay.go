@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -17,8 +18,10 @@ import (
 	"github.com/machinebox/progress"
 
 	"github.com/johnstarich/go-wasm/internal/fs"
+	"github.com/johnstarich/go-wasm/internal/httprange"
 	"github.com/johnstarich/go-wasm/internal/interop"
 	"github.com/johnstarich/go-wasm/internal/promise"
+	"github.com/johnstarich/go-wasm/internal/tarindex"
 	"github.com/johnstarich/go-wasm/log"
 )
 
@@ -44,12 +47,30 @@ func OverlayZip(args []js.Value) error {
 
 	mountPath := args[0].String()
 	zipPath := args[1].String()
-	log.Debug("Downloading overlay zip FS: ", zipPath)
+	log.Debug("Opening overlay zip FS: ", zipPath)
 	u, err := url.Parse(zipPath)
 	if err != nil {
 		return err
 	}
-	resp, err := http.Get(u.Path) // only download from current server, not just any URL
+	// only download from current server, not just any URL
+	rangeReader, err := httprange.New(http.DefaultClient, u.Path)
+	if err == httprange.ErrRangeNotSupported {
+		log.Debug("Range requests not supported, downloading entire zip: ", zipPath)
+		return overlayZipDownload(mountPath, u.Path)
+	}
+	if err != nil {
+		return err
+	}
+	z, err := zip.NewReader(rangeReader, rangeReader.Size())
+	if err != nil {
+		return err
+	}
+	return fs.OverlayZip(mountPath, z)
+}
+
+// overlayZipDownload downloads the whole zip into memory, for servers without Range request support
+func overlayZipDownload(mountPath, zipPath string) error {
+	resp, err := http.Get(zipPath)
 	if err != nil {
 		return err
 	}
@@ -78,13 +99,45 @@ func overlayStorage(this js.Value, args []js.Value) interface{} {
 
 	mountPath := args[0].String()
 	jsStorer := args[1]
-	err := fs.OverlayStorage(mountPath, fs.NewJSStorage(jsStorer))
+	storage, err := fs.NewJSStorage(jsStorer)
+	if err == nil {
+		err = fs.OverlayStorage(mountPath, storage)
+	}
 	if err != nil {
 		log.Error("Failed to overlay storage FS:", err)
 	}
 	return nil
 }
 
+func overlayMemory(this js.Value, args []js.Value) interface{} {
+	resolve, reject, prom := promise.New()
+	go func() {
+		err := OverlayMemory(args)
+		if err != nil {
+			reject(interop.WrapAsJSError(err, "Failed overlaying memory FS"))
+		} else {
+			resolve(nil)
+		}
+	}()
+	return prom
+}
+
+// OverlayMemory mounts an empty in-memory FS, which is discarded on reload
+func OverlayMemory(args []js.Value) error {
+	if len(args) == 0 {
+		return errors.New("overlayMemory: mount path is required")
+	}
+	mountPath := args[0].String()
+	var options map[string]js.Value
+	if len(args) >= 2 && args[1].Type() == js.TypeObject {
+		options = interop.Entries(args[1])
+	}
+	if err := fs.OverlayMemory(mountPath); err != nil {
+		return err
+	}
+	return setOverlayQuota(mountPath, options)
+}
+
 func overlayIndexedDB(this js.Value, args []js.Value) interface{} {
 	resolve, reject, prom := promise.New()
 	go func() {
@@ -114,11 +167,26 @@ func OverlayIndexedDB(args []js.Value) (err error) {
 		shouldCache = func(string) bool { return true }
 	}
 
-	idb, err := fs.NewIndexedDBFs(mountPath, shouldCache)
+	newIndexedDBFs := fs.NewIndexedDBFs
+	if dedup, ok := options["dedup"]; ok && dedup.Bool() {
+		newIndexedDBFs = fs.NewContentAddressedIndexedDBFs
+	}
+	idb, err := newIndexedDBFs(mountPath, shouldCache)
 	if err != nil {
 		return err
 	}
-	return fs.OverlayStorage(mountPath, idb)
+	if err := fs.OverlayStorage(mountPath, idb); err != nil {
+		return err
+	}
+	setCheckPermissions(mountPath, options)
+	return setOverlayQuota(mountPath, options)
+}
+
+// setCheckPermissions disables permission checks for the mount if the 'checkPermissions' option is false
+func setCheckPermissions(mountPath string, options map[string]js.Value) {
+	if checkPermissions, ok := options["checkPermissions"]; ok && checkPermissions.Type() == js.TypeBoolean {
+		fs.SetCheckPermissions(mountPath, checkPermissions.Bool())
+	}
 }
 
 func overlayTarGzip(this js.Value, args []js.Value) interface{} {
@@ -152,7 +220,24 @@ func OverlayTarGzip(args []js.Value) error {
 	if err != nil {
 		return err
 	}
+	persist := options["persist"].Truthy()
+	writable, err := parseWritableLayer(options["writable"])
+	if err != nil {
+		return err
+	}
 	// only download from current server, not just any URL
+	lazy, err := overlayIndexedTar(mountPath, u.Path, persist, writable)
+	if err != nil {
+		return err
+	}
+	if lazy {
+		if progressCallback := options["progress"]; progressCallback.Type() == js.TypeFunction {
+			progressCallback.Invoke(100)
+		}
+		setCheckPermissions(mountPath, options)
+		return setOverlayQuota(mountPath, options)
+	}
+
 	resp, err := http.Get(u.Path) // nolint:bodyclose // Body is closed in OverlayTarGzip handler to keep this async
 	if err != nil {
 		return err
@@ -165,8 +250,44 @@ func OverlayTarGzip(args []js.Value) error {
 			progressCallback.Invoke(percentage)
 		})
 	}
-	persist := options["persist"].Truthy()
-	return fs.OverlayTarGzip(mountPath, reader, persist)
+	if err := fs.OverlayTarGzip(mountPath, reader, persist, writable); err != nil {
+		return err
+	}
+	setCheckPermissions(mountPath, options)
+	return setOverlayQuota(mountPath, options)
+}
+
+// overlayIndexedTar mounts an indexed archive, fetching each file with Range requests on first use.
+// Returns false if the server or archive doesn't support lazy loading, so the caller can download the whole archive instead.
+func overlayIndexedTar(mountPath, downloadPath string, persist bool, writable fs.WritableLayer) (bool, error) {
+	rangeReader, err := httprange.New(http.DefaultClient, downloadPath)
+	if err == httprange.ErrRangeNotSupported {
+		log.Debug("Range requests not supported, downloading entire .tar.gz: ", downloadPath)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = fs.OverlayIndexedTar(mountPath, rangeReader, rangeReader.Size(), persist, writable)
+	if err == tarindex.ErrNotIndexed {
+		log.Debug("Archive is not indexed, downloading entire .tar.gz: ", downloadPath)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func parseWritableLayer(value js.Value) (fs.WritableLayer, error) {
+	if !value.Truthy() {
+		return fs.ReadOnlyLayer, nil
+	}
+	switch layer := value.String(); layer {
+	case "memory":
+		return fs.MemoryLayer, nil
+	case "indexeddb":
+		return fs.PersistLayer, nil
+	default:
+		return fs.ReadOnlyLayer, fmt.Errorf("Unknown writable layer type %q. Must be one of: memory, indexeddb", layer)
+	}
 }
 
 func wrapProgress(r io.ReadCloser, contentLength int64, setProgress func(float64)) io.ReadCloser {
@@ -0,0 +1,68 @@
+// +build js
+
+package fs
+
+import (
+	"syscall/js"
+
+	"github.com/johnstarich/go-wasm/internal/fs"
+	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/promise"
+	"github.com/pkg/errors"
+)
+
+func setQuotaFn(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 || args[1].Type() != js.TypeObject {
+		return interop.WrapAsJSError(errors.New("setQuota: mount path and quota options are required"), "EINVAL")
+	}
+	resolve, reject, prom := promise.New()
+	mountPath := args[0].String()
+	options := interop.Entries(args[1])
+	go func() {
+		err := setQuota(mountPath, options)
+		if err != nil {
+			reject(interop.WrapAsJSError(err, "setQuota"))
+		} else {
+			resolve(nil)
+		}
+	}()
+	return prom
+}
+
+// setQuota sets the mount's limits from the 'bytes' and 'inodes' options. Missing options are unlimited.
+func setQuota(mountPath string, options map[string]js.Value) error {
+	var maxBytes, maxInodes int64
+	if bytes := options["bytes"]; bytes.Type() == js.TypeNumber {
+		maxBytes = int64(bytes.Float())
+	}
+	if inodes := options["inodes"]; inodes.Type() == js.TypeNumber {
+		maxInodes = int64(inodes.Float())
+	}
+	return fs.SetQuota(mountPath, maxBytes, maxInodes)
+}
+
+// setOverlayQuota sets the mount's limits if the 'quota' option is set
+func setOverlayQuota(mountPath string, options map[string]js.Value) error {
+	quota := options["quota"]
+	if quota.Type() != js.TypeObject {
+		return nil
+	}
+	return setQuota(mountPath, interop.Entries(quota))
+}
+
+func getMountUsage(this js.Value, args []js.Value) interface{} {
+	resolve, _, prom := promise.New()
+	go func() {
+		usages := make(map[string]interface{})
+		for mountPath, usage := range fs.MountUsage() {
+			usages[mountPath] = map[string]interface{}{
+				"bytes":     usage.Bytes,
+				"inodes":    usage.Inodes,
+				"maxBytes":  usage.MaxBytes,
+				"maxInodes": usage.MaxInodes,
+			}
+		}
+		resolve(usages)
+	}()
+	return prom
+}
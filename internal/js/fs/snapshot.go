@@ -0,0 +1,87 @@
+// +build js
+
+package fs
+
+import (
+	"bytes"
+	"syscall/js"
+
+	"github.com/johnstarich/go-wasm/internal/common"
+	"github.com/johnstarich/go-wasm/internal/fs"
+	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/process"
+	"github.com/johnstarich/go-wasm/internal/promise"
+	"github.com/pkg/errors"
+)
+
+var (
+	jsArrayBuffer = js.Global().Get("ArrayBuffer")
+	jsBlob        = js.Global().Get("Blob")
+	jsUint8Array  = js.Global().Get("Uint8Array")
+)
+
+// snapshot resolves to a Blob of the given path, or every mount if no path is provided
+func snapshot(this js.Value, args []js.Value) interface{} {
+	path := "/"
+	if len(args) >= 1 && args[0].Type() == js.TypeString {
+		path = common.ResolvePath(process.Current().WorkingDirectory(), args[0].String())
+	}
+	resolve, reject, prom := promise.New()
+	go func() {
+		var buf bytes.Buffer
+		err := fs.Snapshot(&buf, path)
+		if err != nil {
+			reject(interop.WrapAsJSError(err, "snapshot"))
+		} else {
+			resolve(interop.NewBlob("application/gzip", buf.Bytes()))
+		}
+	}()
+	return prom
+}
+
+func restoreSnapshot(this js.Value, args []js.Value) interface{} {
+	if len(args) != 2 {
+		return interop.WrapAsJSError(errors.New("restoreSnapshot: path and snapshot data are required"), "EINVAL")
+	}
+	path := common.ResolvePath(process.Current().WorkingDirectory(), args[0].String())
+	data := args[1]
+	resolve, reject, prom := promise.New()
+	go func() {
+		err := restoreSnapshotData(path, data)
+		if err != nil {
+			reject(interop.WrapAsJSError(err, "restoreSnapshot"))
+		} else {
+			resolve(nil)
+		}
+	}()
+	return prom
+}
+
+func restoreSnapshotData(path string, data js.Value) error {
+	buf, err := readJSBytes(data)
+	if err != nil {
+		return err
+	}
+	return fs.Restore(bytes.NewReader(buf), path)
+}
+
+// readJSBytes copies the contents of a Blob, ArrayBuffer, or Uint8Array into a byte slice
+func readJSBytes(value js.Value) ([]byte, error) {
+	switch {
+	case value.InstanceOf(jsBlob):
+		arrayBuffer, err := promise.From(value.Call("arrayBuffer")).Await()
+		if err != nil {
+			return nil, err
+		}
+		value = arrayBuffer.(js.Value)
+		fallthrough
+	case value.InstanceOf(jsArrayBuffer):
+		value = jsUint8Array.New(value)
+	case value.InstanceOf(jsUint8Array):
+	default:
+		return nil, errors.Errorf("Unsupported data type, must be a Blob, ArrayBuffer, or Uint8Array: %v", value)
+	}
+	buf := make([]byte, value.Length())
+	js.CopyBytesToGo(buf, value)
+	return buf, nil
+}
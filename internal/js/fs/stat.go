@@ -8,6 +8,7 @@ import (
 	"syscall/js"
 
 	"github.com/johnstarich/go-wasm/internal/process"
+	"github.com/johnstarich/go-wasm/internal/storer"
 	"github.com/pkg/errors"
 )
 
@@ -41,20 +42,31 @@ func jsStat(info os.FileInfo) interface{} {
 	}
 	const blockSize = 4096 // TODO find useful value for blksize
 	modTime := info.ModTime().UnixNano() / 1e6
+	accessTime, changeTime := modTime, modTime
+	var uid, gid int
+	if stat, ok := info.Sys().(*storer.Stat); ok {
+		uid, gid = stat.Uid, stat.Gid
+		if !stat.Atime.IsZero() {
+			accessTime = stat.Atime.UnixNano() / 1e6
+		}
+		if !stat.Ctime.IsZero() {
+			changeTime = stat.Ctime.UnixNano() / 1e6
+		}
+	}
 	return map[string]interface{}{
 		"dev":     0,
 		"ino":     0,
 		"mode":    jsMode(info.Mode()),
 		"nlink":   1,
-		"uid":     0, // TODO use real values for uid and gid
-		"gid":     0,
+		"uid":     uid,
+		"gid":     gid,
 		"rdev":    0,
 		"size":    info.Size(),
 		"blksize": blockSize,
 		"blocks":  blockCount(info.Size(), blockSize),
-		"atimeMs": modTime,
+		"atimeMs": accessTime,
 		"mtimeMs": modTime,
-		"ctimeMs": modTime,
+		"ctimeMs": changeTime,
 
 		"isBlockDevice":     funcFalse,
 		"isCharacterDevice": funcFalse,
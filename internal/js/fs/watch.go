@@ -0,0 +1,40 @@
+// +build js
+
+package fs
+
+import (
+	"errors"
+	"syscall/js"
+
+	"github.com/johnstarich/go-wasm/internal/interop"
+	"github.com/johnstarich/go-wasm/internal/process"
+)
+
+// watch(path, options, callback) calls 'callback' with an event object for each change to 'path'. Returns a function to stop watching.
+// Options: { recursive: boolean }
+// Events: { type: "create" | "write" | "remove" | "rename", path: string, oldPath?: string }
+func watch(this js.Value, args []js.Value) interface{} {
+	if len(args) != 3 || args[2].Type() != js.TypeFunction {
+		return interop.WrapAsJSError(errors.New("watch: path, options, and callback are required"), "EINVAL")
+	}
+	path := args[0].String()
+	recursive := args[1].Type() == js.TypeObject && args[1].Get("recursive").Truthy()
+	callback := args[2]
+
+	watcher := process.Current().Files().Watch(path, recursive)
+	go func() {
+		for event := range watcher.Events() {
+			jsEvent := map[string]interface{}{
+				"type": event.Op.String(),
+				"path": event.Path,
+			}
+			if event.OldPath != "" {
+				jsEvent["oldPath"] = event.OldPath
+			}
+			callback.Invoke(jsEvent)
+		}
+	}()
+	return interop.SingleUseFunc(func(this js.Value, args []js.Value) interface{} {
+		return interop.WrapAsJSError(watcher.Close(), "watch")
+	})
+}
@@ -2,21 +2,20 @@
 
 package process
 
-import "syscall/js"
+import (
+	"syscall/js"
 
-const (
-	userID  = 0
-	groupID = 0
+	"github.com/johnstarich/go-wasm/internal/process"
 )
 
 func geteuid(args []js.Value) (interface{}, error) {
-	return userID, nil
+	return process.Current().Files().Uid(), nil
 }
 
 func getegid(args []js.Value) (interface{}, error) {
-	return groupID, nil
+	return process.Current().Files().Gid(), nil
 }
 
 func getgroups(args []js.Value) (interface{}, error) {
-	return groupID, nil
+	return []interface{}{process.Current().Files().Gid()}, nil
 }
@@ -2,15 +2,19 @@
 
 package process
 
-import "syscall/js"
+import (
+	"os"
+	"syscall/js"
 
-var currentUMask = 0755
+	"github.com/johnstarich/go-wasm/internal/process"
+)
 
 func umask(args []js.Value) (interface{}, error) {
+	files := process.Current().Files()
 	if len(args) == 0 {
-		return currentUMask, nil
+		oldUMask := files.Umask(0)
+		files.Umask(oldUMask)
+		return uint32(oldUMask), nil
 	}
-	oldUMask := currentUMask
-	currentUMask = args[0].Int()
-	return oldUMask, nil
+	return uint32(files.Umask(os.FileMode(args[0].Int()))), nil
 }
@@ -0,0 +1,58 @@
+// Package migrate runs ordered upgrade steps for persisted data formats
+package migrate
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrNewerVersion is returned by Run when the stored data was written by a newer version than the known steps
+var ErrNewerVersion = errors.New("migrate: stored data is newer than this version supports")
+
+// Step upgrades stored data from version Version-1 to Version
+type Step struct {
+	Version     int
+	Description string
+	Upgrade     func() error
+}
+
+// Latest returns the version reached after running all 'steps'
+func Latest(steps []Step) int {
+	if len(steps) == 0 {
+		return 0
+	}
+	return steps[len(steps)-1].Version
+}
+
+// Validate returns an error if 'steps' aren't numbered consecutively from 1
+func Validate(steps []Step) error {
+	for i, step := range steps {
+		if step.Version != i+1 {
+			return errors.Errorf("migrate: Step %d (%s) must be version %d", step.Version, step.Description, i+1)
+		}
+		if step.Upgrade == nil {
+			return errors.Errorf("migrate: Step %d (%s) has no upgrade function", step.Version, step.Description)
+		}
+	}
+	return nil
+}
+
+// Run upgrades data at version 'current' by running each later step in order.
+// Returns the version reached, which is the last successful step's version if a step fails.
+func Run(steps []Step, current int) (int, error) {
+	if err := Validate(steps); err != nil {
+		return current, err
+	}
+	if current > Latest(steps) {
+		return current, errors.Wrapf(ErrNewerVersion, "version %d, latest is %d", current, Latest(steps))
+	}
+	for _, step := range steps {
+		if step.Version <= current {
+			continue
+		}
+		if err := step.Upgrade(); err != nil {
+			return current, errors.Wrapf(err, "migrate: Failed upgrading to version %d (%s)", step.Version, step.Description)
+		}
+		current = step.Version
+	}
+	return current, nil
+}
@@ -0,0 +1,92 @@
+package migrate
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordSteps(ran *[]int, versions ...int) []Step {
+	var steps []Step
+	for _, version := range versions {
+		version := version
+		steps = append(steps, Step{
+			Version:     version,
+			Description: "test step",
+			Upgrade: func() error {
+				*ran = append(*ran, version)
+				return nil
+			},
+		})
+	}
+	return steps
+}
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		description string
+		current     int
+		expectRan   []int
+		expectErr   error
+	}{
+		{
+			description: "new data",
+			current:     0,
+			expectRan:   []int{1, 2, 3},
+		},
+		{
+			description: "partially upgraded",
+			current:     1,
+			expectRan:   []int{2, 3},
+		},
+		{
+			description: "up to date",
+			current:     3,
+		},
+		{
+			description: "newer version",
+			current:     4,
+			expectErr:   ErrNewerVersion,
+		},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			var ran []int
+			version, err := Run(recordSteps(&ran, 1, 2, 3), tc.current)
+			if tc.expectErr != nil {
+				assert.Equal(t, tc.expectErr, errors.Cause(err))
+				assert.Equal(t, tc.current, version)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 3, version)
+			assert.Equal(t, tc.expectRan, ran)
+		})
+	}
+}
+
+func TestRunFailedStep(t *testing.T) {
+	var ran []int
+	steps := recordSteps(&ran, 1, 2, 3)
+	stepErr := errors.New("some error")
+	steps[1].Upgrade = func() error { return stepErr }
+
+	version, err := Run(steps, 0)
+	assert.Equal(t, stepErr, errors.Cause(err))
+	assert.Equal(t, 1, version)
+	assert.Equal(t, []int{1}, ran)
+}
+
+func TestValidate(t *testing.T) {
+	var ran []int
+	assert.NoError(t, Validate(nil))
+	assert.NoError(t, Validate(recordSteps(&ran, 1, 2)))
+	assert.Error(t, Validate(recordSteps(&ran, 2)))
+	assert.Error(t, Validate(recordSteps(&ran, 1, 3)))
+	assert.Error(t, Validate([]Step{{Version: 1}}))
+
+	_, err := Run(recordSteps(&ran, 2, 1), 0)
+	assert.Error(t, err)
+	assert.Empty(t, ran)
+}
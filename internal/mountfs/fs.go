@@ -10,14 +10,16 @@ import (
 	"time"
 
 	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/fswatch"
 	"github.com/johnstarich/go-wasm/log"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
 type Fs struct {
-	mounts []mount // When accessing mounts, always copy the slice ref. Changes must always re-slice and re-assign, never mutate
-	mu     sync.RWMutex
+	mounts   []mount // When accessing mounts, always copy the slice ref. Changes must always re-slice and re-assign, never mutate
+	mu       sync.RWMutex
+	watchers fswatch.Watchers
 }
 
 type mount struct {
@@ -86,10 +88,54 @@ func (m *Fs) Mount(path string, fs afero.Fs) error {
 	return nil
 }
 
+// Unmount removes the mount at 'path', leaving its data intact. Fails if another mount is inside it.
+func (m *Fs) Unmount(path string) error {
+	path = fsutil.NormalizePath(path)
+	if path == afero.FilePathSeparator {
+		return &os.PathError{Op: "unmount", Path: path, Err: syscall.EBUSY}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var remaining []mount
+	found := false
+	for _, mount := range m.mounts {
+		switch {
+		case mount.path == path:
+			found = true
+		case strings.HasPrefix(mount.path, path+afero.FilePathSeparator):
+			return &os.PathError{Op: "unmount", Path: path, Err: syscall.EBUSY}
+		default:
+			remaining = append(remaining, mount)
+		}
+	}
+	if !found {
+		return &os.PathError{Op: "unmount", Path: path, Err: syscall.EINVAL}
+	}
+	m.mounts = remaining
+	return nil
+}
+
 func (m *Fs) FSForPath(path string) afero.Fs {
 	return mountedFs{m.mountForPath(path)}
 }
 
+// MountPath returns the path of the mount containing 'path'
+func (m *Fs) MountPath(path string) string {
+	return m.mountForPath(path).path
+}
+
+// Mounted returns the file system mounted at exactly 'path', or nil if there isn't one
+func (m *Fs) Mounted(path string) afero.Fs {
+	path = fsutil.NormalizePath(path)
+	mounts := m.mounts
+	for _, mount := range mounts {
+		if mount.path == path {
+			return mount.fs
+		}
+	}
+	return nil
+}
+
 func (m *Fs) mountForPath(path string) mount {
 	path = fsutil.NormalizePath(path)
 	mounts := m.mounts // copy slice for consistent reads
@@ -102,15 +148,22 @@ func (m *Fs) mountForPath(path string) mount {
 }
 
 func (m *Fs) Create(name string) (afero.File, error) {
-	return m.FSForPath(name).Create(name)
+	return m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
 
 func (m *Fs) Mkdir(name string, perm os.FileMode) error {
-	return m.FSForPath(name).Mkdir(name, perm)
+	err := m.FSForPath(name).Mkdir(name, perm)
+	if err == nil {
+		m.emit(fswatch.Create, name)
+	}
+	return err
 }
 
 func (m *Fs) MkdirAll(path string, perm os.FileMode) error {
-	return m.FSForPath(path).MkdirAll(path, perm)
+	missingPaths := m.missingPaths(path)
+	err := m.FSForPath(path).MkdirAll(path, perm)
+	m.emitCreated(missingPaths)
+	return err
 }
 
 func (m *Fs) Open(name string) (afero.File, error) {
@@ -118,7 +171,20 @@ func (m *Fs) Open(name string) (afero.File, error) {
 }
 
 func (m *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
-	return m.FSForPath(name).OpenFile(name, flag, perm)
+	var missingPaths []string
+	if flag&os.O_CREATE != 0 {
+		missingPaths = m.missingPaths(name)
+	}
+	file, err := m.FSForPath(name).OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	created := len(missingPaths) > 0
+	if created {
+		m.emit(fswatch.Create, name)
+	}
+	truncated := !created && flag&os.O_TRUNC != 0
+	return m.watchFile(fsutil.NormalizePath(name), file, flag, truncated), nil
 }
 
 func (m *Fs) Remove(name string) error {
@@ -126,11 +192,20 @@ func (m *Fs) Remove(name string) error {
 	if mount.path == name {
 		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOSYS}
 	}
-	return mountedFs{mount}.Remove(name)
+	err := mountedFs{mount}.Remove(name)
+	if err == nil {
+		m.emit(fswatch.Remove, name)
+	}
+	return err
 }
 
 func (m *Fs) RemoveAll(path string) error {
-	return m.FSForPath(path).RemoveAll(path)
+	_, statErr := m.Stat(path)
+	err := m.FSForPath(path).RemoveAll(path)
+	if err == nil && statErr == nil {
+		m.emit(fswatch.Remove, path)
+	}
+	return err
 }
 
 func (m *Fs) Rename(oldname, newname string) error {
@@ -151,7 +226,11 @@ func (m *Fs) Rename(oldname, newname string) error {
 			log.Warnf("Attempted rename directory across mounts: %#v != %#v\nat paths: %q -> %q", oldMount, newMount, oldname, newname)
 			return &os.PathError{Op: "rename", Path: oldname, Err: syscall.EXDEV}
 		}
-		return oldFs.Rename(oldname, newname)
+		err := oldFs.Rename(oldname, newname)
+		if err == nil {
+			m.emitRename(oldname, newname)
+		}
+		return err
 	}
 
 	oldFile, err := oldFs.Open(oldname)
@@ -170,7 +249,40 @@ func (m *Fs) Rename(oldname, newname string) error {
 	}
 
 	oldFile.Close()
-	return oldFs.Remove(oldname)
+	err = oldFs.Remove(oldname)
+	if err == nil {
+		m.emitRename(oldname, newname)
+	}
+	return err
+}
+
+// Copy copies the file 'oldname' to 'newname'. Copies within a mount use the mounted file system's Copy, if it has one.
+func (m *Fs) Copy(oldname, newname string) error {
+	m.mu.RLock()
+	oldMount := m.mountForPath(oldname)
+	newMount := m.mountForPath(newname)
+	m.mu.RUnlock()
+
+	missingPaths := m.missingPaths(newname)
+	var err error
+	if oldMount.path == newMount.path {
+		err = fsutil.Copy(mountedFs{oldMount}, oldname, newname)
+	} else {
+		err = fsutil.CopyData(mountedFs{oldMount}, mountedFs{newMount}, oldname, newname)
+	}
+	if err != nil {
+		return err
+	}
+	if len(missingPaths) > 0 {
+		m.emit(fswatch.Create, newname)
+	} else {
+		m.emit(fswatch.Write, newname)
+	}
+	return nil
+}
+
+func (m *Fs) emitRename(oldname, newname string) {
+	m.watchers.Emit(fswatch.Event{Op: fswatch.Rename, Path: newname, OldPath: oldname})
 }
 
 func (m *Fs) Stat(name string) (os.FileInfo, error) {
@@ -185,6 +297,10 @@ func (m *Fs) Chmod(name string, mode os.FileMode) error {
 	return m.FSForPath(name).Chmod(name, mode)
 }
 
+func (m *Fs) Chown(name string, uid, gid int) error {
+	return fsutil.Chown(m.FSForPath(name), name, uid, gid)
+}
+
 func (m *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return m.FSForPath(name).Chtimes(name, atime, mtime)
 }
@@ -0,0 +1,66 @@
+package mountfs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmount(t *testing.T) {
+	fs := New(afero.NewMemMapFs())
+	require.NoError(t, fs.MkdirAll("/mnt/inner", 0755))
+	mnt := afero.NewMemMapFs()
+	require.NoError(t, fs.Mount("/mnt", mnt))
+	require.NoError(t, afero.WriteFile(fs, "/mnt/foo", []byte("foo"), 0644))
+	require.NoError(t, fs.Mkdir("/mnt/inner", 0755))
+	require.NoError(t, fs.Mount("/mnt/inner", afero.NewMemMapFs()))
+
+	assert.Equal(t, &os.PathError{Op: "unmount", Path: "/mnt", Err: syscall.EBUSY}, fs.Unmount("/mnt"))
+	assert.Equal(t, &os.PathError{Op: "unmount", Path: "/", Err: syscall.EBUSY}, fs.Unmount("/"))
+	assert.Equal(t, &os.PathError{Op: "unmount", Path: "/other", Err: syscall.EINVAL}, fs.Unmount("/other"))
+
+	require.NoError(t, fs.Unmount("/mnt/inner/"))
+	require.NoError(t, fs.Unmount("/mnt"))
+	assert.Equal(t, map[string]string{"/": "MemMapFS"}, fs.Mounts())
+	_, err := fs.Stat("/mnt/foo")
+	assert.True(t, os.IsNotExist(err))
+
+	contents, err := afero.ReadFile(mnt, "/foo")
+	assert.NoError(t, err, "Unmounted data should be left intact")
+	assert.Equal(t, "foo", string(contents))
+}
+
+type copierFs struct {
+	afero.Fs
+	copies int
+}
+
+func (c *copierFs) Copy(oldname, newname string) error {
+	c.copies++
+	return fsutil.CopyData(c.Fs, c.Fs, oldname, newname)
+}
+
+func TestCopy(t *testing.T) {
+	fs := New(afero.NewMemMapFs())
+	require.NoError(t, fs.Mkdir("/mnt", 0755))
+	mnt := &copierFs{Fs: afero.NewMemMapFs()}
+	require.NoError(t, fs.Mount("/mnt", mnt))
+	require.NoError(t, afero.WriteFile(fs, "/mnt/foo", []byte("foo"), 0644))
+
+	require.NoError(t, fs.Copy("/mnt/foo", "/mnt/bar"))
+	assert.Equal(t, 1, mnt.copies, "Copies within a mount should use its Copy")
+	require.NoError(t, fs.Copy("/mnt/bar", "/baz"))
+	assert.Equal(t, 1, mnt.copies)
+
+	contents, err := afero.ReadFile(mnt, "/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(contents))
+	contents, err = afero.ReadFile(fs, "/baz")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(contents))
+}
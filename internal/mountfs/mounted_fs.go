@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/johnstarich/go-wasm/internal/fsutil"
 	"github.com/spf13/afero"
 )
 
@@ -52,6 +53,10 @@ func (m mountedFs) Rename(oldname, newname string) error {
 	return m.mount.fs.Rename(m.mountPath(oldname), m.mountPath(newname))
 }
 
+func (m mountedFs) Copy(oldname, newname string) error {
+	return fsutil.Copy(m.mount.fs, m.mountPath(oldname), m.mountPath(newname))
+}
+
 func (m mountedFs) Stat(name string) (os.FileInfo, error) {
 	return m.mount.fs.Stat(m.mountPath(name))
 }
@@ -60,6 +65,10 @@ func (m mountedFs) Chmod(name string, mode os.FileMode) error {
 	return m.mount.fs.Chmod(m.mountPath(name), mode)
 }
 
+func (m mountedFs) Chown(name string, uid, gid int) error {
+	return fsutil.Chown(m.mount.fs, m.mountPath(name), uid, gid)
+}
+
 func (m mountedFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return m.mount.fs.Chtimes(m.mountPath(name), atime, mtime)
 }
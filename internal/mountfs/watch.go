@@ -0,0 +1,139 @@
+package mountfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/blob"
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/fswatch"
+	"github.com/spf13/afero"
+)
+
+var (
+	_ blob.Reader   = &watchedFile{}
+	_ blob.ReaderAt = &watchedFile{}
+	_ blob.Writer   = &watchedFile{}
+	_ blob.WriterAt = &watchedFile{}
+)
+
+// Watch starts watching 'path' for changes across all mounts. See fswatch.Watchers for details.
+func (m *Fs) Watch(path string, recursive bool) *fswatch.Watcher {
+	return m.watchers.Watch(path, recursive)
+}
+
+func (m *Fs) emit(op fswatch.Op, path string) {
+	m.watchers.Emit(fswatch.Event{Op: op, Path: path})
+}
+
+// missingPaths returns 'path' and its parent directories which do not exist yet, in reverse order
+func (m *Fs) missingPaths(path string) []string {
+	if m.watchers.Empty() {
+		return nil
+	}
+	var missing []string
+	for p := fsutil.NormalizePath(path); p != afero.FilePathSeparator; p = filepath.Dir(p) {
+		if _, err := m.Stat(p); err == nil {
+			break
+		}
+		missing = append(missing, p)
+	}
+	return missing
+}
+
+func (m *Fs) emitCreated(missingPaths []string) {
+	for i := len(missingPaths) - 1; i >= 0; i-- {
+		if _, err := m.Stat(missingPaths[i]); err == nil {
+			m.emit(fswatch.Create, missingPaths[i])
+		}
+	}
+}
+
+// watchedFile emits a Write event when a modified file is synced or closed
+type watchedFile struct {
+	afero.File
+	fs   *Fs
+	path string
+
+	mu       sync.Mutex
+	modified bool
+}
+
+func (m *Fs) watchFile(path string, file afero.File, flag int, modified bool) afero.File {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return file
+	}
+	return &watchedFile{File: file, fs: m, path: path, modified: modified}
+}
+
+func (w *watchedFile) markModified(n int, err error) (int, error) {
+	if n > 0 {
+		w.mu.Lock()
+		w.modified = true
+		w.mu.Unlock()
+	}
+	return n, err
+}
+
+func (w *watchedFile) flush() {
+	w.mu.Lock()
+	modified := w.modified
+	w.modified = false
+	w.mu.Unlock()
+	if modified {
+		w.fs.emit(fswatch.Write, w.path)
+	}
+}
+
+func (w *watchedFile) Close() error {
+	err := w.File.Close()
+	if err == nil {
+		w.flush()
+	}
+	return err
+}
+
+func (w *watchedFile) Sync() error {
+	err := w.File.Sync()
+	if err == nil {
+		w.flush()
+	}
+	return err
+}
+
+func (w *watchedFile) Write(p []byte) (n int, err error) {
+	return w.markModified(w.File.Write(p))
+}
+
+func (w *watchedFile) WriteAt(p []byte, off int64) (n int, err error) {
+	return w.markModified(w.File.WriteAt(p, off))
+}
+
+func (w *watchedFile) WriteString(s string) (n int, err error) {
+	return w.markModified(w.File.WriteString(s))
+}
+
+func (w *watchedFile) WriteBlob(p blob.Blob) (n int, err error) {
+	return w.markModified(blob.Write(w.File, p))
+}
+
+func (w *watchedFile) WriteBlobAt(p blob.Blob, off int64) (n int, err error) {
+	return w.markModified(blob.WriteAt(w.File, p, off))
+}
+
+func (w *watchedFile) ReadBlob(length int) (b blob.Blob, n int, err error) {
+	return blob.Read(w.File, length)
+}
+
+func (w *watchedFile) ReadBlobAt(length int, off int64) (b blob.Blob, n int, err error) {
+	return blob.ReadAt(w.File, length, off)
+}
+
+func (w *watchedFile) Truncate(size int64) error {
+	err := w.File.Truncate(size)
+	if err == nil {
+		w.markModified(1, nil)
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package mountfs
+
+import (
+	"testing"
+
+	"github.com/johnstarich/go-wasm/internal/fswatch"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func drainEvents(w *fswatch.Watcher) []fswatch.Event {
+	var events []fswatch.Event
+	for {
+		select {
+		case event := <-w.Events():
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fs := New(afero.NewMemMapFs())
+	require.NoError(t, fs.Mkdir("/mnt", 0755))
+	require.NoError(t, fs.Mount("/mnt", afero.NewMemMapFs()))
+
+	root := fs.Watch("/", false)
+	defer root.Close()
+	recursive := fs.Watch("/", true)
+	defer recursive.Close()
+	file := fs.Watch("/mnt/dir/foo", false)
+	defer file.Close()
+
+	require.NoError(t, fs.MkdirAll("/mnt/dir", 0755))
+	require.NoError(t, afero.WriteFile(fs, "/mnt/dir/foo", []byte("foo"), 0644))
+	require.NoError(t, afero.WriteFile(fs, "/mnt/dir/foo", []byte("bar"), 0644))
+	require.NoError(t, fs.Rename("/mnt/dir/foo", "/baz"))
+	require.NoError(t, fs.RemoveAll("/mnt/dir"))
+
+	assert.Equal(t, []fswatch.Event{
+		{Op: fswatch.Rename, Path: "/baz", OldPath: "/mnt/dir/foo"},
+	}, drainEvents(root))
+	assert.Equal(t, []fswatch.Event{
+		{Op: fswatch.Create, Path: "/mnt/dir"},
+		{Op: fswatch.Create, Path: "/mnt/dir/foo"},
+		{Op: fswatch.Write, Path: "/mnt/dir/foo"},
+		{Op: fswatch.Write, Path: "/mnt/dir/foo"},
+		{Op: fswatch.Rename, Path: "/baz", OldPath: "/mnt/dir/foo"},
+		{Op: fswatch.Remove, Path: "/mnt/dir"},
+	}, drainEvents(recursive))
+	assert.Equal(t, []fswatch.Event{
+		{Op: fswatch.Create, Path: "/mnt/dir/foo"},
+		{Op: fswatch.Write, Path: "/mnt/dir/foo"},
+		{Op: fswatch.Write, Path: "/mnt/dir/foo"},
+		{Op: fswatch.Rename, Path: "/baz", OldPath: "/mnt/dir/foo"},
+		{Op: fswatch.Remove, Path: "/mnt/dir"},
+	}, drainEvents(file))
+
+	require.NoError(t, file.Close())
+	_, open := <-file.Events()
+	assert.False(t, open)
+}
@@ -5,13 +5,17 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+
+	"github.com/johnstarich/go-wasm/internal/fs"
 )
 
 type stater func(string) (os.FileInfo, error)
 
-func lookPath(stat stater, pathVar string, file string) (string, error) {
+type accessChecker func(path string, access os.FileMode) error
+
+func lookPath(stat stater, access accessChecker, pathVar string, file string) (string, error) {
 	if strings.Contains(file, "/") {
-		err := findExecutable(stat, file)
+		err := findExecutable(stat, access, file)
 		if err == nil {
 			return file, nil
 		}
@@ -22,20 +26,20 @@ func lookPath(stat stater, pathVar string, file string) (string, error) {
 			dir = "."
 		}
 		path := filepath.Join(dir, file)
-		if err := findExecutable(stat, path); err == nil {
+		if err := findExecutable(stat, access, path); err == nil {
 			return path, nil
 		}
 	}
 	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
 }
 
-func findExecutable(stat stater, file string) error {
+func findExecutable(stat stater, access accessChecker, file string) error {
 	d, err := stat(file)
 	if err != nil {
 		return err
 	}
-	if m := d.Mode(); !m.IsDir() && m&0111 != 0 {
-		return nil
+	if m := d.Mode(); m.IsDir() || m&0111 == 0 {
+		return os.ErrPermission
 	}
-	return os.ErrPermission
+	return access(file, fs.AccessExecute)
 }
@@ -122,7 +122,7 @@ func (p *process) start() error {
 
 func (p *process) prepExecutable() (command string, err error) {
 	fs := p.Files()
-	command, err = lookPath(fs.Stat, os.Getenv("PATH"), p.command)
+	command, err = lookPath(fs.Stat, fs.Access, os.Getenv("PATH"), p.command)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,325 @@
+package quota
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/spf13/afero"
+)
+
+var _ Limiter = &Fs{}
+
+// Fs tracks usage and enforces quotas for any afero.Fs, like the memory fs.
+// Usage is counted with a full walk the first time it's needed, then tracked incrementally.
+type Fs struct {
+	afero.Fs
+	tracker Tracker
+	mu      sync.Mutex // serializes changes while tracking, so size checks are consistent with writes
+}
+
+// New wraps 'fs' with quota tracking
+func New(fs afero.Fs) *Fs {
+	return &Fs{Fs: fs}
+}
+
+func (q *Fs) Usage() (Usage, error) {
+	err := q.initTracker()
+	return q.tracker.Usage(), err
+}
+
+func (q *Fs) SetLimits(maxBytes, maxInodes int64) error {
+	if err := q.initTracker(); err != nil {
+		return err
+	}
+	q.tracker.SetLimits(maxBytes, maxInodes)
+	return nil
+}
+
+func (q *Fs) initTracker() error {
+	if q.tracker.Initialized() {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.tracker.Initialized() {
+		return nil
+	}
+	bytes, inodes, err := walkUsage(q.Fs, afero.FilePathSeparator)
+	if err != nil {
+		return err
+	}
+	q.tracker.Init(bytes, inodes)
+	return nil
+}
+
+// walkUsage returns the total file sizes and number of files and directories under 'path', including 'path'
+func walkUsage(fs afero.Fs, path string) (bytes, inodes int64, err error) {
+	err = afero.Walk(fs, path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		inodes++
+		if !info.IsDir() {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// lock locks changes if usage is tracked. Returns the unlock func and true if tracking.
+func (q *Fs) lock() (func(), bool) {
+	if !q.tracker.Initialized() {
+		return func() {}, false
+	}
+	q.mu.Lock()
+	return q.mu.Unlock, true
+}
+
+func (q *Fs) Create(name string) (afero.File, error) {
+	return q.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (q *Fs) Mkdir(name string, perm os.FileMode) error {
+	unlock, tracking := q.lock()
+	defer unlock()
+	if !tracking {
+		return q.Fs.Mkdir(name, perm)
+	}
+	if err := q.tracker.Reserve(0, 1); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	err := q.Fs.Mkdir(name, perm)
+	if err != nil {
+		q.tracker.Release(0, 1)
+	}
+	return err
+}
+
+func (q *Fs) MkdirAll(path string, perm os.FileMode) error {
+	unlock, tracking := q.lock()
+	defer unlock()
+	if !tracking {
+		return q.Fs.MkdirAll(path, perm)
+	}
+	var missing int64
+	for dir := fsutil.NormalizePath(path); ; dir = filepath.Dir(dir) {
+		if _, err := q.Fs.Stat(dir); err == nil {
+			break
+		}
+		missing++
+		if dir == afero.FilePathSeparator {
+			break
+		}
+	}
+	if err := q.tracker.Reserve(0, missing); err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	err := q.Fs.MkdirAll(path, perm)
+	if err != nil {
+		q.tracker.Release(0, missing)
+	}
+	return err
+}
+
+func (q *Fs) Open(name string) (afero.File, error) {
+	return q.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (q *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	unlock, tracking := q.lock()
+	defer unlock()
+	if !tracking {
+		f, err := q.Fs.OpenFile(name, flag, perm)
+		return q.wrapFile(f, flag), err
+	}
+
+	info, statErr := q.Fs.Stat(name)
+	var truncated int64
+	if statErr == nil && !info.IsDir() && flag&os.O_TRUNC != 0 {
+		truncated = info.Size() // some file infos read the live size, so record it before truncating
+	}
+	created := os.IsNotExist(statErr) && flag&os.O_CREATE != 0
+	if created {
+		if err := q.tracker.Reserve(0, 1); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	f, err := q.Fs.OpenFile(name, flag, perm)
+	switch {
+	case err != nil && created:
+		q.tracker.Release(0, 1)
+	case err == nil:
+		q.tracker.Release(truncated, 0)
+	}
+	return q.wrapFile(f, flag), err
+}
+
+func (q *Fs) Remove(name string) error {
+	unlock, tracking := q.lock()
+	defer unlock()
+	if !tracking {
+		return q.Fs.Remove(name)
+	}
+	info, err := q.Fs.Stat(name)
+	if err != nil {
+		return q.Fs.Remove(name) // let the underlying fs return a consistent error
+	}
+	var size int64
+	if !info.IsDir() {
+		size = info.Size()
+	}
+	err = q.Fs.Remove(name)
+	if err == nil {
+		q.tracker.Release(size, 1)
+	}
+	return err
+}
+
+func (q *Fs) RemoveAll(path string) error {
+	unlock, tracking := q.lock()
+	defer unlock()
+	if !tracking {
+		return q.Fs.RemoveAll(path)
+	}
+	bytes, inodes, walkErr := walkUsage(q.Fs, path)
+	err := q.Fs.RemoveAll(path)
+	if err == nil && walkErr == nil {
+		q.tracker.Release(bytes, inodes)
+	}
+	return err
+}
+
+func (q *Fs) Rename(oldname, newname string) error {
+	unlock, tracking := q.lock()
+	defer unlock()
+	if !tracking {
+		return q.Fs.Rename(oldname, newname)
+	}
+	if fsutil.NormalizePath(oldname) == fsutil.NormalizePath(newname) {
+		return q.Fs.Rename(oldname, newname)
+	}
+	replaced, statErr := q.Fs.Stat(newname)
+	var replacedSize int64
+	if statErr == nil && !replaced.IsDir() {
+		replacedSize = replaced.Size()
+	}
+	err := q.Fs.Rename(oldname, newname)
+	if err == nil && statErr == nil {
+		q.tracker.Release(replacedSize, 1)
+	}
+	return err
+}
+
+func (q *Fs) Name() string {
+	return "quota.Fs(" + q.Fs.Name() + ")"
+}
+
+type file struct {
+	afero.File
+	fs   *Fs
+	flag int
+}
+
+func (q *Fs) wrapFile(f afero.File, flag int) afero.File {
+	if f == nil || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return f
+	}
+	return &file{File: f, fs: q, flag: flag}
+}
+
+// reserveGrowth reserves bytes needed to write 'length' bytes at 'offset'. An offset < 0 uses the current offset.
+// Returns the file's size before writing and the number of bytes reserved.
+func (f *file) reserveGrowth(op string, offset, length int64) (size, reserved int64, err error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size = info.Size()
+	if f.flag&os.O_APPEND != 0 {
+		offset = size
+	} else if offset < 0 {
+		offset, err = f.File.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	growth := offset + length - size
+	if growth <= 0 {
+		return size, 0, nil
+	}
+	if err := f.fs.tracker.Reserve(growth, 0); err != nil {
+		return 0, 0, &os.PathError{Op: op, Path: f.Name(), Err: err}
+	}
+	return size, growth, nil
+}
+
+// settleGrowth releases any reserved bytes a write didn't use, like after a short or failed write
+func (f *file) settleGrowth(size, reserved int64) {
+	if reserved == 0 {
+		return
+	}
+	info, err := f.File.Stat()
+	if err != nil {
+		return
+	}
+	if unused := reserved - (info.Size() - size); unused > 0 {
+		f.fs.tracker.Release(unused, 0)
+	}
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	unlock, tracking := f.fs.lock()
+	defer unlock()
+	if !tracking {
+		return f.File.Write(p)
+	}
+	size, reserved, err := f.reserveGrowth("write", -1, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer f.settleGrowth(size, reserved)
+	return f.File.Write(p)
+}
+
+func (f *file) WriteAt(p []byte, off int64) (int, error) {
+	unlock, tracking := f.fs.lock()
+	defer unlock()
+	if !tracking || off < 0 {
+		return f.File.WriteAt(p, off)
+	}
+	size, reserved, err := f.reserveGrowth("write", off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer f.settleGrowth(size, reserved)
+	return f.File.WriteAt(p, off)
+}
+
+func (f *file) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *file) Truncate(size int64) error {
+	unlock, tracking := f.fs.lock()
+	defer unlock()
+	if !tracking {
+		return f.File.Truncate(size)
+	}
+	info, err := f.File.Stat()
+	if err != nil {
+		return err
+	}
+	delta := size - info.Size()
+	if err := f.fs.tracker.Reserve(delta, 0); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.Name(), Err: err}
+	}
+	err = f.File.Truncate(size)
+	if err != nil {
+		f.fs.tracker.Release(delta, 0)
+	}
+	return err
+}
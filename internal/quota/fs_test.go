@@ -0,0 +1,77 @@
+package quota
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/johnstarich/go-wasm/internal/fstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFs(t *testing.T) {
+	fs := New(afero.NewMemMapFs())
+	require.NoError(t, fs.SetLimits(1<<20, 0)) // enable tracking with room for all tests
+
+	cleanup := func() error {
+		f, err := fs.Open("/")
+		if err != nil {
+			return err
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := fs.RemoveAll("/" + name); err != nil {
+				return err
+			}
+		}
+		usage, err := fs.Usage()
+		if err != nil {
+			return err
+		}
+		if usage.Bytes != 0 || usage.Inodes != 1 {
+			t.Errorf("Usage should be empty after cleanup: %+v", usage)
+		}
+		return nil
+	}
+	fstest.Run(t, fs, cleanup)
+}
+
+func TestFsUsage(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mem, "/existing", []byte("12345"), 0600))
+	fs := New(mem)
+
+	usage, err := fs.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Bytes: 5, Inodes: 2}, usage)
+
+	require.NoError(t, fs.SetLimits(10, 5))
+	require.NoError(t, fs.MkdirAll("/a/b", 0700))
+	f, err := fs.Create("/a/b/c")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("12345"))
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("6"))
+	assert.Equal(t, &os.PathError{Op: "write", Path: "/a/b/c", Err: syscall.ENOSPC}, err)
+	_, err = f.WriteAt([]byte("abc"), 1)
+	assert.NoError(t, err, "Overwriting existing bytes doesn't need more space")
+	require.NoError(t, f.Close())
+
+	_, err = fs.Create("/d")
+	assert.Equal(t, &os.PathError{Op: "open", Path: "/d", Err: syscall.ENOSPC}, err)
+	usage, err = fs.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Bytes: 10, Inodes: 5, MaxBytes: 10, MaxInodes: 5}, usage)
+
+	require.NoError(t, fs.Rename("/a/b/c", "/existing"))
+	require.NoError(t, fs.RemoveAll("/a"))
+	usage, err = fs.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, Usage{Bytes: 5, Inodes: 2, MaxBytes: 10, MaxInodes: 5}, usage)
+}
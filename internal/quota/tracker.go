@@ -0,0 +1,92 @@
+// Package quota tracks file system usage and enforces byte and inode limits
+package quota
+
+import (
+	"sync"
+	"syscall"
+)
+
+// Usage is a file system's current usage and limits. Limits of 0 are unlimited.
+type Usage struct {
+	Bytes     int64
+	Inodes    int64
+	MaxBytes  int64
+	MaxInodes int64
+}
+
+// Limiter is implemented by file systems which track usage and enforce quotas
+type Limiter interface {
+	// Usage returns the current usage and limits
+	Usage() (Usage, error)
+	// SetLimits sets the maximum bytes and inodes. Limits <= 0 are unlimited.
+	SetLimits(maxBytes, maxInodes int64) error
+}
+
+// Tracker counts bytes and inodes used, failing reservations which exceed limits with ENOSPC.
+// Tracking starts once Init is called, before then all reservations succeed. Safe for concurrent use.
+type Tracker struct {
+	mu          sync.Mutex
+	initialized bool
+	usage       Usage
+}
+
+// Initialized returns true if Init has been called
+func (t *Tracker) Initialized() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.initialized
+}
+
+// Init starts tracking with the current 'bytes' and 'inodes' in use
+func (t *Tracker) Init(bytes, inodes int64) {
+	t.mu.Lock()
+	t.initialized = true
+	t.usage.Bytes = bytes
+	t.usage.Inodes = inodes
+	t.mu.Unlock()
+}
+
+// SetLimits sets the maximum bytes and inodes. Limits <= 0 are unlimited.
+func (t *Tracker) SetLimits(maxBytes, maxInodes int64) {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	if maxInodes < 0 {
+		maxInodes = 0
+	}
+	t.mu.Lock()
+	t.usage.MaxBytes = maxBytes
+	t.usage.MaxInodes = maxInodes
+	t.mu.Unlock()
+}
+
+// Usage returns the current usage and limits
+func (t *Tracker) Usage() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage
+}
+
+// Reserve adds 'bytes' and 'inodes' to the usage, or returns ENOSPC if either would exceed its limit.
+// Negative values release usage and always succeed.
+func (t *Tracker) Reserve(bytes, inodes int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.initialized {
+		return nil
+	}
+	newBytes, newInodes := t.usage.Bytes+bytes, t.usage.Inodes+inodes
+	if bytes > 0 && t.usage.MaxBytes > 0 && newBytes > t.usage.MaxBytes {
+		return syscall.ENOSPC
+	}
+	if inodes > 0 && t.usage.MaxInodes > 0 && newInodes > t.usage.MaxInodes {
+		return syscall.ENOSPC
+	}
+	t.usage.Bytes, t.usage.Inodes = newBytes, newInodes
+	return nil
+}
+
+// Release removes 'bytes' and 'inodes' from the usage
+func (t *Tracker) Release(bytes, inodes int64) {
+	_ = t.Reserve(-bytes, -inodes)
+}
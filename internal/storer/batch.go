@@ -10,20 +10,35 @@ type BatchGetter interface {
 	GetFileRecords(paths []string, dest []*FileRecord) []error
 }
 
+// GetFiles returns files for each of 'paths', like GetFile
 func (f *fileStorer) GetFiles(paths ...string) ([]*File, []error) {
-	fileRecords := make([]*FileRecord, len(paths))
 	files := make([]*File, len(paths))
+	var fetchPaths []string
+	var fetchRecords []*FileRecord
+	var fetchIndexes []int
 	for i := range files {
 		path := fsutil.NormalizePath(paths[i])
+		if data := f.openData(path); data != nil {
+			files[i] = &File{fileData: data}
+			continue
+		}
 		files[i] = &File{
 			fileData: &fileData{
 				path:   path,
 				storer: f,
 			},
 		}
-		fileRecords[i] = &files[i].FileRecord
+		fetchPaths = append(fetchPaths, paths[i])
+		fetchRecords = append(fetchRecords, &files[i].FileRecord)
+		fetchIndexes = append(fetchIndexes, i)
+	}
+	errs := make([]error, len(paths))
+	if len(fetchPaths) == 0 {
+		return files, errs
+	}
+	for i, err := range GetFileRecords(f.Storer, fetchPaths, fetchRecords) {
+		errs[fetchIndexes[i]] = err
 	}
-	errs := GetFileRecords(f.Storer, paths, fileRecords)
 	return files, errs
 }
 
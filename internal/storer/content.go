@@ -0,0 +1,327 @@
+package storer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/blob"
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+const contentRefsSuffix = ".refs"
+
+var (
+	_ Storer        = &ContentAddressed{}
+	_ BatchGetter   = &ContentAddressed{}
+	_ Copier        = &ContentAddressed{}
+	_ WriteDeferrer = &ContentAddressed{}
+)
+
+// ContentAddressed is a Storer which stores each unique file's data only once.
+// File data is keyed by its hash and reference counted in 'contents', while 'paths' maps each path to its data's hash.
+//
+// Saving a record read from another path, like renames and copies, only writes metadata.
+// Changed data is hashed in full when a file is closed or synced, so prefer this for write-once files like build and module caches.
+type ContentAddressed struct {
+	paths    Storer
+	contents Storer
+	mu       sync.Mutex // guards reference counts
+}
+
+// NewContentAddressed returns a deduplicating Storer, which stores path records in 'paths' and file data in 'contents'
+func NewContentAddressed(paths, contents Storer) *ContentAddressed {
+	return &ContentAddressed{
+		paths:    paths,
+		contents: contents,
+	}
+}
+
+func contentPath(hash string) string {
+	return afero.FilePathSeparator + hash
+}
+
+func contentRefsPath(hash string) string {
+	return contentPath(hash) + contentRefsSuffix
+}
+
+func (c *ContentAddressed) GetFileRecord(path string, dest *FileRecord) error {
+	return c.GetFileRecords([]string{path}, []*FileRecord{dest})[0]
+}
+
+func (c *ContentAddressed) GetFileRecords(paths []string, dest []*FileRecord) []error {
+	pointers := make([]*FileRecord, len(paths))
+	for i := range pointers {
+		pointers[i] = new(FileRecord)
+	}
+	errs := GetFileRecords(c.paths, paths, pointers)
+
+	var contentPaths []string
+	var contentIndexes []int
+	var contentHashes []string
+	for i, pointer := range pointers {
+		if errs[i] != nil {
+			continue
+		}
+		dest[i].CopyMetadata(pointer)
+		dest[i].DirNamesFn = pointer.DirNamesFn
+		if pointer.Mode.IsDir() {
+			dest[i].DataFn = pointer.DataFn
+			continue
+		}
+		hash := string(pointer.Data().Bytes())
+		contentPaths = append(contentPaths, contentPath(hash))
+		contentIndexes = append(contentIndexes, i)
+		contentHashes = append(contentHashes, hash)
+	}
+
+	contents := make([]*FileRecord, len(contentPaths))
+	for i := range contents {
+		contents[i] = new(FileRecord)
+	}
+	contentErrs := GetFileRecords(c.contents, contentPaths, contents)
+	for i, content := range contents {
+		destIndex, hash := contentIndexes[i], contentHashes[i]
+		if err := contentErrs[i]; err != nil {
+			errs[destIndex] = errors.Wrapf(err, "Failed to load content %s for path %s", hash, paths[destIndex])
+			continue
+		}
+		dest[destIndex].InitialSize = content.InitialSize
+		dest[destIndex].DataFn = newContentBlobFn(hash, content)
+	}
+	return errs
+}
+
+func (c *ContentAddressed) SetFileRecord(path string, src *FileRecord) error {
+	path = fsutil.NormalizePath(path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	oldHash, err := c.getHash(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if src == nil || src.Mode.IsDir() {
+		err := c.paths.SetFileRecord(path, src)
+		if err != nil {
+			return err
+		}
+		return c.unref(oldHash)
+	}
+
+	data := src.Data()
+	hash := hashBlob(data)
+	if hash != oldHash {
+		if err := c.ref(hash, data); err != nil {
+			return err
+		}
+	}
+	pointer := &FileRecord{
+		DataFn: func() (blob.Blob, error) {
+			return blob.NewFromBytes([]byte(hash)), nil
+		},
+	}
+	pointer.CopyMetadata(src)
+	pointer.InitialSize = int64(len(hash))
+	err = c.paths.SetFileRecord(path, pointer)
+	if hash == oldHash {
+		return err
+	}
+	if err != nil {
+		return c.unref(hash)
+	}
+	return c.unref(oldHash)
+}
+
+// CopyFileRecord copies 'oldPath' to 'newPath' by referencing the same data
+func (c *ContentAddressed) CopyFileRecord(oldPath, newPath string) error {
+	var record FileRecord
+	if err := c.GetFileRecord(oldPath, &record); err != nil {
+		return err
+	}
+	return c.SetFileRecord(newPath, &record)
+}
+
+// DeferWrites returns true, since each save hashes the whole file
+func (c *ContentAddressed) DeferWrites() bool {
+	return true
+}
+
+// getHash returns the content hash for 'path' or "" if 'path' is a directory
+func (c *ContentAddressed) getHash(path string) (string, error) {
+	var pointer FileRecord
+	err := c.paths.GetFileRecord(path, &pointer)
+	if err != nil || pointer.Mode.IsDir() {
+		return "", err
+	}
+	return string(pointer.Data().Bytes()), nil
+}
+
+func hashBlob(b blob.Blob) string {
+	if content, ok := b.(*contentBlob); ok && !content.changed.Load() {
+		return content.hash
+	}
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *ContentAddressed) refCount(hash string) (int, error) {
+	var refs FileRecord
+	err := c.contents.GetFileRecord(contentRefsPath(hash), &refs)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(refs.Data().Bytes()))
+}
+
+func (c *ContentAddressed) setRefCount(hash string, count int) error {
+	if count == 0 {
+		return c.contents.SetFileRecord(contentRefsPath(hash), nil)
+	}
+	return c.contents.SetFileRecord(contentRefsPath(hash), &FileRecord{
+		DataFn: func() (blob.Blob, error) {
+			return blob.NewFromBytes([]byte(strconv.Itoa(count))), nil
+		},
+		ModTime: time.Now(),
+		Mode:    0600,
+	})
+}
+
+// ref adds a reference to 'hash', storing 'data' if it's the first one
+func (c *ContentAddressed) ref(hash string, data blob.Blob) error {
+	count, err := c.refCount(hash)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		err := c.contents.SetFileRecord(contentPath(hash), &FileRecord{
+			DataFn: func() (blob.Blob, error) {
+				return data, nil
+			},
+			ModTime: time.Now(),
+			Mode:    0400,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.setRefCount(hash, count+1)
+}
+
+// unref removes a reference to 'hash', deleting its data when no references remain
+func (c *ContentAddressed) unref(hash string) error {
+	if hash == "" {
+		return nil
+	}
+	count, err := c.refCount(hash)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		if err := c.contents.SetFileRecord(contentPath(hash), nil); err != nil {
+			return err
+		}
+		return c.setRefCount(hash, 0)
+	}
+	return c.setRefCount(hash, count-1)
+}
+
+// RefProblemKind is a type of inconsistency found by CheckRefs
+type RefProblemKind string
+
+const (
+	// RefCountMismatch means a content's reference count doesn't match the number of paths pointing to it
+	RefCountMismatch RefProblemKind = "ref-count-mismatch"
+	// RefMissingContent means paths point to content which isn't stored
+	RefMissingContent RefProblemKind = "missing-content"
+	// RefUnreferenced means content is stored, but no paths point to it
+	RefUnreferenced RefProblemKind = "unreferenced-content"
+)
+
+// RefProblem is an inconsistency for the content with Hash
+type RefProblem struct {
+	Kind   RefProblemKind
+	Hash   string
+	Detail string
+}
+
+// CheckRefs compares stored reference counts with the number of 'paths' pointing to each content hash.
+// 'paths' are the file paths in the paths Storer, and 'contentPaths' are all paths in the contents Storer.
+// If 'repair' is true, reference counts are corrected and unreferenced content is removed. Missing content can't be repaired.
+func (c *ContentAddressed) CheckRefs(paths, contentPaths []string, repair bool) ([]RefProblem, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	refs := make(map[string]int)
+	for _, path := range paths {
+		hash, err := c.getHash(path)
+		if err != nil {
+			return nil, err
+		}
+		if hash != "" {
+			refs[hash]++
+		}
+	}
+	stored := make(map[string]bool)
+	hashSet := make(map[string]bool)
+	for hash := range refs {
+		hashSet[hash] = true
+	}
+	for _, path := range contentPaths {
+		name := strings.TrimPrefix(fsutil.NormalizePath(path), afero.FilePathSeparator)
+		hash := strings.TrimSuffix(name, contentRefsSuffix)
+		if hash == name {
+			stored[hash] = true
+		}
+		hashSet[hash] = true
+	}
+	hashes := make([]string, 0, len(hashSet))
+	for hash := range hashSet {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	var problems []RefProblem
+	for _, hash := range hashes {
+		count, err := c.refCount(hash)
+		if err != nil {
+			return problems, err
+		}
+		expected := refs[hash]
+		switch {
+		case expected > 0 && !stored[hash]:
+			problems = append(problems, RefProblem{Kind: RefMissingContent, Hash: hash, Detail: fmt.Sprintf("%d paths point to content which isn't stored", expected)})
+			continue
+		case expected == 0 && (stored[hash] || count != 0):
+			problems = append(problems, RefProblem{Kind: RefUnreferenced, Hash: hash, Detail: fmt.Sprintf("no paths point to content with %d references", count)})
+			if repair {
+				if err := c.contents.SetFileRecord(contentPath(hash), nil); err != nil && !os.IsNotExist(err) {
+					return problems, err
+				}
+				if err := c.setRefCount(hash, 0); err != nil && !os.IsNotExist(err) {
+					return problems, err
+				}
+			}
+			continue
+		case expected != count:
+			problems = append(problems, RefProblem{Kind: RefCountMismatch, Hash: hash, Detail: fmt.Sprintf("%d references are recorded, but %d paths point to it", count, expected)})
+			if repair {
+				if err := c.setRefCount(hash, expected); err != nil {
+					return problems, err
+				}
+			}
+		}
+	}
+	return problems, nil
+}
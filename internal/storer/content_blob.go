@@ -0,0 +1,80 @@
+package storer
+
+import (
+	"sync"
+
+	"github.com/johnstarich/go-wasm/internal/blob"
+	"go.uber.org/atomic"
+)
+
+var _ blob.Blob = &contentBlob{}
+
+// contentBlob is file data from content-addressed storage. Data is loaded on first use.
+// Remembers its hash until changed, so saving an unchanged blob to another path skips hashing and loading data.
+type contentBlob struct {
+	hash    string
+	changed atomic.Bool
+	length  int64
+
+	dataOnce sync.Once
+	data     blob.Blob
+	dataFn   func() (blob.Blob, error)
+}
+
+func newContentBlobFn(hash string, content *FileRecord) func() (blob.Blob, error) {
+	return func() (blob.Blob, error) {
+		return &contentBlob{
+			hash:   hash,
+			length: content.InitialSize,
+			dataFn: content.DataFn,
+		}, nil
+	}
+}
+
+func (c *contentBlob) load() blob.Blob {
+	var err error
+	c.dataOnce.Do(func() {
+		c.data, err = c.dataFn()
+	})
+	if err != nil {
+		panic(err) // data fn should never fail. IDB data will only fail if types are wrong
+	}
+	return c.data
+}
+
+func (c *contentBlob) Bytes() []byte {
+	return c.load().Bytes()
+}
+
+func (c *contentBlob) Len() int {
+	if !c.changed.Load() {
+		return int(c.length)
+	}
+	return c.load().Len()
+}
+
+func (c *contentBlob) View(start, end int64) (blob.Blob, error) {
+	return c.load().View(start, end)
+}
+
+func (c *contentBlob) Slice(start, end int64) (blob.Blob, error) {
+	return c.load().Slice(start, end)
+}
+
+func (c *contentBlob) Set(w blob.Blob, off int64) (n int, err error) {
+	data := c.load()
+	c.changed.Store(true)
+	return data.Set(w, off)
+}
+
+func (c *contentBlob) Grow(off int64) error {
+	data := c.load()
+	c.changed.Store(true)
+	return data.Grow(off)
+}
+
+func (c *contentBlob) Truncate(size int64) {
+	data := c.load()
+	c.changed.Store(true)
+	data.Truncate(size)
+}
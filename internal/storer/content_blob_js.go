@@ -0,0 +1,9 @@
+// +build js
+
+package storer
+
+import "syscall/js"
+
+func (c *contentBlob) JSValue() js.Value {
+	return c.load().JSValue()
+}
@@ -0,0 +1,182 @@
+package storer
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/johnstarich/go-wasm/internal/blob"
+	"github.com/johnstarich/go-wasm/internal/fstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (m *mapStorer) paths() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var paths []string
+	for path := range m.records {
+		if path != afero.FilePathSeparator {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (m *mapStorer) data(path string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return string(m.records[path].data)
+}
+
+func TestContentAddressedDedup(t *testing.T) {
+	paths, contents := newMapStorer(), newMapStorer()
+	fs := New(NewContentAddressed(paths, contents))
+
+	require.NoError(t, afero.WriteFile(fs, "/foo", []byte("hello"), 0600))
+	require.NoError(t, afero.WriteFile(fs, "/bar", []byte("hello"), 0600))
+	const helloHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	assert.Equal(t, []string{"/" + helloHash, "/" + helloHash + ".refs"}, contents.paths())
+	assert.Equal(t, "2", contents.data("/"+helloHash+".refs"))
+	assert.Equal(t, helloHash, paths.data("/foo"))
+
+	info, err := fs.Stat("/bar")
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, info.Size())
+	data, err := afero.ReadFile(fs, "/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	require.NoError(t, afero.WriteFile(fs, "/bar", []byte("world"), 0600))
+	assert.Equal(t, "1", contents.data("/"+helloHash+".refs"))
+	assert.Len(t, contents.paths(), 4)
+
+	require.NoError(t, fs.Remove("/foo"))
+	require.NoError(t, fs.Remove("/bar"))
+	assert.Empty(t, contents.paths())
+}
+
+func TestContentAddressedCopy(t *testing.T) {
+	paths, contents := newMapStorer(), newMapStorer()
+	s := NewContentAddressed(paths, contents)
+	fs := New(s)
+	bigData := strings.Repeat("a", 1<<20)
+	require.NoError(t, afero.WriteFile(fs, "/foo", []byte(bigData), 0600))
+	require.NoError(t, fs.Mkdir("/dir", 0700))
+
+	var record FileRecord
+	require.NoError(t, s.GetFileRecord("/foo", &record))
+	require.NoError(t, s.SetFileRecord("/dir/bar", &record))
+	assert.Nil(t, record.Data().(*contentBlob).data, "Copying unchanged data should only copy metadata")
+	require.NoError(t, s.CopyFileRecord("/foo", "/dir/baz"))
+	require.NoError(t, fs.Rename("/dir/baz", "/dir/biz"))
+
+	names, err := afero.ReadDir(fs, "/dir")
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	for _, info := range names {
+		assert.EqualValues(t, len(bigData), info.Size())
+	}
+	data, err := afero.ReadFile(fs, "/dir/biz")
+	require.NoError(t, err)
+	assert.Equal(t, bigData, string(data))
+	assert.Len(t, contents.paths(), 2)
+}
+
+func TestContentAddressedDefersWrites(t *testing.T) {
+	paths, contents := newMapStorer(), newMapStorer()
+	fs := New(NewContentAddressed(paths, contents))
+	f, err := fs.Create("/foo")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := f.Write([]byte("hello "))
+		require.NoError(t, err)
+	}
+	emptyHash := hashBlob(blob.NewFromBytes(nil))
+	assert.Equal(t, []string{contentPath(emptyHash), contentRefsPath(emptyHash)}, contents.paths(), "Writes should be saved on close")
+	info, err := fs.Stat("/foo")
+	require.NoError(t, err)
+	assert.EqualValues(t, 60, info.Size())
+	infos, err := afero.ReadDir(fs, "/")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.EqualValues(t, 60, infos[0].Size())
+
+	require.NoError(t, f.Close())
+	data := strings.Repeat("hello ", 10)
+	hash := hashBlob(blob.NewFromBytes([]byte(data)))
+	assert.Equal(t, []string{contentPath(hash), contentRefsPath(hash)}, contents.paths())
+	assert.Equal(t, hash, paths.data("/foo"))
+}
+
+func TestContentAddressedFsCopy(t *testing.T) {
+	paths, contents := newMapStorer(), newMapStorer()
+	fs := New(NewContentAddressed(paths, contents))
+	f, err := fs.Create("/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	require.NoError(t, fs.Copy("/foo", "/bar"), "Copies should include unsaved writes")
+	const helloHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	assert.Equal(t, helloHash, paths.data("/bar"))
+	assert.Equal(t, "2", contents.data(contentRefsPath(helloHash)))
+	require.NoError(t, f.Close())
+
+	data, err := afero.ReadFile(fs, "/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	assert.Len(t, contents.paths(), 2)
+}
+
+func TestContentAddressedCheckRefs(t *testing.T) {
+	paths, contents := newMapStorer(), newMapStorer()
+	s := NewContentAddressed(paths, contents)
+	fs := New(s)
+	require.NoError(t, afero.WriteFile(fs, "/foo", []byte("hello"), 0600))
+	require.NoError(t, afero.WriteFile(fs, "/bar", []byte("hello"), 0600))
+	require.NoError(t, afero.WriteFile(fs, "/baz", []byte("world"), 0600))
+	require.NoError(t, afero.WriteFile(fs, "/lost", []byte("lost"), 0600))
+	const (
+		helloHash = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		worldHash = "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"
+	)
+	problems, err := s.CheckRefs(paths.paths(), contents.paths(), false)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	require.NoError(t, s.setRefCount(helloHash, 5))
+	require.NoError(t, contents.SetFileRecord(contentPath(worldHash), nil))
+	require.NoError(t, paths.SetFileRecord("/lost", nil))
+	lost := hashBlob(blob.NewFromBytes([]byte("lost")))
+	problems, err = s.CheckRefs(paths.paths(), contents.paths(), true)
+	require.NoError(t, err)
+	kinds := make(map[string]RefProblemKind)
+	for _, problem := range problems {
+		kinds[problem.Hash] = problem.Kind
+	}
+	assert.Equal(t, map[string]RefProblemKind{
+		helloHash: RefCountMismatch,
+		worldHash: RefMissingContent,
+		lost:      RefUnreferenced,
+	}, kinds)
+	assert.Equal(t, "2", contents.data(contentRefsPath(helloHash)))
+	assert.NotContains(t, contents.paths(), contentPath(lost))
+	assert.NotContains(t, contents.paths(), contentRefsPath(lost))
+
+	problems, err = s.CheckRefs(paths.paths(), contents.paths(), true)
+	require.NoError(t, err)
+	require.Len(t, problems, 1, "Missing content can't be repaired")
+	assert.Equal(t, RefMissingContent, problems[0].Kind)
+}
+
+func TestContentAddressedConcurrent(t *testing.T) {
+	paths, contents := newMapStorer(), newMapStorer()
+	fstest.RunConcurrent(t, New(NewContentAddressed(paths, contents)), func() error {
+		paths.Clear()
+		contents.Clear()
+		return nil
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/johnstarich/go-wasm/internal/blob"
+	"github.com/johnstarich/go-wasm/internal/fsutil"
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 	"go.uber.org/atomic"
@@ -33,8 +34,12 @@ type File struct {
 type fileData struct {
 	FileRecord
 
-	path   string // path is stored as the "key", keeping it here is for generating os.FileInfo's
-	storer *fileStorer
+	mu       sync.Mutex // guards FileRecord contents and File state for all handles sharing this data
+	path     string     // path is stored as the "key", keeping it here is for generating os.FileInfo's
+	storer   *fileStorer
+	refs     int  // number of open handles sharing this data. Guarded by storer.openMu.
+	unlinked bool // true once the path is removed or replaced, so open handles don't save it again
+	dirty    bool // true if data changed since the last save, when the storer defers writes
 }
 
 type FileRecord struct {
@@ -51,6 +56,23 @@ type FileRecord struct {
 	InitialSize int64 // fallback size, enables lazy-loaded Data
 	ModTime     time.Time
 	Mode        os.FileMode
+
+	Uid, Gid   int
+	AccessTime time.Time
+	ChangeTime time.Time         // last change to data or metadata
+	Xattrs     map[string][]byte // extended attributes. Treat as read-only, replace the map to make changes.
+}
+
+// CopyMetadata copies all attributes except data and directory names from 'src'
+func (f *FileRecord) CopyMetadata(src *FileRecord) {
+	f.InitialSize = src.Size()
+	f.ModTime = src.ModTime
+	f.Mode = src.Mode
+	f.Uid = src.Uid
+	f.Gid = src.Gid
+	f.AccessTime = src.AccessTime
+	f.ChangeTime = src.ChangeTime
+	f.Xattrs = src.Xattrs
 }
 
 func (f *FileRecord) Data() blob.Blob {
@@ -85,57 +107,102 @@ func (f *FileRecord) Size() int64 {
 }
 
 func (fs *Fs) newFile(path string, flag int, mode os.FileMode) *File {
+	now := time.Now()
 	return &File{
 		flag: flag,
 		fileData: &fileData{
 			storer: fs.fileStorer,
-			path:   path,
+			path:   fsutil.NormalizePath(path),
 			FileRecord: FileRecord{
 				DataFn: func() (blob.Blob, error) {
 					return blob.NewFromBytes(nil), nil
 				},
-				ModTime: time.Now(),
-				Mode:    mode,
+				ModTime:    now,
+				Mode:       mode,
+				AccessTime: now,
+				ChangeTime: now,
 			},
 		},
 	}
 }
 
 func (f *fileData) save() error {
-	return f.storer.SetFile(f.path, f)
+	if f.unlinked {
+		return nil
+	}
+	err := f.storer.SetFile(f.path, f)
+	if err == nil {
+		f.dirty = false
+	}
+	return err
+}
+
+// saveData saves 'f' after a data change, or marks it dirty for Close or Sync to save if the storer defers writes
+func (f *fileData) saveData() error {
+	if f.storer.deferWrites {
+		f.dirty = true
+		return nil
+	}
+	return f.save()
 }
 
 func (f *fileData) info() os.FileInfo {
 	return &FileInfo{Record: &f.FileRecord, Path: f.path}
 }
 
+// snapshotInfo returns an os.FileInfo safe for use after f's record changes. Must be called with f.mu held.
+func (f *fileData) snapshotInfo() os.FileInfo {
+	var record FileRecord
+	record.CopyMetadata(&f.FileRecord)
+	return FileInfo{Record: &record, Path: f.path}
+}
+
 func (f *File) Close() error {
-	if f.fileData == nil {
+	data := f.fileData
+	if data == nil {
 		return os.ErrClosed
 	}
+	data.mu.Lock()
 	f.updateModTime()
+	var err error
+	if data.dirty {
+		err = data.save()
+	}
+	data.mu.Unlock()
+	data.storer.release(data)
 	f.fileData = nil
-	return nil
+	return err
 }
 
 func (f *File) updateModTime() {
 	f.ModTime = time.Now()
+	f.ChangeTime = f.ModTime
 }
 
 func (f *File) Read(p []byte) (n int, err error) {
-	n, err = f.ReadAt(p, f.offset)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err = f.readAt(p, f.offset)
 	f.offset += int64(n)
 	return
 }
 
 func (f *File) ReadBlob(length int) (blob blob.Blob, n int, err error) {
-	blob, n, err = f.ReadBlobAt(length, f.offset)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	blob, n, err = f.readBlobAt(length, f.offset)
 	f.offset += int64(n)
 	return
 }
 
 func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
-	blob, n, err := f.ReadBlobAt(len(p), off)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readAt(p, off)
+}
+
+func (f *File) readAt(p []byte, off int64) (n int, err error) {
+	blob, n, err := f.readBlobAt(len(p), off)
 	if blob != nil {
 		copy(p, blob.Bytes())
 	}
@@ -143,6 +210,12 @@ func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
 }
 
 func (f *File) ReadBlobAt(length int, off int64) (blob blob.Blob, n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.readBlobAt(length, off)
+}
+
+func (f *File) readBlobAt(length int, off int64) (blob blob.Blob, n int, err error) {
 	if off >= int64(f.Size()) {
 		return nil, 0, io.EOF
 	}
@@ -163,6 +236,8 @@ func (f *File) ReadBlobAt(length int, off int64) (blob blob.Blob, n int, err err
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	newOffset := f.offset
 	switch whence {
 	case io.SeekStart:
@@ -182,13 +257,16 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (f *File) Write(p []byte) (n int, err error) {
-	n, err = f.WriteAt(p, f.offset)
-	f.offset += int64(n)
-	return
+	return f.WriteBlob(blob.NewFromBytes(p))
 }
 
 func (f *File) WriteBlob(p blob.Blob) (n int, err error) {
-	n, err = f.WriteBlobAt(p, f.offset)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.flag&syscall.O_APPEND != 0 {
+		f.offset = f.fileData.Size()
+	}
+	n, err = f.writeBlobAt(p, f.offset)
 	f.offset += int64(n)
 	return
 }
@@ -198,14 +276,25 @@ func (f *File) WriteAt(p []byte, off int64) (n int, err error) {
 }
 
 func (f *File) WriteBlobAt(p blob.Blob, off int64) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writeBlobAt(p, off)
+}
+
+func (f *File) writeBlobAt(p blob.Blob, off int64) (n int, err error) {
 	if f.flag&syscall.O_APPEND != 0 {
 		off = int64(f.fileData.Size())
 	}
 
 	endIndex := off + int64(p.Len())
 	if int64(f.Size()) < endIndex {
-		err := f.Data().Grow(endIndex - int64(f.Size()))
+		growth := endIndex - int64(f.Size())
+		if err := f.storer.tracker.Reserve(growth, 0); err != nil {
+			return 0, &os.PathError{Op: "write", Path: f.path, Err: err}
+		}
+		err := f.Data().Grow(growth)
 		if err != nil {
+			f.storer.tracker.Release(growth, 0)
 			return 0, err
 		}
 	}
@@ -216,7 +305,7 @@ func (f *File) WriteBlobAt(p blob.Blob, off int64) (n int, err error) {
 	if n != 0 {
 		f.updateModTime()
 	}
-	err = f.fileData.save()
+	err = f.fileData.saveData()
 	return
 }
 
@@ -234,16 +323,25 @@ func (f *File) Readdir(count int) ([]os.FileInfo, error) {
 	for i, name := range names {
 		paths[i] = filepath.Join(f.path, name)
 	}
-	infos, errs := statAll(f.storer, paths)
-	for _, err := range errs {
-		if err != nil {
+	files, errs := f.storer.GetFiles(paths...) // includes unsaved changes to open files
+	existingInfos := make([]os.FileInfo, 0, len(files))
+	for i, err := range errs {
+		switch {
+		case os.IsNotExist(err):
+			// removed since listing names, skip it
+		case err != nil:
 			return nil, err
+		default:
+			info, _ := files[i].Stat()
+			existingInfos = append(existingInfos, info)
 		}
 	}
-	return infos, nil
+	return existingInfos, nil
 }
 
 func (f *File) Readdirnames(count int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if count > 0 && f.dirCount == len(f.DirNames()) {
 		return nil, io.EOF
 	}
@@ -267,15 +365,21 @@ func (f *File) Readdirnames(count int) ([]string, error) {
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
-	return FileInfo{Record: &f.FileRecord, Path: f.path}, nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshotInfo(), nil
 }
 
 func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.updateModTime()
 	return f.save()
 }
 
 func (f *File) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	length := int64(f.Size())
 	switch {
 	case size < 0:
@@ -283,15 +387,20 @@ func (f *File) Truncate(size int64) error {
 	case size == length:
 		return nil
 	case size > length:
+		if err := f.storer.tracker.Reserve(size-length, 0); err != nil {
+			return &os.PathError{Op: "truncate", Path: f.path, Err: err}
+		}
 		err := f.Data().Grow(size - length)
 		if err != nil {
+			f.storer.tracker.Release(size-length, 0)
 			return err
 		}
 	case size < length:
 		f.Data().Truncate(size)
+		f.storer.tracker.Release(length-size, 0)
 	}
 	f.updateModTime()
-	return f.save()
+	return f.saveData()
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
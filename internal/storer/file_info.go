@@ -31,6 +31,21 @@ func (f FileInfo) IsDir() bool {
 	return f.Record.Mode.IsDir()
 }
 
+// Sys returns a *Stat
 func (f FileInfo) Sys() interface{} {
-	return nil
+	return &Stat{
+		Uid:    f.Record.Uid,
+		Gid:    f.Record.Gid,
+		Atime:  f.Record.AccessTime,
+		Mtime:  f.Record.ModTime,
+		Ctime:  f.Record.ChangeTime,
+		Xattrs: f.Record.Xattrs,
+	}
+}
+
+// Stat holds file attributes not included in os.FileInfo, similar to syscall.Stat_t
+type Stat struct {
+	Uid, Gid            int
+	Atime, Mtime, Ctime time.Time
+	Xattrs              map[string][]byte
 }
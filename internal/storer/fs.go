@@ -4,20 +4,26 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/johnstarich/go-wasm/internal/blob"
 	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/quota"
 	"github.com/johnstarich/go-wasm/internal/rwonly"
 	"github.com/spf13/afero"
 )
 
+var _ quota.Limiter = &Fs{}
+
 type Fs struct {
 	*fileStorer
+	mu sync.RWMutex // guards multi-step changes to the directory tree, like creating and renaming files
 }
 
 // New returns a file system that relies on data fetched and set on Storer.
-// NOTE: 100% NOT thread safe
+// Fs is safe for concurrent use, provided 's' is also safe for concurrent use.
 func New(s Storer) *Fs {
 	fs := &Fs{}
 	fs.fileStorer = newFileStorer(s, fs)
@@ -40,8 +46,17 @@ func (fs *Fs) Create(name string) (afero.File, error) {
 }
 
 func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.tracker.Reserve(0, 1); err != nil {
+		return fs.wrapperErr("mkdir", name, err)
+	}
 	file := fs.newDir(name, perm)
-	return fs.wrapperErr("mkdir", name, file.save())
+	err := file.save()
+	if err != nil {
+		fs.tracker.Release(0, 1)
+	}
+	return fs.wrapperErr("mkdir", name, err)
 }
 
 func (fs *Fs) newDir(name string, perm os.FileMode) *File {
@@ -49,16 +64,25 @@ func (fs *Fs) newDir(name string, perm os.FileMode) *File {
 }
 
 func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	missingDirs, err := fs.findMissingDirs(path)
 	if err != nil {
 		return err
 	}
+	if err := fs.tracker.Reserve(0, int64(len(missingDirs))); err != nil {
+		return fs.wrapperErr("mkdirall", path, err)
+	}
 	for i := len(missingDirs) - 1; i >= 0; i-- { // missingDirs are in reverse order
 		name := missingDirs[i]
 		file := fs.newDir(name, perm)
 		err := file.save()
 		err = fs.wrapperErr("mkdirall", name, err)
+		if err != nil {
+			fs.tracker.Release(0, 1)
+		}
 		if err != nil && !os.IsExist(err) {
+			fs.tracker.Release(0, int64(i)) // release the remaining reservations
 			return err
 		}
 	}
@@ -126,6 +150,13 @@ func (fs *Fs) Open(name string) (afero.File, error) {
 }
 
 func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afFile afero.File, retErr error) {
+	if flag&os.O_CREATE != 0 {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+	} else {
+		fs.mu.RLock()
+		defer fs.mu.RUnlock()
+	}
 	paths := []string{name}
 	if flag&os.O_CREATE != 0 {
 		paths = append(paths, filepath.Dir(name))
@@ -138,17 +169,22 @@ func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afFile afero.Fi
 			// write-only on a directory isn't allowed on os.OpenFile either
 			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
 		}
-		storerFile.flag = flag
+		storerFile = fs.openHandle(storerFile.fileData, flag)
 	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
 		// require parent directory
 		err := errs[1]
 		if err != nil {
 			return nil, fs.wrapperErr("stat", name, err)
 		}
+		if err := fs.tracker.Reserve(0, 1); err != nil {
+			return nil, fs.wrapperErr("open", name, err)
+		}
 		storerFile = fs.newFile(name, flag, perm&os.ModePerm)
 		if err := storerFile.save(); err != nil {
+			fs.tracker.Release(0, 1)
 			return nil, fs.wrapperErr("open", name, err)
 		}
+		storerFile = fs.openHandle(storerFile.fileData, flag)
 	default:
 		return nil, fs.wrapperErr("open", name, err)
 	}
@@ -164,21 +200,48 @@ func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afFile afero.Fi
 	}
 
 	if flag&os.O_TRUNC != 0 {
-		return file, fs.wrapperErr("open", name, file.Truncate(0))
+		if err := file.Truncate(0); err != nil {
+			_ = file.Close()
+			return nil, fs.wrapperErr("open", name, err)
+		}
 	}
 	return file, nil
 }
 
 func (fs *Fs) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	file, err := fs.fileStorer.GetFile(name)
 	if err != nil {
 		return fs.wrapperErr("remove", name, err)
 	}
 
+	file.mu.Lock()
+	defer file.mu.Unlock()
 	if file.Mode.IsDir() && len(file.DirNames()) != 0 {
 		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
 	}
-	return fs.fileStorer.SetFile(name, nil)
+	err = fs.fileStorer.SetFile(name, nil)
+	if err == nil {
+		fs.unlinkOpen(file.fileData)
+		fs.tracker.Release(recordUsage(&file.FileRecord), 1)
+	}
+	return err
+}
+
+// recordUsage returns the bytes counted against quotas for 'record'
+func recordUsage(record *FileRecord) int64 {
+	if record.Mode.IsDir() {
+		return 0
+	}
+	return record.Size()
+}
+
+// lockedUsage returns recordUsage for 'file', which may be shared with open handles
+func lockedUsage(file *File) int64 {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	return recordUsage(&file.FileRecord)
 }
 
 func (fs *Fs) RemoveAll(path string) error {
@@ -186,6 +249,12 @@ func (fs *Fs) RemoveAll(path string) error {
 }
 
 func (fs *Fs) Rename(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.rename(oldname, newname)
+}
+
+func (fs *Fs) rename(oldname, newname string) error {
 	oldFile, err := fs.fileStorer.GetFile(oldname)
 	if err != nil {
 		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: afero.ErrFileNotFound}
@@ -194,12 +263,31 @@ func (fs *Fs) Rename(oldname, newname string) error {
 	if err != nil {
 		return err
 	}
+	if fsutil.NormalizePath(oldname) == fsutil.NormalizePath(newname) {
+		return nil
+	}
 	if !oldInfo.IsDir() {
+		replacedUsage := int64(-1)
+		if fs.tracker.Initialized() {
+			if file, err := fs.fileStorer.GetFile(newname); err == nil {
+				replacedUsage = lockedUsage(file)
+			}
+		}
+		// hold the lock until open handles are moved, so their writes aren't saved to the old path
+		oldFile.mu.Lock()
+		defer oldFile.mu.Unlock()
 		err := fs.fileStorer.SetFile(newname, oldFile.fileData)
 		if err != nil {
 			return err
 		}
-		return fs.fileStorer.SetFile(oldname, nil)
+		if replacedUsage != -1 {
+			fs.tracker.Release(replacedUsage, 1)
+		}
+		if err := fs.fileStorer.SetFile(oldname, nil); err != nil {
+			return err
+		}
+		fs.moveOpen(oldFile.fileData, newname)
+		return nil
 	}
 
 	_, err = fs.fileStorer.GetFile(newname)
@@ -216,7 +304,7 @@ func (fs *Fs) Rename(oldname, newname string) error {
 		return err
 	}
 	for _, name := range files {
-		err := fs.Rename(filepath.Join(oldname, name), filepath.Join(newname, name))
+		err := fs.rename(filepath.Join(oldname, name), filepath.Join(newname, name))
 		if err != nil {
 			// TODO don't leave destination in corrupted state (missing file records for dir names)
 			return err
@@ -225,12 +313,145 @@ func (fs *Fs) Rename(oldname, newname string) error {
 	return fs.fileStorer.SetFile(oldname, nil)
 }
 
+// Copy copies the file 'oldname' to 'newname', keeping its mode, owner, and times. Replaces 'newname' if it's a file.
+// If the Storer is a Copier, only the file record is copied and data is shared with 'oldname'.
+func (fs *Fs) Copy(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	files, errs := fs.fileStorer.GetFiles(oldname, newname, filepath.Dir(newname))
+	oldFile, err := files[0], errs[0]
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: oldname, New: newname, Err: afero.ErrFileNotFound}
+	}
+	if err := errs[2]; err != nil {
+		return fs.wrapperErr("copy", newname, err)
+	}
+	newFile, newErr := files[1], errs[1]
+	if newErr != nil && !os.IsNotExist(newErr) {
+		return fs.wrapperErr("copy", newname, newErr)
+	}
+	replaced := newErr == nil
+	if oldFile.Mode.IsDir() || (replaced && newFile.Mode.IsDir()) {
+		return &os.LinkError{Op: "copy", Old: oldname, New: newname, Err: syscall.EISDIR}
+	}
+	if oldFile.path == newFile.path {
+		return nil
+	}
+
+	replacedUsage, inodes := int64(0), int64(1)
+	if replaced {
+		replacedUsage, inodes = lockedUsage(newFile), 0
+	}
+	oldFile.mu.Lock()
+	defer oldFile.mu.Unlock()
+	usage := recordUsage(&oldFile.FileRecord)
+	if err := fs.tracker.Reserve(usage, inodes); err != nil {
+		return fs.wrapperErr("copy", newname, err)
+	}
+	if oldFile.dirty {
+		err = oldFile.save()
+	}
+	if err == nil {
+		err = fs.copyRecord(oldFile.fileData, newFile.path)
+	}
+	if err != nil {
+		fs.tracker.Release(usage, inodes)
+		return err
+	}
+	fs.tracker.Release(replacedUsage, 0)
+	if replaced {
+		newFile.mu.Lock()
+		fs.unlinkOpen(newFile.fileData)
+		newFile.mu.Unlock()
+	}
+	return nil
+}
+
+// copyRecord saves a copy of 'file' at 'newPath'. Must be called with file.mu held.
+func (fs *Fs) copyRecord(file *fileData, newPath string) error {
+	if copier, ok := fs.Storer.(Copier); ok {
+		return copier.CopyFileRecord(file.path, newPath)
+	}
+	data, err := file.Data().Slice(0, int64(file.Data().Len()))
+	if err != nil {
+		return err
+	}
+	record := &FileRecord{
+		DataFn: func() (blob.Blob, error) {
+			return data, nil
+		},
+	}
+	record.CopyMetadata(&file.FileRecord)
+	return <-QueueSetFileRecord(fs.Storer, newPath, record)
+}
+
+// Usage returns the bytes and inodes used, and the current limits. The first call scans every file record to count usage.
+func (fs *Fs) Usage() (quota.Usage, error) {
+	err := fs.initTracker()
+	return fs.tracker.Usage(), err
+}
+
+// SetLimits sets the maximum bytes and inodes for this file system. Writes exceeding them fail with ENOSPC.
+func (fs *Fs) SetLimits(maxBytes, maxInodes int64) error {
+	if err := fs.initTracker(); err != nil {
+		return err
+	}
+	fs.tracker.SetLimits(maxBytes, maxInodes)
+	return nil
+}
+
+func (fs *Fs) initTracker() error {
+	if fs.tracker.Initialized() {
+		return nil
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.tracker.Initialized() {
+		return nil
+	}
+	bytes, inodes, err := fs.scanUsage(afero.FilePathSeparator)
+	if err != nil {
+		return err
+	}
+	fs.tracker.Init(bytes, inodes)
+	return nil
+}
+
+// scanUsage counts bytes and inodes for 'path' and its children. Must be called with fs.mu held.
+func (fs *Fs) scanUsage(path string) (bytes, inodes int64, err error) {
+	file, err := fs.fileStorer.GetFile(path)
+	if os.IsNotExist(err) && path == afero.FilePathSeparator {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	inodes = 1
+	if !file.Mode.IsDir() {
+		return lockedUsage(file), inodes, nil
+	}
+	for _, name := range file.DirNames() {
+		childBytes, childInodes, err := fs.scanUsage(filepath.Join(path, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		bytes += childBytes
+		inodes += childInodes
+	}
+	return bytes, inodes, nil
+}
+
 func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
 	file, err := fs.fileStorer.GetFile(name)
 	if err != nil {
 		return nil, fs.wrapperErr("stat", name, err)
 	}
-	return file.info(), nil
+	return file.Stat()
 }
 
 func (fs *Fs) Name() string {
@@ -238,21 +459,88 @@ func (fs *Fs) Name() string {
 }
 
 func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	file, err := fs.fileStorer.GetFile(name)
 	if err != nil {
 		return fs.wrapperErr("chmod", name, err)
 	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
 
 	const chmodBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky // Only a subset of bits are allowed to be changed. Documented under os.Chmod()
 	file.Mode = (file.Mode & ^chmodBits) | (mode & chmodBits)
+	file.ChangeTime = time.Now()
+	return file.save()
+}
+
+// Chown changes the owner and group of 'name'. A uid or gid of -1 is left unchanged, like os.Chown.
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	file, err := fs.fileStorer.GetFile(name)
+	if err != nil {
+		return fs.wrapperErr("chown", name, err)
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if uid != -1 {
+		file.Uid = uid
+	}
+	if gid != -1 {
+		file.Gid = gid
+	}
+	file.ChangeTime = time.Now()
 	return file.save()
 }
 
 func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	file, err := fs.fileStorer.GetFile(name)
 	if err != nil {
 		return fs.wrapperErr("chtimes", name, err)
 	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	file.AccessTime = atime
 	file.ModTime = mtime
+	file.ChangeTime = time.Now()
+	return file.save()
+}
+
+// Xattrs returns the extended attributes of 'name'
+func (fs *Fs) Xattrs(name string) (map[string][]byte, error) {
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info.Sys().(*Stat).Xattrs, nil
+}
+
+// SetXattr sets the extended attribute 'key' on 'name' to 'value'. A nil value removes the attribute.
+func (fs *Fs) SetXattr(name, key string, value []byte) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	file, err := fs.fileStorer.GetFile(name)
+	if err != nil {
+		return fs.wrapperErr("setxattr", name, err)
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	xattrs := make(map[string][]byte, len(file.Xattrs)+1)
+	for k, v := range file.Xattrs {
+		xattrs[k] = v
+	}
+	if value == nil {
+		delete(xattrs, key)
+	} else {
+		xattrs[key] = append([]byte(nil), value...)
+	}
+	if len(xattrs) == 0 {
+		xattrs = nil
+	}
+	file.Xattrs = xattrs
+	file.ChangeTime = time.Now()
 	return file.save()
 }
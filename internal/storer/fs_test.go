@@ -0,0 +1,226 @@
+package storer
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/blob"
+	"github.com/johnstarich/go-wasm/internal/fstest"
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/quota"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapStorer is an in-memory Storer, safe for concurrent use
+type mapStorer struct {
+	mu      sync.Mutex
+	records map[string]mapRecord
+}
+
+type mapRecord struct {
+	data   []byte
+	record *FileRecord // metadata only
+}
+
+func newMapStorer() *mapStorer {
+	m := &mapStorer{}
+	m.Clear()
+	return m
+}
+
+func (m *mapStorer) Clear() {
+	m.mu.Lock()
+	m.records = map[string]mapRecord{
+		afero.FilePathSeparator: {record: &FileRecord{Mode: os.ModeDir | 0755}},
+	}
+	m.mu.Unlock()
+}
+
+func (m *mapStorer) GetFileRecord(path string, dest *FileRecord) error {
+	path = fsutil.NormalizePath(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[path]
+	if !ok {
+		return os.ErrNotExist
+	}
+	var dirNames []string
+	if record.record.Mode.IsDir() {
+		for p := range m.records {
+			if p != afero.FilePathSeparator && filepath.Dir(p) == path {
+				dirNames = append(dirNames, filepath.Base(p))
+			}
+		}
+	}
+	dest.DataFn = func() (blob.Blob, error) {
+		return blob.NewFromBytes(append([]byte(nil), record.data...)), nil
+	}
+	dest.DirNamesFn = func() ([]string, error) {
+		return dirNames, nil
+	}
+	dest.CopyMetadata(record.record)
+	return nil
+}
+
+func (m *mapStorer) SetFileRecord(path string, src *FileRecord) error {
+	path = fsutil.NormalizePath(path)
+	var record mapRecord
+	if src != nil {
+		record.data = append([]byte(nil), src.Data().Bytes()...)
+		record.record = new(FileRecord)
+		record.record.CopyMetadata(src)
+		record.record.InitialSize = int64(len(record.data))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if src == nil {
+		delete(m.records, path)
+		return nil
+	}
+	if parent, ok := m.records[filepath.Dir(path)]; !ok || !parent.record.Mode.IsDir() {
+		return syscall.ENOTDIR
+	}
+	m.records[path] = record
+	return nil
+}
+
+func TestFsConcurrent(t *testing.T) {
+	s := newMapStorer()
+	fstest.RunConcurrent(t, New(s), func() error {
+		s.Clear()
+		return nil
+	})
+}
+
+func TestFsMetadata(t *testing.T) {
+	s := newMapStorer()
+	fs := New(s)
+	require.NoError(t, afero.WriteFile(fs, "/foo", []byte("bar"), 0600))
+
+	require.NoError(t, fs.Chown("/foo", 1000, 100))
+	require.NoError(t, fs.Chown("/foo", -1, 200))
+	atime, mtime := time.Unix(1, 0), time.Unix(2, 0)
+	require.NoError(t, fs.Chtimes("/foo", atime, mtime))
+	require.NoError(t, fs.SetXattr("/foo", "user.a", []byte("b")))
+	require.NoError(t, fs.SetXattr("/foo", "user.c", []byte("d")))
+	require.NoError(t, fs.SetXattr("/foo", "user.c", nil))
+
+	info, err := New(s).Stat("/foo") // new Fs to ensure metadata was persisted
+	require.NoError(t, err)
+	stat := info.Sys().(*Stat)
+	assert.Equal(t, 1000, stat.Uid)
+	assert.Equal(t, 200, stat.Gid)
+	assert.Equal(t, atime, stat.Atime)
+	assert.Equal(t, mtime, stat.Mtime)
+	assert.True(t, stat.Ctime.After(mtime))
+	assert.Equal(t, map[string][]byte{"user.a": []byte("b")}, stat.Xattrs)
+
+	err = fs.Chown("/missing", 0, 0)
+	assert.Equal(t, &os.PathError{Op: "chown", Path: "/missing", Err: os.ErrNotExist}, err)
+}
+
+func TestFsQuota(t *testing.T) {
+	s := newMapStorer()
+	require.NoError(t, afero.WriteFile(New(s), "/existing", []byte("12345"), 0600))
+	fs := New(s)
+
+	usage, err := fs.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, quota.Usage{Bytes: 5, Inodes: 2}, usage)
+
+	require.NoError(t, fs.SetLimits(10, 5))
+	require.NoError(t, fs.MkdirAll("/a/b", 0700))
+	f, err := fs.Create("/a/b/c")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("12345"))
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("6"))
+	assert.Equal(t, &os.PathError{Op: "write", Path: "/a/b/c", Err: syscall.ENOSPC}, err)
+	assert.Equal(t, &os.PathError{Op: "truncate", Path: "/a/b/c", Err: syscall.ENOSPC}, f.Truncate(6))
+	require.NoError(t, f.Close())
+
+	_, err = fs.Create("/d")
+	assert.Equal(t, &os.PathError{Op: "create", Path: "/d", Err: syscall.ENOSPC}, err)
+	assert.Equal(t, &os.PathError{Op: "mkdir", Path: "/d", Err: syscall.ENOSPC}, fs.Mkdir("/d", 0700))
+	usage, err = fs.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, quota.Usage{Bytes: 10, Inodes: 5, MaxBytes: 10, MaxInodes: 5}, usage)
+
+	require.NoError(t, fs.Rename("/a/b/c", "/existing"))
+	require.NoError(t, fs.Remove("/a/b"))
+	require.NoError(t, fs.Remove("/a"))
+	usage, err = fs.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, quota.Usage{Bytes: 5, Inodes: 2, MaxBytes: 10, MaxInodes: 5}, usage)
+}
+
+func TestFsOpenHandlesFollowPath(t *testing.T) {
+	s := newMapStorer()
+	fs := New(s)
+	renamed, err := fs.Create("/foo")
+	require.NoError(t, err)
+	require.NoError(t, fs.Rename("/foo", "/bar"))
+	_, err = renamed.Write([]byte("bar"))
+	require.NoError(t, err)
+	require.NoError(t, renamed.Close())
+	_, err = fs.Stat("/foo")
+	assert.True(t, os.IsNotExist(err), "Writes after a rename shouldn't recreate the old path")
+	contents, err := afero.ReadFile(fs, "/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(contents))
+
+	removed, err := fs.Create("/baz")
+	require.NoError(t, err)
+	require.NoError(t, fs.Remove("/baz"))
+	_, err = removed.Write([]byte("baz"))
+	require.NoError(t, err)
+	require.NoError(t, removed.Close())
+	_, err = fs.Stat("/baz")
+	assert.True(t, os.IsNotExist(err), "Writes after a remove shouldn't recreate the file")
+
+	require.NoError(t, fs.Chmod("/bar", 0400))
+	f, err := fs.OpenFile("/bar", os.O_RDWR, 0)
+	require.NoError(t, err)
+	require.NoError(t, fs.Chmod("/bar", 0600))
+	_, err = f.Write([]byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	info, err := New(s).Stat("/bar")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode(), "Writes from open handles shouldn't undo changes by path")
+}
+
+func TestFsCopy(t *testing.T) {
+	fs := New(newMapStorer())
+	require.NoError(t, afero.WriteFile(fs, "/foo", []byte("foo"), 0640))
+	require.NoError(t, afero.WriteFile(fs, "/bar", []byte("old bar"), 0600))
+	require.NoError(t, fs.Mkdir("/dir", 0700))
+	require.NoError(t, fs.SetLimits(100, 10))
+
+	require.NoError(t, fs.Copy("/foo", "/dir/foo"))
+	require.NoError(t, fs.Copy("/foo", "/bar"))
+	require.NoError(t, afero.WriteFile(fs, "/foo", []byte("changed"), 0640))
+	for _, path := range []string{"/dir/foo", "/bar"} {
+		contents, err := afero.ReadFile(fs, path)
+		require.NoError(t, err)
+		assert.Equal(t, "foo", string(contents), "Copies shouldn't change with the original")
+		info, err := fs.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode())
+	}
+	usage, err := fs.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, quota.Usage{Bytes: 13, Inodes: 5, MaxBytes: 100, MaxInodes: 10}, usage)
+
+	err = fs.Copy("/dir", "/baz")
+	assert.Equal(t, &os.LinkError{Op: "copy", Old: "/dir", New: "/baz", Err: syscall.EISDIR}, err)
+	err = fs.Copy("/missing", "/baz")
+	assert.Equal(t, &os.LinkError{Op: "copy", Old: "/missing", New: "/baz", Err: afero.ErrFileNotFound}, err)
+}
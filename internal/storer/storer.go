@@ -1,7 +1,10 @@
 package storer
 
 import (
+	"sync"
+
 	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/quota"
 	"github.com/spf13/afero"
 )
 
@@ -15,18 +18,42 @@ type Storer interface {
 	SetFileRecord(path string, src *FileRecord) error
 }
 
+// WriteDeferrer is implemented by Storers which prefer saving file data once a file is closed or synced, instead of after every write
+type WriteDeferrer interface {
+	DeferWrites() bool
+}
+
+// Copier is implemented by Storers which can copy a file record without rewriting its data
+type Copier interface {
+	CopyFileRecord(oldPath, newPath string) error
+}
+
 type fileStorer struct {
 	Storer
-	fs afero.Fs
+	fs          afero.Fs
+	tracker     quota.Tracker
+	deferWrites bool // true if writes to open files are saved on close or sync
+
+	openMu sync.Mutex           // guards open and each fileData's refs. Acquire after fileData.mu, if both are needed.
+	open   map[string]*fileData // data shared by the open handles of regular files, keyed by path
 }
 
 func newFileStorer(s Storer, sourceFS afero.Fs) *fileStorer {
-	return &fileStorer{Storer: s, fs: sourceFS}
+	deferrer, ok := s.(WriteDeferrer)
+	return &fileStorer{
+		Storer:      s,
+		fs:          sourceFS,
+		deferWrites: ok && deferrer.DeferWrites(),
+		open:        make(map[string]*fileData),
+	}
 }
 
-// GetFile returns a file for 'path' if it exists, os.ErrNotExist otherwise
+// GetFile returns a file for 'path' if it exists, os.ErrNotExist otherwise. If the file is open, the result shares the open handles' data.
 func (f *fileStorer) GetFile(path string) (*File, error) {
 	path = fsutil.NormalizePath(path)
+	if data := f.openData(path); data != nil {
+		return &File{fileData: data}, nil
+	}
 	file := fileData{
 		path:   path,
 		storer: f,
@@ -42,3 +69,68 @@ func (f *fileStorer) SetFile(path string, file *fileData) error {
 	}
 	return <-QueueSetFileRecord(f.Storer, path, &file.FileRecord)
 }
+
+// openData returns the data shared by handles open at 'path', or nil if there are none. 'path' must be normalized.
+func (f *fileStorer) openData(path string) *fileData {
+	f.openMu.Lock()
+	defer f.openMu.Unlock()
+	return f.open[path]
+}
+
+// openHandle returns a new handle for 'data'. Handles for the same regular file share data, so writes from one are seen by the others.
+func (f *fileStorer) openHandle(data *fileData, flag int) *File {
+	if data.Mode.IsDir() {
+		return &File{fileData: data, flag: flag}
+	}
+	f.openMu.Lock()
+	defer f.openMu.Unlock()
+	if open, ok := f.open[data.path]; ok {
+		data = open
+	} else {
+		f.open[data.path] = data
+	}
+	data.refs++
+	return &File{fileData: data, flag: flag}
+}
+
+// release removes a handle's reference to 'data', forgetting the data once no handles remain
+func (f *fileStorer) release(data *fileData) {
+	f.openMu.Lock()
+	defer f.openMu.Unlock()
+	if data.refs == 0 {
+		return // directory or unopened file
+	}
+	data.refs--
+	if data.refs == 0 && f.open[data.path] == data {
+		delete(f.open, data.path)
+	}
+}
+
+// unlinkOpen detaches handles open for 'data' after its path is removed, so they don't save it again. Must be called with data.mu held.
+func (f *fileStorer) unlinkOpen(data *fileData) {
+	data.unlinked = true
+	f.openMu.Lock()
+	defer f.openMu.Unlock()
+	if f.open[data.path] == data {
+		delete(f.open, data.path)
+	}
+}
+
+// moveOpen moves handles open for 'data' to 'newPath' after a rename, and detaches any others open at 'newPath'. Must be called with Fs.mu and data.mu held.
+func (f *fileStorer) moveOpen(data *fileData, newPath string) {
+	newPath = fsutil.NormalizePath(newPath)
+	f.openMu.Lock()
+	replaced := f.open[newPath]
+	delete(f.open, newPath)
+	if f.open[data.path] == data {
+		delete(f.open, data.path)
+		f.open[newPath] = data
+	}
+	data.path = newPath
+	f.openMu.Unlock()
+	if replaced != nil && replaced != data {
+		replaced.mu.Lock()
+		replaced.unlinked = true
+		replaced.mu.Unlock()
+	}
+}
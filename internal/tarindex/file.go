@@ -0,0 +1,55 @@
+package tarindex
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// File is an opened archive entry. Directory listings come from the table of contents, so unfetched files are included.
+type File struct {
+	afero.File
+	fs       *Fs
+	entry    *Entry
+	dirCount int
+}
+
+func (f *File) Name() string {
+	return f.entry.Name
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	return &FileInfo{f.entry}, nil
+}
+
+func (f *File) Readdirnames(count int) ([]string, error) {
+	if !f.entry.Mode.IsDir() {
+		return f.File.Readdirnames(count) // return the cache's error for non-directories
+	}
+	names := f.fs.children[f.entry.Name][f.dirCount:]
+	if count > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		if count < len(names) {
+			names = names[:count]
+		}
+	}
+	f.dirCount += len(names)
+	return append([]string{}, names...), nil
+}
+
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	names, err := f.Readdirnames(count)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		entry := f.fs.entries[filepath.Join(f.entry.Name, name)]
+		infos = append(infos, &FileInfo{entry})
+	}
+	return infos, nil
+}
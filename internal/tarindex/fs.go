@@ -0,0 +1,322 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// maxFooterSize is larger than the footer member, to allow for differences in gzip headers
+const maxFooterSize = 512
+
+// ErrNotIndexed is returned by Open if the archive doesn't contain a table of contents
+var ErrNotIndexed = errors.New("tarindex: archive is not indexed")
+
+var _ afero.Fs = &Fs{}
+
+// Fs is a read-only file system for an indexed archive. Each file's contents are fetched from the archive on first open, then kept in a cache file system.
+type Fs struct {
+	archive  io.ReaderAt
+	entries  map[string]*Entry
+	children map[string][]string // directory paths to sorted child names
+	cache    afero.Fs
+
+	mu       sync.Mutex
+	fetching map[string]*fetch
+}
+
+type fetch struct {
+	done chan struct{}
+	err  error
+}
+
+// Open reads the table of contents from 'archive' and returns a file system for its contents. Returns ErrNotIndexed if 'archive' isn't an indexed archive.
+// File contents are stored in 'cache' as they're fetched. If 'cache' is persistent, files fetched before are reused.
+func Open(archive io.ReaderAt, size int64, cache afero.Fs) (*Fs, error) {
+	tocOffset, err := readFooter(archive, size)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readTOC(io.NewSectionReader(archive, tocOffset, size-tocOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &Fs{
+		archive:  archive,
+		entries:  make(map[string]*Entry, len(entries)),
+		children: make(map[string][]string),
+		cache:    cache,
+		fetching: make(map[string]*fetch),
+	}
+	fs.entries[afero.FilePathSeparator] = &Entry{Name: afero.FilePathSeparator, Mode: os.ModeDir | 0755}
+	for i := range entries {
+		entry := &entries[i]
+		_, exists := fs.entries[entry.Name] // directories may be implied by an earlier entry, or listed more than once
+		fs.entries[entry.Name] = entry
+		if !exists {
+			fs.addParents(entry.Name)
+		}
+	}
+	for dir := range fs.children {
+		sort.Strings(fs.children[dir])
+	}
+	return fs, fs.createDirs()
+}
+
+// addParents records 'path' in its parent directory, creating implied parent directories as needed
+func (fs *Fs) addParents(path string) {
+	for path != afero.FilePathSeparator {
+		dir := filepath.Dir(path)
+		fs.children[dir] = append(fs.children[dir], filepath.Base(path))
+		if _, exists := fs.entries[dir]; exists {
+			return
+		}
+		fs.entries[dir] = &Entry{Name: dir, Mode: os.ModeDir | 0755}
+		path = dir
+	}
+}
+
+// createDirs creates all directories in the cache, so they can be opened without fetching
+func (fs *Fs) createDirs() error {
+	var dirs []*Entry
+	for _, entry := range fs.entries {
+		if entry.Mode.IsDir() {
+			dirs = append(dirs, entry)
+		}
+	}
+	sort.Slice(dirs, func(a, b int) bool {
+		return dirs[a].Name < dirs[b].Name
+	})
+	for _, dir := range dirs {
+		if err := fs.cache.MkdirAll(dir.Name, 0755); err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		if err := fs.cache.Chmod(dir.Name, dir.Mode); err != nil {
+			return err
+		}
+		if err := fs.cache.Chtimes(dir.Name, dir.ModTime, dir.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFooter(archive io.ReaderAt, size int64) (int64, error) {
+	footerSize := int64(maxFooterSize)
+	if size < footerSize {
+		footerSize = size
+	}
+	footer := make([]byte, footerSize)
+	if _, err := archive.ReadAt(footer, size-footerSize); err != nil && err != io.EOF {
+		return 0, err
+	}
+	index := bytes.LastIndex(footer, []byte(footerMagic))
+	const offsetLength = 16
+	if index == -1 || index+len(footerMagic)+offsetLength > len(footer) {
+		return 0, ErrNotIndexed
+	}
+	offsetStart := index + len(footerMagic)
+	offset, err := strconv.ParseInt(string(footer[offsetStart:offsetStart+offsetLength]), 16, 64)
+	if err != nil || offset < 0 || offset >= size {
+		return 0, ErrNotIndexed
+	}
+	return offset, nil
+}
+
+func readTOC(r io.Reader) ([]Entry, error) {
+	decompressor, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	decompressor.Multistream(false)
+	var entries []Entry
+	err = json.NewDecoder(decompressor).Decode(&entries)
+	return entries, errors.Wrap(err, "tarindex: Failed to decode table of contents")
+}
+
+// openChunk returns a tar reader for a single gzip member
+func openChunk(r io.Reader) (*tar.Reader, error) {
+	decompressor, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	decompressor.Multistream(false)
+	return tar.NewReader(decompressor), nil
+}
+
+func (fs *Fs) entry(op, path string) (*Entry, error) {
+	entry, ok := fs.entries[fsutil.NormalizePath(path)]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return entry, nil
+}
+
+// ensureFetched fetches the contents of the file at 'entry' into the cache, if it hasn't been already
+func (fs *Fs) ensureFetched(entry *Entry) error {
+	fs.mu.Lock()
+	f, inProgress := fs.fetching[entry.Name]
+	if !inProgress {
+		f = &fetch{done: make(chan struct{})}
+		fs.fetching[entry.Name] = f
+	}
+	fs.mu.Unlock()
+	if inProgress {
+		<-f.done
+		return f.err
+	}
+
+	f.err = fs.fetch(entry)
+	if f.err != nil {
+		// allow retries, like after a network failure
+		fs.mu.Lock()
+		delete(fs.fetching, entry.Name)
+		fs.mu.Unlock()
+	}
+	close(f.done)
+	return f.err
+}
+
+func (fs *Fs) fetch(entry *Entry) error {
+	// persistent caches may only keep whole seconds, like IndexedDB, so compare at that precision
+	if info, err := fs.cache.Stat(entry.Name); err == nil && info.Size() == entry.Size && info.ModTime().Unix() == entry.ModTime.Unix() {
+		return nil // fetched previously into a persistent cache
+	}
+
+	archive, err := openChunk(io.NewSectionReader(fs.archive, entry.Offset, entry.ChunkSize))
+	if err != nil {
+		return err
+	}
+	if _, err := archive.Next(); err != nil {
+		return err
+	}
+	file, err := fs.cache.OpenFile(entry.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, archive)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "tarindex: Failed to fetch %q", entry.Name)
+	}
+	if err := fs.cache.Chmod(entry.Name, entry.Mode); err != nil {
+		return err
+	}
+	return fs.cache.Chtimes(entry.Name, entry.ModTime, entry.ModTime)
+}
+
+func (fs *Fs) Name() string {
+	return "tarindex.Fs"
+}
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+	}
+	entry, err := fs.entry("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.Mode.IsDir() {
+		if err := fs.ensureFetched(entry); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	file, err := fs.cache.Open(entry.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: file, fs: fs, entry: entry}, nil
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	entry, err := fs.entry("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{entry}, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: syscall.EPERM}
+}
+
+func (fs *Fs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *Fs) RemoveAll(path string) error {
+	return &os.PathError{Op: "removeall", Path: path, Err: syscall.EPERM}
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EPERM}
+}
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EPERM}
+}
+
+func (fs *Fs) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EPERM}
+}
+
+// FileInfo describes an archive entry
+type FileInfo struct {
+	entry *Entry
+}
+
+func (f *FileInfo) Name() string {
+	return filepath.Base(f.entry.Name)
+}
+
+func (f *FileInfo) Size() int64 {
+	return f.entry.Size
+}
+
+func (f *FileInfo) Mode() os.FileMode {
+	return f.entry.Mode
+}
+
+func (f *FileInfo) ModTime() time.Time {
+	return f.entry.ModTime
+}
+
+func (f *FileInfo) IsDir() bool {
+	return f.entry.Mode.IsDir()
+}
+
+func (f *FileInfo) Sys() interface{} {
+	return nil
+}
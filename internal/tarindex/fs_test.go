@@ -0,0 +1,252 @@
+package tarindex
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/fstest"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type swappableFS struct {
+	afero.Fs
+}
+
+func (s *swappableFS) Swap(fs afero.Fs) {
+	s.Fs = fs
+}
+
+func TestFs(t *testing.T) {
+	indexFS := &swappableFS{}
+	memFS := &swappableFS{afero.NewMemMapFs()}
+
+	rebuildFromMem := func() error {
+		archive, err := buildFromFS(memFS)
+		if err != nil {
+			return err
+		}
+		fs, err := Open(bytes.NewReader(archive), int64(len(archive)), afero.NewMemMapFs())
+		if err == nil {
+			indexFS.Swap(fs)
+		}
+		return err
+	}
+	require.NoError(t, rebuildFromMem())
+
+	cleanup := func() error {
+		memFS.Swap(afero.NewMemMapFs())
+		return rebuildFromMem()
+	}
+
+	fstest.RunReadOnly(t, indexFS, memFS, cleanup, rebuildFromMem)
+}
+
+func buildFromFS(src afero.Fs) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := NewWriter(&buf)
+	err := afero.Walk(src, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = path
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		contents, err := afero.ReadFile(src, path)
+		if err != nil {
+			return err
+		}
+		_, err = archive.Write(contents)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed building archive from FS walk")
+	}
+	err = archive.Close()
+	return buf.Bytes(), err
+}
+
+func writeTestArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	src := afero.NewMemMapFs()
+	for path, contents := range files {
+		require.NoError(t, src.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, afero.WriteFile(src, path, []byte(contents), 0644))
+	}
+	archive, err := buildFromFS(src)
+	require.NoError(t, err)
+	return archive
+}
+
+func TestWriterIsTarGzip(t *testing.T) {
+	archive := writeTestArchive(t, map[string]string{
+		"/foo":     "foo contents",
+		"/bar/baz": "baz contents",
+	})
+
+	decompressor, err := gzip.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	reader := tar.NewReader(decompressor)
+	files := make(map[string]string)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag == tar.TypeReg {
+			contents, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			files[header.Name] = string(contents)
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"/foo":     "foo contents",
+		"/bar/baz": "baz contents",
+	}, files, "The table of contents should not be a tar entry")
+}
+
+func TestOpenNotIndexed(t *testing.T) {
+	var buf bytes.Buffer
+	compressor := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(compressor)
+	require.NoError(t, archive.WriteHeader(&tar.Header{Name: "foo", Typeflag: tar.TypeReg, Mode: 0644}))
+	require.NoError(t, archive.Close())
+	require.NoError(t, compressor.Close())
+
+	_, err := Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()), afero.NewMemMapFs())
+	assert.Equal(t, ErrNotIndexed, err)
+}
+
+type countingReaderAt struct {
+	io.ReaderAt
+	reads int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&c.reads, int64(len(p)))
+	return c.ReaderAt.ReadAt(p, off)
+}
+
+func TestLazyFetch(t *testing.T) {
+	large := string(bytes.Repeat([]byte("not compressible? "), 1<<14))
+	archive := writeTestArchive(t, map[string]string{
+		"/small":     "small contents",
+		"/dir/large": large,
+	})
+	reader := &countingReaderAt{ReaderAt: bytes.NewReader(archive)}
+	cache := afero.NewMemMapFs()
+	fs, err := Open(reader, int64(len(archive)), cache)
+	require.NoError(t, err)
+
+	info, err := fs.Stat("/dir/large")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(large)), info.Size())
+	_, err = cache.Stat("/dir/large")
+	assert.True(t, os.IsNotExist(err), "Stat should not fetch file contents")
+
+	dir, err := fs.Open("/dir")
+	require.NoError(t, err)
+	names, err := dir.Readdirnames(-1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"large"}, names)
+	require.NoError(t, dir.Close())
+
+	readsBefore := atomic.LoadInt64(&reader.reads)
+	contents, err := afero.ReadFile(fs, "/small")
+	require.NoError(t, err)
+	assert.Equal(t, "small contents", string(contents))
+	assert.Less(t, atomic.LoadInt64(&reader.reads)-readsBefore, int64(len(archive)/2), "Only the file's chunk should be read")
+	_, err = cache.Stat("/dir/large")
+	assert.True(t, os.IsNotExist(err), "Other files should not be fetched")
+
+	contents, err = afero.ReadFile(fs, "/dir/large")
+	require.NoError(t, err)
+	assert.Equal(t, large, string(contents))
+
+	readsBefore = atomic.LoadInt64(&reader.reads)
+	_, err = afero.ReadFile(fs, "/dir/large")
+	require.NoError(t, err)
+	assert.Equal(t, readsBefore, atomic.LoadInt64(&reader.reads), "Fetched files should be read from the cache")
+}
+
+func TestOutOfOrderDirectories(t *testing.T) {
+	var buf bytes.Buffer
+	archive := NewWriter(&buf)
+	modTime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, archive.WriteHeader(&tar.Header{Name: "/bin/go", Typeflag: tar.TypeReg, Mode: 0755, Size: 2}))
+	_, err := archive.Write([]byte("go"))
+	require.NoError(t, err)
+	require.NoError(t, archive.WriteHeader(&tar.Header{Name: "/bin", Typeflag: tar.TypeDir, Mode: 0700, ModTime: modTime}))
+	require.NoError(t, archive.Close())
+
+	fs, err := Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()), afero.NewMemMapFs())
+	require.NoError(t, err)
+	for dir, expectNames := range map[string][]string{"/": {"bin"}, "/bin": {"go"}} {
+		f, err := fs.Open(dir)
+		require.NoError(t, err)
+		names, err := f.Readdirnames(-1)
+		require.NoError(t, err)
+		assert.Equal(t, expectNames, names, "dir: %s", dir)
+		require.NoError(t, f.Close())
+	}
+	info, err := fs.Stat("/bin")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0700, info.Mode(), "The explicit directory entry should be used")
+}
+
+func TestPersistentCacheSeconds(t *testing.T) {
+	var buf bytes.Buffer
+	archive := NewWriter(&buf)
+	modTime := time.Date(2021, 1, 2, 3, 4, 5, 678, time.UTC)
+	require.NoError(t, archive.WriteHeader(&tar.Header{Name: "/foo", Typeflag: tar.TypeReg, Mode: 0644, Size: 3, ModTime: modTime, Format: tar.FormatPAX}))
+	_, err := archive.Write([]byte("foo"))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+
+	cache := afero.NewMemMapFs()
+	fs, err := Open(bytes.NewReader(buf.Bytes()), int64(buf.Len()), cache)
+	require.NoError(t, err)
+	_, err = afero.ReadFile(fs, "/foo")
+	require.NoError(t, err)
+	// simulate a persistent cache which only stores whole seconds
+	require.NoError(t, cache.Chtimes("/foo", modTime.Truncate(time.Second), modTime.Truncate(time.Second)))
+
+	reader := &countingReaderAt{ReaderAt: bytes.NewReader(buf.Bytes())}
+	fs, err = Open(reader, int64(buf.Len()), cache)
+	require.NoError(t, err)
+	readsBefore := atomic.LoadInt64(&reader.reads)
+	contents, err := afero.ReadFile(fs, "/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(contents))
+	assert.Equal(t, readsBefore, atomic.LoadInt64(&reader.reads), "Previously fetched files should be reused")
+}
+
+func TestReadOnly(t *testing.T) {
+	archive := writeTestArchive(t, map[string]string{"/foo": "foo"})
+	fs, err := Open(bytes.NewReader(archive), int64(len(archive)), afero.NewMemMapFs())
+	require.NoError(t, err)
+
+	_, err = fs.OpenFile("/foo", os.O_RDWR, 0)
+	assert.True(t, os.IsPermission(err))
+	assert.True(t, os.IsPermission(fs.Remove("/foo")))
+	assert.True(t, os.IsPermission(fs.Mkdir("/bar", 0755)))
+}
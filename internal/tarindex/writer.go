@@ -0,0 +1,173 @@
+// Package tarindex reads and writes indexed .tar.gz archives, where each file can be fetched and decompressed on its own.
+//
+// Every entry is written as a separate gzip member, followed by a member with the tar trailer, a member containing a table of contents, and a small footer member pointing to it.
+// Since concatenated gzip members are a valid gzip stream, the archive is still a valid .tar.gz file for other tools.
+// Tar readers stop at the trailer, so the table of contents isn't extracted as a file.
+package tarindex
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+)
+
+const (
+	footerMagic = "TARINDEX"
+	// footerExtraID is the gzip extra field subfield ID holding the footer
+	footerExtraID0, footerExtraID1 = 'G', 'W'
+)
+
+// Entry describes a file or directory in the archive and the location of its gzip member
+type Entry struct {
+	Name      string      `json:"name"`
+	Mode      os.FileMode `json:"mode"`
+	ModTime   time.Time   `json:"modTime"`
+	Size      int64       `json:"size"`
+	Offset    int64       `json:"offset"`
+	ChunkSize int64       `json:"chunkSize"`
+}
+
+// Writer writes an indexed .tar.gz archive. Usage mirrors tar.Writer.
+type Writer struct {
+	w       *countingWriter
+	level   int
+	gzip    *gzip.Writer
+	tar     *tar.Writer
+	entries []Entry
+}
+
+type countingWriter struct {
+	io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// NewWriter returns a Writer with the default compression level
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterLevel(w, gzip.DefaultCompression)
+}
+
+// NewWriterLevel returns a Writer with the given gzip compression level
+func NewWriterLevel(w io.Writer, level int) *Writer {
+	writer := &Writer{
+		w:     &countingWriter{Writer: w},
+		level: level,
+	}
+	writer.tar = tar.NewWriter(chunkWriter{writer})
+	return writer
+}
+
+// chunkWriter writes to the current entry's gzip member
+type chunkWriter struct {
+	*Writer
+}
+
+func (c chunkWriter) Write(p []byte) (int, error) {
+	return c.gzip.Write(p)
+}
+
+// WriteHeader starts a new entry. Its contents, if any, are written with Write.
+func (w *Writer) WriteHeader(header *tar.Header) error {
+	if err := w.endChunk(); err != nil {
+		return err
+	}
+	if err := w.startChunk(); err != nil {
+		return err
+	}
+	w.entries = append(w.entries, Entry{
+		Name:    fsutil.NormalizePath(header.Name),
+		Mode:    header.FileInfo().Mode(),
+		ModTime: header.ModTime,
+		Size:    header.Size,
+		Offset:  w.w.count,
+	})
+	return w.tar.WriteHeader(header)
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.tar.Write(p)
+}
+
+func (w *Writer) startChunk() error {
+	var err error
+	w.gzip, err = gzip.NewWriterLevel(w.w, w.level)
+	return err
+}
+
+// endChunk ends the current entry's gzip member, if any
+func (w *Writer) endChunk() error {
+	if w.gzip == nil {
+		return nil
+	}
+	if err := w.tar.Flush(); err != nil {
+		return err
+	}
+	if err := w.gzip.Close(); err != nil {
+		return err
+	}
+	w.gzip = nil
+	entry := &w.entries[len(w.entries)-1]
+	entry.ChunkSize = w.w.count - entry.Offset
+	return nil
+}
+
+// Close writes the tar trailer, table of contents, and footer. Does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.endChunk(); err != nil {
+		return err
+	}
+	if err := w.startChunk(); err != nil {
+		return err
+	}
+	if err := w.tar.Close(); err != nil {
+		return err
+	}
+	if err := w.gzip.Close(); err != nil {
+		return err
+	}
+	w.gzip = nil
+
+	toc, err := json.Marshal(w.entries)
+	if err != nil {
+		return err
+	}
+	tocOffset := w.w.count
+	if err := w.startChunk(); err != nil {
+		return err
+	}
+	if _, err := w.gzip.Write(toc); err != nil {
+		return err
+	}
+	if err := w.gzip.Close(); err != nil {
+		return err
+	}
+	w.gzip = nil
+	return w.writeFooter(tocOffset)
+}
+
+// writeFooter writes an empty gzip member with the table of contents offset in its header's extra field
+func (w *Writer) writeFooter(tocOffset int64) error {
+	payload := fmt.Sprintf("%s%016x", footerMagic, tocOffset)
+	extra := []byte{footerExtraID0, footerExtraID1, 0, 0}
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(payload)))
+	extra = append(extra, payload...)
+
+	footer, err := gzip.NewWriterLevel(w.w, w.level)
+	if err != nil {
+		return err
+	}
+	footer.Header.Extra = extra
+	return footer.Close()
+}
@@ -0,0 +1,294 @@
+// Package tmpfs is a size-capped, in-memory file system for temporary files
+package tmpfs
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/quota"
+	"github.com/johnstarich/go-wasm/log"
+	"github.com/spf13/afero"
+)
+
+const (
+	cleanInterval = time.Minute
+	// evictAge is how long an entry must be unused before it can be evicted to free space, so entries in use like a running build's work directory are kept
+	evictAge = time.Minute
+)
+
+// Fs is an in-memory file system with a size cap, cleared on page reload.
+// Usage is tracked per top-level entry, like a build's temporary work directory.
+// Entries unused for longer than the max age are removed. When a write would exceed the size cap, the least recently used entries are evicted if they have been unused for a while.
+type Fs struct {
+	*quota.Fs
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	lastUsed  map[string]time.Time // top-level entry names to their last use
+	lastClean time.Time
+}
+
+// New returns an empty tmpfs limited to 'maxBytes'. Entries older than 'maxAge' are removed, unless 'maxAge' is <= 0.
+func New(maxBytes int64, maxAge time.Duration) (*Fs, error) {
+	memFs := quota.New(afero.NewMemMapFs())
+	if err := memFs.MkdirAll(afero.FilePathSeparator, 0755); err != nil {
+		return nil, err
+	}
+	if err := memFs.SetLimits(maxBytes, 0); err != nil {
+		return nil, err
+	}
+	return &Fs{
+		Fs:       memFs,
+		maxAge:   maxAge,
+		now:      time.Now,
+		lastUsed: make(map[string]time.Time),
+	}, nil
+}
+
+// entryName returns the top-level entry containing 'path', or an empty string for the root
+func entryName(path string) string {
+	path = strings.TrimPrefix(fsutil.NormalizePath(path), afero.FilePathSeparator)
+	if i := strings.IndexRune(path, '/'); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// touch marks the top-level entry of 'path' as recently used and runs a cleanup if one is due
+func (t *Fs) touch(path string) {
+	name := entryName(path)
+	now := t.now()
+	t.mu.Lock()
+	if name != "" {
+		t.lastUsed[name] = now
+	}
+	cleanDue := t.maxAge > 0 && now.Sub(t.lastClean) >= cleanInterval
+	if cleanDue {
+		t.lastClean = now
+	}
+	t.mu.Unlock()
+	if cleanDue {
+		t.Clean()
+	}
+}
+
+// Clean removes top-level entries unused for longer than the max age
+func (t *Fs) Clean() {
+	if t.maxAge <= 0 {
+		return
+	}
+	cutoff := t.now().Add(-t.maxAge)
+	for _, name := range t.entriesByLastUse() {
+		t.mu.Lock()
+		lastUsed := t.lastUsed[name]
+		t.mu.Unlock()
+		if lastUsed.After(cutoff) {
+			break
+		}
+		t.removeEntry(name)
+	}
+}
+
+// entriesByLastUse returns all top-level entry names, least recently used first
+func (t *Fs) entriesByLastUse() []string {
+	dir, err := t.Fs.Open(afero.FilePathSeparator)
+	if err != nil {
+		return nil
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil
+	}
+	t.mu.Lock()
+	sort.Slice(names, func(a, b int) bool {
+		return t.lastUsed[names[a]].Before(t.lastUsed[names[b]])
+	})
+	t.mu.Unlock()
+	return names
+}
+
+func (t *Fs) removeEntry(name string) {
+	if err := t.RemoveAll(afero.FilePathSeparator + name); err != nil {
+		log.Warnf("tmpfs: Failed to remove %q: %v", name, err)
+	}
+}
+
+// evict removes the least recently used top-level entry, except the one containing 'keepPath'. Returns false if no entry has been unused for the eviction age.
+func (t *Fs) evict(keepPath string) bool {
+	keep := entryName(keepPath)
+	cutoff := t.now().Add(-evictAge)
+	for _, name := range t.entriesByLastUse() {
+		if name == keep {
+			continue
+		}
+		t.mu.Lock()
+		lastUsed := t.lastUsed[name]
+		t.mu.Unlock()
+		if lastUsed.After(cutoff) {
+			return false // the remaining entries were used even more recently
+		}
+		log.Debugf("tmpfs: Evicting %q to free space", name)
+		t.removeEntry(name)
+		return true
+	}
+	return false
+}
+
+// retryNoSpace runs 'fn', evicting stale entries and retrying while it fails with ENOSPC
+func (t *Fs) retryNoSpace(path string, fn func() error) error {
+	for {
+		err := fn()
+		if !isNoSpace(err) || !t.evict(path) {
+			return err
+		}
+	}
+}
+
+func isNoSpace(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == syscall.ENOSPC
+}
+
+func (t *Fs) Name() string {
+	return "tmpfs"
+}
+
+func (t *Fs) Create(name string) (afero.File, error) {
+	return t.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (t *Fs) Mkdir(name string, perm os.FileMode) error {
+	t.touch(name)
+	return t.retryNoSpace(name, func() error {
+		return t.Fs.Mkdir(name, perm)
+	})
+}
+
+func (t *Fs) MkdirAll(path string, perm os.FileMode) error {
+	t.touch(path)
+	return t.retryNoSpace(path, func() error {
+		return t.Fs.MkdirAll(path, perm)
+	})
+}
+
+func (t *Fs) Open(name string) (afero.File, error) {
+	return t.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (t *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	t.touch(name)
+	var file afero.File
+	err := t.retryNoSpace(name, func() error {
+		var err error
+		file, err = t.Fs.OpenFile(name, flag, perm)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return file, nil
+	}
+	return &tmpFile{File: file, fs: t, path: name}, nil
+}
+
+func (t *Fs) Remove(name string) error {
+	err := t.Fs.Remove(name)
+	if err == nil {
+		t.forget(name)
+	}
+	return err
+}
+
+func (t *Fs) RemoveAll(path string) error {
+	err := t.Fs.RemoveAll(path)
+	if err == nil {
+		t.forget(path)
+	}
+	return err
+}
+
+// forget stops tracking 'path' if it was a top-level entry
+func (t *Fs) forget(path string) {
+	name := entryName(path)
+	if name != strings.TrimPrefix(fsutil.NormalizePath(path), afero.FilePathSeparator) {
+		return
+	}
+	t.mu.Lock()
+	delete(t.lastUsed, name)
+	t.mu.Unlock()
+}
+
+func (t *Fs) Rename(oldname, newname string) error {
+	t.touch(newname)
+	err := t.Fs.Rename(oldname, newname)
+	if err == nil {
+		t.forget(oldname)
+	}
+	return err
+}
+
+func (t *Fs) Stat(name string) (os.FileInfo, error) {
+	t.touch(name)
+	return t.Fs.Stat(name)
+}
+
+// Clear removes all files
+func (t *Fs) Clear() error {
+	for _, name := range t.entriesByLastUse() {
+		if err := t.Fs.RemoveAll(afero.FilePathSeparator + name); err != nil {
+			return err
+		}
+	}
+	t.mu.Lock()
+	t.lastUsed = make(map[string]time.Time)
+	t.mu.Unlock()
+	return nil
+}
+
+// tmpFile evicts other entries when a write runs out of space
+type tmpFile struct {
+	afero.File
+	fs   *Fs
+	path string
+}
+
+func (f *tmpFile) Write(p []byte) (n int, err error) {
+	f.fs.touch(f.path)
+	err = f.fs.retryNoSpace(f.path, func() error {
+		var err error
+		n, err = f.File.Write(p)
+		return err
+	})
+	return
+}
+
+func (f *tmpFile) WriteAt(p []byte, off int64) (n int, err error) {
+	f.fs.touch(f.path)
+	err = f.fs.retryNoSpace(f.path, func() error {
+		var err error
+		n, err = f.File.WriteAt(p, off)
+		return err
+	})
+	return
+}
+
+func (f *tmpFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *tmpFile) Truncate(size int64) error {
+	f.fs.touch(f.path)
+	return f.fs.retryNoSpace(f.path, func() error {
+		return f.File.Truncate(size)
+	})
+}
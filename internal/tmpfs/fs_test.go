@@ -0,0 +1,65 @@
+package tmpfs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/fstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFs(t *testing.T) {
+	fs, err := New(1<<20, 0)
+	require.NoError(t, err)
+	fstest.Run(t, fs, fs.Clear)
+}
+
+func TestFsEvict(t *testing.T) {
+	fs, err := New(10, 0)
+	require.NoError(t, err)
+	now := time.Unix(0, 0)
+	fs.now = func() time.Time { return now }
+
+	require.NoError(t, afero.WriteFile(fs, "/old", []byte("1234"), 0600))
+	now = now.Add(evictAge)
+	require.NoError(t, afero.WriteFile(fs, "/recent", []byte("1234"), 0600))
+	now = now.Add(time.Second)
+	require.NoError(t, afero.WriteFile(fs, "/new", []byte("1234"), 0600))
+
+	_, err = fs.Stat("/old")
+	assert.True(t, os.IsNotExist(err), "Least recently used entry should be evicted")
+	_, err = fs.Stat("/recent")
+	assert.NoError(t, err)
+
+	err = afero.WriteFile(fs, "/new", []byte("12345678"), 0600)
+	assert.Equal(t, &os.PathError{Op: "write", Path: "/new", Err: syscall.ENOSPC}, err, "Entries in use should not be evicted")
+	_, err = fs.Stat("/recent")
+	assert.NoError(t, err)
+
+	now = now.Add(evictAge)
+	err = afero.WriteFile(fs, "/new", []byte("12345678901"), 0600)
+	assert.Equal(t, &os.PathError{Op: "write", Path: "/new", Err: syscall.ENOSPC}, err, "Writes larger than the cap should fail after evicting everything else")
+	_, err = fs.Stat("/recent")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFsClean(t *testing.T) {
+	fs, err := New(0, time.Hour)
+	require.NoError(t, err)
+	now := time.Unix(0, 0).Add(cleanInterval)
+	fs.now = func() time.Time { return now }
+
+	require.NoError(t, fs.MkdirAll("/go-build1/b001", 0700))
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, fs.MkdirAll("/go-build2/b001", 0700))
+	now = now.Add(31 * time.Minute)
+
+	_, err = fs.Stat("/go-build2/b001")
+	assert.NoError(t, err)
+	_, err = fs.Stat("/go-build1")
+	assert.True(t, os.IsNotExist(err), "Entries unused for more than the max age should be removed")
+}
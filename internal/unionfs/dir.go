@@ -0,0 +1,60 @@
+package unionfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// dir is a directory file with its contents merged from both layers
+type dir struct {
+	afero.File
+	fs     *Fs
+	path   string
+	names  []string
+	loaded bool
+	offset int
+}
+
+func (d *dir) Readdirnames(count int) ([]string, error) {
+	if !d.loaded {
+		names, err := d.fs.readDirNames(d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.names = names
+		d.loaded = true
+	}
+
+	remaining := d.names[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.names)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
+
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
+	names, err := d.Readdirnames(count)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		info, err := d.fs.Stat(filepath.Join(d.path, name))
+		if err != nil {
+			return infos, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
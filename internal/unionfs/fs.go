@@ -0,0 +1,561 @@
+package unionfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/johnstarich/go-wasm/internal/fsutil"
+	"github.com/johnstarich/go-wasm/internal/quota"
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+const (
+	// whiteoutPrefix marks a file in the upper layer as deleted from the lower layer. Uses the same convention as overlayfs and aufs.
+	whiteoutPrefix = ".wh."
+	// opaqueMarker marks an upper layer directory as hiding all of the lower layer's contents for the same directory
+	opaqueMarker = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// Fs is a copy-on-write union of a writable upper layer on top of a read-only lower layer
+type Fs struct {
+	upper, lower afero.Fs
+}
+
+var _ afero.Fs = &Fs{}
+
+// New returns a union file system with a writable 'upper' layer on top of a read-only 'lower' layer.
+// Writes to lower files copy them up to 'upper' first. Deletions of lower files are recorded as whiteout files in 'upper'.
+func New(upper, lower afero.Fs) (*Fs, error) {
+	err := upper.MkdirAll(afero.FilePathSeparator, 0755) // ensure root exists
+	if err != nil {
+		return nil, errors.Wrap(err, "unionfs: Failed to ensure root '/' directory on upper FS")
+	}
+	return &Fs{
+		upper: upper,
+		lower: lower,
+	}, nil
+}
+
+func whiteoutPath(path string) string {
+	return filepath.Join(filepath.Dir(path), whiteoutPrefix+filepath.Base(path))
+}
+
+func exists(fs afero.Fs, path string) bool {
+	_, err := fs.Stat(path)
+	return err == nil
+}
+
+func underlyingError(err error) error {
+	switch err := err.(type) {
+	case *os.PathError:
+		return err.Err
+	case *os.LinkError:
+		return err.Err
+	default:
+		return err
+	}
+}
+
+// lowerVisible returns true if 'path' in the lower layer is not hidden by whiteouts or opaque directories in the upper layer
+func (u *Fs) lowerVisible(path string) bool {
+	for p := path; p != afero.FilePathSeparator; p = filepath.Dir(p) {
+		if exists(u.upper, whiteoutPath(p)) || exists(u.upper, filepath.Join(filepath.Dir(p), opaqueMarker)) {
+			return false
+		}
+	}
+	return true
+}
+
+// inLower returns true if 'path' exists in the lower layer and is visible
+func (u *Fs) inLower(path string) bool {
+	return exists(u.lower, path) && u.lowerVisible(path)
+}
+
+// stat returns the file info for the top-most layer containing 'path'
+func (u *Fs) stat(path string) (info os.FileInfo, inUpper bool, err error) {
+	info, err = u.upper.Stat(path)
+	if err == nil {
+		return info, true, nil
+	}
+	if !os.IsNotExist(err) && !afero.IsNotDir(err) {
+		return nil, false, err
+	}
+	if !u.lowerVisible(path) {
+		return nil, false, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	info, err = u.lower.Stat(path)
+	return info, false, err
+}
+
+// ensureParent copies up the parent directory of 'path', if necessary
+func (u *Fs) ensureParent(path string) error {
+	parent := filepath.Dir(path)
+	info, inUpper, err := u.stat(parent)
+	switch {
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return &os.PathError{Op: "stat", Path: parent, Err: afero.ErrNotDir}
+	case inUpper:
+		return nil
+	default:
+		return u.copyUp(parent)
+	}
+}
+
+// copyUp copies 'path' from the lower layer to the upper layer, if it isn't already there.
+// Directories are copied without their contents.
+func (u *Fs) copyUp(path string) error {
+	if exists(u.upper, path) {
+		return nil
+	}
+	info, err := u.lower.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := u.ensureParent(path); err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		err = u.upper.Mkdir(path, info.Mode().Perm())
+	} else {
+		err = u.copyUpFile(path, info)
+	}
+	if err != nil {
+		return err
+	}
+	return u.upper.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+func (u *Fs) copyUpFile(path string, info os.FileInfo) error {
+	src, err := u.lower.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest, err := u.upper.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dest, src)
+	if err != nil {
+		_ = dest.Close()
+		return err
+	}
+	return dest.Close()
+}
+
+// copyUpAll copies 'path' and all of its lower layer contents to the upper layer
+func (u *Fs) copyUpAll(path string) error {
+	if err := u.copyUp(path); err != nil {
+		return err
+	}
+	info, err := u.upper.Stat(path)
+	if err != nil || !info.IsDir() {
+		return err
+	}
+	names, err := u.readDirNames(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := u.copyUpAll(filepath.Join(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// whiteout hides 'path' in the lower layer
+func (u *Fs) whiteout(path string) error {
+	if err := u.ensureParent(path); err != nil {
+		return err
+	}
+	f, err := u.upper.Create(whiteoutPath(path))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// removeWhiteout removes a whiteout for 'path', if present. Returns true if a whiteout was removed.
+func (u *Fs) removeWhiteout(path string) (bool, error) {
+	whiteout := whiteoutPath(path)
+	if !exists(u.upper, whiteout) {
+		return false, nil
+	}
+	return true, u.upper.Remove(whiteout)
+}
+
+func (u *Fs) markOpaque(path string) error {
+	f, err := u.upper.Create(filepath.Join(path, opaqueMarker))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readDirNames returns the sorted, merged names of the upper and lower layers' directory 'path'
+func (u *Fs) readDirNames(path string) ([]string, error) {
+	nameSet := make(map[string]bool)
+	whiteouts := make(map[string]bool)
+	opaque := false
+
+	upperNames, err := readDirNames(u.upper, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range upperNames {
+		switch {
+		case name == opaqueMarker:
+			opaque = true
+		case strings.HasPrefix(name, whiteoutPrefix):
+			whiteouts[strings.TrimPrefix(name, whiteoutPrefix)] = true
+		default:
+			nameSet[name] = true
+		}
+	}
+
+	if !opaque && u.lowerVisible(path) {
+		lowerNames, err := readDirNames(u.lower, path)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range lowerNames {
+			if !whiteouts[name] {
+				nameSet[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(nameSet))
+	for name := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readDirNames returns the names in directory 'path' on 'fs', or none if 'path' is not a directory
+func readDirNames(fs afero.Fs, path string) ([]string, error) {
+	info, err := fs.Stat(path)
+	if err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+func (u *Fs) Create(name string) (afero.File, error) {
+	return u.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (u *Fs) Mkdir(name string, perm os.FileMode) error {
+	name = fsutil.NormalizePath(name)
+	_, inUpper, err := u.stat(name)
+	switch {
+	case inUpper:
+		return u.upper.Mkdir(name, perm)
+	case err == nil:
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	case !os.IsNotExist(err):
+		return &os.PathError{Op: "mkdir", Path: name, Err: underlyingError(err)}
+	}
+
+	if err := u.ensureParent(name); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: underlyingError(err)}
+	}
+	removedWhiteout, err := u.removeWhiteout(name)
+	if err != nil {
+		return err
+	}
+	if err := u.upper.Mkdir(name, perm); err != nil {
+		return err
+	}
+	if removedWhiteout {
+		// the old lower directory was deleted, so hide its contents
+		return u.markOpaque(name)
+	}
+	return nil
+}
+
+func (u *Fs) MkdirAll(path string, perm os.FileMode) error {
+	path = fsutil.NormalizePath(path)
+	var missingDirs []string
+	for p := path; p != afero.FilePathSeparator; p = filepath.Dir(p) {
+		info, _, err := u.stat(p)
+		if err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: p, Err: afero.ErrNotDir}
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		missingDirs = append(missingDirs, p)
+	}
+
+	for i := len(missingDirs) - 1; i >= 0; i-- { // missingDirs are in reverse order
+		err := u.Mkdir(missingDirs[i], perm)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Fs) Open(name string) (afero.File, error) {
+	return u.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (u *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	name = fsutil.NormalizePath(name)
+	const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+	info, inUpper, err := u.stat(name)
+	switch {
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		// check the merged view, since lower files aren't in the upper layer yet
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil && !inUpper && flag&writeFlags == 0:
+		f, err := u.lower.OpenFile(name, flag, perm)
+		return u.openDir(name, f, err)
+	case err == nil && !inUpper && !info.IsDir() && flag&os.O_TRUNC != 0:
+		// skip copying contents which are about to be truncated
+		if err := u.ensureParent(name); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: underlyingError(err)}
+		}
+		return u.upper.OpenFile(name, flag|os.O_CREATE, info.Mode().Perm())
+	case err == nil && !inUpper:
+		if err := u.copyUp(name); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: underlyingError(err)}
+		}
+	case os.IsNotExist(err) && flag&os.O_CREATE != 0:
+		if err := u.ensureParent(name); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: underlyingError(err)}
+		}
+		if _, err := u.removeWhiteout(name); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, &os.PathError{Op: "open", Path: name, Err: underlyingError(err)}
+	}
+	f, err := u.upper.OpenFile(name, flag, perm)
+	return u.openDir(name, f, err)
+}
+
+// openDir wraps directories to list merged directory contents
+func (u *Fs) openDir(name string, f afero.File, err error) (afero.File, error) {
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil || !info.IsDir() {
+		return f, err
+	}
+	return &dir{File: f, fs: u, path: name}, nil
+}
+
+func (u *Fs) Remove(name string) error {
+	name = fsutil.NormalizePath(name)
+	if name == afero.FilePathSeparator {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	info, inUpper, err := u.stat(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: underlyingError(err)}
+	}
+	inLower := u.inLower(name)
+
+	if info.IsDir() {
+		names, err := u.readDirNames(name)
+		if err != nil {
+			return err
+		}
+		if len(names) != 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	if inUpper {
+		if info.IsDir() {
+			// only whiteouts remain, so remove them before the directory itself
+			whiteouts, err := readDirNames(u.upper, name)
+			if err != nil {
+				return err
+			}
+			for _, whiteout := range whiteouts {
+				if err := u.upper.Remove(filepath.Join(name, whiteout)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := u.upper.Remove(name); err != nil {
+			return err
+		}
+	}
+	if inLower {
+		return u.whiteout(name)
+	}
+	return nil
+}
+
+func (u *Fs) RemoveAll(path string) error {
+	path = fsutil.NormalizePath(path)
+	info, _, err := u.stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		names, err := u.readDirNames(path)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := u.RemoveAll(filepath.Join(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return u.Remove(path)
+}
+
+func (u *Fs) Rename(oldname, newname string) error {
+	oldname = fsutil.NormalizePath(oldname)
+	newname = fsutil.NormalizePath(newname)
+	oldInfo, _, err := u.stat(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: underlyingError(err)}
+	}
+	newInfo, newInUpper, err := u.stat(newname)
+	if err == nil && !newInUpper && newInfo.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: afero.ErrFileExists}
+	}
+	oldInLower := u.inLower(oldname)
+
+	if err := u.copyUpAll(oldname); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: underlyingError(err)}
+	}
+	if err := u.ensureParent(newname); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: underlyingError(err)}
+	}
+	if _, err := u.removeWhiteout(newname); err != nil {
+		return err
+	}
+	if err := u.upper.Rename(oldname, newname); err != nil {
+		return err
+	}
+	if oldInfo.IsDir() && exists(u.lower, newname) {
+		// all of the old directory's contents were copied up, so hide any lower contents at the new path
+		if err := u.markOpaque(newname); err != nil {
+			return err
+		}
+	}
+	if oldInLower && oldname != newname {
+		return u.whiteout(oldname)
+	}
+	return nil
+}
+
+func (u *Fs) Stat(name string) (os.FileInfo, error) {
+	name = fsutil.NormalizePath(name)
+	info, _, err := u.stat(name)
+	return info, err
+}
+
+func (u *Fs) Name() string {
+	return fmt.Sprintf("unionfs.Fs(%q, %q)", u.upper.Name(), u.lower.Name())
+}
+
+func (u *Fs) Chmod(name string, mode os.FileMode) error {
+	name = fsutil.NormalizePath(name)
+	if err := u.copyUpExisting("chmod", name); err != nil {
+		return err
+	}
+	return u.upper.Chmod(name, mode)
+}
+
+func (u *Fs) Chown(name string, uid, gid int) error {
+	name = fsutil.NormalizePath(name)
+	if err := u.copyUpExisting("chown", name); err != nil {
+		return err
+	}
+	return fsutil.Chown(u.upper, name, uid, gid)
+}
+
+func (u *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	name = fsutil.NormalizePath(name)
+	if err := u.copyUpExisting("chtimes", name); err != nil {
+		return err
+	}
+	return u.upper.Chtimes(name, atime, mtime)
+}
+
+func (u *Fs) copyUpExisting(op, name string) error {
+	_, inUpper, err := u.stat(name)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: underlyingError(err)}
+	}
+	if inUpper {
+		return nil
+	}
+	err = u.copyUp(name)
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: underlyingError(err)}
+	}
+	return nil
+}
+
+type clearerFs interface {
+	Clear() error
+}
+
+// Clear discards all changes in the upper layer. Also clears the lower layer if supported.
+func (u *Fs) Clear() error {
+	clearer, ok := u.upper.(clearerFs)
+	if !ok {
+		return errors.Errorf("Unsupported operation for fs: %s", u.upper.Name())
+	}
+	if err := clearer.Clear(); err != nil {
+		return err
+	}
+	if err := u.upper.MkdirAll(afero.FilePathSeparator, 0755); err != nil {
+		return err
+	}
+	if clearer, ok := u.lower.(clearerFs); ok {
+		return clearer.Clear()
+	}
+	return nil
+}
+
+// Usage returns the upper layer's usage, since only changes consume space. Returns ENOTSUP if the upper layer doesn't track usage.
+func (u *Fs) Usage() (quota.Usage, error) {
+	limiter, ok := u.upper.(quota.Limiter)
+	if !ok {
+		return quota.Usage{}, syscall.ENOTSUP
+	}
+	return limiter.Usage()
+}
+
+// SetLimits sets quotas on the upper layer. Returns ENOTSUP if the upper layer doesn't track usage.
+func (u *Fs) SetLimits(maxBytes, maxInodes int64) error {
+	limiter, ok := u.upper.(quota.Limiter)
+	if !ok {
+		return syscall.ENOTSUP
+	}
+	return limiter.SetLimits(maxBytes, maxInodes)
+}
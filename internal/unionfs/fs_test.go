@@ -0,0 +1,136 @@
+package unionfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/johnstarich/go-wasm/internal/fstest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFs(t *testing.T) {
+	upper := afero.NewMemMapFs()
+	fs, err := New(upper, afero.NewReadOnlyFs(afero.NewMemMapFs()))
+	require.NoError(t, err)
+
+	cleanup := func() error {
+		names, err := readDirNames(upper, "/")
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := upper.RemoveAll(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	fstest.Run(t, fs, cleanup)
+}
+
+func newTestUnion(t *testing.T) (fs *Fs, upper, lower afero.Fs) {
+	lower = afero.NewMemMapFs()
+	require.NoError(t, lower.MkdirAll("/dir/sub", 0755))
+	require.NoError(t, afero.WriteFile(lower, "/dir/foo", []byte("lower foo"), 0644))
+	require.NoError(t, afero.WriteFile(lower, "/dir/sub/bar", []byte("lower bar"), 0600))
+
+	upper = afero.NewMemMapFs()
+	fs, err := New(upper, afero.NewReadOnlyFs(lower))
+	require.NoError(t, err)
+	return fs, upper, lower
+}
+
+func readDir(t *testing.T, fs afero.Fs, path string) []string {
+	t.Helper()
+	f, err := fs.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	require.NoError(t, err)
+	return names
+}
+
+func TestCopyUp(t *testing.T) {
+	fs, upper, lower := newTestUnion(t)
+
+	require.NoError(t, afero.WriteFile(fs, "/dir/foo", []byte("upper foo"), 0644))
+	contents, err := afero.ReadFile(fs, "/dir/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "upper foo", string(contents))
+
+	contents, err = afero.ReadFile(lower, "/dir/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "lower foo", string(contents), "Lower layer should not change")
+
+	f, err := fs.OpenFile("/dir/sub/bar", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(" appended"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	contents, err = afero.ReadFile(upper, "/dir/sub/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "lower bar appended", string(contents))
+	info, err := fs.Stat("/dir/sub/bar")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode())
+
+	assert.Equal(t, []string{"foo", "sub"}, readDir(t, fs, "/dir"))
+}
+
+func TestOpenExclusive(t *testing.T) {
+	fs, upper, _ := newTestUnion(t)
+
+	_, err := fs.OpenFile("/dir/foo", os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_TRUNC, 0600)
+	assert.True(t, os.IsExist(err), "Lower files should exist: %v", err)
+	assert.False(t, exists(upper, "/dir/foo"), "Failed opens should not copy up")
+	contents, err := afero.ReadFile(fs, "/dir/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "lower foo", string(contents))
+
+	f, err := fs.OpenFile("/dir/new", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestWhiteout(t *testing.T) {
+	fs, _, lower := newTestUnion(t)
+
+	require.NoError(t, fs.Remove("/dir/foo"))
+	_, err := fs.Stat("/dir/foo")
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []string{"sub"}, readDir(t, fs, "/dir"))
+	_, err = lower.Stat("/dir/foo")
+	assert.NoError(t, err, "Lower layer should not change")
+
+	assert.Error(t, fs.Remove("/dir/sub"), "Non-empty directories should not be removable")
+	require.NoError(t, fs.RemoveAll("/dir"))
+	_, err = fs.Stat("/dir/sub/bar")
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, readDir(t, fs, "/"))
+
+	require.NoError(t, fs.Mkdir("/dir", 0700))
+	assert.Empty(t, readDir(t, fs, "/dir"), "Recreated directory should not contain deleted lower files")
+	require.NoError(t, afero.WriteFile(fs, "/dir/foo", []byte("new foo"), 0644))
+	contents, err := afero.ReadFile(fs, "/dir/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "new foo", string(contents))
+}
+
+func TestRename(t *testing.T) {
+	fs, _, _ := newTestUnion(t)
+
+	require.NoError(t, fs.Rename("/dir/foo", "/dir/baz"))
+	assert.Equal(t, []string{"baz", "sub"}, readDir(t, fs, "/dir"))
+	contents, err := afero.ReadFile(fs, "/dir/baz")
+	require.NoError(t, err)
+	assert.Equal(t, "lower foo", string(contents))
+
+	require.NoError(t, fs.Rename("/dir/sub", "/sub"))
+	assert.Equal(t, []string{"baz"}, readDir(t, fs, "/dir"))
+	assert.Equal(t, []string{"bar"}, readDir(t, fs, "/sub"))
+	contents, err = afero.ReadFile(fs, "/sub/bar")
+	require.NoError(t, err)
+	assert.Equal(t, "lower bar", string(contents))
+}
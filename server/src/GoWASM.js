@@ -31,6 +31,7 @@ async function init() {
   await mkdir("/usr/local/go", {recursive: true, mode: 0o700})
   await goWasm.overlayTarGzip('/usr/local/go', 'wasm/go.tar.gz', {
     persist: true,
+    checkPermissions: false,
     progress: percentage => {
       overlayProgress = percentage
       progressListeners.forEach(c => c(percentage))